@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	minActiveBrightness = 32
+	maxActiveBrightness = 255
+)
+
+// Brightness curves mapping activity to a level
+const (
+	BrightnessCurveLinear = "linear" // activity / max
+	BrightnessCurveLog    = "log"    // log(1+activity) / log(1+max)
+	BrightnessCurveGamma  = "gamma"  // (activity / max)^(1/gamma)
+)
+
+// scaleBrightness maps activity against the running max to the active
+// brightness range along curve, from minActiveBrightness at zero activity to
+// maxActiveBrightness at the max. Whether an idle LED is off instead is for
+// the caller to decide.
+func scaleBrightness(activity, maxActivity uint64, curve string, gamma float64) byte {
+	return brightnessForLevel(curveLevel(curve, activity, maxActivity, gamma))
+}
+
+// curveLevel maps activity against the running max to a level in 0..1. The
+// log and gamma curves lift low activity so it stays visible next to bursts.
+func curveLevel(curve string, activity, maxActivity uint64, gamma float64) float64 {
+	switch curve {
+	case BrightnessCurveLinear:
+		return activityLevel(activity, maxActivity)
+	case BrightnessCurveLog:
+		if activity == 0 {
+			return 0
+		}
+		if maxActivity < activity {
+			maxActivity = activity
+		}
+		return math.Log1p(float64(activity)) / math.Log1p(float64(maxActivity))
+	}
+	return applyGamma(activityLevel(activity, maxActivity), gamma)
+}
+
+// shapeLevel applies curve to a level that is already normalized, such as a
+// brightness formula or link utilization. Only gamma reshapes it, since the
+// log curve needs the raw activity.
+func shapeLevel(curve string, level, gamma float64) float64 {
+	if curve == BrightnessCurveGamma {
+		return applyGamma(level, gamma)
+	}
+	return level
+}
+
+// activityLevel returns activity as a fraction of the running max, in 0..1
+func activityLevel(activity, maxActivity uint64) float64 {
+	if activity == 0 {
+		return 0
+	}
+	if maxActivity < activity {
+		maxActivity = activity
+	}
+	return float64(activity) / float64(maxActivity)
+}
+
+// applyGamma returns level^(1/gamma); a gamma of 1 is linear
+func applyGamma(level, gamma float64) float64 {
+	if gamma <= 0 {
+		return level
+	}
+	return math.Pow(level, 1/gamma)
+}
+
+// brightnessForLevel maps an activity level in 0..1 to the active brightness range
+func brightnessForLevel(level float64) byte {
+	level = math.Max(0, math.Min(level, 1))
+	return byte(minActiveBrightness + math.Round(level*(maxActiveBrightness-minActiveBrightness)))
+}
+
+// pulsePeriodsMs are the blink periods of active_mode pulse, slowest first,
+// one per equal band of activity level. Bands rather than a continuous rate
+// keep steady activity from rewriting the blink every poll.
+var pulsePeriodsMs = []int{1000, 600, 300, 150}
+
+// pulsePeriodMs returns the blink period for an activity level in 0..1
+func pulsePeriodMs(level float64) int {
+	band := int(level * float64(len(pulsePeriodsMs)))
+	return pulsePeriodsMs[max(0, min(band, len(pulsePeriodsMs)-1))]
+}
+
+// bytesPerSecond converts the bytes moved in one poll of interval to a rate
+func bytesPerSecond(bytes uint64, interval time.Duration) uint64 {
+	if interval <= 0 {
+		return 0
+	}
+	return uint64(math.Round(float64(bytes) / interval.Seconds()))
+}
+
+// decayPeak returns max(current, peak*decay), letting the brightness scale
+// recover from a burst instead of staying dimmed by it forever
+func decayPeak(peak, current uint64, decay float64) uint64 {
+	decayed := uint64(float64(peak) * decay)
+	if current > decayed {
+		return current
+	}
+	return decayed
+}
+
+// Activity metrics selectable with activity_metric
+const (
+	ActivityMetricThroughput = "throughput" // bytes read and written
+	ActivityMetricUtil       = "util"       // percent of the interval the disk was busy
+	ActivityMetricIOPS       = "iops"       // I/Os completed
+)
+
+// activityFormula returns the brightness formula in effect: brightness_formula
+// when set, otherwise the single metric chosen by activity_metric, or nil for
+// plain throughput
+func (c *Config) activityFormula() map[string]float64 {
+	if len(c.BrightnessFormula) > 0 {
+		return c.BrightnessFormula
+	}
+	switch c.ActivityMetric {
+	case ActivityMetricUtil:
+		return map[string]float64{MetricBusy: 1}
+	case ActivityMetricIOPS:
+		return map[string]float64{MetricIOPS: 1}
+	}
+	return nil
+}
+
+// Metric names usable in brightness_formula
+const (
+	MetricThroughput = "throughput"
+	MetricIOPS       = "iops"
+	MetricBusy       = "busy"
+	MetricQueue      = "queue"
+	MetricLatency    = "latency"
+)
+
+var brightnessMetrics = []string{
+	MetricThroughput, MetricIOPS, MetricBusy, MetricQueue, MetricLatency,
+}
+
+// DiskMetrics holds the per-interval metrics derived from two diskstats samples
+type DiskMetrics struct {
+	Throughput float64 // bytes transferred
+	IOPS       float64 // I/Os completed
+	Busy       float64 // fraction of the interval spent doing I/O, 0..1
+	Queue      float64 // average number of queued I/Os
+	Latency    float64 // average ms per completed I/O
+}
+
+// diskMetrics computes the metrics for the interval between prev and curr
+func diskMetrics(prev, curr DiskActivity, interval time.Duration) DiskMetrics {
+	delta := func(a, b uint64) float64 {
+		return float64(counterDelta(a, b))
+	}
+
+	m := DiskMetrics{
+		Throughput: delta(prev.Activity, curr.Activity),
+		IOPS:       delta(prev.ReadIOs, curr.ReadIOs) + delta(prev.WriteIOs, curr.WriteIOs),
+	}
+	if ms := float64(interval.Milliseconds()); ms > 0 {
+		m.Busy = math.Min(delta(prev.IOTicks, curr.IOTicks)/ms, 1)
+		m.Queue = delta(prev.TimeInQueue, curr.TimeInQueue) / ms
+	}
+	if m.IOPS > 0 {
+		m.Latency = (delta(prev.ReadTicks, curr.ReadTicks) + delta(prev.WriteTicks, curr.WriteTicks)) / m.IOPS
+	}
+	return m
+}
+
+// value returns the metric with the given name
+func (m DiskMetrics) value(name string) float64 {
+	switch name {
+	case MetricThroughput:
+		return m.Throughput
+	case MetricIOPS:
+		return m.IOPS
+	case MetricBusy:
+		return m.Busy
+	case MetricQueue:
+		return m.Queue
+	case MetricLatency:
+		return m.Latency
+	}
+	return 0
+}
+
+// decayMetricPeaks decays every metric peak; call once per tick before updatePeaks
+func decayMetricPeaks(peaks map[string]float64, decay float64) {
+	for name := range peaks {
+		peaks[name] *= decay
+	}
+}
+
+// updatePeaks raises the per-metric peaks used to normalize formula inputs
+func updatePeaks(peaks map[string]float64, m DiskMetrics) {
+	for _, name := range brightnessMetrics {
+		if v := m.value(name); v > peaks[name] {
+			peaks[name] = v
+		}
+	}
+}
+
+// formulaLevel combines the metrics named in formula into a level in 0..1.
+// Each metric is normalized against its peak (busy is already a fraction),
+// then weighted, with the weights normalized to sum to 1.
+func formulaLevel(formula map[string]float64, m DiskMetrics, peaks map[string]float64) float64 {
+	var sum, total float64
+	for name, weight := range formula {
+		total += weight
+		sum += weight * metricLevel(name, m, peaks)
+	}
+	if total <= 0 {
+		return 0
+	}
+	return sum / total
+}
+
+// metricLevel returns the named metric normalized against its peak, in 0..1
+func metricLevel(name string, m DiskMetrics, peaks map[string]float64) float64 {
+	v := m.value(name)
+	if name != MetricBusy {
+		if peaks[name] <= 0 {
+			return 0
+		}
+		v /= peaks[name]
+	}
+	return math.Min(v, 1)
+}
+
+// isBrightnessMetric reports whether name is one of brightnessMetrics
+func isBrightnessMetric(name string) bool {
+	for _, m := range brightnessMetrics {
+		if name == m {
+			return true
+		}
+	}
+	return false
+}
+
+// validateBrightnessFormula checks metric names and weights
+func validateBrightnessFormula(formula map[string]float64) error {
+	if len(formula) == 0 {
+		return nil
+	}
+
+	var total float64
+	for name, weight := range formula {
+		if !isBrightnessMetric(name) {
+			return fmt.Errorf("brightness_formula: unknown metric %q (valid: %s)", name, strings.Join(brightnessMetrics, ", "))
+		}
+		if math.IsNaN(weight) || math.IsInf(weight, 0) || weight < 0 {
+			return fmt.Errorf("brightness_formula: weight for %q must be a non-negative number, got %v", name, weight)
+		}
+		total += weight
+	}
+	if total == 0 {
+		return fmt.Errorf("brightness_formula: at least one weight must be positive")
+	}
+	return nil
+}
+
+// formatBrightnessFormula renders a formula like "0.6*throughput + 0.4*queue"
+func formatBrightnessFormula(formula map[string]float64) string {
+	names := make([]string, 0, len(formula))
+	for name := range formula {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	terms := make([]string, 0, len(names))
+	for _, name := range names {
+		terms = append(terms, fmt.Sprintf("%g*%s", formula[name], name))
+	}
+	return strings.Join(terms, " + ")
+}
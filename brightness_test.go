@@ -0,0 +1,279 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestScaleBrightness(t *testing.T) {
+	tests := []struct {
+		activity uint64
+		linear   byte
+		gamma22  byte
+	}{
+		{0, 32, 32},
+		{1, 34, 59},    // 1% of max
+		{10, 54, 110},  // 10% of max
+		{50, 144, 195}, // 50% of max
+		{100, 255, 255},
+		{200, 255, 255}, // above the running max
+	}
+	for _, tt := range tests {
+		if got := scaleBrightness(tt.activity, 100, BrightnessCurveGamma, 1.0); got != tt.linear {
+			t.Errorf("scaleBrightness(%d, 100, BrightnessCurveGamma, 1.0) = %d, want %d", tt.activity, got, tt.linear)
+		}
+		if got := scaleBrightness(tt.activity, 100, BrightnessCurveGamma, 2.2); got != tt.gamma22 {
+			t.Errorf("scaleBrightness(%d, 100, BrightnessCurveGamma, 2.2) = %d, want %d", tt.activity, got, tt.gamma22)
+		}
+		if tt.activity > 0 && tt.activity < 100 && scaleBrightness(tt.activity, 100, BrightnessCurveGamma, 2.2) <= scaleBrightness(tt.activity, 100, BrightnessCurveGamma, 1.0) {
+			t.Errorf("expected gamma 2.2 to brighten low activity %d", tt.activity)
+		}
+	}
+}
+
+func TestCurveLevel(t *testing.T) {
+	const max = 1000
+	for _, curve := range []string{BrightnessCurveLinear, BrightnessCurveLog, BrightnessCurveGamma} {
+		if got := scaleBrightness(1, max, curve, 2.2); got < minActiveBrightness {
+			t.Errorf("%s: expected minimal activity at least %d, got %d", curve, minActiveBrightness, got)
+		}
+		if got := scaleBrightness(0, max, curve, 2.2); got != minActiveBrightness {
+			t.Errorf("%s: expected no activity at %d, got %d", curve, minActiveBrightness, got)
+		}
+		if got := scaleBrightness(max, max, curve, 2.2); got != maxActiveBrightness {
+			t.Errorf("%s: expected max activity at %d, got %d", curve, maxActiveBrightness, got)
+		}
+		prev := -1.0
+		for a := uint64(0); a <= max; a += 10 {
+			level := curveLevel(curve, a, max, 2.2)
+			if level < prev {
+				t.Errorf("%s: level decreased from %v to %v at activity %d", curve, prev, level, a)
+			}
+			prev = level
+		}
+	}
+
+	// 10% of max: log lifts it well above linear
+	if lin, log := scaleBrightness(100, max, BrightnessCurveLinear, 1), scaleBrightness(100, max, BrightnessCurveLog, 1); lin != 54 || log != 181 {
+		t.Errorf("expected linear 54 and log 181 at 10%%, got %d and %d", lin, log)
+	}
+}
+
+func TestDecayPeak(t *testing.T) {
+	var peak uint64
+	peak = decayPeak(peak, 10000, 0.95) // spike
+	if peak != 10000 {
+		t.Fatalf("expected spike to set peak 10000, got %d", peak)
+	}
+
+	for range 200 {
+		peak = decayPeak(peak, 100, 0.95) // steady low activity
+	}
+	if peak != 100 {
+		t.Errorf("expected peak to decay back to steady level 100, got %d", peak)
+	}
+	if b := scaleBrightness(100, peak, BrightnessCurveGamma, 1.0); b != 255 {
+		t.Errorf("expected steady activity at full brightness after decay, got %d", b)
+	}
+
+	// A decay of 1 keeps the peak forever
+	if got := decayPeak(10000, 100, 1.0); got != 10000 {
+		t.Errorf("expected no decay with factor 1, got %d", got)
+	}
+}
+
+func TestBytesPerSecond(t *testing.T) {
+	tests := []struct {
+		bytes    uint64
+		interval time.Duration
+		want     uint64
+	}{
+		{1000, time.Second, 1000},
+		{1000, 100 * time.Millisecond, 10000},
+		{1000, 3 * time.Second, 333},
+		{1000, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := bytesPerSecond(tt.bytes, tt.interval); got != tt.want {
+			t.Errorf("bytesPerSecond(%d, %s) = %d, want %d", tt.bytes, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestDiskMaxBytesPerSec(t *testing.T) {
+	conf := &Config{PollInterval: 100 * time.Millisecond, IdleTicks: 1, BrightnessCurve: BrightnessCurveLinear, BrightnessSmoothing: 1, DiskMaxBytesPerSec: 1000000}
+	disk := DiskInfo{Name: "sda"}
+	am := newTestMonitor(t, disk)
+	am.maxActivity = 1 // ignored with a known maximum
+	brightness := func(bytes uint64, interval time.Duration) byte {
+		am.updateDiskLed(conf, time.Now(), interval, firstDiskLedIndex, disk, DiskActivity{Writes: bytes, Activity: bytes}, true, DiskMetrics{}, 0)
+		for _, state := range am.leds.LedStates() {
+			if state.Index == firstDiskLedIndex {
+				return state.Brightness
+			}
+		}
+		return 0
+	}
+
+	// 50000 bytes in 100ms is half of 1MB/s
+	if got, want := brightness(50000, 100*time.Millisecond), brightnessForLevel(0.5); got != want {
+		t.Errorf("brightness at half the maximum = %d, want %d", got, want)
+	}
+	// A late tick measures its bytes over the time it actually took
+	if got, want := brightness(100000, 200*time.Millisecond), brightnessForLevel(0.5); got != want {
+		t.Errorf("brightness at half the maximum over a late tick = %d, want %d", got, want)
+	}
+	if got := brightness(500000, 100*time.Millisecond); got != 255 {
+		t.Errorf("brightness above the maximum = %d, want 255", got)
+	}
+}
+
+func TestPulsePeriodMs(t *testing.T) {
+	tests := []struct {
+		level float64
+		want  int
+	}{
+		{0, 1000},
+		{0.24, 1000},
+		{0.25, 600},
+		{0.49, 600},
+		{0.5, 300},
+		{0.75, 150},
+		{1, 150},
+		{1.5, 150},
+		{-1, 1000},
+	}
+	for _, tt := range tests {
+		if got := pulsePeriodMs(tt.level); got != tt.want {
+			t.Errorf("pulsePeriodMs(%g) = %d, want %d", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestActiveModePulse(t *testing.T) {
+	conf := &Config{PollInterval: 100 * time.Millisecond, IdleTicks: 1, BrightnessCurve: BrightnessCurveLinear, BrightnessSmoothing: 1, ActiveMode: ActiveModePulse}
+	disk := DiskInfo{Name: "sda"}
+	am := newTestMonitor(t, disk)
+	am.maxActivity = 1000
+	status := func(activity uint64) leds.LedStatus {
+		am.updateDiskLed(conf, time.Now(), conf.PollInterval, firstDiskLedIndex, disk, DiskActivity{Writes: activity, Activity: activity}, true, DiskMetrics{}, 0)
+		status, err := am.leds.GetLedStatus(firstDiskLedIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	if got := status(100); got.OpMode != "blink" || got.TOn+got.TOff != 1000 {
+		t.Errorf("light activity = %+v, want a 1000ms blink", got)
+	}
+	if got := status(1000); got.OpMode != "blink" || got.TOn != 75 || got.TOff != 75 {
+		t.Errorf("peak activity = %+v, want a 75ms on, 75ms off blink", got)
+	}
+}
+
+func TestFormulaLevel(t *testing.T) {
+	formula := map[string]float64{MetricThroughput: 0.6, MetricQueue: 0.4}
+	metrics := DiskMetrics{Throughput: 100, Queue: 3}
+	peaks := map[string]float64{MetricThroughput: 200, MetricQueue: 4}
+
+	// 0.6*(100/200) + 0.4*(3/4)
+	want := 0.6*0.5 + 0.4*0.75
+	got := formulaLevel(formula, metrics, peaks)
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("expected level %v, got %v", want, got)
+	}
+	if b := brightnessForLevel(got); b != byte(32+math.Round(want*223)) {
+		t.Errorf("expected brightness %d, got %d", byte(32+math.Round(want*223)), b)
+	}
+
+	// Weights are normalized, so scaling them all leaves the level unchanged
+	scaled := map[string]float64{MetricThroughput: 6, MetricQueue: 4}
+	if got := formulaLevel(scaled, metrics, peaks); math.Abs(got-want) > 1e-9 {
+		t.Errorf("expected normalized level %v, got %v", want, got)
+	}
+
+	// Busy is already a fraction and is not normalized against a peak
+	busy := map[string]float64{MetricBusy: 1}
+	if got := formulaLevel(busy, DiskMetrics{Busy: 0.25}, peaks); got != 0.25 {
+		t.Errorf("expected busy level 0.25, got %v", got)
+	}
+}
+
+func TestDiskMetrics(t *testing.T) {
+	prev := DiskActivity{Activity: 100, ReadIOs: 10, WriteIOs: 10, ReadTicks: 20, WriteTicks: 20, IOTicks: 0, TimeInQueue: 0}
+	curr := DiskActivity{Activity: 300, ReadIOs: 15, WriteIOs: 15, ReadTicks: 40, WriteTicks: 40, IOTicks: 50, TimeInQueue: 200}
+
+	m := diskMetrics(prev, curr, 100*time.Millisecond)
+	if m.Throughput != 200 {
+		t.Errorf("expected throughput 200, got %v", m.Throughput)
+	}
+	if m.IOPS != 10 {
+		t.Errorf("expected iops 10, got %v", m.IOPS)
+	}
+	if m.Busy != 0.5 {
+		t.Errorf("expected busy 0.5, got %v", m.Busy)
+	}
+	if m.Queue != 2 {
+		t.Errorf("expected queue 2, got %v", m.Queue)
+	}
+	if m.Latency != 4 {
+		t.Errorf("expected latency 4, got %v", m.Latency)
+	}
+}
+
+func TestActivityMetricLevels(t *testing.T) {
+	devices := deviceSet([]string{"sda"})
+	prev := parseDiskStats([]byte("   8       0 sda 100 0 800 50 200 0 1600 70 0 300 400\n"), devices, make(map[string]DiskActivity))["sda"]
+	curr := parseDiskStats([]byte("   8       0 sda 150 0 1000 60 250 0 2000 90 2 550 700\n"), devices, make(map[string]DiskActivity))["sda"]
+	m := diskMetrics(prev, curr, time.Second)
+	peaks := map[string]float64{MetricIOPS: 200, MetricThroughput: 4 * 307200}
+
+	tests := []struct {
+		metric string
+		want   float64
+	}{
+		{ActivityMetricUtil, 0.25}, // 250ms of io_ticks in 1s
+		{ActivityMetricIOPS, 0.5},  // 100 completed I/Os against a peak of 200
+	}
+	for _, tt := range tests {
+		conf := &Config{ActivityMetric: tt.metric}
+		if got := formulaLevel(conf.activityFormula(), m, peaks); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: level = %v, want %v", tt.metric, got, tt.want)
+		}
+	}
+
+	if f := (&Config{ActivityMetric: ActivityMetricThroughput}).activityFormula(); f != nil {
+		t.Errorf("expected throughput to use the activity curve, got formula %v", f)
+	}
+	if m.Throughput != 307200 {
+		t.Errorf("expected throughput 307200 bytes, got %v", m.Throughput)
+	}
+	formula := map[string]float64{MetricQueue: 1}
+	if f := (&Config{ActivityMetric: ActivityMetricUtil, BrightnessFormula: formula}).activityFormula(); len(f) != 1 || f[MetricQueue] != 1 {
+		t.Errorf("expected brightness_formula to override activity_metric, got %v", f)
+	}
+}
+
+func TestValidateBrightnessFormula(t *testing.T) {
+	tests := []struct {
+		formula map[string]float64
+		ok      bool
+	}{
+		{nil, true},
+		{map[string]float64{MetricThroughput: 0.6, MetricQueue: 0.4}, true},
+		{map[string]float64{"bogus": 1}, false},
+		{map[string]float64{MetricIOPS: -1}, false},
+		{map[string]float64{MetricIOPS: math.NaN()}, false},
+		{map[string]float64{MetricIOPS: 0, MetricBusy: 0}, false},
+	}
+	for _, tt := range tests {
+		err := validateBrightnessFormula(tt.formula)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateBrightnessFormula(%v) error = %v, want ok=%v", tt.formula, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Activity sources
+const (
+	SourceDiskstats = "diskstats" // all I/O to each disk, from /proc/diskstats
+	SourceCgroup    = "cgroup"    // one cgroup's I/O, from its io.stat
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+const cgroupRoot = "sys/fs/cgroup"
+
+// activityReader reads the current cumulative counters of the monitored disks
+type activityReader interface {
+	Read() (map[string]DiskActivity, error)
+}
+
+// newActivityReader returns the reader for the configured source. A cgroup
+// that can't be read is warned about once; its disks then show no activity.
+func (am *ActivityMonitor) newActivityReader(conf *Config, disks []DiskInfo) activityReader {
+	if conf.Source != SourceCgroup {
+		return newDiskStatsReader(diskDevices(disks))
+	}
+	reader := newCgroupIOReader("/", conf.CgroupPath, diskDevices(disks))
+	if _, err := reader.Read(); err != nil {
+		am.warn("cgroup activity unavailable: %v", err)
+	}
+	return reader
+}
+
+// cgroupIOReader reads a cgroup's io.stat, attributing its I/O to disks by
+// device number
+type cgroupIOReader struct {
+	path    string
+	devices map[string]string // "major:minor" to device name
+}
+
+// newCgroupIOReader reads the io.stat of cgroup, a path under /sys/fs/cgroup
+// given with or without that prefix, with /sys rooted at root
+func newCgroupIOReader(root, cgroup string, devices []string) *cgroupIOReader {
+	cgroup = filepath.Clean("/" + cgroup)
+	if rest, ok := strings.CutPrefix(cgroup, "/"+cgroupRoot); ok && (rest == "" || rest[0] == '/') {
+		cgroup = rest
+	}
+	return &cgroupIOReader{
+		path:    filepath.Join(root, cgroupRoot, cgroup, "io.stat"),
+		devices: blockDevNumbers(root, devices),
+	}
+}
+
+// Read returns the cgroup's counters for each disk. Disks the cgroup hasn't
+// touched read as zero rather than missing, so their LEDs show idle.
+func (r *cgroupIOReader) Read() (map[string]DiskActivity, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return make(map[string]DiskActivity), err
+	}
+	return parseIOStat(data, r.devices), nil
+}
+
+// blockDevNumbers maps the "major:minor" of each device, from
+// /sys/block/<dev>/dev, to its name. Devices without one are left out.
+func blockDevNumbers(root string, devices []string) map[string]string {
+	numbers := make(map[string]string, len(devices))
+	for _, dev := range devices {
+		data, err := os.ReadFile(filepath.Join(root, "sys/block", dev, "dev"))
+		if err != nil {
+			continue
+		}
+		numbers[strings.TrimSpace(string(data))] = dev
+	}
+	return numbers
+}
+
+// parseIOStat extracts the counters of the given devices from cgroup v2
+// io.stat data, lines like "8:0 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0".
+// io.stat has no time or queue counters, so those stay zero.
+func parseIOStat(data []byte, devices map[string]string) map[string]DiskActivity {
+	stats := make(map[string]DiskActivity, len(devices))
+	for _, dev := range devices {
+		stats[dev] = DiskActivity{}
+	}
+	for line := range bytes.Lines(data) {
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		dev, ok := devices[string(fields[0])]
+		if !ok {
+			continue
+		}
+		var a DiskActivity
+		for _, field := range fields[1:] {
+			key, value, ok := bytes.Cut(field, []byte("="))
+			if !ok {
+				continue
+			}
+			switch string(key) {
+			case "rbytes":
+				a.Reads = parseUintBytes(value)
+			case "wbytes":
+				a.Writes = parseUintBytes(value)
+			case "rios":
+				a.ReadIOs = parseUintBytes(value)
+			case "wios":
+				a.WriteIOs = parseUintBytes(value)
+			}
+		}
+		a.Activity = a.Reads + a.Writes
+		stats[dev] = a
+	}
+	return stats
+}
+
+// validateCgroupSource checks the cgroup options when source is cgroup
+func validateCgroupSource(source, cgroup string) error {
+	if source == SourceCgroup && cgroup == "" {
+		return fmt.Errorf("source %s requires cgroup_path", SourceCgroup)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const sampleIOStat = `8:16 rbytes=1048576 wbytes=4096 rios=16 wios=1 dbytes=0 dios=0
+8:0 rbytes=512 wbytes=2097152 rios=1 wios=32 dbytes=0 dios=0
+259:0 rbytes=99 wbytes=99 rios=1 wios=1 dbytes=0 dios=0
+`
+
+func TestParseIOStat(t *testing.T) {
+	devices := map[string]string{"8:0": "sda", "8:16": "sdb", "8:32": "sdc"}
+	got := parseIOStat([]byte(sampleIOStat), devices)
+	want := map[string]DiskActivity{
+		"sda": {Reads: 512, Writes: 2097152, Activity: 2097664, ReadIOs: 1, WriteIOs: 32},
+		"sdb": {Reads: 1048576, Writes: 4096, Activity: 1052672, ReadIOs: 16, WriteIOs: 1},
+		"sdc": {}, // untouched by the cgroup, still present
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseIOStat = %+v, want %+v", got, want)
+	}
+	for dev, w := range want {
+		if got[dev] != w {
+			t.Errorf("%s = %+v, want %+v", dev, got[dev], w)
+		}
+	}
+}
+
+func TestCgroupIOReader(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, data string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("sys/block/sda/dev", "8:0\n")
+	write("sys/block/sdb/dev", "8:16\n")
+	write("sys/fs/cgroup/system.slice/docker.service/io.stat", sampleIOStat)
+
+	for _, cgroup := range []string{"system.slice/docker.service", "/sys/fs/cgroup/system.slice/docker.service"} {
+		stats, err := newCgroupIOReader(root, cgroup, []string{"sda", "sdb", "sdz"}).Read()
+		if err != nil {
+			t.Fatalf("Read(%s): %v", cgroup, err)
+		}
+		if stats["sda"].Writes != 2097152 || stats["sdb"].Reads != 1048576 {
+			t.Errorf("Read(%s) = %+v", cgroup, stats)
+		}
+		if _, ok := stats["sdz"]; ok {
+			t.Errorf("Read(%s) reported sdz, which has no device number", cgroup)
+		}
+	}
+
+	if _, err := newCgroupIOReader(root, "missing.slice", []string{"sda"}).Read(); err == nil {
+		t.Error("expected an error for a cgroup without io.stat")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+const defaultSetPeriodMs = 1000
+
+// LedSetting is a one-shot LED change parsed from --set
+type LedSetting struct {
+	ID         int
+	Mode       byte
+	Color      *[3]byte
+	Brightness *byte
+	PeriodMs   int // blink/breath period
+}
+
+var ledModeNames = map[string]byte{
+	"off":    leds.LedModeOff,
+	"on":     leds.LedModeOn,
+	"blink":  leds.LedModeBlink,
+	"breath": leds.LedModeBreath,
+}
+
+// ledIndexByName returns the LED index for a name like "power" or "disk1"
+func ledIndexByName(name string) (int, error) {
+	for i, n := range leds.LedNames {
+		if n == strings.ToLower(name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown LED %q (valid: %s)", name, strings.Join(leds.LedNames, ", "))
+}
+
+// parseLedSetting parses "<led>:<mode>[:<r,g,b>][:brightness=<n>][:period=<ms>]",
+// e.g. "disk1:on:255,0,0:brightness=128"
+func parseLedSetting(spec string) (LedSetting, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 {
+		return LedSetting{}, fmt.Errorf("invalid LED setting %q: expected <led>:<mode>[:<r,g,b>][:brightness=<n>][:period=<ms>]", spec)
+	}
+
+	id, err := ledIndexByName(parts[0])
+	if err != nil {
+		return LedSetting{}, err
+	}
+	mode, ok := ledModeNames[strings.ToLower(parts[1])]
+	if !ok {
+		return LedSetting{}, fmt.Errorf("unknown mode %q (valid: off, on, blink, breath)", parts[1])
+	}
+	setting := LedSetting{ID: id, Mode: mode, PeriodMs: defaultSetPeriodMs}
+
+	for _, part := range parts[2:] {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			color, err := parseRGB(part)
+			if err != nil {
+				return LedSetting{}, err
+			}
+			setting.Color = &color
+			continue
+		}
+		switch key {
+		case "brightness":
+			v, err := parseByte("brightness", value)
+			if err != nil {
+				return LedSetting{}, err
+			}
+			setting.Brightness = &v
+		case "period":
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 2 || v > leds.MaxTimingMs {
+				return LedSetting{}, fmt.Errorf("invalid period %q: must be 2-65535 ms", value)
+			}
+			setting.PeriodMs = v
+		default:
+			return LedSetting{}, fmt.Errorf("unknown option %q (valid: brightness, period)", key)
+		}
+	}
+	return setting, nil
+}
+
+// parseRGB parses "r,g,b" with each channel in 0-255
+func parseRGB(s string) ([3]byte, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return [3]byte{}, fmt.Errorf("invalid color %q: expected r,g,b", s)
+	}
+	var color [3]byte
+	for i, name := range []string{"red", "green", "blue"} {
+		v, err := parseByte(name, strings.TrimSpace(parts[i]))
+		if err != nil {
+			return [3]byte{}, err
+		}
+		color[i] = v
+	}
+	return color, nil
+}
+
+func parseByte(name, s string) (byte, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 255 {
+		return 0, fmt.Errorf("invalid %s %q: must be 0-255", name, s)
+	}
+	return byte(v), nil
+}
+
+// applyLedSetting writes a parsed setting: color and brightness first, then mode
+func applyLedSetting(controller *leds.UGreenLeds, setting LedSetting) error {
+	if setting.Color != nil {
+		if err := controller.SetLedColor(setting.ID, setting.Color[0], setting.Color[1], setting.Color[2]); err != nil {
+			return fmt.Errorf("error setting color: %w", err)
+		}
+	}
+	if setting.Brightness != nil {
+		if err := controller.SetLedBrightness(setting.ID, *setting.Brightness); err != nil {
+			return fmt.Errorf("error setting brightness: %w", err)
+		}
+	}
+	var params []byte
+	if setting.Mode == leds.LedModeBlink || setting.Mode == leds.LedModeBreath {
+		params = leds.BreathParams(setting.PeriodMs)
+	}
+	if err := controller.SetLedMode(setting.ID, setting.Mode, params); err != nil {
+		return fmt.Errorf("error setting mode: %w", err)
+	}
+	return nil
+}
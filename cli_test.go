@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestParseLedSetting(t *testing.T) {
+	setting, err := parseLedSetting("disk1:on:255,0,0:brightness=128")
+	if err != nil {
+		t.Fatalf("parseLedSetting: %v", err)
+	}
+	if setting.ID != 2 {
+		t.Errorf("expected disk1 at LED 2, got %d", setting.ID)
+	}
+	if setting.Mode != leds.LedModeOn {
+		t.Errorf("expected mode on, got %d", setting.Mode)
+	}
+	if setting.Color == nil || *setting.Color != [3]byte{255, 0, 0} {
+		t.Errorf("expected color 255,0,0, got %v", setting.Color)
+	}
+	if setting.Brightness == nil || *setting.Brightness != 128 {
+		t.Errorf("expected brightness 128, got %v", setting.Brightness)
+	}
+
+	setting, err = parseLedSetting("LAN:blink:period=400")
+	if err != nil {
+		t.Fatalf("parseLedSetting: %v", err)
+	}
+	if setting.ID != 1 || setting.Mode != leds.LedModeBlink || setting.PeriodMs != 400 {
+		t.Errorf("unexpected setting %+v", setting)
+	}
+	if setting.Color != nil || setting.Brightness != nil {
+		t.Errorf("expected color and brightness unset, got %+v", setting)
+	}
+}
+
+func TestParseLedSettingErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"disk1", "expected <led>:<mode>"},
+		{"disk9:on", "unknown LED \"disk9\""},
+		{"disk1:flash", "unknown mode \"flash\""},
+		{"disk1:on:256,0,0", "invalid red \"256\""},
+		{"disk1:on:1,2", "invalid color \"1,2\""},
+		{"disk1:on:brightness=300", "invalid brightness \"300\""},
+		{"disk1:blink:period=0", "invalid period \"0\""},
+		{"disk1:on:speed=3", "unknown option \"speed\""},
+	}
+	for _, tt := range tests {
+		_, err := parseLedSetting(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseLedSetting(%q) error = %v, want containing %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestApplyLedSetting(t *testing.T) {
+	transport := leds.NewFakeTransport()
+	controller := leds.NewUGreenLedsWithTransport(transport)
+
+	setting, err := parseLedSetting("power:on:0,255,0:brightness=64")
+	if err != nil {
+		t.Fatalf("parseLedSetting: %v", err)
+	}
+	if err := applyLedSetting(controller, setting); err != nil {
+		t.Fatalf("applyLedSetting: %v", err)
+	}
+	status, _ := transport.ReadStatus(0)
+	if status.OpMode != "on" || status.ColorG != 255 || status.Brightness != 64 {
+		t.Errorf("unexpected status after apply: %+v", status)
+	}
+}
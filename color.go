@@ -0,0 +1,305 @@
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Color modes for active disks
+const (
+	ColorModeWhite   = "white"
+	ColorModeRWBlend = "rw_blend"
+	ColorModeHSV     = "hsv"
+	ColorModePerDisk = "per_disk"
+	ColorModeHashed  = "hashed" // a fixed color per disk from its serial
+
+	ColorModeTrafficLight = "traffic_light" // green, yellow, or red by the read/write mix
+)
+
+// Traffic light colors
+var (
+	trafficLightGreen  = [3]byte{0, 255, 0}
+	trafficLightYellow = [3]byte{255, 255, 0}
+	trafficLightRed    = [3]byte{255, 0, 0}
+)
+
+// defaultDiskPalette colors disk1 through disk8 in per_disk mode when
+// disk_colors doesn't list them
+var defaultDiskPalette = [][3]byte{
+	{255, 0, 0},     // red
+	{0, 255, 0},     // green
+	{0, 0, 255},     // blue
+	{255, 255, 0},   // yellow
+	{255, 0, 255},   // magenta
+	{0, 255, 255},   // cyan
+	{255, 128, 0},   // orange
+	{255, 255, 255}, // white
+}
+
+// Default colors of pure reads and pure writes
+var (
+	defaultReadColor  = [3]byte{0, 0, 255} // blue
+	defaultWriteColor = [3]byte{255, 0, 0} // red
+)
+
+// ColorOptions controls how disk activity maps to a color
+type ColorOptions struct {
+	Mode   string
+	SwapRW bool // reads red and writes blue instead of the reverse
+
+	// Emphasis exaggerates the dominant side of the read/write mix; 1 is
+	// proportional, higher values push mixed traffic toward pure red or blue
+	Emphasis float64
+
+	// ReadColor and WriteColor are the colors of pure reads and pure
+	// writes; nil for blue and red
+	ReadColor  *[3]byte
+	WriteColor *[3]byte
+
+	// ReadHeavy and WriteHeavy are the shares of reads and of writes at
+	// which traffic_light shows green and red
+	ReadHeavy  float64
+	WriteHeavy float64
+}
+
+// rwColors returns the colors of pure reads and pure writes
+func (o ColorOptions) rwColors() (read, write [3]byte) {
+	read, write = defaultReadColor, defaultWriteColor
+	if o.ReadColor != nil {
+		read = *o.ReadColor
+	}
+	if o.WriteColor != nil {
+		write = *o.WriteColor
+	}
+	return read, write
+}
+
+// colorOptions returns the configured color options
+func (c *Config) colorOptions() ColorOptions {
+	return ColorOptions{
+		Mode: c.ColorMode, SwapRW: c.SwapRWColors, Emphasis: c.ColorEmphasis, ReadColor: c.readColor, WriteColor: c.writeColor,
+		ReadHeavy: c.TrafficLightReadRatio, WriteHeavy: c.TrafficLightWriteRatio,
+	}
+}
+
+// colorForActivity returns the color of an active LED from its read and
+// write activity. level is the overall activity in 0..1, used as the HSV value,
+// and green is the green channel contribution in 0..1.
+//
+//   - white: always white
+//   - rw_blend: blends from the read color (blue) to the write color (red)
+//     by the share of writes, with green added to the green channel
+//   - hsv: hue sweeps from the read color's hue (blue) to the write color's
+//     (red), through green by default
+//   - traffic_light: green for mostly reads, red for mostly writes, yellow
+//     in between, see trafficLightColor
+func colorForActivity(reads, writes uint64, level, green float64, opts ColorOptions) (r, g, b byte) {
+	if opts.SwapRW {
+		reads, writes = writes, reads
+	}
+	total := reads + writes
+	if total == 0 {
+		return 255, 255, 255
+	}
+	writeRatio := emphasize(float64(writes)/float64(total), opts.Emphasis)
+
+	switch opts.Mode {
+	case ColorModeRWBlend:
+		read, write := opts.rwColors()
+		var c [3]float64
+		for i := range c {
+			c[i] = float64(read[i]) + (float64(write[i])-float64(read[i]))*writeRatio
+		}
+		c[1] += math.Max(0, math.Min(green, 1)) * 255
+		return channel(c[0]), channel(c[1]), channel(c[2])
+	case ColorModeHSV:
+		read, write := opts.rwColors()
+		readHue, _, _ := rgbToHsv(read[0], read[1], read[2])
+		writeHue, _, _ := rgbToHsv(write[0], write[1], write[2])
+		hue := readHue + (writeHue-readHue)*writeRatio
+		return hsvToRgb(hue, 1.0, math.Max(0, math.Min(level, 1)))
+	case ColorModeTrafficLight:
+		c := trafficLightColor(reads, writes, opts.ReadHeavy, opts.WriteHeavy)
+		return c[0], c[1], c[2]
+	}
+	return 255, 255, 255
+}
+
+// trafficLightColor returns green when reads are at least readHeavy of the
+// traffic, red when writes are at least writeHeavy, and yellow otherwise
+func trafficLightColor(reads, writes uint64, readHeavy, writeHeavy float64) [3]byte {
+	total := float64(reads + writes)
+	switch {
+	case float64(reads)/total >= readHeavy:
+		return trafficLightGreen
+	case float64(writes)/total >= writeHeavy:
+		return trafficLightRed
+	}
+	return trafficLightYellow
+}
+
+// channel rounds a color channel and clamps it to 0..255
+func channel(v float64) byte {
+	return byte(math.Round(math.Max(0, math.Min(v, 255))))
+}
+
+// tintColor mixes tint into color, strength 0 keeping color and 1 replacing it
+func tintColor(color, tint [3]byte, strength float64) [3]byte {
+	var mixed [3]byte
+	for i := range mixed {
+		mixed[i] = channel(float64(color[i])*(1-strength) + float64(tint[i])*strength)
+	}
+	return mixed
+}
+
+// parseColor parses "#RRGGBB" or "r,g,b"
+func parseColor(s string) ([3]byte, error) {
+	if hexColor, ok := strings.CutPrefix(s, "#"); ok {
+		b, err := hex.DecodeString(hexColor)
+		if err != nil || len(b) != 3 {
+			return [3]byte{}, fmt.Errorf("invalid color %q: expected #RRGGBB", s)
+		}
+		return [3]byte{b[0], b[1], b[2]}, nil
+	}
+	return parseRGB(s)
+}
+
+// parseOptionalColor parses the color option name, returning nil when unset
+func parseOptionalColor(name, s string) (*[3]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	color, err := parseColor(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &color, nil
+}
+
+// parseDiskColors parses disk_colors, keyed by disk number (1 for disk1)
+// or disk serial
+func parseDiskColors(colors map[string]string) (map[string][3]byte, error) {
+	parsed := make(map[string][3]byte, len(colors))
+	for key, value := range colors {
+		if key == "" {
+			return nil, fmt.Errorf("disk_colors: empty disk")
+		}
+		if n, err := strconv.Atoi(key); err == nil && (n < 1 || n > len(defaultDiskPalette)) {
+			return nil, fmt.Errorf("disk_colors: disk number %d out of range (valid range: 1-%d)", n, len(defaultDiskPalette))
+		}
+		color, err := parseColor(value)
+		if err != nil {
+			return nil, fmt.Errorf("disk_colors: %s: %w", key, err)
+		}
+		parsed[key] = color
+	}
+	return parsed, nil
+}
+
+// diskColor returns the fixed per_disk color of the disk driving ledIndex:
+// its serial's entry in disk_colors, else its disk number's, else the
+// default palette
+func (c *Config) diskColor(ledIndex int, disk DiskInfo) [3]byte {
+	if color, ok := c.diskColors[disk.Serial]; ok && disk.Serial != "" {
+		return color
+	}
+	n := ledIndex - firstDiskLedIndex + 1
+	if color, ok := c.diskColors[strconv.Itoa(n)]; ok {
+		return color
+	}
+	return defaultDiskPalette[(n-1+len(defaultDiskPalette))%len(defaultDiskPalette)]
+}
+
+// hashedHues is the number of evenly spaced hues hashed mode picks from,
+// 30 degrees apart
+const hashedHues = 12
+
+// hashedDiskColors returns the hashed mode color of each disk by name. The
+// FNV-1a hash of a disk's serial, or its name without one, picks one of
+// hashedHues hues, so a disk keeps its color across reboots. Disks whose hue
+// is taken move to the next free one, in serial order, then name order for
+// disks with the same serial, so the colors stay distinct and the same disks
+// always get the same ones. Adding a disk can still change another's color,
+// when the new disk sorts first and takes the hue it had.
+func hashedDiskColors(disks []DiskInfo) map[string][3]byte {
+	type keyed struct{ key, name string }
+	keys := make([]keyed, len(disks))
+	for i, disk := range disks {
+		keys[i] = keyed{disk.Serial, disk.Name}
+		if keys[i].key == "" {
+			keys[i].key = disk.Name
+		}
+	}
+	slices.SortFunc(keys, func(a, b keyed) int {
+		return cmp.Or(cmp.Compare(a.key, b.key), cmp.Compare(a.name, b.name))
+	})
+
+	colors := make(map[string][3]byte, len(disks))
+	var taken [hashedHues]bool
+	for i, k := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(k.key))
+		hue := int(h.Sum32() % hashedHues)
+		if i < hashedHues { // with more disks than hues, some must share
+			for taken[hue] {
+				hue = (hue + 1) % hashedHues
+			}
+		}
+		taken[hue] = true
+		r, g, b := hsvToRgb(float64(hue)/hashedHues, 1, 1)
+		colors[k.name] = [3]byte{r, g, b}
+	}
+	return colors
+}
+
+// hashedColor returns the hashed mode color of disk, assigning colors for
+// the current disks on first use after they change
+func (am *ActivityMonitor) hashedColor(disk DiskInfo) [3]byte {
+	if am.hashedColors == nil {
+		am.hashedColors = hashedDiskColors(am.disks)
+	}
+	return am.hashedColors[disk.Name]
+}
+
+// emphasize applies exponent to both sides of ratio and renormalizes, so an
+// exponent above 1 pushes a 70/30 split toward the dominant side
+func emphasize(ratio, exponent float64) float64 {
+	if exponent <= 0 || exponent == 1 {
+		return ratio
+	}
+	a := math.Pow(ratio, exponent)
+	b := math.Pow(1-ratio, exponent)
+	return a / (a + b)
+}
+
+// rgbToHsv converts RGB (0..255) to HSV values (h in 0..1, s/v in 0..1)
+func rgbToHsv(r, g, b byte) (h, s, v float64) {
+	rr, gg, bb := float64(r)/255, float64(g)/255, float64(b)/255
+	maxC := math.Max(rr, math.Max(gg, bb))
+	minC := math.Min(rr, math.Min(gg, bb))
+	v = maxC
+	d := maxC - minC
+	if maxC == 0 || d == 0 {
+		return 0, 0, v
+	}
+	s = d / maxC
+	switch maxC {
+	case rr:
+		h = math.Mod((gg-bb)/d, 6)
+	case gg:
+		h = (bb-rr)/d + 2
+	default:
+		h = (rr-gg)/d + 4
+	}
+	h /= 6
+	if h < 0 {
+		h++
+	}
+	return h, s, v
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHsvToRgbPrimaries(t *testing.T) {
+	tests := []struct {
+		degrees float64
+		want    [3]byte
+	}{
+		{0, [3]byte{255, 0, 0}},
+		{60, [3]byte{255, 255, 0}},
+		{120, [3]byte{0, 255, 0}},
+		{180, [3]byte{0, 255, 255}},
+		{240, [3]byte{0, 0, 255}},
+		{300, [3]byte{255, 0, 255}},
+	}
+	for _, tt := range tests {
+		r, g, b := hsvToRgb(tt.degrees/360, 1, 1)
+		if got := [3]byte{r, g, b}; got != tt.want {
+			t.Errorf("hsvToRgb(%v°) = %v, want %v", tt.degrees, got, tt.want)
+		}
+	}
+	if r, g, b := hsvToRgb(0.5, 0, 1); [3]byte{r, g, b} != [3]byte{255, 255, 255} {
+		t.Errorf("expected zero saturation to be white, got %v,%v,%v", r, g, b)
+	}
+}
+
+func TestHsvRoundTrip(t *testing.T) {
+	for h := 0.0; h < 1; h += 0.05 {
+		for _, sv := range [][2]float64{{1, 1}, {0.5, 0.8}, {0.25, 0.5}} {
+			r, g, b := hsvToRgb(h, sv[0], sv[1])
+			r2, g2, b2 := hsvToRgb(rgbToHsv(r, g, b))
+			if absDiff(r, r2) > 1 || absDiff(g, g2) > 1 || absDiff(b, b2) > 1 {
+				t.Errorf("round trip of h=%.2f s=%.2f v=%.2f: %v,%v,%v -> %v,%v,%v", h, sv[0], sv[1], r, g, b, r2, g2, b2)
+			}
+		}
+	}
+}
+
+func absDiff(a, b byte) int {
+	return int(math.Abs(float64(a) - float64(b)))
+}
+
+func TestColorForActivity(t *testing.T) {
+	tests := []struct {
+		mode          string
+		reads, writes uint64
+		level         float64
+		want          [3]byte
+	}{
+		{ColorModeWhite, 10, 90, 1, [3]byte{255, 255, 255}},
+		{ColorModeRWBlend, 0, 0, 1, [3]byte{255, 255, 255}}, // no traffic: white
+		{ColorModeHSV, 0, 0, 1, [3]byte{255, 255, 255}},
+		{ColorModeRWBlend, 100, 0, 1, [3]byte{0, 0, 255}},
+		{ColorModeRWBlend, 0, 100, 1, [3]byte{255, 0, 0}},
+		{ColorModeRWBlend, 50, 50, 1, [3]byte{128, 0, 128}},
+		{ColorModeHSV, 100, 0, 1, [3]byte{0, 0, 255}},   // all reads: blue
+		{ColorModeHSV, 0, 100, 1, [3]byte{255, 0, 0}},   // all writes: red
+		{ColorModeHSV, 50, 50, 1, [3]byte{0, 255, 0}},   // balanced: green
+		{ColorModeHSV, 0, 100, 0.5, [3]byte{127, 0, 0}}, // value follows level
+	}
+	for _, tt := range tests {
+		r, g, b := colorForActivity(tt.reads, tt.writes, tt.level, 0, ColorOptions{Mode: tt.mode})
+		if got := [3]byte{r, g, b}; got != tt.want {
+			t.Errorf("colorForActivity(%d, %d, %v, %s) = %v, want %v", tt.reads, tt.writes, tt.level, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestColorForActivitySwapRW(t *testing.T) {
+	tests := []struct {
+		mode          string
+		swap          bool
+		reads, writes uint64
+		want          [3]byte
+	}{
+		{ColorModeRWBlend, false, 100, 0, [3]byte{0, 0, 255}},
+		{ColorModeRWBlend, true, 100, 0, [3]byte{255, 0, 0}}, // reads red
+		{ColorModeRWBlend, false, 0, 100, [3]byte{255, 0, 0}},
+		{ColorModeRWBlend, true, 0, 100, [3]byte{0, 0, 255}}, // writes blue
+		{ColorModeHSV, false, 100, 0, [3]byte{0, 0, 255}},
+		{ColorModeHSV, true, 100, 0, [3]byte{255, 0, 0}},
+		{ColorModeHSV, true, 0, 100, [3]byte{0, 0, 255}},
+	}
+	for _, tt := range tests {
+		r, g, b := colorForActivity(tt.reads, tt.writes, 1, 0, ColorOptions{Mode: tt.mode, SwapRW: tt.swap})
+		if got := [3]byte{r, g, b}; got != tt.want {
+			t.Errorf("colorForActivity(%d, %d, %s, swap=%v) = %v, want %v", tt.reads, tt.writes, tt.mode, tt.swap, got, tt.want)
+		}
+	}
+}
+
+func TestColorForActivityRWColors(t *testing.T) {
+	blue, red := [3]byte{0, 0, 255}, [3]byte{255, 0, 0}
+	green, yellow := [3]byte{0, 255, 0}, [3]byte{255, 200, 0}
+	tests := []struct {
+		mode          string
+		read, write   *[3]byte
+		reads, writes uint64
+		want          [3]byte
+	}{
+		// Explicit blue and red match the defaults
+		{ColorModeRWBlend, &blue, &red, 100, 0, [3]byte{0, 0, 255}},
+		{ColorModeRWBlend, &blue, &red, 0, 100, [3]byte{255, 0, 0}},
+		{ColorModeRWBlend, &blue, &red, 50, 50, [3]byte{128, 0, 128}},
+		{ColorModeHSV, &blue, &red, 50, 50, [3]byte{0, 255, 0}},
+		// Reads green, writes yellow
+		{ColorModeRWBlend, &green, &yellow, 100, 0, [3]byte{0, 255, 0}},
+		{ColorModeRWBlend, &green, &yellow, 0, 100, [3]byte{255, 200, 0}},
+		{ColorModeRWBlend, &green, &yellow, 50, 50, [3]byte{128, 228, 0}},
+		{ColorModeHSV, &green, &yellow, 100, 0, [3]byte{0, 255, 0}},
+		{ColorModeHSV, &green, &yellow, 50, 50, [3]byte{155, 255, 0}}, // hue halfway, 120° to 47°
+		// Only one color set: the other keeps its default
+		{ColorModeRWBlend, &green, nil, 0, 100, [3]byte{255, 0, 0}},
+	}
+	for _, tt := range tests {
+		r, g, b := colorForActivity(tt.reads, tt.writes, 1, 0, ColorOptions{Mode: tt.mode, ReadColor: tt.read, WriteColor: tt.write})
+		if got := [3]byte{r, g, b}; got != tt.want {
+			t.Errorf("colorForActivity(%d, %d, %s, %v, %v) = %v, want %v", tt.reads, tt.writes, tt.mode, tt.read, tt.write, got, tt.want)
+		}
+	}
+}
+
+func TestTrafficLightColor(t *testing.T) {
+	tests := []struct {
+		reads, writes         uint64
+		readHeavy, writeHeavy float64
+		want                  [3]byte
+	}{
+		{100, 0, 0.75, 0.75, trafficLightGreen},
+		{75, 25, 0.75, 0.75, trafficLightGreen}, // at the read threshold
+		{74, 26, 0.75, 0.75, trafficLightYellow},
+		{50, 50, 0.75, 0.75, trafficLightYellow},
+		{26, 74, 0.75, 0.75, trafficLightYellow},
+		{25, 75, 0.75, 0.75, trafficLightRed}, // at the write threshold
+		{0, 100, 0.75, 0.75, trafficLightRed},
+		{50, 50, 0.5, 0.5, trafficLightGreen}, // both thresholds met: reads win
+		{60, 40, 0.9, 0.6, trafficLightYellow},
+		{40, 60, 0.9, 0.6, trafficLightRed},
+	}
+	for _, tt := range tests {
+		if got := trafficLightColor(tt.reads, tt.writes, tt.readHeavy, tt.writeHeavy); got != tt.want {
+			t.Errorf("trafficLightColor(%d, %d, %g, %g) = %v, want %v", tt.reads, tt.writes, tt.readHeavy, tt.writeHeavy, got, tt.want)
+		}
+	}
+
+	opts := ColorOptions{Mode: ColorModeTrafficLight, ReadHeavy: 0.67, WriteHeavy: 0.67}
+	if r, g, b := colorForActivity(90, 10, 0.1, 0, opts); [3]byte{r, g, b} != trafficLightGreen {
+		t.Errorf("read-heavy traffic light = %v, want green", [3]byte{r, g, b})
+	}
+	opts.SwapRW = true
+	if r, g, b := colorForActivity(90, 10, 0.1, 0, opts); [3]byte{r, g, b} != trafficLightRed {
+		t.Errorf("swapped read-heavy traffic light = %v, want red", [3]byte{r, g, b})
+	}
+}
+
+func TestParseOptionalColor(t *testing.T) {
+	if color, err := parseOptionalColor("read_color", ""); color != nil || err != nil {
+		t.Errorf("unset: got %v, %v, want nil", color, err)
+	}
+	if color, err := parseOptionalColor("read_color", "#00FF00"); err != nil || *color != [3]byte{0, 255, 0} {
+		t.Errorf("#00FF00: got %v, %v", color, err)
+	}
+	if _, err := parseOptionalColor("write_color", "yellow"); err == nil || !strings.HasPrefix(err.Error(), "write_color: ") {
+		t.Errorf("expected a write_color error, got %v", err)
+	}
+}
+
+func TestColorForActivityGreen(t *testing.T) {
+	opts := ColorOptions{Mode: ColorModeRWBlend}
+	if r, g, b := colorForActivity(0, 100, 1, 0.5, opts); r != 255 || g != 128 || b != 0 {
+		t.Errorf("expected (255,128,0) with half green, got (%d,%d,%d)", r, g, b)
+	}
+	if _, g, _ := colorForActivity(0, 100, 1, 2, opts); g != 255 {
+		t.Errorf("expected green clamped to 255, got %d", g)
+	}
+	if r, g, b := colorForActivity(0, 100, 1, 1, ColorOptions{Mode: ColorModeHSV}); r != 255 || g != 0 || b != 0 {
+		t.Errorf("expected hsv to ignore green, got (%d,%d,%d)", r, g, b)
+	}
+}
+
+func TestColorEmphasis(t *testing.T) {
+	// 70% reads, 30% writes
+	r, _, b := colorForActivity(70, 30, 1, 0, ColorOptions{Mode: ColorModeRWBlend})
+	if r != 77 || b != 179 {
+		t.Errorf("expected proportional (77, 179) without emphasis, got (%d, %d)", r, b)
+	}
+
+	r, _, b = colorForActivity(70, 30, 1, 0, ColorOptions{Mode: ColorModeRWBlend, Emphasis: 3})
+	if b < 5*r {
+		t.Errorf("expected a clearly blue-dominant color with emphasis 3, got r=%d b=%d", r, b)
+	}
+
+	// Pure and balanced traffic are unchanged
+	for _, tt := range []struct {
+		reads, writes uint64
+		want          [3]byte
+	}{
+		{100, 0, [3]byte{0, 0, 255}},
+		{0, 100, [3]byte{255, 0, 0}},
+		{50, 50, [3]byte{128, 0, 128}},
+	} {
+		r, g, b := colorForActivity(tt.reads, tt.writes, 1, 0, ColorOptions{Mode: ColorModeRWBlend, Emphasis: 3})
+		if got := [3]byte{r, g, b}; got != tt.want {
+			t.Errorf("colorForActivity(%d, %d) with emphasis = %v, want %v", tt.reads, tt.writes, got, tt.want)
+		}
+	}
+}
+
+func TestEmphasizeMonotonic(t *testing.T) {
+	prev := -1.0
+	for i := 0; i <= 100; i++ {
+		v := emphasize(float64(i)/100, 3)
+		if v < prev || v < 0 || v > 1 {
+			t.Fatalf("emphasize(%v, 3) = %v after %v", float64(i)/100, v, prev)
+		}
+		prev = v
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    [3]byte
+		wantErr bool
+	}{
+		{"#FF8000", [3]byte{255, 128, 0}, false},
+		{"#00ff7f", [3]byte{0, 255, 127}, false},
+		{"255,128,0", [3]byte{255, 128, 0}, false},
+		{"0, 64, 255", [3]byte{0, 64, 255}, false},
+		{"#FF80", [3]byte{}, true},
+		{"#FF80000", [3]byte{}, true},
+		{"#GG0000", [3]byte{}, true},
+		{"FF8000", [3]byte{}, true},
+		{"256,0,0", [3]byte{}, true},
+		{"1,2", [3]byte{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseColor(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseColor(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseColor(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseDiskColors(t *testing.T) {
+	for _, colors := range []map[string]string{
+		{"0": "#FF0000"},
+		{"9": "#FF0000"},
+		{"": "#FF0000"},
+		{"1": "red"},
+	} {
+		if _, err := parseDiskColors(colors); err == nil {
+			t.Errorf("parseDiskColors(%v): expected an error", colors)
+		}
+	}
+}
+
+func TestDiskColor(t *testing.T) {
+	diskColors, err := parseDiskColors(map[string]string{
+		"2":      "#00FF00",
+		"WD-123": "10,20,30",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &Config{diskColors: diskColors}
+
+	tests := []struct {
+		name     string
+		ledIndex int
+		disk     DiskInfo
+		want     [3]byte
+	}{
+		{"serial", firstDiskLedIndex, DiskInfo{Serial: "WD-123"}, [3]byte{10, 20, 30}},
+		{"serial beats number", firstDiskLedIndex + 1, DiskInfo{Serial: "WD-123"}, [3]byte{10, 20, 30}},
+		{"number", firstDiskLedIndex + 1, DiskInfo{Serial: "WD-456"}, [3]byte{0, 255, 0}},
+		{"default palette", firstDiskLedIndex + 2, DiskInfo{}, defaultDiskPalette[2]},
+	}
+	for _, tt := range tests {
+		if got := conf.diskColor(tt.ledIndex, tt.disk); got != tt.want {
+			t.Errorf("%s: diskColor() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNvmeColorTint(t *testing.T) {
+	tint := [3]byte{0, 255, 0}
+	conf := &Config{PollInterval: 100 * time.Millisecond, IdleTicks: 1, BrightnessCurve: BrightnessCurveLinear, BrightnessSmoothing: 1, nvmeColorTint: &tint, NvmeTintStrength: 0.5}
+	sata := DiskInfo{Name: "sda", Type: DiskTypeSATA}
+	nvme := DiskInfo{Name: "nvme0n1", Type: DiskTypeNVMe}
+	am := newTestMonitor(t, sata, nvme)
+	am.maxActivity = 1000
+	color := func(ledIndex int, disk DiskInfo) [3]byte {
+		am.updateDiskLed(conf, time.Now(), conf.PollInterval, ledIndex, disk, DiskActivity{Writes: 1000, Activity: 1000}, true, DiskMetrics{}, 0)
+		status, err := am.leds.GetLedStatus(ledIndex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return [3]byte{status.ColorR, status.ColorG, status.ColorB}
+	}
+
+	if got := color(firstDiskLedIndex, sata); got != [3]byte{255, 255, 255} {
+		t.Errorf("SATA disk color = %v, want untinted white", got)
+	}
+	if got := color(firstDiskLedIndex+1, nvme); got != [3]byte{128, 255, 128} {
+		t.Errorf("NVMe disk color = %v, want white half tinted green", got)
+	}
+}
+
+func TestHashedDiskColors(t *testing.T) {
+	disks := []DiskInfo{{Name: "sda", Serial: "WD-1"}, {Name: "sdb", Serial: "WD-2"}, {Name: "sdc", Serial: "WD-3"}, {Name: "sdd"}}
+	colors := hashedDiskColors(disks)
+
+	// The same serials get the same colors, in any order and on any device
+	moved := []DiskInfo{{Name: "sdd"}, {Name: "sdx", Serial: "WD-3"}, {Name: "sdb", Serial: "WD-2"}, {Name: "sda", Serial: "WD-1"}}
+	again := hashedDiskColors(moved)
+	if again["sdx"] != colors["sdc"] || again["sda"] != colors["sda"] || again["sdd"] != colors["sdd"] {
+		t.Errorf("expected stable colors by serial, got %v then %v", colors, again)
+	}
+
+	seen := make(map[[3]byte]string)
+	for name, color := range colors {
+		if other, ok := seen[color]; ok {
+			t.Errorf("%s and %s share color %v", name, other, color)
+		}
+		seen[color] = name
+	}
+
+	// More disks than hues still all get a color
+	var many []DiskInfo
+	for i := range hashedHues + 2 {
+		many = append(many, DiskInfo{Name: fmt.Sprintf("sd%c", 'a'+i), Serial: fmt.Sprintf("S%d", i)})
+	}
+	distinct := make(map[[3]byte]bool)
+	for _, color := range hashedDiskColors(many) {
+		distinct[color] = true
+	}
+	if len(distinct) != hashedHues {
+		t.Errorf("expected all %d hues used by %d disks, got %d", hashedHues, len(many), len(distinct))
+	}
+
+	// Disks with the same key still get distinct colors, and none is left black
+	dup := hashedDiskColors([]DiskInfo{{Name: "sda", Serial: "WD-1"}, {Name: "sdb", Serial: "WD-1"}, {Name: "WD-1"}})
+	if len(dup) != 3 || dup["sda"] == dup["sdb"] || dup["sdb"] == dup["WD-1"] || dup["sda"] == dup["WD-1"] {
+		t.Errorf("expected distinct colors for disks with the same serial, got %v", dup)
+	}
+	for name, color := range dup {
+		if color == ([3]byte{}) {
+			t.Errorf("%s left black", name)
+		}
+	}
+}
+
+func TestHashedColorMode(t *testing.T) {
+	conf := &Config{PollInterval: 100 * time.Millisecond, IdleTicks: 1, BrightnessCurve: BrightnessCurveLinear, BrightnessSmoothing: 1, ColorMode: ColorModeHashed}
+	disk := DiskInfo{Name: "sda", Serial: "WD-1"}
+	am := newTestMonitor(t, disk)
+	am.maxActivity = 1000
+	am.updateDiskLed(conf, time.Now(), conf.PollInterval, firstDiskLedIndex, disk, DiskActivity{Writes: 500, Activity: 500}, true, DiskMetrics{}, 0)
+	status, err := am.leds.GetLedStatus(firstDiskLedIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := [3]byte{status.ColorR, status.ColorG, status.ColorB}, hashedDiskColors(am.disks)["sda"]; got != want {
+		t.Errorf("hashed mode color = %v, want %v", got, want)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/devilmonastery/configloader"
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
 )
 
 const (
@@ -15,6 +20,96 @@ const (
 	defaultRainbowCycleTime = 3 * time.Second
 	minRainbowCycleTime     = 1 * time.Second
 	maxRainbowCycleTime     = 10 * time.Second
+
+	defaultRainbowBrightness = 48
+	minRainbowBrightness     = 16 // dimmer is invisible on most panels
+
+	defaultBrightnessGamma = 2.2
+	minBrightnessGamma     = 0.1
+	maxBrightnessGamma     = 5.0
+
+	defaultActivityDecay = 0.95
+
+	defaultBrightnessSmoothing = 0.5
+
+	defaultIdleDimBrightness = 8
+
+	maxI2CRetry = 20
+	maxI2CDelay = 100 * time.Millisecond
+
+	defaultStateSaveInterval = 5 * time.Minute
+	minStateSaveInterval     = 10 * time.Second
+
+	defaultPowerLedInterval = 5 * time.Second
+	minPowerLedInterval     = 100 * time.Millisecond
+
+	defaultWatchdogTimeout = 30 * time.Second
+	minWatchdogTimeout     = time.Second
+	watchdogMinPolls       = 3 // the timeout spans at least this many poll intervals
+
+	minSummaryInterval = 10 * time.Second
+
+	defaultColorEmphasis = 1.0
+	minColorEmphasis     = 0.1
+	maxColorEmphasis     = 10.0
+
+	defaultTrafficLightRatio = 0.67
+	minTrafficLightRatio     = 0.5 // above half, so one side dominates
+
+	maxActivityWeight = 100
+
+	defaultNvmeTintStrength = 0.5
+
+	defaultSmartInterval = 5 * time.Minute
+	minSmartInterval     = time.Minute
+
+	defaultScrubInterval = time.Minute
+	minScrubInterval     = 10 * time.Second
+
+	defaultRediscoverInterval = 30 * time.Second
+	minRediscoverInterval     = time.Second
+
+	defaultStandbyInterval = time.Minute
+	minStandbyInterval     = 10 * time.Second
+
+	defaultSelftestStep = 250 * time.Millisecond
+	minSelftestStep     = 10 * time.Millisecond
+	maxSelftestStep     = 5 * time.Second
+
+	defaultLanBlinkMs = 100
+
+	maxMinOnMs = 10000
+
+	maxMinWriteIntervalMs = 10000
+
+	maxTransitionMs = 10000
+
+	defaultIdleTicks = 3
+	maxIdleTicks     = 100
+
+	defaultIdleBreathPeriod = 2 * time.Second
+	minIdleBreathPeriod     = 200 * time.Millisecond
+	maxIdleBreathPeriod     = leds.MaxTimingMs * time.Millisecond
+)
+
+// Orders for writing disk LEDs each poll
+const (
+	LedUpdateOrderSequential = "sequential"
+	LedUpdateOrderRoundRobin = "roundrobin"
+)
+
+// Idle modes for disks with no activity
+const (
+	IdleModeRainbow = "rainbow"
+	IdleModeOff     = "off"
+	IdleModeBreath  = "breath"
+	IdleModeSolid   = "solid" // idle_color at idle_brightness
+)
+
+// Active disk displays
+const (
+	ActiveModeSolid = "solid" // steady on
+	ActiveModePulse = "pulse" // blinking faster with more activity
 )
 
 type Config struct {
@@ -23,6 +118,314 @@ type Config struct {
 	RainbowCycleTime  time.Duration `yaml:"rainbow_cycle_time"`
 	EnableRainbow     *bool         `yaml:"enable_rainbow"`
 	RainbowBrightness *byte         `yaml:"rainbow_brightness"`
+
+	// StrictConfig rejects a config with an out-of-range poll_interval or
+	// rainbow_brightness instead of clamping it, so a bad reload keeps the
+	// previous config
+	StrictConfig bool `yaml:"strict_config"`
+
+	// BrightnessFormula maps metric names to weights, e.g. {throughput: 0.6, queue: 0.4}.
+	// When set, disk brightness is computed from the weighted blend instead of throughput alone.
+	BrightnessFormula map[string]float64 `yaml:"brightness_formula"`
+
+	// Source selects where disk activity is read from: diskstats (all I/O
+	// to each disk) or cgroup (experimental: the I/O of the cgroup at
+	// CgroupPath, under /sys/fs/cgroup, from its io.stat). Read when
+	// monitoring starts and when disks are rediscovered.
+	Source     string `yaml:"source"`
+	CgroupPath string `yaml:"cgroup_path"`
+
+	// ActivityMetric selects what drives disk brightness: throughput, util
+	// (percent busy), or iops. Ignored when BrightnessFormula is set.
+	ActivityMetric string `yaml:"activity_metric"`
+
+	// BrightnessCurve maps activity to brightness: linear, log, or gamma
+	BrightnessCurve string `yaml:"brightness_curve"`
+
+	// BrightnessGamma shapes the gamma curve; 1.0 is linear
+	BrightnessGamma float64 `yaml:"brightness_gamma"`
+
+	// ActivityDecay is the per-tick factor applied to the peak activity used
+	// for brightness scaling; 1.0 never decays
+	ActivityDecay float64 `yaml:"activity_decay"`
+
+	// DiskMaxBytesPerSec scales disk brightness against a fixed throughput
+	// instead of the running peak, so the same I/O rate shows the same
+	// brightness across restarts and units; 0 keeps the running peak
+	DiskMaxBytesPerSec uint64 `yaml:"disk_max_bytes_per_sec"`
+
+	// BrightnessSmoothing is the weight (0-1) of each tick's brightness in
+	// the exponential moving average shown on active disk LEDs; 1.0 is off
+	BrightnessSmoothing float64 `yaml:"brightness_smoothing"`
+
+	// Count selects the disk I/O that counts as activity: read_write, read,
+	// or write. Busy time and queue depth can't be split and always count
+	// both.
+	Count string `yaml:"count"`
+
+	// CountDiscards adds discarded (TRIM) bytes to disk activity, unless
+	// Count is read. Needs Linux 4.18 or later.
+	CountDiscards bool `yaml:"count_discards"`
+
+	// ReadWeight and WriteWeight scale read and written bytes in the
+	// activity that sets brightness, e.g. a WriteWeight of 4 to make backup
+	// writes stand out, or a ReadWeight of 0 to ignore reads. Unset is 1;
+	// colors use the unweighted bytes.
+	ReadWeight  *float64 `yaml:"read_weight"`
+	WriteWeight *float64 `yaml:"write_weight"`
+
+	// ActivityFloor is the bytes a disk must move in one poll to count as
+	// active; smaller deltas are treated as no activity
+	ActivityFloor uint64 `yaml:"activity_floor"`
+
+	// DiskLedMap maps disk serials to the LED index they drive.
+	// Disks not listed use the positional mapping (disk1 is LED 2).
+	DiskLedMap map[string]int `yaml:"disk_led_map"`
+
+	// ReverseLeds mirrors the positional mapping for LED strips wired in the
+	// reverse order, so disk1 drives the last disk LED of the layout
+	ReverseLeds bool `yaml:"reverse_leds"`
+
+	// DiskOrder lists disk serials in physical bay order, overriding the
+	// discovery order that decides which disk is disk1. Unlisted disks follow
+	// in discovery order. Read at startup only.
+	DiskOrder []string `yaml:"disk_order"`
+
+	// ExcludeDisks lists disk serials or device names to leave off the LEDs,
+	// such as a busy boot SSD. Excluded disks take no bay and are still
+	// listed by discovery.
+	ExcludeDisks []string `yaml:"exclude_disks"`
+
+	// LedUpdateOrder is the order disk LEDs are written each poll:
+	// sequential (disk1 first) or roundrobin (starting one disk later each poll)
+	LedUpdateOrder string `yaml:"led_update_order"`
+
+	// RediscoverInterval is how often disk discovery is re-run, so hot-plugged
+	// disks get LEDs and removed disks' LEDs turn off
+	RediscoverInterval time.Duration `yaml:"rediscover_interval"`
+
+	// StartupDelay is how long to wait before the first disk discovery,
+	// e.g. for udev to finish at boot
+	StartupDelay time.Duration `yaml:"startup_delay"`
+
+	// DiskSettleTimeout is how long startup keeps rediscovering disks until
+	// two scans in a row agree; 0 uses the first scan
+	DiskSettleTimeout time.Duration `yaml:"disk_settle_timeout"`
+
+	// Model selects the LED layout, e.g. "DXP4800". When unset, the layout is
+	// sized from the number of discovered disks.
+	Model string `yaml:"model"`
+
+	// IdleMode controls disks with no activity: rainbow, off, breath, or
+	// solid. Defaults to rainbow, or off when enable_rainbow is false.
+	IdleMode         string        `yaml:"idle_mode"`
+	IdleBreathPeriod time.Duration `yaml:"idle_breath_period"`
+
+	// ActiveMode shows active disks steadily on (solid) or blinking at a
+	// rate that rises with activity (pulse)
+	ActiveMode string `yaml:"active_mode"`
+
+	// IdleColor ("#RRGGBB" or "r,g,b", default white) and IdleBrightness
+	// (default rainbow_brightness) are shown by idle disks in solid mode;
+	// IdleBrightness also sets the breath mode brightness
+	IdleColor      string  `yaml:"idle_color"`
+	IdleBrightness *byte   `yaml:"idle_brightness"`
+	idleColor      [3]byte // parsed IdleColor
+
+	// ShowIdleDim keeps populated bays visible: idle disks show a dim solid
+	// idle_color (idle_brightness defaults to 8) instead of being off. It
+	// replaces idle_mode off or unset.
+	ShowIdleDim bool `yaml:"show_idle_dim"`
+
+	// IdleTicks is how many consecutive polls without activity it takes
+	// before an LED switches to its idle display
+	IdleTicks int `yaml:"idle_ticks"`
+
+	// MinOnMs keeps an LED showing its last activity color for at least this
+	// many milliseconds after activity, so single-poll bursts are visible
+	MinOnMs int `yaml:"min_on_ms"`
+
+	// MinWriteIntervalMs is the minimum time between writes of the same kind
+	// (color, brightness, or mode) to one LED, so a flapping signal can't
+	// saturate the I2C bus. Changes within it are coalesced; 0 disables.
+	MinWriteIntervalMs int `yaml:"min_write_interval_ms"`
+
+	// ColorMode selects the color of active disks: white, rw_blend, hsv,
+	// per_disk, or traffic_light
+	ColorMode string `yaml:"color_mode"`
+
+	// TrafficLightReadRatio and TrafficLightWriteRatio are the shares of
+	// reads and of writes at which traffic_light shows green and red
+	TrafficLightReadRatio  float64 `yaml:"traffic_light_read_ratio"`
+	TrafficLightWriteRatio float64 `yaml:"traffic_light_write_ratio"`
+
+	// DiskColors gives each disk a fixed color in per_disk mode, keyed by
+	// disk number (1 for disk1) or serial, as "#RRGGBB" or "r,g,b"
+	DiskColors map[string]string  `yaml:"disk_colors"`
+	diskColors map[string][3]byte // parsed DiskColors
+
+	// ColorEmphasis exaggerates the dominant side of the read/write mix in
+	// rw_blend and hsv modes; 1 is proportional
+	ColorEmphasis float64 `yaml:"color_emphasis"`
+
+	// TransitionMs fades active disk colors and brightness over this many
+	// milliseconds, one step per poll, instead of snapping; 0 disables
+	TransitionMs int `yaml:"transition_ms"`
+
+	// SwapRWColors shows reads in red and writes in blue instead of the reverse
+	SwapRWColors bool `yaml:"swap_rw_colors"`
+
+	// ReadColor and WriteColor ("#RRGGBB" or "r,g,b") are the colors of pure
+	// reads and pure writes in rw_blend and hsv modes, blue and red by default
+	ReadColor  string   `yaml:"read_color"`
+	WriteColor string   `yaml:"write_color"`
+	readColor  *[3]byte // parsed ReadColor, nil for the default
+	writeColor *[3]byte // parsed WriteColor, nil for the default
+
+	// NvmeColorTint ("#RRGGBB" or "r,g,b") is mixed into the active colors
+	// of NVMe disks by NvmeTintStrength (0-1, default 0.5), to tell them
+	// apart from SATA disks
+	NvmeColorTint    string   `yaml:"nvme_color_tint"`
+	NvmeTintStrength float64  `yaml:"nvme_tint_strength"`
+	nvmeColorTint    *[3]byte // parsed NvmeColorTint, nil when unset
+
+	// GreenMetric names a brightness_formula metric that drives the green
+	// channel in rw_blend mode, scaled by GreenWeight (0-1, default 1)
+	GreenMetric string  `yaml:"green_metric"`
+	GreenWeight float64 `yaml:"green_weight"`
+
+	// ColorCorrection holds red, green, and blue multipliers applied to every
+	// LED color, e.g. [1.0, 0.9, 0.6] to tame an overly bright blue channel
+	ColorCorrection []float64 `yaml:"color_correction"`
+
+	// I2CTiming overrides the LED write retry count and I2C delays
+	I2CTiming leds.LedTiming `yaml:"i2c_timing"`
+
+	// StateFile persists the learned brightness scaling across restarts.
+	// Defaults to /var/lib/ugreen-leds/state.json; set to "" to disable.
+	StateFile         *string       `yaml:"state_file"`
+	StateSaveInterval time.Duration `yaml:"state_save_interval"`
+
+	// PowerLedMode drives the power LED: off (untouched), solid, load, or
+	// pool_activity
+	PowerLedMode       string        `yaml:"power_led_mode"`
+	PowerLedBrightness *byte         `yaml:"power_led_brightness"`
+	PowerLedInterval   time.Duration `yaml:"power_led_interval"`
+
+	// SmartHealth blinks a disk's LED slowly in red while smartctl reports it
+	// failing or a pre-fail attribute at threshold, checked every SmartInterval.
+	// Defaults to true. Read at startup only.
+	SmartHealth   *bool         `yaml:"smart_health"`
+	SmartInterval time.Duration `yaml:"smart_interval"`
+
+	// ScrubBrightnessCap caps active disk LED brightness while a ZFS scrub
+	// runs, checked every ScrubInterval with `zpool status`, or ScrubCommand
+	// when set (exit 0 while scrubbing, 1 otherwise). 0 disables. Read at
+	// startup only.
+	ScrubBrightnessCap byte          `yaml:"scrub_brightness_cap"`
+	ScrubCommand       string        `yaml:"scrub_command"`
+	ScrubInterval      time.Duration `yaml:"scrub_interval"`
+
+	// MaxBrightness caps every LED brightness. During the night window from
+	// NightStart to NightEnd ("HH:MM", local time, may wrap past midnight) the
+	// cap is NightMaxBrightness instead.
+	MaxBrightness      byte    `yaml:"max_brightness"`
+	NightStart         string  `yaml:"night_start"`
+	NightEnd           string  `yaml:"night_end"`
+	NightMaxBrightness byte    `yaml:"night_max_brightness"`
+	nightWindow        *[2]int // parsed NightStart and NightEnd, in minutes after midnight
+
+	// AmbientTimeout dims every LED to AmbientBrightness once neither the
+	// disks nor the network have been active for that long; 0 disables.
+	// The next activity restores normal brightness.
+	AmbientTimeout    time.Duration `yaml:"ambient_timeout"`
+	AmbientBrightness byte          `yaml:"ambient_brightness"`
+
+	// StandbyLedMode shows spun-down disks: ignore (as idle), off, or dim.
+	// Power states are checked every StandbyInterval with `hdparm -C`, or
+	// the sysfs runtime power state without hdparm. Read at startup only.
+	StandbyLedMode  string        `yaml:"standby_led_mode"`
+	StandbyInterval time.Duration `yaml:"standby_interval"`
+
+	// StartupSelftest cycles every LED through red, green, and blue for
+	// SelftestStep each before monitoring starts
+	StartupSelftest bool          `yaml:"startup_selftest"`
+	SelftestStep    time.Duration `yaml:"selftest_step"`
+
+	// StatusListen is the address of the HTTP status endpoint, e.g. "127.0.0.1:9090".
+	// Empty disables it. Read at startup only.
+	StatusListen string `yaml:"status_listen"`
+
+	// HistoryLen is how many recent ticks of activity are kept per disk for
+	// the /history endpoint
+	HistoryLen int `yaml:"history_len"`
+
+	// MQTTBroker is the broker that per-tick activity is published to, e.g.
+	// "tcp://homeassistant.local:1883". Empty disables it. Read at startup only.
+	MQTTBroker string `yaml:"mqtt_broker"`
+	MQTTTopic  string `yaml:"mqtt_topic"`
+
+	// LanScale sets what full LAN LED brightness means: link (the busier
+	// direction saturating the link speed) or peak (the running peak)
+	LanScale string `yaml:"lan_scale"`
+
+	// EnableLanLed drives the LAN LED from network traffic. When false the
+	// LED is turned off and /proc/net/dev is never read.
+	EnableLanLed *bool `yaml:"enable_lan_led"`
+
+	// LanLedSource selects what the LAN LED shows: network traffic, the
+	// summed disk activity (disk_total), or nothing (off). It overrides
+	// EnableLanLed, which is set to whether the network is read.
+	LanLedSource string `yaml:"lan_led_source"`
+
+	// LanIdleMode controls the LAN LED while the link is up without traffic:
+	// rainbow, off, on (steady), or breath. The LED is off while every
+	// included interface is down. Defaults to rainbow, or off when
+	// enable_rainbow is false.
+	LanIdleMode string `yaml:"lan_idle_mode"`
+
+	// LanIdleBrightness is the brightness of the on and breath LAN idle
+	// modes, default rainbow_brightness
+	LanIdleBrightness *byte `yaml:"lan_idle_brightness"`
+
+	// LanBlinkOnMs and LanBlinkOffMs time the LAN LED blink during traffic.
+	// Together they must fit the LED's 16-bit period, at most 65535ms.
+	LanBlinkOnMs  int `yaml:"lan_blink_on_ms"`
+	LanBlinkOffMs int `yaml:"lan_blink_off_ms"`
+
+	// NetworkInterfaces limits LAN activity to the listed interfaces.
+	// When unset, all interfaces not matching NetworkExcludePrefixes are summed.
+	// Entries of both may be glob patterns.
+	NetworkInterfaces      []string `yaml:"network_interfaces"`
+	NetworkExcludePrefixes []string `yaml:"network_exclude_prefixes"`
+
+	// NetworkBondCount counts a bonded interface's traffic once, through the
+	// bond or through its members
+	NetworkBondCount string `yaml:"network_bond_count"`
+
+	// WatchdogTimeout is how long the monitor loop can go without a tick
+	// before a stall is logged. With WatchdogReopen the LED controller is
+	// also reopened, even while a write is blocked on it.
+	WatchdogTimeout time.Duration `yaml:"watchdog_timeout"`
+	WatchdogReopen  bool          `yaml:"watchdog_reopen"`
+
+	// SummaryInterval is how often a line totalling the disk and network
+	// activity since the last one is logged; 0 disables it
+	SummaryInterval time.Duration `yaml:"summary_interval"`
+
+	// ShutdownState sets LEDs on a graceful exit, each entry in the --set
+	// format. LEDs the monitor drives and that are not listed are turned off.
+	ShutdownState []string     `yaml:"shutdown_state"`
+	shutdownState []LedSetting // parsed ShutdownState
+
+	// FirmwareStatusLayout selects the status block layout of the controller
+	// firmware; see the leds.StatusLayout constants
+	FirmwareStatusLayout string `yaml:"firmware_status_layout"`
+
+	// LedSegments splits the LEDs across controllers, for units with more
+	// than one LED controller or I2C bus. Device is then ignored.
+	LedSegments []LedSegmentConfig `yaml:"led_segments"`
+	ledSegments []leds.LedSegment  // parsed LedSegments
 }
 
 func NewConfigLoader(path string) (*configloader.ConfigLoader[Config], error) {
@@ -33,9 +436,13 @@ func NewConfigLoader(path string) (*configloader.ConfigLoader[Config], error) {
 
 	// Register a callback to validate and set defaults
 	ret.RegisterCallback(func(conf Config) (Config, error) {
+		// Environment variables take precedence over the file
+		if err := applyEnv(&conf, os.LookupEnv); err != nil {
+			return conf, err
+		}
 		log.Printf("Loaded config: %+v", conf)
 
-		if conf.Device == "" {
+		if conf.Device == "" && len(conf.LedSegments) == 0 {
 			log.Printf("Warning: device unset, will auto-detect LED I2C device")
 		}
 
@@ -44,11 +451,15 @@ func NewConfigLoader(path string) (*configloader.ConfigLoader[Config], error) {
 			log.Printf("Warning: PollInterval unset, using %s", conf.PollInterval)
 		}
 		if conf.PollInterval < minPollInterval {
-			log.Printf("Warning: PollInterval %s too low, using %s", conf.PollInterval, minPollInterval)
+			if err := conf.outOfRange(fmt.Sprintf("PollInterval %s too low", conf.PollInterval), minPollInterval); err != nil {
+				return conf, err
+			}
 			conf.PollInterval = minPollInterval
 		}
 		if conf.PollInterval > maxPollInterval {
-			log.Printf("Warning: PollInterval %s too high, using %s", conf.PollInterval, maxPollInterval)
+			if err := conf.outOfRange(fmt.Sprintf("PollInterval %s too high", conf.PollInterval), maxPollInterval); err != nil {
+				return conf, err
+			}
 			conf.PollInterval = maxPollInterval
 		}
 
@@ -57,11 +468,15 @@ func NewConfigLoader(path string) (*configloader.ConfigLoader[Config], error) {
 			log.Printf("Warning: RainbowCycleTime unset, using %s", conf.RainbowCycleTime)
 		}
 		if conf.RainbowCycleTime < minRainbowCycleTime {
-			log.Printf("Warning: RainbowCycleTime %s too low, using %s", conf.RainbowCycleTime, minRainbowCycleTime)
+			if err := conf.outOfRange(fmt.Sprintf("RainbowCycleTime %s too low", conf.RainbowCycleTime), minRainbowCycleTime); err != nil {
+				return conf, err
+			}
 			conf.RainbowCycleTime = minRainbowCycleTime
 		}
 		if conf.RainbowCycleTime > maxRainbowCycleTime {
-			log.Printf("Warning: RainbowCycleTime %s too high, using %s", conf.RainbowCycleTime, maxRainbowCycleTime)
+			if err := conf.outOfRange(fmt.Sprintf("RainbowCycleTime %s too high", conf.RainbowCycleTime), maxRainbowCycleTime); err != nil {
+				return conf, err
+			}
 			conf.RainbowCycleTime = maxRainbowCycleTime
 		}
 
@@ -71,12 +486,620 @@ func NewConfigLoader(path string) (*configloader.ConfigLoader[Config], error) {
 			conf.EnableRainbow = &v
 		}
 
+		if conf.ShowIdleDim {
+			switch conf.IdleMode {
+			case "", IdleModeOff, IdleModeSolid:
+				conf.IdleMode = IdleModeSolid
+				if conf.IdleBrightness == nil {
+					v := byte(defaultIdleDimBrightness)
+					conf.IdleBrightness = &v
+				}
+			default:
+				log.Printf("Warning: show_idle_dim ignored with idle_mode %s", conf.IdleMode)
+			}
+		}
+
+		switch conf.IdleMode {
+		case IdleModeRainbow, IdleModeOff, IdleModeBreath, IdleModeSolid:
+		case "":
+			conf.IdleMode = IdleModeRainbow
+			if !*conf.EnableRainbow {
+				conf.IdleMode = IdleModeOff
+			}
+		default:
+			log.Printf("Warning: unknown idle_mode %q, using %s", conf.IdleMode, IdleModeRainbow)
+			conf.IdleMode = IdleModeRainbow
+		}
+
+		switch conf.ActiveMode {
+		case ActiveModeSolid, ActiveModePulse:
+		case "":
+			conf.ActiveMode = ActiveModeSolid
+		default:
+			log.Printf("Warning: unknown active_mode %q, using %s", conf.ActiveMode, ActiveModeSolid)
+			conf.ActiveMode = ActiveModeSolid
+		}
+
+		switch conf.Count {
+		case CountReadWrite, CountRead, CountWrite:
+		case "":
+			conf.Count = CountReadWrite
+		default:
+			log.Printf("Warning: unknown count %q, using %s", conf.Count, CountReadWrite)
+			conf.Count = CountReadWrite
+		}
+
+		weights := []struct {
+			name  string
+			value **float64
+		}{
+			{"read_weight", &conf.ReadWeight},
+			{"write_weight", &conf.WriteWeight},
+		}
+		for _, w := range weights {
+			if *w.value == nil {
+				v := 1.0
+				*w.value = &v
+			}
+			switch v := *w.value; {
+			case *v < 0:
+				if err := conf.outOfRange(fmt.Sprintf("%s %g is negative", w.name, *v), 1); err != nil {
+					return conf, err
+				}
+				*v = 1
+			case *v > maxActivityWeight:
+				if err := conf.outOfRange(fmt.Sprintf("%s %g too high", w.name, *v), maxActivityWeight); err != nil {
+					return conf, err
+				}
+				*v = maxActivityWeight
+			}
+		}
+		if *conf.ReadWeight == 0 && *conf.WriteWeight == 0 {
+			log.Printf("Warning: read_weight and write_weight are both 0, disk LEDs will never show activity")
+		}
+
+		if conf.FirmwareStatusLayout == "" {
+			conf.FirmwareStatusLayout = leds.StatusLayoutStandard
+		} else if !leds.IsValidStatusLayout(conf.FirmwareStatusLayout) {
+			log.Printf("Warning: unknown firmware_status_layout %q, using %s", conf.FirmwareStatusLayout, leds.StatusLayoutStandard)
+			conf.FirmwareStatusLayout = leds.StatusLayoutStandard
+		}
+
+		switch conf.Source {
+		case SourceDiskstats, SourceCgroup:
+		case "":
+			conf.Source = SourceDiskstats
+		default:
+			log.Printf("Warning: unknown source %q, using %s", conf.Source, SourceDiskstats)
+			conf.Source = SourceDiskstats
+		}
+		if err := validateCgroupSource(conf.Source, conf.CgroupPath); err != nil {
+			return conf, err
+		}
+
+		switch conf.ColorMode {
+		case ColorModeWhite, ColorModeRWBlend, ColorModeHSV, ColorModePerDisk, ColorModeHashed, ColorModeTrafficLight:
+		case "":
+			conf.ColorMode = ColorModeWhite
+		default:
+			log.Printf("Warning: unknown color_mode %q, using %s", conf.ColorMode, ColorModeWhite)
+			conf.ColorMode = ColorModeWhite
+		}
+
+		ratios := []struct {
+			name  string
+			value *float64
+		}{
+			{"traffic_light_read_ratio", &conf.TrafficLightReadRatio},
+			{"traffic_light_write_ratio", &conf.TrafficLightWriteRatio},
+		}
+		for _, r := range ratios {
+			if *r.value == 0 {
+				*r.value = defaultTrafficLightRatio
+			}
+			if *r.value < minTrafficLightRatio {
+				if err := conf.outOfRange(fmt.Sprintf("%s %g too low", r.name, *r.value), minTrafficLightRatio); err != nil {
+					return conf, err
+				}
+				*r.value = minTrafficLightRatio
+			}
+			if *r.value > 1 {
+				if err := conf.outOfRange(fmt.Sprintf("%s %g too high", r.name, *r.value), 1); err != nil {
+					return conf, err
+				}
+				*r.value = 1
+			}
+		}
+
+		if conf.IdleTicks <= 0 {
+			conf.IdleTicks = defaultIdleTicks
+		}
+		if conf.IdleTicks > maxIdleTicks {
+			if err := conf.outOfRange(fmt.Sprintf("idle_ticks %d too high", conf.IdleTicks), maxIdleTicks); err != nil {
+				return conf, err
+			}
+			conf.IdleTicks = maxIdleTicks
+		}
+
+		if conf.IdleBreathPeriod <= 0 {
+			conf.IdleBreathPeriod = defaultIdleBreathPeriod
+		}
+		if conf.IdleBreathPeriod < minIdleBreathPeriod {
+			if err := conf.outOfRange(fmt.Sprintf("idle_breath_period %s too low", conf.IdleBreathPeriod), minIdleBreathPeriod); err != nil {
+				return conf, err
+			}
+			conf.IdleBreathPeriod = minIdleBreathPeriod
+		}
+		if conf.IdleBreathPeriod > maxIdleBreathPeriod {
+			if err := conf.outOfRange(fmt.Sprintf("idle_breath_period %s too high", conf.IdleBreathPeriod), maxIdleBreathPeriod); err != nil {
+				return conf, err
+			}
+			conf.IdleBreathPeriod = maxIdleBreathPeriod
+		}
+
 		if conf.RainbowBrightness == nil {
-			log.Printf("Warning: rainbow_brightness unset, defaulting to 48")
-			v := byte(48)
+			log.Printf("Warning: rainbow_brightness unset, defaulting to %d", defaultRainbowBrightness)
+			v := byte(defaultRainbowBrightness)
+			conf.RainbowBrightness = &v
+		}
+		if v := clampByte(*conf.RainbowBrightness, minRainbowBrightness, 255); v != *conf.RainbowBrightness {
+			if err := conf.outOfRange(fmt.Sprintf("rainbow_brightness %d too low", *conf.RainbowBrightness), v); err != nil {
+				return conf, err
+			}
 			conf.RainbowBrightness = &v
 		}
 
+		switch conf.BrightnessCurve {
+		case BrightnessCurveLinear, BrightnessCurveLog, BrightnessCurveGamma:
+		case "":
+			conf.BrightnessCurve = BrightnessCurveGamma
+		default:
+			log.Printf("Warning: unknown brightness_curve %q, using %s", conf.BrightnessCurve, BrightnessCurveGamma)
+			conf.BrightnessCurve = BrightnessCurveGamma
+		}
+		if conf.BrightnessGamma <= 0 {
+			conf.BrightnessGamma = defaultBrightnessGamma
+			log.Printf("Warning: brightness_gamma unset, using %g", conf.BrightnessGamma)
+		}
+		if conf.BrightnessGamma < minBrightnessGamma {
+			if err := conf.outOfRange(fmt.Sprintf("brightness_gamma %g too low", conf.BrightnessGamma), minBrightnessGamma); err != nil {
+				return conf, err
+			}
+			conf.BrightnessGamma = minBrightnessGamma
+		}
+		if conf.BrightnessGamma > maxBrightnessGamma {
+			if err := conf.outOfRange(fmt.Sprintf("brightness_gamma %g too high", conf.BrightnessGamma), maxBrightnessGamma); err != nil {
+				return conf, err
+			}
+			conf.BrightnessGamma = maxBrightnessGamma
+		}
+
+		if conf.ActivityDecay <= 0 {
+			conf.ActivityDecay = defaultActivityDecay
+			log.Printf("Warning: activity_decay unset, using %g", conf.ActivityDecay)
+		}
+		if conf.ActivityDecay > 1 {
+			if err := conf.outOfRange(fmt.Sprintf("activity_decay %g too high", conf.ActivityDecay), 1); err != nil {
+				return conf, err
+			}
+			conf.ActivityDecay = 1
+		}
+		if conf.HistoryLen <= 0 {
+			conf.HistoryLen = defaultHistoryLen
+		}
+		if conf.HistoryLen > maxHistoryLen {
+			if err := conf.outOfRange(fmt.Sprintf("history_len %d too high", conf.HistoryLen), maxHistoryLen); err != nil {
+				return conf, err
+			}
+			conf.HistoryLen = maxHistoryLen
+		}
+
+		if conf.BrightnessSmoothing <= 0 {
+			conf.BrightnessSmoothing = defaultBrightnessSmoothing
+		}
+		if conf.BrightnessSmoothing > 1 {
+			if err := conf.outOfRange(fmt.Sprintf("brightness_smoothing %g too high", conf.BrightnessSmoothing), 1); err != nil {
+				return conf, err
+			}
+			conf.BrightnessSmoothing = 1
+		}
+
+		timing, err := conf.normalizeLedTiming(conf.I2CTiming)
+		if err != nil {
+			return conf, err
+		}
+		conf.I2CTiming = timing
+
+		if conf.StateFile == nil {
+			v := defaultStateFile
+			conf.StateFile = &v
+		}
+		if conf.StateSaveInterval <= 0 {
+			conf.StateSaveInterval = defaultStateSaveInterval
+		}
+		if conf.StateSaveInterval < minStateSaveInterval {
+			if err := conf.outOfRange(fmt.Sprintf("state_save_interval %s too low", conf.StateSaveInterval), minStateSaveInterval); err != nil {
+				return conf, err
+			}
+			conf.StateSaveInterval = minStateSaveInterval
+		}
+
+		switch conf.PowerLedMode {
+		case PowerLedModeOff, PowerLedModeSolid, PowerLedModeLoad, PowerLedModePoolActivity:
+		case "":
+			conf.PowerLedMode = PowerLedModeOff
+		default:
+			log.Printf("Warning: unknown power_led_mode %q, using %s", conf.PowerLedMode, PowerLedModeOff)
+			conf.PowerLedMode = PowerLedModeOff
+		}
+		if conf.PowerLedBrightness == nil {
+			v := byte(64)
+			conf.PowerLedBrightness = &v
+		}
+		if conf.PowerLedInterval <= 0 {
+			conf.PowerLedInterval = defaultPowerLedInterval
+		}
+		if conf.PowerLedInterval < minPowerLedInterval {
+			if err := conf.outOfRange(fmt.Sprintf("power_led_interval %s too low", conf.PowerLedInterval), minPowerLedInterval); err != nil {
+				return conf, err
+			}
+			conf.PowerLedInterval = minPowerLedInterval
+		}
+
+		if conf.WatchdogTimeout <= 0 {
+			conf.WatchdogTimeout = defaultWatchdogTimeout
+		}
+		if minTimeout := max(minWatchdogTimeout, watchdogMinPolls*conf.PollInterval); conf.WatchdogTimeout < minTimeout {
+			if err := conf.outOfRange(fmt.Sprintf("watchdog_timeout %s too low", conf.WatchdogTimeout), minTimeout); err != nil {
+				return conf, err
+			}
+			conf.WatchdogTimeout = minTimeout
+		}
+
+		if conf.SummaryInterval < 0 {
+			conf.SummaryInterval = 0
+		}
+		if conf.SummaryInterval > 0 && conf.SummaryInterval < minSummaryInterval {
+			if err := conf.outOfRange(fmt.Sprintf("summary_interval %s too low", conf.SummaryInterval), minSummaryInterval); err != nil {
+				return conf, err
+			}
+			conf.SummaryInterval = minSummaryInterval
+		}
+
+		if conf.ColorEmphasis <= 0 {
+			conf.ColorEmphasis = defaultColorEmphasis
+		}
+		if conf.ColorEmphasis < minColorEmphasis {
+			if err := conf.outOfRange(fmt.Sprintf("color_emphasis %g too low", conf.ColorEmphasis), minColorEmphasis); err != nil {
+				return conf, err
+			}
+			conf.ColorEmphasis = minColorEmphasis
+		}
+		if conf.ColorEmphasis > maxColorEmphasis {
+			if err := conf.outOfRange(fmt.Sprintf("color_emphasis %g too high", conf.ColorEmphasis), maxColorEmphasis); err != nil {
+				return conf, err
+			}
+			conf.ColorEmphasis = maxColorEmphasis
+		}
+
+		if conf.MinOnMs < 0 {
+			conf.MinOnMs = 0
+		}
+		if conf.MinOnMs > maxMinOnMs {
+			if err := conf.outOfRange(fmt.Sprintf("min_on_ms %d too high", conf.MinOnMs), maxMinOnMs); err != nil {
+				return conf, err
+			}
+			conf.MinOnMs = maxMinOnMs
+		}
+
+		if conf.MinWriteIntervalMs < 0 {
+			conf.MinWriteIntervalMs = 0
+		}
+		if conf.MinWriteIntervalMs > maxMinWriteIntervalMs {
+			if err := conf.outOfRange(fmt.Sprintf("min_write_interval_ms %d too high", conf.MinWriteIntervalMs), maxMinWriteIntervalMs); err != nil {
+				return conf, err
+			}
+			conf.MinWriteIntervalMs = maxMinWriteIntervalMs
+		}
+
+		if conf.TransitionMs < 0 {
+			conf.TransitionMs = 0
+		}
+		if conf.TransitionMs > maxTransitionMs {
+			if err := conf.outOfRange(fmt.Sprintf("transition_ms %d too high", conf.TransitionMs), maxTransitionMs); err != nil {
+				return conf, err
+			}
+			conf.TransitionMs = maxTransitionMs
+		}
+
+		if conf.GreenMetric != "" && !isBrightnessMetric(conf.GreenMetric) {
+			return conf, fmt.Errorf("green_metric: unknown metric %q (valid: %s)", conf.GreenMetric, strings.Join(brightnessMetrics, ", "))
+		}
+		if conf.GreenWeight <= 0 {
+			conf.GreenWeight = 1
+		}
+		if conf.GreenWeight > 1 {
+			if err := conf.outOfRange(fmt.Sprintf("green_weight %v too high", conf.GreenWeight), 1); err != nil {
+				return conf, err
+			}
+			conf.GreenWeight = 1
+		}
+
+		if conf.Model != "" {
+			if _, err := layoutForModel(conf.Model); err != nil {
+				log.Printf("Warning: %v, sizing LED layout from discovered disks", err)
+				conf.Model = ""
+			}
+		}
+
+		if conf.SmartHealth == nil {
+			v := true
+			conf.SmartHealth = &v
+		}
+		if conf.SmartInterval <= 0 {
+			conf.SmartInterval = defaultSmartInterval
+		}
+		if conf.SmartInterval < minSmartInterval {
+			if err := conf.outOfRange(fmt.Sprintf("smart_interval %s too low", conf.SmartInterval), minSmartInterval); err != nil {
+				return conf, err
+			}
+			conf.SmartInterval = minSmartInterval
+		}
+
+		if conf.ScrubInterval <= 0 {
+			conf.ScrubInterval = defaultScrubInterval
+		}
+		if conf.ScrubInterval < minScrubInterval {
+			if err := conf.outOfRange(fmt.Sprintf("scrub_interval %s too low", conf.ScrubInterval), minScrubInterval); err != nil {
+				return conf, err
+			}
+			conf.ScrubInterval = minScrubInterval
+		}
+
+		if conf.MaxBrightness == 0 {
+			conf.MaxBrightness = 255
+		}
+		if conf.NightMaxBrightness == 0 {
+			conf.NightMaxBrightness = defaultNightMaxBrightness
+		}
+		if conf.AmbientTimeout < 0 {
+			if err := conf.outOfRange(fmt.Sprintf("ambient_timeout %s is negative", conf.AmbientTimeout), time.Duration(0)); err != nil {
+				return conf, err
+			}
+			conf.AmbientTimeout = 0
+		}
+		if conf.AmbientBrightness == 0 {
+			conf.AmbientBrightness = defaultAmbientBrightness
+		}
+		if (conf.NightStart == "") != (conf.NightEnd == "") {
+			return conf, fmt.Errorf("night_start and night_end must be set together")
+		}
+		if conf.NightStart != "" {
+			start, err := parseClock(conf.NightStart)
+			if err != nil {
+				return conf, fmt.Errorf("night_start: %w", err)
+			}
+			end, err := parseClock(conf.NightEnd)
+			if err != nil {
+				return conf, fmt.Errorf("night_end: %w", err)
+			}
+			conf.nightWindow = &[2]int{start, end}
+		}
+
+		switch conf.LedUpdateOrder {
+		case LedUpdateOrderSequential, LedUpdateOrderRoundRobin:
+		case "":
+			conf.LedUpdateOrder = LedUpdateOrderSequential
+		default:
+			log.Printf("Warning: unknown led_update_order %q, using %s", conf.LedUpdateOrder, LedUpdateOrderSequential)
+			conf.LedUpdateOrder = LedUpdateOrderSequential
+		}
+
+		if conf.RediscoverInterval <= 0 {
+			conf.RediscoverInterval = defaultRediscoverInterval
+		}
+		if conf.RediscoverInterval < minRediscoverInterval {
+			if err := conf.outOfRange(fmt.Sprintf("rediscover_interval %s too low", conf.RediscoverInterval), minRediscoverInterval); err != nil {
+				return conf, err
+			}
+			conf.RediscoverInterval = minRediscoverInterval
+		}
+		if conf.StartupDelay < 0 {
+			if err := conf.outOfRange(fmt.Sprintf("startup_delay %s is negative", conf.StartupDelay), time.Duration(0)); err != nil {
+				return conf, err
+			}
+			conf.StartupDelay = 0
+		}
+		if conf.DiskSettleTimeout < 0 {
+			if err := conf.outOfRange(fmt.Sprintf("disk_settle_timeout %s is negative", conf.DiskSettleTimeout), time.Duration(0)); err != nil {
+				return conf, err
+			}
+			conf.DiskSettleTimeout = 0
+		}
+
+		switch conf.StandbyLedMode {
+		case StandbyLedModeIgnore, StandbyLedModeOff, StandbyLedModeDim:
+		case "":
+			conf.StandbyLedMode = StandbyLedModeIgnore
+		default:
+			log.Printf("Warning: unknown standby_led_mode %q, using %s", conf.StandbyLedMode, StandbyLedModeIgnore)
+			conf.StandbyLedMode = StandbyLedModeIgnore
+		}
+		if conf.StandbyInterval <= 0 {
+			conf.StandbyInterval = defaultStandbyInterval
+		}
+		if conf.StandbyInterval < minStandbyInterval {
+			if err := conf.outOfRange(fmt.Sprintf("standby_interval %s too low", conf.StandbyInterval), minStandbyInterval); err != nil {
+				return conf, err
+			}
+			conf.StandbyInterval = minStandbyInterval
+		}
+
+		if conf.SelftestStep <= 0 {
+			conf.SelftestStep = defaultSelftestStep
+		}
+		if conf.SelftestStep < minSelftestStep {
+			if err := conf.outOfRange(fmt.Sprintf("selftest_step %s too low", conf.SelftestStep), minSelftestStep); err != nil {
+				return conf, err
+			}
+			conf.SelftestStep = minSelftestStep
+		}
+		if conf.SelftestStep > maxSelftestStep {
+			if err := conf.outOfRange(fmt.Sprintf("selftest_step %s too high", conf.SelftestStep), maxSelftestStep); err != nil {
+				return conf, err
+			}
+			conf.SelftestStep = maxSelftestStep
+		}
+
+		switch conf.LanScale {
+		case LanScaleLink, LanScalePeak:
+		case "":
+			conf.LanScale = LanScaleLink
+		default:
+			log.Printf("Warning: unknown lan_scale %q, using %s", conf.LanScale, LanScaleLink)
+			conf.LanScale = LanScaleLink
+		}
+
+		if conf.EnableLanLed == nil {
+			v := true
+			conf.EnableLanLed = &v
+		}
+		switch conf.LanLedSource {
+		case LanLedSourceNetwork, LanLedSourceDiskTotal, LanLedSourceOff:
+		case "":
+			conf.LanLedSource = LanLedSourceNetwork
+			if !*conf.EnableLanLed {
+				conf.LanLedSource = LanLedSourceOff
+			}
+		default:
+			log.Printf("Warning: unknown lan_led_source %q, using %s", conf.LanLedSource, LanLedSourceNetwork)
+			conf.LanLedSource = LanLedSourceNetwork
+		}
+		readNetwork := conf.LanLedSource == LanLedSourceNetwork
+		conf.EnableLanLed = &readNetwork
+		switch conf.LanIdleMode {
+		case LanIdleModeRainbow, LanIdleModeOff, LanIdleModeOn, LanIdleModeBreath:
+		case "":
+			conf.LanIdleMode = LanIdleModeRainbow
+			if !*conf.EnableRainbow {
+				conf.LanIdleMode = LanIdleModeOff
+			}
+		default:
+			log.Printf("Warning: unknown lan_idle_mode %q, using %s", conf.LanIdleMode, LanIdleModeRainbow)
+			conf.LanIdleMode = LanIdleModeRainbow
+		}
+
+		if conf.LanBlinkOnMs <= 0 {
+			conf.LanBlinkOnMs = defaultLanBlinkMs
+		}
+		if conf.LanBlinkOffMs <= 0 {
+			conf.LanBlinkOffMs = defaultLanBlinkMs
+		}
+		if conf.LanBlinkOnMs+conf.LanBlinkOffMs > leds.MaxTimingMs {
+			return conf, fmt.Errorf("lan_blink_on_ms %d plus lan_blink_off_ms %d exceeds %dms", conf.LanBlinkOnMs, conf.LanBlinkOffMs, leds.MaxTimingMs)
+		}
+
+		if conf.MQTTTopic == "" {
+			conf.MQTTTopic = defaultMQTTTopic
+		}
+
+		if conf.NetworkExcludePrefixes == nil {
+			conf.NetworkExcludePrefixes = defaultNetworkExcludePrefixes
+		}
+		if len(conf.NetworkInterfaces) > 0 {
+			log.Printf("LAN activity limited to interfaces: %v", conf.NetworkInterfaces)
+		}
+		if err := validateInterfacePatterns(conf.NetworkInterfaces, conf.NetworkExcludePrefixes); err != nil {
+			return conf, err
+		}
+		switch conf.NetworkBondCount {
+		case NetworkBondCountBond, NetworkBondCountMembers:
+		case "":
+			conf.NetworkBondCount = NetworkBondCountBond
+		default:
+			log.Printf("Warning: unknown network_bond_count %q, using %s", conf.NetworkBondCount, NetworkBondCountBond)
+			conf.NetworkBondCount = NetworkBondCountBond
+		}
+
+		if err := validateBrightnessFormula(conf.BrightnessFormula); err != nil {
+			return conf, err
+		}
+
+		switch conf.ActivityMetric {
+		case ActivityMetricThroughput, ActivityMetricUtil, ActivityMetricIOPS:
+		case "":
+			conf.ActivityMetric = ActivityMetricThroughput
+		default:
+			log.Printf("Warning: unknown activity_metric %q, using %s", conf.ActivityMetric, ActivityMetricThroughput)
+			conf.ActivityMetric = ActivityMetricThroughput
+		}
+		if conf.ActivityMetric != ActivityMetricThroughput && len(conf.BrightnessFormula) > 0 {
+			log.Printf("Warning: brightness_formula is set, ignoring activity_metric %s", conf.ActivityMetric)
+		}
+
+		if err := validateDiskLedMap(conf.DiskLedMap); err != nil {
+			return conf, err
+		}
+
+		if err := validateDiskOrder(conf.DiskOrder); err != nil {
+			return conf, err
+		}
+
+		if err := validateColorCorrection(conf.ColorCorrection); err != nil {
+			return conf, err
+		}
+
+		diskColors, err := parseDiskColors(conf.DiskColors)
+		if err != nil {
+			return conf, err
+		}
+		conf.diskColors = diskColors
+
+		conf.idleColor = [3]byte{255, 255, 255}
+		if conf.IdleColor != "" {
+			if conf.idleColor, err = parseColor(conf.IdleColor); err != nil {
+				return conf, fmt.Errorf("idle_color: %w", err)
+			}
+		}
+		if conf.IdleBrightness == nil {
+			conf.IdleBrightness = conf.RainbowBrightness
+		}
+		if conf.LanIdleBrightness == nil {
+			conf.LanIdleBrightness = conf.RainbowBrightness
+		}
+
+		if conf.readColor, err = parseOptionalColor("read_color", conf.ReadColor); err != nil {
+			return conf, err
+		}
+		if conf.writeColor, err = parseOptionalColor("write_color", conf.WriteColor); err != nil {
+			return conf, err
+		}
+		if conf.nvmeColorTint, err = parseOptionalColor("nvme_color_tint", conf.NvmeColorTint); err != nil {
+			return conf, err
+		}
+		if conf.NvmeTintStrength <= 0 {
+			conf.NvmeTintStrength = defaultNvmeTintStrength
+		}
+		if conf.NvmeTintStrength > 1 {
+			if err := conf.outOfRange(fmt.Sprintf("nvme_tint_strength %g too high", conf.NvmeTintStrength), 1); err != nil {
+				return conf, err
+			}
+			conf.NvmeTintStrength = 1
+		}
+
+		if conf.shutdownState, err = parseShutdownState(conf.ShutdownState); err != nil {
+			return conf, err
+		}
+		if conf.ledSegments, err = parseLedSegments(conf.LedSegments); err != nil {
+			return conf, err
+		}
+		if len(conf.ledSegments) > 0 && conf.Device != "" {
+			log.Printf("Warning: device %s ignored, led_segments set", conf.Device)
+		}
+		if len(conf.BrightnessFormula) > 0 {
+			log.Printf("Using brightness formula: %s", formatBrightnessFormula(conf.BrightnessFormula))
+		}
+
 		return conf, nil
 	})
 
@@ -86,3 +1109,124 @@ func NewConfigLoader(path string) (*configloader.ConfigLoader[Config], error) {
 	}
 	return ret, nil
 }
+
+// validateDiskLedMap checks that every mapped LED index is a disk LED and is used only once
+func validateDiskLedMap(ledMap map[string]int) error {
+	used := make(map[int]string)
+	for serial, index := range ledMap {
+		if serial == "" {
+			return fmt.Errorf("disk_led_map: empty disk serial")
+		}
+		if index < firstDiskLedIndex || !leds.IsValidLedIndex(index) {
+			return fmt.Errorf("disk_led_map: LED index %d for disk %q out of range (valid range: %d-%d)", index, serial, firstDiskLedIndex, leds.GetMaxLedIndex())
+		}
+		if other, ok := used[index]; ok {
+			return fmt.Errorf("disk_led_map: LED index %d assigned to both %q and %q", index, other, serial)
+		}
+		used[index] = serial
+	}
+	return nil
+}
+
+// outOfRange handles a value past its limit: with StrictConfig it returns an
+// error rejecting the config, otherwise it warns that limit is used instead
+func (c *Config) outOfRange(problem string, limit any) error {
+	if c.StrictConfig {
+		return fmt.Errorf("%s (limit %v)", problem, limit)
+	}
+	log.Printf("Warning: %s, using %v", problem, limit)
+	return nil
+}
+
+// clampByte limits v to the range lo-hi
+func clampByte(v, lo, hi byte) byte {
+	return min(max(v, lo), hi)
+}
+
+// MinOn returns how long an LED stays lit after activity
+func (c *Config) MinOn() time.Duration {
+	return time.Duration(c.MinOnMs) * time.Millisecond
+}
+
+// MinWriteInterval returns the minimum time between writes of a kind to one LED
+func (c *Config) MinWriteInterval() time.Duration {
+	return time.Duration(c.MinWriteIntervalMs) * time.Millisecond
+}
+
+// validateDiskOrder checks that disk_order lists each serial once
+func validateDiskOrder(order []string) error {
+	seen := make(map[string]bool, len(order))
+	for _, serial := range order {
+		if serial == "" {
+			return fmt.Errorf("disk_order: empty disk serial")
+		}
+		if seen[serial] {
+			return fmt.Errorf("disk_order: disk %q listed twice", serial)
+		}
+		seen[serial] = true
+	}
+	return nil
+}
+
+// validateColorCorrection checks for three non-negative channel multipliers
+func validateColorCorrection(correction []float64) error {
+	if correction == nil {
+		return nil
+	}
+	if len(correction) != 3 {
+		return fmt.Errorf("color_correction: expected 3 multipliers (red, green, blue), got %d", len(correction))
+	}
+	for i, m := range correction {
+		if math.IsNaN(m) || math.IsInf(m, 0) || m < 0 {
+			return fmt.Errorf("color_correction: multiplier %d must be a non-negative number, got %v", i, m)
+		}
+	}
+	return nil
+}
+
+// normalizeLedTiming fills unset fields from the named profile, or
+// leds.DefaultLedTiming, and clamps the rest
+func (c *Config) normalizeLedTiming(timing leds.LedTiming) (leds.LedTiming, error) {
+	base := leds.DefaultLedTiming
+	if timing.Profile != "" {
+		profile, ok := leds.LedTimingProfiles[timing.Profile]
+		if ok {
+			base = profile
+		} else {
+			log.Printf("Warning: unknown i2c_timing.profile %q, using default", timing.Profile)
+			timing.Profile = "default"
+		}
+	}
+
+	if timing.MaxRetry <= 0 {
+		timing.MaxRetry = base.MaxRetry
+	}
+	if timing.MaxRetry > maxI2CRetry {
+		if err := c.outOfRange(fmt.Sprintf("i2c_timing.max_retry %d too high", timing.MaxRetry), maxI2CRetry); err != nil {
+			return timing, err
+		}
+		timing.MaxRetry = maxI2CRetry
+	}
+
+	delays := []struct {
+		name  string
+		value *time.Duration
+		def   time.Duration
+	}{
+		{"modification_delay", &timing.ModificationDelay, base.ModificationDelay},
+		{"retry_delay", &timing.RetryDelay, base.RetryDelay},
+		{"query_delay", &timing.QueryDelay, base.QueryDelay},
+	}
+	for _, d := range delays {
+		if *d.value <= 0 {
+			*d.value = d.def
+		}
+		if *d.value > maxI2CDelay {
+			if err := c.outOfRange(fmt.Sprintf("i2c_timing.%s %s too high", d.name, *d.value), maxI2CDelay); err != nil {
+				return timing, err
+			}
+			*d.value = maxI2CDelay
+		}
+	}
+	return timing, nil
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
 )
 
 func TestConfigLoader(t *testing.T) {
@@ -56,3 +59,442 @@ func TestEmptyConfig(t *testing.T) {
 		t.Errorf("expected Device to be empty for auto-detection, got %q", cfg.Device)
 	}
 }
+
+func TestDiskLedMap(t *testing.T) {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	f, err := os.CreateTemp("", "testconfig-*.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("disk_led_map:\n  WD-123: 5\n  WD-456: 2\n")
+	f.Close()
+
+	loader, err := NewConfigLoader(f.Name())
+	if err != nil {
+		t.Fatalf("failed to create config loader: %v", err)
+	}
+	cfg := loader.Config()
+	if cfg == nil {
+		t.Fatal("expected valid config")
+	}
+	if cfg.DiskLedMap["WD-123"] != 5 || cfg.DiskLedMap["WD-456"] != 2 {
+		t.Errorf("unexpected disk_led_map: %v", cfg.DiskLedMap)
+	}
+}
+
+func TestValidateDiskLedMap(t *testing.T) {
+	tests := []struct {
+		ledMap map[string]int
+		ok     bool
+	}{
+		{nil, true},
+		{map[string]int{"A": 2, "B": 9}, true},
+		{map[string]int{"A": 1}, false},  // lan LED
+		{map[string]int{"A": 10}, false}, // past the last LED
+		{map[string]int{"A": 3, "B": 3}, false},
+		{map[string]int{"": 3}, false},
+	}
+	for _, tt := range tests {
+		err := validateDiskLedMap(tt.ledMap)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateDiskLedMap(%v) error = %v, want ok=%v", tt.ledMap, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateDiskOrder(t *testing.T) {
+	tests := []struct {
+		order []string
+		ok    bool
+	}{
+		{nil, true},
+		{[]string{"A", "B"}, true},
+		{[]string{"A", "A"}, false},
+		{[]string{""}, false},
+	}
+	for _, tt := range tests {
+		err := validateDiskOrder(tt.order)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateDiskOrder(%v) error = %v, want ok=%v", tt.order, err, tt.ok)
+		}
+	}
+}
+
+func TestIdleModeDefaults(t *testing.T) {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	f, err := os.CreateTemp("", "testconfig-*.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("enable_rainbow: false\n")
+	f.Close()
+
+	loader, err := NewConfigLoader(f.Name())
+	if err != nil {
+		t.Fatalf("failed to create config loader: %v", err)
+	}
+	cfg := loader.Config()
+	if cfg.IdleMode != IdleModeOff {
+		t.Errorf("expected idle_mode=off when rainbow is disabled, got %q", cfg.IdleMode)
+	}
+	if cfg.IdleBreathPeriod != defaultIdleBreathPeriod {
+		t.Errorf("expected IdleBreathPeriod=%s, got %s", defaultIdleBreathPeriod, cfg.IdleBreathPeriod)
+	}
+	if cfg.LanIdleBrightness == nil || *cfg.LanIdleBrightness != *cfg.RainbowBrightness {
+		t.Errorf("expected lan_idle_brightness to default to rainbow_brightness, got %v", cfg.LanIdleBrightness)
+	}
+}
+
+func TestLanBlinkTiming(t *testing.T) {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	tests := []struct {
+		yaml        string
+		valid       bool
+		onMs, offMs int
+	}{
+		{"", true, defaultLanBlinkMs, defaultLanBlinkMs},
+		{"lan_blink_on_ms: 300\nlan_blink_off_ms: 700\n", true, 300, 700},
+		{"lan_blink_on_ms: 65000\nlan_blink_off_ms: 535\n", true, 65000, 535},
+		{"lan_blink_on_ms: 65000\nlan_blink_off_ms: 536\n", false, 0, 0},
+	}
+	for _, tt := range tests {
+		f, err := os.CreateTemp("", "testconfig-*.yaml")
+		if err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(tt.yaml)
+		f.Close()
+
+		loader, err := NewConfigLoader(f.Name())
+		if err != nil {
+			t.Fatalf("failed to create config loader: %v", err)
+		}
+		cfg := loader.Config()
+		if !tt.valid {
+			if cfg != nil {
+				t.Errorf("%q: expected config to be rejected", tt.yaml)
+			}
+			continue
+		}
+		if cfg == nil {
+			t.Fatalf("%q: expected valid config", tt.yaml)
+		}
+		if cfg.LanBlinkOnMs != tt.onMs || cfg.LanBlinkOffMs != tt.offMs {
+			t.Errorf("%q: expected %d/%dms, got %d/%dms", tt.yaml, tt.onMs, tt.offMs, cfg.LanBlinkOnMs, cfg.LanBlinkOffMs)
+		}
+	}
+}
+
+func TestValidateColorCorrection(t *testing.T) {
+	if err := validateColorCorrection(nil); err != nil {
+		t.Errorf("expected nil correction to be valid, got %v", err)
+	}
+	if err := validateColorCorrection([]float64{1, 0.9, 0.6}); err != nil {
+		t.Errorf("expected valid correction, got %v", err)
+	}
+	if err := validateColorCorrection([]float64{1, 1}); err == nil {
+		t.Error("expected error for 2 multipliers")
+	}
+	if err := validateColorCorrection([]float64{1, -1, 1}); err == nil {
+		t.Error("expected error for negative multiplier")
+	}
+}
+
+func TestClampByte(t *testing.T) {
+	tests := []struct {
+		v, lo, hi, want byte
+	}{
+		{0, 16, 255, 16},
+		{15, 16, 255, 16},
+		{16, 16, 255, 16},
+		{17, 16, 255, 17},
+		{255, 16, 255, 255},
+		{200, 0, 128, 128},
+	}
+	for _, tt := range tests {
+		if got := clampByte(tt.v, tt.lo, tt.hi); got != tt.want {
+			t.Errorf("clampByte(%d, %d, %d) = %d, want %d", tt.v, tt.lo, tt.hi, got, tt.want)
+		}
+	}
+}
+
+func TestRainbowBrightnessClamp(t *testing.T) {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	tests := []struct {
+		yaml string
+		want byte
+	}{
+		{"", defaultRainbowBrightness},
+		{"rainbow_brightness: 0\n", minRainbowBrightness},
+		{"rainbow_brightness: 15\n", minRainbowBrightness},
+		{"rainbow_brightness: 16\n", 16},
+		{"rainbow_brightness: 255\n", 255},
+	}
+	for _, tt := range tests {
+		f, err := os.CreateTemp("", "testconfig-*.yaml")
+		if err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(tt.yaml)
+		f.Close()
+
+		loader, err := NewConfigLoader(f.Name())
+		if err != nil {
+			t.Fatalf("failed to create config loader: %v", err)
+		}
+		cfg := loader.Config()
+		if cfg == nil {
+			t.Fatalf("%q: expected valid config", tt.yaml)
+		}
+		if *cfg.RainbowBrightness != tt.want {
+			t.Errorf("%q: expected rainbow_brightness %d, got %d", tt.yaml, tt.want, *cfg.RainbowBrightness)
+		}
+	}
+}
+
+func TestDiskColorsConfig(t *testing.T) {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	tests := []struct {
+		yaml  string
+		valid bool
+	}{
+		{"color_mode: per_disk\ndisk_colors:\n  1: \"#FF0000\"\n  WD-123: 0,0,255\n", true},
+		{"color_mode: per_disk\ndisk_colors:\n  1: \"#FF00\"\n", false},
+	}
+	for _, tt := range tests {
+		f, err := os.CreateTemp("", "testconfig-*.yaml")
+		if err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(tt.yaml)
+		f.Close()
+
+		loader, err := NewConfigLoader(f.Name())
+		if err != nil {
+			t.Fatalf("failed to create config loader: %v", err)
+		}
+		cfg := loader.Config()
+		if !tt.valid {
+			if cfg != nil {
+				t.Errorf("%q: expected config to be rejected", tt.yaml)
+			}
+			continue
+		}
+		if cfg == nil {
+			t.Fatalf("%q: expected valid config", tt.yaml)
+		}
+		if got := cfg.diskColor(firstDiskLedIndex, DiskInfo{}); got != [3]byte{255, 0, 0} {
+			t.Errorf("disk1 color = %v, want red", got)
+		}
+		if got := cfg.diskColor(firstDiskLedIndex+1, DiskInfo{Serial: "WD-123"}); got != [3]byte{0, 0, 255} {
+			t.Errorf("WD-123 color = %v, want blue", got)
+		}
+	}
+}
+
+func TestStrictConfig(t *testing.T) {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	tests := []struct {
+		yaml  string
+		valid bool
+	}{
+		{"poll_interval: 1ms\n", true},
+		{"poll_interval: 1h\n", true},
+		{"rainbow_brightness: 1\n", true},
+		{"strict_config: true\npoll_interval: 250ms\nrainbow_brightness: 64\n", true},
+		{"strict_config: true\npoll_interval: 1ms\n", false},
+		{"strict_config: true\npoll_interval: 1h\n", false},
+		{"strict_config: true\nrainbow_brightness: 1\n", false},
+	}
+	for _, tt := range tests {
+		f, err := os.CreateTemp("", "testconfig-*.yaml")
+		if err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(tt.yaml)
+		f.Close()
+
+		loader, err := NewConfigLoader(f.Name())
+		if err != nil {
+			t.Fatalf("failed to create config loader: %v", err)
+		}
+		cfg := loader.Config()
+		if !tt.valid {
+			if cfg != nil {
+				t.Errorf("%q: expected config to be rejected", tt.yaml)
+			}
+			continue
+		}
+		if cfg == nil {
+			t.Fatalf("%q: expected valid config", tt.yaml)
+		}
+		// lenient configs are clamped into range
+		if cfg.PollInterval < minPollInterval || cfg.PollInterval > maxPollInterval {
+			t.Errorf("%q: poll_interval %s out of range", tt.yaml, cfg.PollInterval)
+		}
+		if *cfg.RainbowBrightness < minRainbowBrightness {
+			t.Errorf("%q: rainbow_brightness %d out of range", tt.yaml, *cfg.RainbowBrightness)
+		}
+	}
+}
+
+func TestStrictConfigFields(t *testing.T) {
+	// Every clamped field is rejected under strict_config and clamped
+	// without it
+	fields := []string{
+		"rainbow_cycle_time: 100ms",
+		"rainbow_cycle_time: 1m",
+		"read_weight: -1",
+		"write_weight: 1000",
+		"traffic_light_read_ratio: 0.1",
+		"traffic_light_write_ratio: 2",
+		"idle_ticks: 1000",
+		"idle_breath_period: 10ms",
+		"idle_breath_period: 1h",
+		"brightness_gamma: 0.01",
+		"brightness_gamma: 10",
+		"activity_decay: 2",
+		"history_len: 100000",
+		"brightness_smoothing: 2",
+		"state_save_interval: 1s",
+		"power_led_interval: 10ms",
+		"watchdog_timeout: 100ms",
+		"summary_interval: 1s",
+		"color_emphasis: 0.01",
+		"color_emphasis: 100",
+		"min_on_ms: 100000",
+		"min_write_interval_ms: 100000",
+		"transition_ms: 100000",
+		"green_weight: 2",
+		"smart_interval: 1s",
+		"scrub_interval: 1s",
+		"ambient_timeout: -1s",
+		"rediscover_interval: 100ms",
+		"startup_delay: -1s",
+		"disk_settle_timeout: -1s",
+		"standby_interval: 1s",
+		"selftest_step: 1ms",
+		"selftest_step: 1m",
+		"nvme_tint_strength: 2",
+		"i2c_timing: {max_retry: 100}",
+		"i2c_timing: {query_delay: 1s}",
+	}
+	for _, field := range fields {
+		t.Run(field, func(t *testing.T) {
+			if newTestConfigLoader(t, field+"\n").Config() == nil {
+				t.Errorf("lenient config rejected")
+			}
+			if newTestConfigLoader(t, "strict_config: true\n"+field+"\n").Config() != nil {
+				t.Errorf("strict config accepted")
+			}
+		})
+	}
+}
+
+func TestI2CTimingProfiles(t *testing.T) {
+	tests := []struct {
+		timing leds.LedTiming
+		want   leds.LedTiming
+	}{
+		{leds.LedTiming{}, leds.DefaultLedTiming},
+		{
+			leds.LedTiming{Profile: "fast"},
+			leds.LedTiming{Profile: "fast", MaxRetry: 5, ModificationDelay: 200 * time.Microsecond, RetryDelay: 200 * time.Microsecond, QueryDelay: 200 * time.Microsecond},
+		},
+		{
+			leds.LedTiming{Profile: "default"},
+			leds.LedTiming{Profile: "default", MaxRetry: 5, ModificationDelay: 500 * time.Microsecond, RetryDelay: 500 * time.Microsecond, QueryDelay: 500 * time.Microsecond},
+		},
+		{
+			leds.LedTiming{Profile: "slow"},
+			leds.LedTiming{Profile: "slow", MaxRetry: 8, ModificationDelay: 2 * time.Millisecond, RetryDelay: 2 * time.Millisecond, QueryDelay: 2 * time.Millisecond},
+		},
+		// explicit fields override the profile
+		{
+			leds.LedTiming{Profile: "slow", QueryDelay: 5 * time.Millisecond, BatchConfirm: true},
+			leds.LedTiming{Profile: "slow", MaxRetry: 8, ModificationDelay: 2 * time.Millisecond, RetryDelay: 2 * time.Millisecond, QueryDelay: 5 * time.Millisecond, BatchConfirm: true},
+		},
+		{
+			leds.LedTiming{Profile: "turbo"},
+			leds.LedTiming{Profile: "default", MaxRetry: 5, ModificationDelay: 500 * time.Microsecond, RetryDelay: 500 * time.Microsecond, QueryDelay: 500 * time.Microsecond},
+		},
+	}
+	for _, tt := range tests {
+		if got, err := (&Config{}).normalizeLedTiming(tt.timing); err != nil || got != tt.want {
+			t.Errorf("normalizeLedTiming(%+v) = %+v, %v, want %+v", tt.timing, got, err, tt.want)
+		}
+	}
+}
+
+func TestLanLedSource(t *testing.T) {
+	tests := []struct {
+		yaml        string
+		source      string
+		readNetwork bool
+	}{
+		{"", LanLedSourceNetwork, true},
+		{"enable_lan_led: false\n", LanLedSourceOff, false},
+		{"lan_led_source: disk_total\n", LanLedSourceDiskTotal, false},
+		{"lan_led_source: disk_total\nenable_lan_led: true\n", LanLedSourceDiskTotal, false},
+		{"lan_led_source: off\n", LanLedSourceOff, false},
+		{"lan_led_source: wifi\n", LanLedSourceNetwork, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		loader, err := NewConfigLoader(path)
+		if err != nil {
+			t.Fatalf("failed to create config loader: %v", err)
+		}
+		cfg := loader.Config()
+		if cfg == nil {
+			t.Fatalf("%q: expected valid config", tt.yaml)
+		}
+		if cfg.LanLedSource != tt.source || *cfg.EnableLanLed != tt.readNetwork {
+			t.Errorf("%q: lan_led_source %s, enable_lan_led %v; want %s, %v", tt.yaml, cfg.LanLedSource, *cfg.EnableLanLed, tt.source, tt.readNetwork)
+		}
+	}
+}
+
+func TestActivityWeights(t *testing.T) {
+	tests := []struct {
+		yaml        string
+		read, write float64
+	}{
+		{"", 1, 1},
+		{"read_weight: 0.5\nwrite_weight: 4\n", 0.5, 4},
+		{"read_weight: -1\nwrite_weight: 1000\n", 1, maxActivityWeight},
+		{"read_weight: 0\n", 0, 1},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		loader, err := NewConfigLoader(path)
+		if err != nil {
+			t.Fatalf("failed to create config loader: %v", err)
+		}
+		cfg := loader.Config()
+		if cfg == nil {
+			t.Fatalf("%q: expected valid config", tt.yaml)
+		}
+		if *cfg.ReadWeight != tt.read || *cfg.WriteWeight != tt.write {
+			t.Errorf("%q: weights %g, %g; want %g, %g", tt.yaml, *cfg.ReadWeight, *cfg.WriteWeight, tt.read, tt.write)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+const (
+	defaultDemoStep     = 150 * time.Millisecond
+	demoBrightness      = maxActiveBrightness
+	demoTrailBrightness = minActiveBrightness
+)
+
+// demoFrame returns each disk bay's brightness for a frame of the demo
+// sweep: a bright dot at bay frame % bays with a dim trail behind it, and 0
+// (off) elsewhere
+func demoFrame(frame, bays int) []byte {
+	levels := make([]byte, bays)
+	if bays == 0 {
+		return levels
+	}
+	pos := frame % bays
+	if bays > 1 {
+		levels[(pos+bays-1)%bays] = demoTrailBrightness
+	}
+	levels[pos] = demoBrightness
+	return levels
+}
+
+// runDemo sweeps a white dot across the disk LEDs, one bay per step, until
+// ctx is cancelled, then turns the LEDs off. It writes through the same
+// batched path as the monitor, without reading any disk activity.
+func (am *ActivityMonitor) runDemo(ctx context.Context, step time.Duration) {
+	log.Printf("Running LED demo (%s, %s per step), press Ctrl-C to stop", am.layout.Model, step)
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		am.leds.BeginBatch()
+		for bay, brightness := range demoFrame(frame, am.layout.DiskBays) {
+			ledIndex := firstDiskLedIndex + bay
+			if brightness == 0 {
+				am.setLedMode(ledIndex, leds.LedModeOff, nil)
+				continue
+			}
+			am.setLedColor(ledIndex, 255, 255, 255)
+			am.setLedBrightness(ledIndex, brightness)
+			am.setLedMode(ledIndex, leds.LedModeOn, nil)
+		}
+		for id, err := range am.leds.EndBatch() {
+			am.logLedError(id, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			am.turnOffLeds()
+			return
+		case <-ticker.C:
+		}
+	}
+}
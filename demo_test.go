@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDemoFrame(t *testing.T) {
+	const bright, dim = demoBrightness, demoTrailBrightness
+	want := [][]byte{
+		{bright, 0, 0, dim},
+		{dim, bright, 0, 0},
+		{0, dim, bright, 0},
+		{0, 0, dim, bright},
+		{bright, 0, 0, dim}, // wraps
+	}
+	for frame, w := range want {
+		if got := demoFrame(frame, 4); !slices.Equal(got, w) {
+			t.Errorf("frame %d = %v, want %v", frame, got, w)
+		}
+	}
+
+	if got := demoFrame(3, 1); !slices.Equal(got, []byte{bright}) {
+		t.Errorf("single bay frame = %v, want always lit", got)
+	}
+	if got := demoFrame(3, 0); len(got) != 0 {
+		t.Errorf("expected no levels without bays, got %v", got)
+	}
+}
+
+func TestRunDemoTurnsOffLeds(t *testing.T) {
+	am := newTestMonitor(t)
+	am.layout = layoutForDiskCount(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	am.runDemo(ctx, 10*time.Millisecond)
+
+	for _, state := range am.leds.LedStates() {
+		if state.Index >= firstDiskLedIndex && state.Mode != "off" {
+			t.Errorf("%s left %s after the demo", state.Name, state.Mode)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -12,35 +17,97 @@ import (
 
 // DiskInfo describes a disk
 type DiskInfo struct {
-	Name   string
-	HCTL   string
-	Serial string
-	Path   string // by-path link name, for sorting
-	PCIBus string // e.g. 0000:59:00.0
-	Port   int    // e.g. 1 for -ata-1
+	Name   string `json:"name"`
+	HCTL   string `json:"hctl"`
+	Serial string `json:"serial"`
+	Path   string `json:"path"`    // by-path link name, for sorting
+	PCIBus string `json:"pci_bus"` // e.g. 0000:59:00.0
+	Port   int    `json:"port"`    // e.g. 1 for -ata-1 or -nvme-1
+	Type   string `json:"type"`    // DiskTypeSATA or DiskTypeNVMe
 }
 
+// Disk types
+const (
+	DiskTypeSATA = "sata"
+	DiskTypeNVMe = "nvme"
+)
+
+// diskStatsSectorSize is the unit of the sector counts in /proc/diskstats
+const diskStatsSectorSize = 512
+
 type DiskActivity struct {
-	Reads    uint64
-	Writes   uint64
-	Activity uint64 // Reads + Writes
+	Reads    uint64 // bytes read
+	Writes   uint64 // bytes written
+	Activity uint64 // Reads + Writes, plus Discards with count_discards
+	Discards uint64 // bytes discarded (TRIM), 0 before Linux 4.18
+
+	ReadIOs     uint64 // reads completed
+	WriteIOs    uint64 // writes completed
+	ReadTicks   uint64 // ms spent reading
+	WriteTicks  uint64 // ms spent writing
+	InFlight    uint64 // I/Os currently in progress
+	IOTicks     uint64 // ms spent doing I/O
+	TimeInQueue uint64 // weighted ms spent doing I/O
+}
+
+// DiscoveryWarning records a disk or by-path entry that discovery skipped or
+// could only partly describe
+type DiscoveryWarning struct {
+	Entry  string `json:"entry"`
+	Reason string `json:"reason"`
+}
+
+func (w DiscoveryWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Entry, w.Reason)
+}
+
+// discoverySummary formats a one-line discovery result, e.g.
+// "3 disks found, 1 skipped: pci-0000:59:00.0-ata-x: invalid ata port"
+func discoverySummary(disks []DiskInfo, warnings []DiscoveryWarning) string {
+	summary := fmt.Sprintf("%d disks found", len(disks))
+	if len(warnings) == 0 {
+		return summary
+	}
+	reasons := make([]string, len(warnings))
+	for i, w := range warnings {
+		reasons[i] = w.String()
+	}
+	return fmt.Sprintf("%s, %d skipped: %s", summary, len(warnings), strings.Join(reasons, "; "))
+}
+
+// disksJSON formats discovered disks for --list-disks, as an array even when
+// no disks were found
+func disksJSON(disks []DiskInfo) ([]byte, error) {
+	if disks == nil {
+		disks = []DiskInfo{}
+	}
+	return json.MarshalIndent(disks, "", "  ")
 }
 
-func discoverDisks() ([]DiskInfo, error) {
+// discoverDisks finds the bay disks on the running system. Entries that can't
+// be resolved or parsed are skipped and reported as warnings; the error is
+// only set when discovery can't run at all.
+func discoverDisks() ([]DiskInfo, []DiscoveryWarning, error) {
+	return discoverDisksIn("/")
+}
+
+// discoverDisksIn is discoverDisks with /sys, /dev, and /run rooted at root
+func discoverDisksIn(root string) ([]DiskInfo, []DiscoveryWarning, error) {
 	var disks []DiskInfo
+	var warnings []DiscoveryWarning
 
-	serials, err := getBlockDevicesSerials()
+	serials, err := getBlockDevicesSerials(root)
 	if err != nil {
-		log.Printf("Error getting block device serials: %v", err)
+		warnings = append(warnings, DiscoveryWarning{Entry: "serials", Reason: err.Error()})
 	}
 
 	// Map device name -> HCTL
 	hctlMap := make(map[string]string)
 
-	scsiDiskDir := "/sys/class/scsi_disk/"
+	scsiDiskDir := filepath.Join(root, "sys/class/scsi_disk")
 	entries, err := os.ReadDir(scsiDiskDir)
-	if err != nil {
-		return nil, err
+	if err != nil && !os.IsNotExist(err) {
+		return nil, warnings, err
 	}
 
 	for _, entry := range entries {
@@ -55,10 +122,10 @@ func discoverDisks() ([]DiskInfo, error) {
 		hctlMap[name] = hctl
 	}
 
-	byPathDir := "/dev/disk/by-path"
+	byPathDir := filepath.Join(root, "dev/disk/by-path")
 	byPathEntries, err := os.ReadDir(byPathDir)
 	if err != nil {
-		return nil, err
+		return nil, warnings, err
 	}
 
 	seen := make(map[string]bool)
@@ -69,12 +136,14 @@ func discoverDisks() ([]DiskInfo, error) {
 
 		resolved, err := filepath.EvalSymlinks(fullPath)
 		if err != nil {
+			warnings = append(warnings, DiscoveryWarning{Entry: name, Reason: "unresolvable link"})
 			continue
 		}
 
 		dev := filepath.Base(resolved)
 
-		if !strings.HasPrefix(dev, "sd") || len(dev) != 3 {
+		diskType := diskTypeForDevice(dev)
+		if diskType == "" {
 			continue
 		}
 
@@ -83,10 +152,14 @@ func discoverDisks() ([]DiskInfo, error) {
 		}
 		seen[dev] = true
 
-		// Expect name like pci-0000:59:00.0-ata-1
-		bus, port, err := parsePCIAta(name)
+		// Expect name like pci-0000:59:00.0-ata-1 or pci-0000:01:00.0-nvme-1
+		token := "ata"
+		if diskType == DiskTypeNVMe {
+			token = "nvme"
+		}
+		bus, port, err := parsePCIToken(name, token)
 		if err != nil {
-			log.Printf("Skipping entry %q: %v", name, err)
+			warnings = append(warnings, DiscoveryWarning{Entry: name, Reason: err.Error()})
 			continue
 		}
 
@@ -97,10 +170,13 @@ func discoverDisks() ([]DiskInfo, error) {
 			Path:   name,
 			PCIBus: bus,
 			Port:   port,
+			Type:   diskType,
 		})
 	}
 
-	// Sort: first by PCI bus, then by ATA port
+	disks = append(disks, sysfsNvmeDisks(root, seen, serials)...)
+
+	// Sort: first by PCI bus, then by ATA port or NVMe namespace
 	sort.Slice(disks, func(i, j int) bool {
 		if disks[i].PCIBus != disks[j].PCIBus {
 			return disks[i].PCIBus > disks[j].PCIBus
@@ -108,11 +184,95 @@ func discoverDisks() ([]DiskInfo, error) {
 		return disks[i].Port < disks[j].Port
 	})
 
-	return disks, nil
+	return disks, warnings, nil
 }
 
-// parsePCIAta parses a by-path name like "pci-0000:59:00.0-ata-1" and extracts bus address and port number
-func parsePCIAta(name string) (string, int, error) {
+// excludeDisks removes the disks whose serial or device name, with or
+// without /dev/, is listed in exclude, returning the kept and the removed
+// disks
+func excludeDisks(disks []DiskInfo, exclude []string) ([]DiskInfo, []DiskInfo) {
+	if len(exclude) == 0 {
+		return disks, nil
+	}
+	var kept, excluded []DiskInfo
+	for _, disk := range disks {
+		if slices.ContainsFunc(exclude, func(e string) bool {
+			return (disk.Serial != "" && e == disk.Serial) || strings.TrimPrefix(e, "/dev/") == disk.Name
+		}) {
+			excluded = append(excluded, disk)
+			continue
+		}
+		kept = append(kept, disk)
+	}
+	return kept, excluded
+}
+
+// orderDisks moves the disks whose serials are listed in order to the front,
+// in that order, followed by the unlisted disks in their discovery order. It
+// returns the listed serials that match no disk.
+func orderDisks(disks []DiskInfo, order []string) ([]DiskInfo, []string) {
+	bySerial := make(map[string]int, len(disks))
+	for i, disk := range disks {
+		if disk.Serial != "" {
+			bySerial[disk.Serial] = i
+		}
+	}
+	ordered := make([]DiskInfo, 0, len(disks))
+	placed := make([]bool, len(disks))
+	var missing []string
+	for _, serial := range order {
+		i, ok := bySerial[serial]
+		if !ok {
+			missing = append(missing, serial)
+			continue
+		}
+		ordered = append(ordered, disks[i])
+		placed[i] = true
+	}
+	for i, disk := range disks {
+		if !placed[i] {
+			ordered = append(ordered, disk)
+		}
+	}
+	return ordered, missing
+}
+
+// diskSerials lists the disks' serials for messages
+func diskSerials(disks []DiskInfo) string {
+	var serials []string
+	for _, disk := range disks {
+		if disk.Serial != "" {
+			serials = append(serials, disk.Serial)
+		}
+	}
+	if len(serials) == 0 {
+		return "none"
+	}
+	return strings.Join(serials, ", ")
+}
+
+// diskTypeForDevice returns the disk type for a whole-disk device name like
+// "sda" or "nvme0n1", or "" for partitions and other devices
+func diskTypeForDevice(dev string) string {
+	if strings.HasPrefix(dev, "sd") && len(dev) == 3 {
+		return DiskTypeSATA
+	}
+	if nvmeDevicePattern.MatchString(dev) {
+		return DiskTypeNVMe
+	}
+	return ""
+}
+
+var nvmeDevicePattern = regexp.MustCompile(`^nvme[0-9]+n[0-9]+$`)
+
+// pciTokenUnits names the number after each by-path transport token, for
+// parse errors
+var pciTokenUnits = map[string]string{"ata": "port", "nvme": "namespace"}
+
+// parsePCIToken parses a by-path name like "pci-0000:59:00.0-ata-1" or
+// "pci-0000:01:00.0-nvme-1" and extracts the bus address and the number
+// after token, the ATA port or NVMe namespace
+func parsePCIToken(name, token string) (string, int, error) {
 	parts := strings.Split(name, "-")
 	if len(parts) < 3 {
 		return "", 0, fmt.Errorf("invalid format")
@@ -124,26 +284,78 @@ func parsePCIAta(name string) (string, int, error) {
 		if parts[i] == "pci" && i+1 < len(parts) {
 			bus = parts[i+1]
 		}
-		if parts[i] == "ata" && i+1 < len(parts) {
+		if parts[i] == token && i+1 < len(parts) {
 			p, err := strconv.Atoi(parts[i+1])
 			if err != nil {
-				return "", 0, fmt.Errorf("invalid ata port")
+				return "", 0, fmt.Errorf("invalid %s %s", token, pciTokenUnits[token])
 			}
 			port = p
 		}
 	}
 	if bus == "" || port == 0 {
-		return "", 0, fmt.Errorf("missing pci bus or ata port")
+		return "", 0, fmt.Errorf("missing pci bus or %s %s", token, pciTokenUnits[token])
 	}
 	return bus, port, nil
 }
 
-// read disk serials from /run/udev by mapping major:minor to serial
-func getBlockDevicesSerials() (map[string]string, error) {
+var pciAddressPattern = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// sysfsNvmeDisks finds the NVMe namespaces of PCIe controllers under
+// /sys/class/nvme that aren't in seen, for udev versions that don't create
+// their /dev/disk/by-path links. Path is set to the link name udev would
+// have given them. Controllers not on PCIe, as over fabrics, aren't bay
+// disks and are skipped.
+func sysfsNvmeDisks(root string, seen map[string]bool, serials map[string]string) []DiskInfo {
+	nvmeDir := filepath.Join(root, "sys/class/nvme")
+	controllers, err := os.ReadDir(nvmeDir)
+	if err != nil {
+		return nil
+	}
+	var disks []DiskInfo
+	for _, controller := range controllers {
+		device, err := filepath.EvalSymlinks(filepath.Join(nvmeDir, controller.Name(), "device"))
+		if err != nil {
+			continue
+		}
+		bus := filepath.Base(device)
+		if !pciAddressPattern.MatchString(bus) {
+			continue
+		}
+		namespaces, err := os.ReadDir(filepath.Join(nvmeDir, controller.Name()))
+		if err != nil {
+			continue
+		}
+		for _, ns := range namespaces {
+			dev := ns.Name()
+			if !nvmeDevicePattern.MatchString(dev) || seen[dev] {
+				continue
+			}
+			n, err := strconv.Atoi(dev[strings.LastIndexByte(dev, 'n')+1:])
+			if err != nil || n == 0 {
+				continue
+			}
+			seen[dev] = true
+			disks = append(disks, DiskInfo{
+				Name:   dev,
+				Serial: serials[dev],
+				Path:   fmt.Sprintf("pci-%s-nvme-%d", bus, n),
+				PCIBus: bus,
+				Port:   n,
+				Type:   DiskTypeNVMe,
+			})
+		}
+	}
+	return disks
+}
+
+// getBlockDevicesSerials reads disk serials from /run/udev by mapping
+// major:minor to serial, falling back to sysfs for devices udev doesn't
+// describe (minimal containers, early boot)
+func getBlockDevicesSerials(root string) (map[string]string, error) {
 	serials := make(map[string]string)
 
-	blockDir := "/sys/block"
-	udevDir := "/run/udev/data"
+	blockDir := filepath.Join(root, "sys/block")
+	udevDir := filepath.Join(root, "run/udev/data")
 
 	entries, err := os.ReadDir(blockDir)
 	if err != nil {
@@ -152,54 +364,328 @@ func getBlockDevicesSerials() (map[string]string, error) {
 
 	for _, entry := range entries {
 		dev := entry.Name()
-		devPath := filepath.Join(blockDir, dev, "dev")
-		devNumBytes, err := os.ReadFile(devPath)
+		serial := udevSerial(udevDir, filepath.Join(blockDir, dev))
+		if serial == "" {
+			serial = sysfsSerial(filepath.Join(blockDir, dev, "device"))
+		}
+		if serial != "" {
+			serials[dev] = serial
+		}
+	}
+
+	return serials, nil
+}
+
+// udevSerial returns ID_SERIAL_SHORT from the udev database entry for the
+// block device at devDir, or "" if there is none
+func udevSerial(udevDir, devDir string) string {
+	devNumBytes, err := os.ReadFile(filepath.Join(devDir, "dev"))
+	if err != nil {
+		return ""
+	}
+	devNum := strings.TrimSpace(string(devNumBytes)) // e.g. "8:0"
+	data, err := os.ReadFile(filepath.Join(udevDir, "b"+devNum))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if serial, ok := strings.CutPrefix(line, "E:ID_SERIAL_SHORT="); ok {
+			return serial
+		}
+	}
+	return ""
+}
+
+// sysfsSerial reads a serial from a block device's sysfs device directory:
+// the serial attribute (NVMe), else the SCSI unit serial number VPD page
+func sysfsSerial(deviceDir string) string {
+	if data, err := os.ReadFile(filepath.Join(deviceDir, "serial")); err == nil {
+		if serial := strings.TrimSpace(string(data)); serial != "" {
+			return serial
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(deviceDir, "vpd_pg80")); err == nil {
+		return parseVPDSerial(data)
+	}
+	return ""
+}
+
+// parseVPDSerial extracts the serial from a unit serial number VPD page
+// (0x80): a 4-byte header with the page length in bytes 2-3, then the
+// space-padded serial
+func parseVPDSerial(page []byte) string {
+	if len(page) < 4 || page[1] != 0x80 {
+		return ""
+	}
+	n := int(page[2])<<8 | int(page[3])
+	if n > len(page)-4 {
+		n = len(page) - 4
+	}
+	return strings.TrimSpace(string(page[4 : 4+n]))
+}
+
+// counterDelta returns curr-prev, treating a counter that went backwards
+// (device reset or wraparound) as no activity
+func counterDelta(prev, curr uint64) uint64 {
+	if curr < prev {
+		return 0
+	}
+	return curr - prev
+}
+
+// diskDelta returns the activity between two samples of the same disk.
+// havePrev is false when the disk was missing from the previous sample, in
+// which case its counters carry no information about this interval.
+func diskDelta(prev, curr DiskActivity, havePrev bool) DiskActivity {
+	if !havePrev {
+		return DiskActivity{}
+	}
+	reads := counterDelta(prev.Reads, curr.Reads)
+	writes := counterDelta(prev.Writes, curr.Writes)
+	discards := counterDelta(prev.Discards, curr.Discards)
+	return DiskActivity{Reads: reads, Writes: writes, Activity: reads + writes, Discards: discards}
+}
+
+// diskDeltas returns the per-disk activity between two samples. Disks missing
+// from curr are omitted so the caller can turn their LEDs off.
+func diskDeltas(prevStats, currStats map[string]DiskActivity) map[string]DiskActivity {
+	return diskDeltasInto(make(map[string]DiskActivity, len(currStats)), prevStats, currStats)
+}
+
+// diskDeltasInto is diskDeltas reusing the deltas map, which it clears first
+func diskDeltasInto(deltas, prevStats, currStats map[string]DiskActivity) map[string]DiskActivity {
+	clear(deltas)
+	for dev, curr := range currStats {
+		prev, ok := prevStats[dev]
+		deltas[dev] = diskDelta(prev, curr, ok)
+	}
+	return deltas
+}
+
+// Disk I/O directions selectable with count
+const (
+	CountReadWrite = "read_write"
+	CountRead      = "read"
+	CountWrite     = "write"
+)
+
+// countedActivity returns a with the counters of the direction count
+// excludes zeroed
+func countedActivity(a DiskActivity, count string) DiskActivity {
+	switch count {
+	case CountRead:
+		a.Writes, a.WriteIOs, a.WriteTicks, a.Discards = 0, 0, 0, 0
+	case CountWrite:
+		a.Reads, a.ReadIOs, a.ReadTicks = 0, 0, 0
+	default:
+		return a
+	}
+	a.Activity = a.Reads + a.Writes
+	return a
+}
+
+// applyActivityCount restricts the deltas to the direction count includes
+func applyActivityCount(deltas map[string]DiskActivity, count string) {
+	for dev, delta := range deltas {
+		deltas[dev] = countedActivity(delta, count)
+	}
+}
+
+// applyDiscards adds the discarded bytes to the activity of each disk, so
+// TRIM from fstrim or online discard lights the LEDs like writes
+func applyDiscards(deltas map[string]DiskActivity) {
+	for dev, delta := range deltas {
+		delta.Activity += delta.Discards
+		deltas[dev] = delta
+	}
+}
+
+// applyActivityFloor zeroes the deltas of disks that moved fewer than floor
+// bytes, so background housekeeping leaves their LEDs idle
+func applyActivityFloor(deltas map[string]DiskActivity, floor uint64) {
+	if floor == 0 {
+		return
+	}
+	for dev, delta := range deltas {
+		if delta.Activity < floor {
+			deltas[dev] = DiskActivity{}
+		}
+	}
+}
+
+// applyActivityWeights scales the read and the remaining, write side of each
+// disk's activity by its weight, so one direction lights the LEDs brighter
+// than the other. Reads and Writes stay raw for the read/write color.
+func applyActivityWeights(deltas map[string]DiskActivity, readWeight, writeWeight float64) {
+	if readWeight == 1 && writeWeight == 1 {
+		return
+	}
+	for dev, delta := range deltas {
+		writes := delta.Activity - delta.Reads // with discards when counted
+		delta.Activity = uint64(readWeight*float64(delta.Reads) + writeWeight*float64(writes))
+		deltas[dev] = delta
+	}
+}
+
+// diskStatsReader reads the counters for a fixed set of devices from
+// /proc/diskstats, reusing its read buffer between polls. It alternates
+// between two maps, so a result stays valid until the second Read after it,
+// long enough to serve as the previous sample.
+type diskStatsReader struct {
+	path      string
+	wanted    map[string]string
+	buf       []byte
+	stats     map[string]DiskActivity
+	prevStats map[string]DiskActivity
+}
+
+func newDiskStatsReader(devices []string) *diskStatsReader {
+	return &diskStatsReader{
+		path:      "/proc/diskstats",
+		wanted:    deviceSet(devices),
+		buf:       make([]byte, 0, 4096),
+		stats:     make(map[string]DiskActivity, len(devices)),
+		prevStats: make(map[string]DiskActivity, len(devices)),
+	}
+}
+
+// deviceSet returns devices as a set for lookups by name. Each name maps to
+// itself, so a name found by its bytes can be used without allocating.
+func deviceSet(devices []string) map[string]string {
+	set := make(map[string]string, len(devices))
+	for _, dev := range devices {
+		set[dev] = dev
+	}
+	return set
+}
+
+// Read returns the current counters of the reader's devices
+func (r *diskStatsReader) Read() (map[string]DiskActivity, error) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return make(map[string]DiskActivity), err
+	}
+	defer f.Close()
+
+	// procfs reports a size of 0, so read until EOF, growing the buffer as needed
+	r.buf = r.buf[:0]
+	for {
+		if len(r.buf) == cap(r.buf) {
+			r.buf = append(r.buf, 0)[:len(r.buf)]
+		}
+		n, err := f.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
+			return make(map[string]DiskActivity), err
+		}
+	}
+	r.stats, r.prevStats = r.prevStats, r.stats
+	return parseDiskStats(r.buf, r.wanted, r.stats), nil
+}
+
+// /proc/diskstats layouts. Every kernel since 2.6 has the 14 classic fields,
+// through the weighted time in queue. Linux 4.18 appends 4 discard fields and
+// 5.5 appends 2 flush fields, for 18 and 20; the kernel only ever appends, so
+// the classic indices hold for all of them.
+const (
+	diskStatsFields         = 14 // minimum, the classic layout
+	diskStatsDiscardSectors = 16 // index of the discard sectors, 4.18+
+	diskStatsMaxFields      = 20 // with the flush fields, 5.5+
+)
+
+// parseDiskStats extracts the counters for the wanted devices from
+// /proc/diskstats data in a single pass into stats, which it clears first.
+// Reads and Writes are in bytes. The kernel reports sectors in fixed 512-byte
+// units regardless of the device's logical block size, so 512n, 512e, and
+// 4Kn drives all convert the same way.
+func parseDiskStats(data []byte, wanted map[string]string, stats map[string]DiskActivity) map[string]DiskActivity {
+	clear(stats)
+	var fields [diskStatsMaxFields][]byte
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		n := splitFields(line, fields[:])
+		if n < diskStatsFields {
 			continue
 		}
-		devNum := strings.TrimSpace(string(devNumBytes)) // e.g. "8:0"
-		udevFile := filepath.Join(udevDir, "b"+devNum)
-		data, err := os.ReadFile(udevFile)
-		if err != nil {
+		name, ok := wanted[string(fields[2])]
+		if !ok {
 			continue
 		}
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "E:ID_SERIAL_SHORT=") {
-				serial := strings.TrimPrefix(line, "E:ID_SERIAL_SHORT=")
-				serials[dev] = serial
-				break
-			}
+		reads := parseUintBytes(fields[5]) * diskStatsSectorSize
+		writes := parseUintBytes(fields[9]) * diskStatsSectorSize
+		var discards uint64
+		if n > diskStatsDiscardSectors {
+			discards = parseUintBytes(fields[diskStatsDiscardSectors]) * diskStatsSectorSize
+		}
+		stats[name] = DiskActivity{
+			Reads:       reads,
+			Writes:      writes,
+			Activity:    reads + writes,
+			Discards:    discards,
+			ReadIOs:     parseUintBytes(fields[3]),
+			WriteIOs:    parseUintBytes(fields[7]),
+			ReadTicks:   parseUintBytes(fields[6]),
+			WriteTicks:  parseUintBytes(fields[10]),
+			InFlight:    parseUintBytes(fields[11]),
+			IOTicks:     parseUintBytes(fields[12]),
+			TimeInQueue: parseUintBytes(fields[13]),
 		}
 	}
+	return stats
+}
 
-	return serials, nil
+// splitFields fills fields with the space-separated fields of line, up to
+// len(fields), and returns how many it found
+func splitFields(line []byte, fields [][]byte) int {
+	n := 0
+	for n < len(fields) {
+		for len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			line = line[1:]
+		}
+		if len(line) == 0 {
+			break
+		}
+		end := 0
+		for end < len(line) && line[end] != ' ' && line[end] != '\t' {
+			end++
+		}
+		fields[n] = line[:end]
+		line = line[end:]
+		n++
+	}
+	return n
 }
 
-func getDiskActivity(devices []string) (map[string]DiskActivity, error) {
-	stats := make(map[string]DiskActivity)
-	data, err := os.ReadFile("/proc/diskstats")
-	if err != nil {
-		return stats, err
+// parseUintBytes parses a decimal counter without allocating, returning 0
+// for anything that isn't a valid uint64, like strconv.ParseUint's error case
+func parseUintBytes(b []byte) uint64 {
+	v, _ := parseCounter(b)
+	return v
+}
+
+// parseCounter parses a decimal counter without allocating. ok is false,
+// and the value 0, for anything that isn't a valid uint64.
+func parseCounter(b []byte) (v uint64, ok bool) {
+	if len(b) == 0 {
+		return 0, false
 	}
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) < 14 {
-			continue
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
 		}
-		name := fields[2]
-		for _, dev := range devices {
-			if name == dev {
-				reads, _ := strconv.ParseUint(fields[5], 10, 64)  // sectors read
-				writes, _ := strconv.ParseUint(fields[9], 10, 64) // sectors written
-				stats[dev] = DiskActivity{
-					Reads:    reads,
-					Writes:   writes,
-					Activity: reads + writes,
-				}
-			}
+		d := uint64(c - '0')
+		if v > (math.MaxUint64-d)/10 {
+			return 0, false
 		}
+		v = v*10 + d
 	}
-	return stats, nil
+	return v, true
 }
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestDiskDelta(t *testing.T) {
+	tests := []struct {
+		name     string
+		prev     DiskActivity
+		curr     DiskActivity
+		havePrev bool
+		want     DiskActivity
+	}{
+		{
+			name:     "normal",
+			prev:     DiskActivity{Reads: 100, Writes: 200},
+			curr:     DiskActivity{Reads: 150, Writes: 260},
+			havePrev: true,
+			want:     DiskActivity{Reads: 50, Writes: 60, Activity: 110},
+		},
+		{
+			name:     "counter reset",
+			prev:     DiskActivity{Reads: 1000, Writes: 2000},
+			curr:     DiskActivity{Reads: 10, Writes: 2010},
+			havePrev: true,
+			want:     DiskActivity{Reads: 0, Writes: 10, Activity: 10},
+		},
+		{
+			name:     "missing from previous sample",
+			curr:     DiskActivity{Reads: 1000, Writes: 2000},
+			havePrev: false,
+			want:     DiskActivity{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diskDelta(tt.prev, tt.curr, tt.havePrev)
+			if got != tt.want {
+				t.Errorf("diskDelta() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiskDeltasMissingDevice(t *testing.T) {
+	prev := map[string]DiskActivity{
+		"sda": {Reads: 10, Writes: 10},
+		"sdb": {Reads: 10, Writes: 10},
+	}
+	curr := map[string]DiskActivity{
+		"sda": {Reads: 20, Writes: 10},
+	}
+
+	deltas := diskDeltas(prev, curr)
+	if got := deltas["sda"].Activity; got != 10 {
+		t.Errorf("expected sda activity 10, got %d", got)
+	}
+	if _, ok := deltas["sdb"]; ok {
+		t.Errorf("expected missing sdb to be omitted, got %+v", deltas["sdb"])
+	}
+}
+
+func TestParseDiskStatsBytes(t *testing.T) {
+	// sda is a 512n drive and sdb a 4Kn drive; both report 512-byte sectors
+	data := []byte(`   8       0 sda 100 0 8 10 200 0 16 20 0 30 30
+   8      16 sdb 100 0 8 10 200 0 16 20 0 30 30
+   8      32 sdc 100 0 8 10 200 0 16 20 0 30 30
+`)
+	stats := parseDiskStats(data, deviceSet([]string{"sda", "sdb"}), make(map[string]DiskActivity))
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(stats))
+	}
+	for _, dev := range []string{"sda", "sdb"} {
+		s := stats[dev]
+		if s.Reads != 8*512 || s.Writes != 16*512 || s.Activity != 24*512 {
+			t.Errorf("%s: expected reads=4096 writes=8192 activity=12288, got %+v", dev, s)
+		}
+		if s.ReadIOs != 100 || s.WriteIOs != 200 || s.IOTicks != 30 {
+			t.Errorf("%s: unexpected I/O counters %+v", dev, s)
+		}
+	}
+}
+
+// syntheticDiskStats returns /proc/diskstats data for n disks named sda
+// onward, each with one partition
+func syntheticDiskStats(n int) ([]byte, []string) {
+	var b strings.Builder
+	var names []string
+	for i := range n {
+		name := "sd" + string(rune('a'+i/26)) + string(rune('a'+i%26))
+		if i < 26 {
+			name = "sd" + string(rune('a'+i))
+		}
+		names = append(names, name)
+		fmt.Fprintf(&b, "   8 %7d %s %d 789 98765432 4321 234567 890 87654321 5432 0 67890 9753 0 0 0 0 0 0\n", i*16, name, i)
+		fmt.Fprintf(&b, "   8 %7d %s1 1234 0 98765 43 2345 0 8765 54 0 678 97 0 0 0 0 0 0\n", i*16+1, name)
+	}
+	return []byte(b.String()), names
+}
+
+func TestDiskStatsReader(t *testing.T) {
+	// Large enough to grow the read buffer several times
+	data, names := syntheticDiskStats(200)
+	path := filepath.Join(t.TempDir(), "diskstats")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wanted := []string{names[0], names[57], names[199], "sdzz"}
+	r := newDiskStatsReader(wanted)
+	r.path = path
+
+	for range 2 { // the second read reuses the buffer
+		stats, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if len(stats) != 3 {
+			t.Fatalf("expected 3 devices, got %d: %v", len(stats), stats)
+		}
+		for _, i := range []int{0, 57, 199} {
+			s := stats[names[i]]
+			if s.ReadIOs != uint64(i) || s.Reads != 98765432*512 || s.Writes != 87654321*512 || s.TimeInQueue != 9753 {
+				t.Errorf("%s: unexpected counters %+v", names[i], s)
+			}
+		}
+	}
+
+	r.path = filepath.Join(t.TempDir(), "missing")
+	if stats, err := r.Read(); err == nil || len(stats) != 0 {
+		t.Errorf("expected an error and no stats for a missing file, got %v, %v", stats, err)
+	}
+}
+
+func TestDiskStatsReaderKeepsPrevious(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diskstats")
+	r := newDiskStatsReader([]string{"sda"})
+	r.path = path
+	read := func(line string) map[string]DiskActivity {
+		if err := os.WriteFile(path, []byte(line), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		return stats
+	}
+	prev := read("   8       0 sda 100 0 8 10 200 0 16 20 0 30 30\n")
+	curr := read("   8       0 sda 150 0 8 10 200 0 16 20 0 30 30\n")
+	if prev["sda"].ReadIOs != 100 || curr["sda"].ReadIOs != 150 {
+		t.Errorf("expected the previous result kept through the next read, got %+v and %+v", prev, curr)
+	}
+}
+
+func TestParseDiskStatsNoTrailingNewline(t *testing.T) {
+	data := []byte("   8       0 sda 100 0 8 10 200 0 16 20 0 30 30")
+	if stats := parseDiskStats(data, deviceSet([]string{"sda"}), make(map[string]DiskActivity)); stats["sda"].ReadIOs != 100 {
+		t.Errorf("expected the last line parsed, got %+v", stats)
+	}
+}
+
+func TestParseUintBytes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"0", 0},
+		{"12345", 12345},
+		{"18446744073709551615", 18446744073709551615},
+		{"18446744073709551616", 0}, // overflow
+		{"12a", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseUintBytes([]byte(tt.in)); got != tt.want {
+			t.Errorf("parseUintBytes(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkParseDiskStats parses a 200-disk /proc/diskstats for the 8 bay
+// disks, as one poll does. Splitting every line into strings and comparing
+// each against every device cost about 400 allocations per poll on this
+// input; a single pass over the bytes only allocates the result.
+func BenchmarkParseDiskStats(b *testing.B) {
+	data, names := syntheticDiskStats(200)
+	wanted := deviceSet(names[:8])
+	stats := make(map[string]DiskActivity)
+	b.ReportAllocs()
+	for b.Loop() {
+		parseDiskStats(data, wanted, stats)
+	}
+}
+
+func TestParsePCIToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		bus     string
+		port    int
+		wantErr string
+	}{
+		{"pci-0000:59:00.0-ata-1", "ata", "0000:59:00.0", 1, ""},
+		{"pci-0000:01:00.0-nvme-1", "nvme", "0000:01:00.0", 1, ""},
+		{"pci-0000:02:00.0-nvme-2", "nvme", "0000:02:00.0", 2, ""},
+		{"pci-0000:59:00.0-ata-x", "ata", "", 0, "invalid ata port"},
+		{"pci-0000:01:00.0-nvme-x", "nvme", "", 0, "invalid nvme namespace"},
+		{"pci-0000:59:00.0-ata-1", "nvme", "", 0, "missing pci bus or nvme namespace"},
+		{"nvme", "nvme", "", 0, "invalid format"},
+	}
+	for _, tt := range tests {
+		bus, port, err := parsePCIToken(tt.name, tt.token)
+		if gotErr := fmt.Sprint(err); (err != nil || tt.wantErr != "") && gotErr != tt.wantErr {
+			t.Errorf("parsePCIToken(%q, %q) error = %v, want %q", tt.name, tt.token, err, tt.wantErr)
+			continue
+		}
+		if bus != tt.bus || port != tt.port {
+			t.Errorf("parsePCIToken(%q, %q) = %q, %d, want %q, %d", tt.name, tt.token, bus, port, tt.bus, tt.port)
+		}
+	}
+}
+
+func TestDiscoverDisksInSysfsNvme(t *testing.T) {
+	root := discoveryFixture(t)
+	mkdir := func(dir string) {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(target, name string) {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// nvme0 has no by-path link, nvme1 has one, and nvme2 is over fabrics
+	mkdir("sys/devices/pci0000:00/0000:01:00.0")
+	mkdir("sys/devices/pci0000:00/0000:02:00.0")
+	mkdir("sys/devices/virtual/nvme-fabrics/ctl")
+	for _, ctrl := range []string{"nvme0", "nvme1", "nvme2"} {
+		mkdir("sys/class/nvme/" + ctrl + "/" + ctrl + "n1")
+	}
+	symlink("../../../devices/pci0000:00/0000:01:00.0", "sys/class/nvme/nvme0/device")
+	symlink("../../../devices/pci0000:00/0000:02:00.0", "sys/class/nvme/nvme1/device")
+	symlink("../../../devices/virtual/nvme-fabrics/ctl", "sys/class/nvme/nvme2/device")
+	if err := os.WriteFile(filepath.Join(root, "dev/nvme1n1"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	symlink("../../nvme1n1", "dev/disk/by-path/pci-0000:02:00.0-nvme-1")
+
+	disks, _, err := discoverDisksIn(root)
+	if err != nil {
+		t.Fatalf("discoverDisksIn() error: %v", err)
+	}
+	var nvme []DiskInfo
+	for _, d := range disks {
+		if d.Type == DiskTypeNVMe {
+			nvme = append(nvme, d)
+		}
+	}
+	want := []DiskInfo{
+		{Name: "nvme1n1", Path: "pci-0000:02:00.0-nvme-1", PCIBus: "0000:02:00.0", Port: 1, Type: DiskTypeNVMe},
+		{Name: "nvme0n1", Path: "pci-0000:01:00.0-nvme-1", PCIBus: "0000:01:00.0", Port: 1, Type: DiskTypeNVMe},
+	}
+	if !reflect.DeepEqual(nvme, want) {
+		t.Errorf("NVMe disks = %+v, want %+v", nvme, want)
+	}
+}
+
+func TestDiskTypeForDevice(t *testing.T) {
+	tests := map[string]string{
+		"sda":       DiskTypeSATA,
+		"sda1":      "",
+		"nvme0n1":   DiskTypeNVMe,
+		"nvme12n3":  DiskTypeNVMe,
+		"nvme0n1p1": "",
+		"nvme0":     "",
+		"loop0":     "",
+	}
+	for dev, want := range tests {
+		if got := diskTypeForDevice(dev); got != want {
+			t.Errorf("diskTypeForDevice(%q) = %q, want %q", dev, got, want)
+		}
+	}
+}
+
+// discoveryFixture builds a root with three bay disks and one unparsable
+// by-path entry, for discoverDisksIn
+func discoveryFixture(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	mkdir := func(dir string) {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(name, target string) {
+		if err := os.Symlink(target, filepath.Join(root, "dev/disk/by-path", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mkdir("dev/disk/by-path")
+	mkdir("sys/block/sda")
+	mkdir("run/udev/data")
+	for _, dev := range []string{"sda", "sdb", "sdc", "sdd"} {
+		write("dev/"+dev, "")
+	}
+	write("sys/block/sda/dev", "8:0\n")
+	write("run/udev/data/b8:0", "E:ID_SERIAL_SHORT=SER123\n")
+
+	link("pci-0000:59:00.0-ata-2", "../../sdb")
+	link("pci-0000:59:00.0-ata-1", "../../sda")
+	link("pci-0000:00:17.0-ata-1", "../../sdc")
+	link("pci-0000:59:00.0-ata-x", "../../sdd")
+	return root
+}
+
+func TestDiscoverDisksInWarnings(t *testing.T) {
+	root := discoveryFixture(t)
+	disks, warnings, err := discoverDisksIn(root)
+	if err != nil {
+		t.Fatalf("discoverDisksIn() error: %v", err)
+	}
+
+	var names []string
+	for _, d := range disks {
+		names = append(names, d.Name)
+	}
+	if got, want := strings.Join(names, ","), "sda,sdb,sdc"; got != want {
+		t.Errorf("disks = %s, want %s", got, want)
+	}
+	if disks[0].Serial != "SER123" {
+		t.Errorf("sda serial = %q, want SER123", disks[0].Serial)
+	}
+
+	want := []DiscoveryWarning{{Entry: "pci-0000:59:00.0-ata-x", Reason: "invalid ata port"}}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %+v, want %+v", warnings, want)
+	}
+	if got, want := discoverySummary(disks, warnings), "3 disks found, 1 skipped: pci-0000:59:00.0-ata-x: invalid ata port"; got != want {
+		t.Errorf("discoverySummary() = %q, want %q", got, want)
+	}
+}
+
+func TestDisksJSON(t *testing.T) {
+	disks, _, err := discoverDisksIn(discoveryFixture(t))
+	if err != nil {
+		t.Fatalf("discoverDisksIn() error: %v", err)
+	}
+	out, err := disksJSON(disks)
+	if err != nil {
+		t.Fatalf("disksJSON() error: %v", err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", out, err)
+	}
+	want := map[string]any{
+		"name":    "sda",
+		"hctl":    "",
+		"serial":  "SER123",
+		"path":    "pci-0000:59:00.0-ata-1",
+		"pci_bus": "0000:59:00.0",
+		"port":    float64(1),
+		"type":    DiskTypeSATA,
+	}
+	if len(got) != 3 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("disksJSON() = %s, want 3 disks starting with %v", out, want)
+	}
+
+	if out, err := disksJSON(nil); err != nil || string(out) != "[]" {
+		t.Errorf("disksJSON(nil) = %s, %v, want []", out, err)
+	}
+}
+
+func TestDiscoverDisksInMissingByPath(t *testing.T) {
+	if _, _, err := discoverDisksIn(t.TempDir()); err == nil {
+		t.Error("expected error when /dev/disk/by-path is missing")
+	}
+}
+
+func TestApplyActivityCount(t *testing.T) {
+	prev := DiskActivity{Reads: 1000, Writes: 5000, Activity: 6000, ReadIOs: 10, WriteIOs: 50}
+	curr := DiskActivity{Reads: 4000, Writes: 6000, Activity: 10000, ReadIOs: 40, WriteIOs: 60}
+	opts := ColorOptions{Mode: ColorModeRWBlend}
+
+	tests := []struct {
+		count string
+		want  DiskActivity
+		color [3]byte
+		iops  float64
+	}{
+		{CountReadWrite, DiskActivity{Reads: 3000, Writes: 1000, Activity: 4000}, [3]byte{64, 0, 191}, 40},
+		{CountRead, DiskActivity{Reads: 3000, Activity: 3000}, [3]byte{0, 0, 255}, 30},
+		{CountWrite, DiskActivity{Writes: 1000, Activity: 1000}, [3]byte{255, 0, 0}, 10},
+	}
+	for _, tt := range tests {
+		deltas := diskDeltas(map[string]DiskActivity{"sda": prev}, map[string]DiskActivity{"sda": curr})
+		applyActivityCount(deltas, tt.count)
+		got := deltas["sda"]
+		if got != tt.want {
+			t.Errorf("%s: delta = %+v, want %+v", tt.count, got, tt.want)
+		}
+		r, g, b := colorForActivity(got.Reads, got.Writes, 1, 0, opts)
+		if [3]byte{r, g, b} != tt.color {
+			t.Errorf("%s: color = %v, want %v", tt.count, [3]byte{r, g, b}, tt.color)
+		}
+		m := diskMetrics(countedActivity(prev, tt.count), countedActivity(curr, tt.count), time.Second)
+		if m.IOPS != tt.iops || m.Throughput != float64(tt.want.Activity) {
+			t.Errorf("%s: metrics = %+v, want %v IOPS and %d bytes", tt.count, m, tt.iops, tt.want.Activity)
+		}
+	}
+}
+
+func TestApplyActivityWeights(t *testing.T) {
+	opts := ColorOptions{Mode: ColorModeRWBlend}
+	delta := DiskActivity{Reads: 3000, Writes: 1000, Activity: 4000}
+	const maxActivity = 16000
+
+	deltas := map[string]DiskActivity{"sda": delta}
+	applyActivityWeights(deltas, 1, 1)
+	if deltas["sda"] != delta {
+		t.Errorf("expected unit weights to keep %+v, got %+v", delta, deltas["sda"])
+	}
+
+	applyActivityWeights(deltas, 1, 4)
+	weighted := deltas["sda"]
+	if weighted.Activity != 7000 || weighted.Reads != 3000 || weighted.Writes != 1000 {
+		t.Errorf("expected activity 7000 with raw reads and writes, got %+v", weighted)
+	}
+	if before, after := scaleBrightness(delta.Activity, maxActivity, BrightnessCurveLinear, 1), scaleBrightness(weighted.Activity, maxActivity, BrightnessCurveLinear, 1); after <= before {
+		t.Errorf("expected weighted writes to raise brightness from %d, got %d", before, after)
+	}
+	r, g, b := colorForActivity(weighted.Reads, weighted.Writes, 1, 0, opts)
+	if wr, wg, wb := colorForActivity(delta.Reads, delta.Writes, 1, 0, opts); [3]byte{r, g, b} != [3]byte{wr, wg, wb} {
+		t.Errorf("expected color unaffected by weights, got %v, want %v", [3]byte{r, g, b}, [3]byte{wr, wg, wb})
+	}
+
+	// Discards counted as activity weigh like writes
+	deltas = map[string]DiskActivity{"sda": {Reads: 1000, Discards: 2000, Activity: 1000}}
+	applyDiscards(deltas)
+	applyActivityWeights(deltas, 0.5, 2)
+	if got := deltas["sda"].Activity; got != 4500 {
+		t.Errorf("expected activity 4500 with weighted discards, got %d", got)
+	}
+}
+
+func TestApplyActivityFloor(t *testing.T) {
+	deltas := map[string]DiskActivity{
+		"sda": {Reads: 0, Writes: 4096, Activity: 4096},             // housekeeping
+		"sdb": {Reads: 1 << 20, Writes: 1 << 20, Activity: 2 << 20}, // real I/O
+		"sdc": {Activity: 65536},                                    // exactly at the floor
+	}
+	applyActivityFloor(deltas, 65536)
+
+	if deltas["sda"] != (DiskActivity{}) {
+		t.Errorf("expected sda below the floor zeroed, got %+v", deltas["sda"])
+	}
+	if deltas["sdb"].Activity != 2<<20 {
+		t.Errorf("expected sdb above the floor kept, got %+v", deltas["sdb"])
+	}
+	if deltas["sdc"].Activity != 65536 {
+		t.Errorf("expected sdc at the floor kept, got %+v", deltas["sdc"])
+	}
+
+	// Below the floor the LED goes idle like a disk with no I/O; above it lights
+	controller := leds.NewUGreenLedsWithTransport(leds.NewFakeTransport())
+	var idle bool
+	for range 3 {
+		idle = controller.DebounceIdle(2, deltas["sda"].Activity > 0, 3, time.Now(), 0)
+	}
+	if !idle {
+		t.Error("expected a disk below the floor to go idle")
+	}
+	if controller.DebounceIdle(3, deltas["sdb"].Activity > 0, 3, time.Now(), 0) {
+		t.Error("expected a disk above the floor to light")
+	}
+
+	// A zero floor changes nothing
+	deltas = map[string]DiskActivity{"sda": {Activity: 1}}
+	applyActivityFloor(deltas, 0)
+	if deltas["sda"].Activity != 1 {
+		t.Errorf("expected no floor to keep activity, got %+v", deltas["sda"])
+	}
+}
+
+func TestOrderDisks(t *testing.T) {
+	disks := []DiskInfo{
+		{Name: "sda", Serial: "A"},
+		{Name: "sdb", Serial: "B"},
+		{Name: "sdc", Serial: "C"},
+		{Name: "sdd"}, // no serial
+	}
+	names := func(disks []DiskInfo) []string {
+		var names []string
+		for _, disk := range disks {
+			names = append(names, disk.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name        string
+		order       []string
+		wantNames   []string
+		wantMissing []string
+	}{
+		{"no order", nil, []string{"sda", "sdb", "sdc", "sdd"}, nil},
+		{"full", []string{"C", "A", "B"}, []string{"sdc", "sda", "sdb", "sdd"}, nil},
+		{"partial", []string{"B"}, []string{"sdb", "sda", "sdc", "sdd"}, nil},
+		{"typo", []string{"C", "X"}, []string{"sdc", "sda", "sdb", "sdd"}, []string{"X"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, missing := orderDisks(disks, tt.order)
+			if !reflect.DeepEqual(names(got), tt.wantNames) {
+				t.Errorf("orderDisks() order = %v, want %v", names(got), tt.wantNames)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("orderDisks() missing = %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+	if disks[0].Name != "sda" {
+		t.Error("orderDisks modified its input")
+	}
+}
+
+func TestExcludeDisks(t *testing.T) {
+	disks := []DiskInfo{
+		{Name: "sda", Serial: "A"},
+		{Name: "sdb", Serial: "BOOT"},
+		{Name: "sdc", Serial: "C"},
+		{Name: "sdd"}, // no serial
+	}
+	names := func(disks []DiskInfo) []string {
+		var names []string
+		for _, disk := range disks {
+			names = append(names, disk.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name         string
+		exclude      []string
+		wantKept     []string
+		wantExcluded []string
+	}{
+		{"none", nil, []string{"sda", "sdb", "sdc", "sdd"}, nil},
+		{"serial", []string{"BOOT"}, []string{"sda", "sdc", "sdd"}, []string{"sdb"}},
+		{"device names", []string{"sdd", "/dev/sda"}, []string{"sdb", "sdc"}, []string{"sda", "sdd"}},
+		{"no match", []string{"X", ""}, []string{"sda", "sdb", "sdc", "sdd"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, excluded := excludeDisks(disks, tt.exclude)
+			if !reflect.DeepEqual(names(kept), tt.wantKept) || !reflect.DeepEqual(names(excluded), tt.wantExcluded) {
+				t.Errorf("excludeDisks() = %v, %v, want %v, %v", names(kept), names(excluded), tt.wantKept, tt.wantExcluded)
+			}
+		})
+	}
+}
+
+func TestGetBlockDevicesSerials(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, data string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// sda has a udev entry and a sysfs VPD page; udev wins
+	write("sys/block/sda/dev", "8:0\n")
+	write("sys/block/sda/device/vpd_pg80", "\x00\x80\x00\x06SYSFS1")
+	write("run/udev/data/b8:0", "S:disk/by-id/ata-X\nE:ID_SERIAL_SHORT=UDEV1\n")
+	// sdb has no udev entry, only a VPD page with padding
+	write("sys/block/sdb/dev", "8:16\n")
+	write("sys/block/sdb/device/vpd_pg80", "\x00\x80\x00\x0a  VPD2    ")
+	// nvme0n1 has no udev entry, only a serial attribute
+	write("sys/block/nvme0n1/dev", "259:0\n")
+	write("sys/block/nvme0n1/device/serial", "NVME3   \n")
+	// sdc has nothing
+	write("sys/block/sdc/dev", "8:32\n")
+
+	serials, err := getBlockDevicesSerials(root)
+	if err != nil {
+		t.Fatalf("getBlockDevicesSerials() error: %v", err)
+	}
+	want := map[string]string{"sda": "UDEV1", "sdb": "VPD2", "nvme0n1": "NVME3"}
+	if !reflect.DeepEqual(serials, want) {
+		t.Errorf("serials = %v, want %v", serials, want)
+	}
+}
+
+func TestParseVPDSerial(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"serial", "\x00\x80\x00\x04ABCD", "ABCD"},
+		{"padded", "\x00\x80\x00\x06  AB  ", "AB"},
+		{"length past end", "\x00\x80\x00\x10ABCD", "ABCD"},
+		{"wrong page", "\x00\x83\x00\x04ABCD", ""},
+		{"short", "\x00\x80", ""},
+	}
+	for _, tt := range tests {
+		if got := parseVPDSerial([]byte(tt.page)); got != tt.want {
+			t.Errorf("%s: parseVPDSerial() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseDiskStatsLayouts(t *testing.T) {
+	// The classic 14-field layout, and Linux 5.5+ with discard and flush fields
+	data := []byte(`   8       0 sda 100 0 8 10 200 0 16 20 0 30 30
+   8      16 sdb 100 0 8 10 200 0 16 20 0 30 30 5 0 64 3 7 2
+`)
+	stats := parseDiskStats(data, deviceSet([]string{"sda", "sdb"}), make(map[string]DiskActivity))
+	for _, dev := range []string{"sda", "sdb"} {
+		s := stats[dev]
+		if s.Reads != 8*512 || s.Writes != 16*512 || s.Activity != 24*512 || s.TimeInQueue != 30 {
+			t.Errorf("%s: unexpected counters %+v", dev, s)
+		}
+	}
+	if got := stats["sda"].Discards; got != 0 {
+		t.Errorf("sda: expected no discards without the discard fields, got %d", got)
+	}
+	if got := stats["sdb"].Discards; got != 64*512 {
+		t.Errorf("sdb: expected discards %d, got %d", 64*512, got)
+	}
+}
+
+func TestApplyDiscards(t *testing.T) {
+	prev := DiskActivity{Reads: 100, Writes: 200, Discards: 1000}
+	curr := DiskActivity{Reads: 150, Writes: 200, Discards: 5000}
+	deltas := map[string]DiskActivity{"sda": diskDelta(prev, curr, true)}
+	applyActivityCount(deltas, CountReadWrite)
+	applyDiscards(deltas)
+	if got := deltas["sda"]; got.Discards != 4000 || got.Activity != 4050 {
+		t.Errorf("expected discards 4000 and activity 4050, got %+v", got)
+	}
+
+	deltas = map[string]DiskActivity{"sda": diskDelta(prev, curr, true)}
+	applyActivityCount(deltas, CountRead)
+	applyDiscards(deltas)
+	if got := deltas["sda"].Activity; got != 50 {
+		t.Errorf("expected discards ignored with count read, activity 50, got %d", got)
+	}
+}
+
+// BenchmarkDiskTick covers the disk side of a monitor tick, from reading
+// /proc/diskstats to the event
+func BenchmarkDiskTick(b *testing.B) {
+	data, names := syntheticDiskStats(8)
+	path := filepath.Join(b.TempDir(), "diskstats")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	r := newDiskStatsReader(names)
+	r.path = path
+	am := &ActivityMonitor{history: newDiskHistory()}
+	for _, name := range names {
+		am.disks = append(am.disks, DiskInfo{Name: name})
+	}
+	prevStats, _ := r.Read()
+	deltas := make(map[string]DiskActivity)
+	b.ReportAllocs()
+	for b.Loop() {
+		currStats, _ := r.Read()
+		diskDeltasInto(deltas, prevStats, currStats)
+		applyActivityCount(deltas, CountReadWrite)
+		applyActivityFloor(deltas, 4096)
+		am.history.record(time.Now(), am.disks, deltas, 60)
+		am.activityEvent(time.Now(), deltas)
+		prevStats = currStats
+	}
+}
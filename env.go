@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix prefixes the environment variables that override config fields
+const envPrefix = "UGREEN_"
+
+// applyEnv overrides conf's fields from environment variables named after
+// their YAML keys, e.g. UGREEN_POLL_INTERVAL for poll_interval. Values are
+// parsed as YAML, like the config file, so durations read as "250ms" and
+// lists as "[sda, sdb]". Empty variables are ignored.
+func applyEnv(conf *Config, lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(conf).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || key == "" || key == "-" {
+			continue
+		}
+		name := envPrefix + strings.ToUpper(key)
+		value, ok := lookup(name)
+		if !ok || value == "" {
+			continue
+		}
+		target := reflect.New(field.Type)
+		if err := yaml.UnmarshalStrict([]byte(value), target.Interface()); err != nil {
+			return fmt.Errorf("%s: invalid value %q: %w", name, value, err)
+		}
+		v.Field(i).Set(target.Elem())
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"UGREEN_POLL_INTERVAL":      "250ms",
+		"UGREEN_ENABLE_RAINBOW":     "false",
+		"UGREEN_RAINBOW_BRIGHTNESS": "40",
+		"UGREEN_COLOR_MODE":         "hsv",
+		"UGREEN_EXCLUDE_DISKS":      "[sda, BOOT-1]",
+		"UGREEN_DEVICE":             "",   // empty is ignored
+		"POLL_INTERVAL":             "1s", // no prefix
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	conf := Config{Device: "/dev/i2c-1"}
+	if err := applyEnv(&conf, lookup); err != nil {
+		t.Fatalf("applyEnv: %v", err)
+	}
+	if conf.PollInterval != 250*time.Millisecond {
+		t.Errorf("PollInterval = %s, want 250ms", conf.PollInterval)
+	}
+	if conf.EnableRainbow == nil || *conf.EnableRainbow {
+		t.Errorf("EnableRainbow = %v, want false", conf.EnableRainbow)
+	}
+	if conf.RainbowBrightness == nil || *conf.RainbowBrightness != 40 {
+		t.Errorf("RainbowBrightness = %v, want 40", conf.RainbowBrightness)
+	}
+	if conf.ColorMode != ColorModeHSV {
+		t.Errorf("ColorMode = %q, want hsv", conf.ColorMode)
+	}
+	if !slices.Equal(conf.ExcludeDisks, []string{"sda", "BOOT-1"}) {
+		t.Errorf("ExcludeDisks = %q, want [sda BOOT-1]", conf.ExcludeDisks)
+	}
+	if conf.Device != "/dev/i2c-1" {
+		t.Errorf("Device = %q, want the unchanged /dev/i2c-1", conf.Device)
+	}
+
+	tests := []struct {
+		name, value string
+	}{
+		{"UGREEN_POLL_INTERVAL", "fast"},
+		{"UGREEN_ENABLE_RAINBOW", "maybe"},
+		{"UGREEN_RAINBOW_BRIGHTNESS", "300"},
+	}
+	for _, tt := range tests {
+		lookup := func(name string) (string, bool) {
+			return tt.value, name == tt.name
+		}
+		if err := applyEnv(&Config{}, lookup); err == nil || !strings.HasPrefix(err.Error(), tt.name+": invalid value") {
+			t.Errorf("%s=%s: error = %v", tt.name, tt.value, err)
+		}
+	}
+}
+
+func TestEnvOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("poll_interval: 200ms\ncolor_mode: rw_blend\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("UGREEN_POLL_INTERVAL", "500ms")
+
+	loader, err := NewConfigLoader(path)
+	if err != nil {
+		t.Fatalf("failed to create config loader: %v", err)
+	}
+	cfg := loader.Config()
+	if cfg == nil {
+		t.Fatal("expected valid config")
+	}
+	if cfg.PollInterval != 500*time.Millisecond || cfg.ColorMode != ColorModeRWBlend {
+		t.Errorf("poll_interval %s, color_mode %s; want 500ms from the environment and rw_blend from the file", cfg.PollInterval, cfg.ColorMode)
+	}
+
+	// An invalid value rejects the config
+	t.Setenv("UGREEN_POLL_INTERVAL", "soon")
+	loader, err = NewConfigLoader(path)
+	if err == nil && loader.Config() != nil {
+		t.Error("config accepted with an invalid environment value")
+	}
+}
@@ -2,12 +2,18 @@ module github.com/devilmonastery/ugreen-truenas-leds
 
 go 1.26.4
 
-require github.com/devilmonastery/configloader v0.2.7
+require (
+	github.com/devilmonastery/configloader v0.2.7
+	github.com/eclipse/paho.mqtt.golang v1.5.1
+	github.com/gorilla/websocket v1.5.3
+	gopkg.in/yaml.v2 v2.4.0
+)
 
 require (
 	github.com/fsnotify/fsnotify v1.9.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/rogpeppe/go-internal v1.15.0 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
 	golang.org/x/sys v0.37.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// unhealthyReason returns why the monitor can't be considered live at now,
+// or "" when it is: the LED controller must be open, at least one disk
+// found, and the monitor loop must have ticked within stallAfter
+func (am *ActivityMonitor) unhealthyReason(now time.Time, stallAfter time.Duration) string {
+	if controller := am.controller(); controller == nil || !controller.IsOpen() {
+		return "LED controller not open"
+	}
+	if len(am.diskList()) == 0 {
+		return "no disks found"
+	}
+	if since := am.watchdog.since(now); since >= stallAfter {
+		return fmt.Sprintf("monitor loop stalled, no tick for %s", since.Round(time.Millisecond))
+	}
+	return ""
+}
+
+// handleHealthz serves a liveness probe: 200 when healthy, otherwise 503
+// with the reason
+func (am *ActivityMonitor) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	stallAfter := defaultWatchdogTimeout
+	if am.configLoader != nil {
+		if conf := am.configLoader.Config(); conf != nil {
+			stallAfter = conf.WatchdogTimeout
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if reason := am.unhealthyReason(time.Now(), stallAfter); reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, reason)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestHealthz(t *testing.T) {
+	now := time.Now()
+	healthy := func() *ActivityMonitor {
+		am := newTestMonitor(t, DiskInfo{Name: "sda"})
+		am.watchdog.pet(now.Add(-time.Second))
+		return am
+	}
+	get := func(am *ActivityMonitor) (int, string) {
+		rec := httptest.NewRecorder()
+		am.statusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, body := get(healthy()); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("healthy: %d %q, want 200 ok", code, body)
+	}
+
+	tests := []struct {
+		name    string
+		degrade func(am *ActivityMonitor)
+		want    string
+	}{
+		{"controller closed", func(am *ActivityMonitor) { am.leds.Close() }, "LED controller not open"},
+		{"no controller", func(am *ActivityMonitor) { am.leds = nil }, "LED controller not open"},
+		{"no disks", func(am *ActivityMonitor) { am.disks = nil }, "no disks found"},
+		{"stalled", func(am *ActivityMonitor) { am.watchdog.pet(now.Add(-time.Hour)) }, "monitor loop stalled"},
+	}
+	for _, tt := range tests {
+		am := healthy()
+		tt.degrade(am)
+		if code, body := get(am); code != http.StatusServiceUnavailable || !strings.HasPrefix(body, tt.want) {
+			t.Errorf("%s: %d %q, want 503 %q", tt.name, code, body, tt.want)
+		}
+	}
+
+	// Before the first tick the loop isn't stalled
+	am := healthy()
+	am.watchdog = watchdog{}
+	if reason := am.unhealthyReason(now, time.Second); reason != "" {
+		t.Errorf("before the first tick: %q, want healthy", reason)
+	}
+}
+
+// blockingTransport blocks every write until release is closed, like an
+// ioctl stuck in the driver
+type blockingTransport struct {
+	*leds.FakeTransport
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTransport) WriteCommand(ledID int, command byte, params []byte) error {
+	t.blocked <- struct{}{}
+	<-t.release
+	return t.FakeTransport.WriteCommand(ledID, command, params)
+}
+
+func TestHealthzDuringBlockedWrite(t *testing.T) {
+	am := newTestMonitor(t, DiskInfo{Name: "sda"})
+	am.watchdog.pet(time.Now())
+	transport := &blockingTransport{leds.NewFakeTransport(), make(chan struct{}), make(chan struct{})}
+	am.leds = leds.NewUGreenLedsWithTransport(transport)
+	done := make(chan struct{})
+	go func() {
+		am.leds.SetLedColor(firstDiskLedIndex, 255, 0, 0)
+		close(done)
+	}()
+	<-transport.blocked
+	defer func() {
+		close(transport.release)
+		<-done
+	}()
+
+	answered := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		am.statusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		answered <- rec.Code
+	}()
+	select {
+	case code := <-answered:
+		if code != http.StatusOK {
+			t.Errorf("healthz during a blocked write = %d, want 200", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("healthz blocked by a write in progress")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHistoryLen = 120
+	maxHistoryLen     = 3600
+)
+
+// HistorySample is one disk's activity during a tick
+type HistorySample struct {
+	Time       time.Time `json:"time"`
+	ReadBytes  uint64    `json:"read_bytes"`
+	WriteBytes uint64    `json:"write_bytes"`
+}
+
+// ringBuffer holds the most recent samples, overwriting the oldest once full
+type ringBuffer struct {
+	samples []HistorySample
+	next    int // index the next sample is written to
+	full    bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{samples: make([]HistorySample, size)}
+}
+
+func (r *ringBuffer) push(sample HistorySample) {
+	r.samples[r.next] = sample
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the samples oldest first, newest last
+func (r *ringBuffer) list() []HistorySample {
+	if !r.full {
+		return append([]HistorySample(nil), r.samples[:r.next]...)
+	}
+	return append(append([]HistorySample(nil), r.samples[r.next:]...), r.samples[:r.next]...)
+}
+
+// diskHistory keeps a ring buffer of recent activity per disk, by device name
+type diskHistory struct {
+	mu    sync.Mutex
+	size  int
+	disks map[string]*ringBuffer
+}
+
+func newDiskHistory() *diskHistory {
+	return &diskHistory{disks: make(map[string]*ringBuffer)}
+}
+
+// record appends each disk's activity for the tick at now, keeping size
+// samples per disk. Disks missing from deltas record no activity, and
+// buffers of disks no longer listed are dropped. A new size starts every
+// buffer over.
+func (h *diskHistory) record(now time.Time, disks []DiskInfo, deltas map[string]DiskActivity, size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if size != h.size {
+		h.size = size
+		clear(h.disks)
+	}
+	for _, disk := range disks {
+		buf, ok := h.disks[disk.Name]
+		if !ok {
+			buf = newRingBuffer(size)
+			h.disks[disk.Name] = buf
+		}
+		delta := deltas[disk.Name]
+		buf.push(HistorySample{Time: now, ReadBytes: delta.Reads, WriteBytes: delta.Writes})
+	}
+	for name := range h.disks {
+		if !slices.ContainsFunc(disks, func(disk DiskInfo) bool { return disk.Name == name }) {
+			delete(h.disks, name)
+		}
+	}
+}
+
+// snapshot returns a copy of every disk's history, newest last
+func (h *diskHistory) snapshot() map[string][]HistorySample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	history := make(map[string][]HistorySample, len(h.disks))
+	for name, buf := range h.disks {
+		history[name] = buf.list()
+	}
+	return history
+}
+
+func (am *ActivityMonitor) handleHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(am.history.snapshot()); err != nil {
+		log.Printf("Error writing history: %v", err)
+	}
+}
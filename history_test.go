@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiskHistoryWraps(t *testing.T) {
+	h := newDiskHistory()
+	disks := []DiskInfo{{Name: "sda"}, {Name: "sdb"}}
+	start := time.Unix(1000, 0)
+	const size = 4
+	for i := range 10 {
+		deltas := map[string]DiskActivity{"sda": {Reads: uint64(i), Writes: uint64(10 * i)}}
+		h.record(start.Add(time.Duration(i)*time.Second), disks, deltas, size)
+	}
+
+	history := h.snapshot()
+	sda := history["sda"]
+	if len(sda) != size {
+		t.Fatalf("expected %d samples, got %d", size, len(sda))
+	}
+	for j, sample := range sda {
+		i := 10 - size + j // newest last
+		want := HistorySample{Time: start.Add(time.Duration(i) * time.Second), ReadBytes: uint64(i), WriteBytes: uint64(10 * i)}
+		if sample != want {
+			t.Errorf("sample %d = %+v, want %+v", j, sample, want)
+		}
+	}
+	if sdb := history["sdb"]; len(sdb) != size || sdb[size-1] != (HistorySample{Time: start.Add(9 * time.Second)}) {
+		t.Errorf("expected idle sdb to record zero activity, got %+v", sdb)
+	}
+}
+
+func TestDiskHistoryPartial(t *testing.T) {
+	h := newDiskHistory()
+	disks := []DiskInfo{{Name: "sda"}}
+	for i := range 2 {
+		h.record(time.Unix(int64(i), 0), disks, map[string]DiskActivity{"sda": {Reads: uint64(i)}}, 4)
+	}
+	sda := h.snapshot()["sda"]
+	if len(sda) != 2 || sda[0].ReadBytes != 0 || sda[1].ReadBytes != 1 {
+		t.Errorf("expected 2 samples oldest first, got %+v", sda)
+	}
+
+	// removed disks are dropped
+	h.record(time.Unix(2, 0), nil, nil, 4)
+	if history := h.snapshot(); len(history) != 0 {
+		t.Errorf("expected no history after the disk was removed, got %+v", history)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// discoverOrderedDisks discovers disks and applies exclude_disks and
+// disk_order
+func discoverOrderedDisks(conf *Config) ([]DiskInfo, error) {
+	disks, _, err := discoverDisks()
+	if err != nil {
+		return nil, err
+	}
+	disks, _ = excludeDisks(disks, conf.ExcludeDisks)
+	if len(conf.DiskOrder) > 0 {
+		disks, _ = orderDisks(disks, conf.DiskOrder)
+	}
+	return disks, nil
+}
+
+// diskSettleInterval is the wait between discovery scans while disks settle
+const diskSettleInterval = 2 * time.Second
+
+// settleDisks runs discover until two scans in a row, interval apart, find
+// the same disks, or timeout elapses, so a boot-time start doesn't run with
+// the partial list udev has populated so far. A failed scan is retried, as
+// when /dev/disk/by-path doesn't exist yet. It returns the last scan.
+func settleDisks(discover func() ([]DiskInfo, []DiscoveryWarning, error), interval, timeout time.Duration, sleep func(time.Duration)) ([]DiskInfo, []DiscoveryWarning, error) {
+	disks, warnings, err := discover()
+	for waited := time.Duration(0); waited < timeout; waited += interval {
+		sleep(interval)
+		next, nextWarnings, nextErr := discover()
+		if err == nil && nextErr == nil && slices.Equal(next, disks) {
+			return next, nextWarnings, nil
+		}
+		disks, warnings, err = next, nextWarnings, nextErr
+	}
+	if err == nil && timeout > 0 {
+		log.Printf("Warning: disks still changing after %s, using the %d found", timeout, len(disks))
+	}
+	return disks, warnings, err
+}
+
+// diskList returns the current disks. The slice is replaced, never modified,
+// when disks are rediscovered, so callers may keep it.
+func (am *ActivityMonitor) diskList() []DiskInfo {
+	am.disksMu.RLock()
+	defer am.disksMu.RUnlock()
+	return am.disks
+}
+
+// diskDevices lists the disks' device names
+func diskDevices(disks []DiskInfo) []string {
+	devices := make([]string, len(disks))
+	for i, disk := range disks {
+		devices[i] = disk.Name
+	}
+	return devices
+}
+
+// rediscoverLoop re-runs disk discovery every RediscoverInterval, following
+// config changes, and sends each changed disk set to the monitor until ctx
+// is cancelled
+func (am *ActivityMonitor) rediscoverLoop(ctx context.Context, discover func(conf *Config) ([]DiskInfo, error)) {
+	conf := am.configLoader.Config()
+	subscriber := am.configLoader.Subscribe()
+
+	ticker := time.NewTicker(conf.RediscoverInterval)
+	defer ticker.Stop()
+	current := am.diskList()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case newconf := <-subscriber:
+			conf = &newconf
+			ticker.Reset(conf.RediscoverInterval)
+		case <-ticker.C:
+			disks, err := discover(conf)
+			if err != nil {
+				log.Printf("Error rediscovering disks: %v", err)
+				continue
+			}
+			if slices.Equal(disks, current) {
+				continue
+			}
+			current = disks
+			select {
+			case am.rediscovered <- disks:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// setDisks replaces the monitored disks, logging the change, turning off
+// the LEDs no longer driven by any disk, and re-enabling LEDs disabled by
+// write failures. Only the monitor loop calls it.
+func (am *ActivityMonitor) setDisks(conf *Config, disks []DiskInfo) {
+	oldLeds := am.diskLeds(conf)
+	oldNames := make(map[string]bool, len(am.disks))
+	for _, disk := range am.disks {
+		oldNames[disk.Name] = true
+	}
+
+	am.disksMu.Lock()
+	am.disks = disks
+	am.disksMu.Unlock()
+	am.layout = resolveLedLayout(conf.Model, len(disks))
+	clear(am.noLedWarned)
+	am.ledFailures.reset()
+	am.hashedColors = nil
+	// Fades in progress belong to the old disk to LED mapping
+	am.fades = nil
+
+	for _, disk := range disks {
+		if !oldNames[disk.Name] {
+			log.Printf("Disk added: %s (%s) at %s", disk.Name, disk.Serial, disk.Path)
+		}
+		delete(oldNames, disk.Name)
+	}
+	for name := range oldNames {
+		log.Printf("Disk removed: %s", name)
+	}
+
+	newLeds := am.diskLeds(conf)
+	for ledIndex := range oldLeds {
+		if !newLeds[ledIndex] {
+			am.setLedMode(ledIndex, leds.LedModeOff, nil)
+		}
+	}
+}
+
+// diskLeds returns the LED indices driven by the current disks
+func (am *ActivityMonitor) diskLeds(conf *Config) map[int]bool {
+	driven := make(map[int]bool, len(am.disks))
+	for i, disk := range am.disks {
+		if ledIndex, ok := diskLedIndex(i, disk, conf.DiskLedMap, am.layout, conf.ReverseLeds); ok {
+			driven[ledIndex] = true
+		}
+	}
+	return driven
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestSetDisks(t *testing.T) {
+	conf := &Config{}
+	sda := DiskInfo{Name: "sda", Serial: "A"}
+	sdb := DiskInfo{Name: "sdb", Serial: "B"}
+	sdc := DiskInfo{Name: "sdc", Serial: "C"}
+	am := newTestMonitor(t, sda, sdb)
+	modes := func() map[int]string {
+		m := make(map[int]string)
+		for _, state := range am.leds.LedStates() {
+			m[state.Index] = state.Mode
+		}
+		return m
+	}
+	for ledIndex := range am.diskLeds(conf) {
+		if err := am.leds.SetLedMode(ledIndex, leds.LedModeOn, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// sdb is pulled: its LED, disk2, turns off
+	am.setDisks(conf, []DiskInfo{sda})
+	if !slices.Equal(am.diskList(), []DiskInfo{sda}) {
+		t.Fatalf("disks = %+v, want sda", am.diskList())
+	}
+	if m := modes(); m[firstDiskLedIndex] != "on" || m[firstDiskLedIndex+1] != "off" {
+		t.Errorf("after removal: LED modes = %v, want disk1 on, disk2 off", m)
+	}
+
+	// sdb and a new sdc are plugged in: both get LEDs
+	am.setDisks(conf, []DiskInfo{sda, sdb, sdc})
+	driven := am.diskLeds(conf)
+	for _, ledIndex := range []int{firstDiskLedIndex, firstDiskLedIndex + 1, firstDiskLedIndex + 2} {
+		if !driven[ledIndex] {
+			t.Errorf("after adding: LED %d not driven", ledIndex)
+		}
+	}
+	if got := diskDevices(am.diskList()); !slices.Equal(got, []string{"sda", "sdb", "sdc"}) {
+		t.Errorf("devices = %v, want sda, sdb, sdc", got)
+	}
+}
+
+func TestRediscoverLoop(t *testing.T) {
+	loader := newTestConfigLoader(t, "rediscover_interval: 1s\n")
+	sda := DiskInfo{Name: "sda"}
+	sdb := DiskInfo{Name: "sdb"}
+	am := &ActivityMonitor{
+		configLoader: loader,
+		disks:        []DiskInfo{sda},
+		rediscovered: make(chan []DiskInfo),
+	}
+
+	// The first rediscovery finds the same disks, the second finds sdb added
+	calls := 0
+	discover := func(conf *Config) ([]DiskInfo, error) {
+		calls++
+		if calls == 1 {
+			return []DiskInfo{sda}, nil
+		}
+		return []DiskInfo{sda, sdb}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go am.rediscoverLoop(ctx, discover)
+
+	select {
+	case disks := <-am.rediscovered:
+		if !slices.Equal(disks, []DiskInfo{sda, sdb}) || calls != 2 {
+			t.Errorf("rediscovered %+v after %d discoveries, want sda, sdb after 2", disks, calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rediscovered disk set")
+	}
+}
+
+func TestSettleDisks(t *testing.T) {
+	sda := DiskInfo{Name: "sda", Serial: "A"}
+	sdb := DiskInfo{Name: "sdb", Serial: "B"}
+	scans := [][]DiskInfo{{sda}, {sda, {Name: "sdb"}}, {sda, sdb}, {sda, sdb}, {sda, sdb}}
+	newDiscover := func() (func() ([]DiskInfo, []DiscoveryWarning, error), *int) {
+		calls := 0
+		return func() ([]DiskInfo, []DiscoveryWarning, error) {
+			disks := scans[min(calls, len(scans)-1)]
+			calls++
+			return disks, nil, nil
+		}, &calls
+	}
+	var slept time.Duration
+	sleep := func(d time.Duration) { slept += d }
+
+	discover, calls := newDiscover()
+	disks, _, err := settleDisks(discover, time.Second, time.Minute, sleep)
+	if err != nil || !slices.Equal(disks, []DiskInfo{sda, sdb}) || *calls != 4 || slept != 3*time.Second {
+		t.Errorf("settled on %v, %v after %d scans and %s; want sda and sdb after 4 scans and 3s", disks, err, *calls, slept)
+	}
+
+	// The timeout returns the last scan
+	slept = 0
+	discover, calls = newDiscover()
+	disks, _, _ = settleDisks(discover, time.Second, time.Second, sleep)
+	if len(disks) != 2 || disks[1].Serial != "" || *calls != 2 || slept != time.Second {
+		t.Errorf("timed out with %v after %d scans and %s; want the partial second scan after 1s", disks, *calls, slept)
+	}
+
+	// No timeout uses the first scan
+	discover, calls = newDiscover()
+	if disks, _, _ := settleDisks(discover, time.Second, 0, sleep); len(disks) != 1 || *calls != 1 {
+		t.Errorf("got %v after %d scans, want the first scan only", disks, *calls)
+	}
+
+	// Failed scans are retried until the timeout
+	calls = new(int)
+	failing := func() ([]DiskInfo, []DiscoveryWarning, error) {
+		*calls++
+		if *calls <= 2 {
+			return nil, nil, errors.New("no /dev/disk/by-path")
+		}
+		return []DiskInfo{sda}, nil, nil
+	}
+	disks, _, err = settleDisks(failing, time.Second, time.Minute, sleep)
+	if err != nil || !slices.Equal(disks, []DiskInfo{sda}) || *calls != 4 {
+		t.Errorf("got %v, %v after %d scans failing twice, want sda after 4 scans", disks, err, *calls)
+	}
+	*calls = 0
+	if _, _, err := settleDisks(failing, time.Second, time.Second, sleep); err == nil || *calls != 2 {
+		t.Errorf("got %v after %d failing scans until the timeout, want the last error after 2 scans", err, *calls)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// LED indices are the same on every model: power, lan, then one per disk bay
+const (
+	powerLedIndex     = 0
+	lanLedIndex       = 1
+	firstDiskLedIndex = 2
+)
+
+// LedLayout describes the LEDs present on a UGREEN model
+type LedLayout struct {
+	Model    string
+	DiskBays int
+}
+
+// ledLayouts lists the known models, smallest first
+var ledLayouts = []LedLayout{
+	{Model: "DXP2800", DiskBays: 2},
+	{Model: "DXP4800", DiskBays: 4},
+	{Model: "DXP6800", DiskBays: 6},
+	{Model: "DXP8800", DiskBays: 8},
+}
+
+// Count returns the number of LEDs in the layout
+func (l LedLayout) Count() int {
+	return firstDiskLedIndex + l.DiskBays
+}
+
+// Names returns the LED names in index order
+func (l LedLayout) Names() []string {
+	return leds.LedNames[:l.Count()]
+}
+
+// Valid reports whether index is an LED in the layout
+func (l LedLayout) Valid(index int) bool {
+	return index >= 0 && index < l.Count()
+}
+
+// Index returns the LED index for a name like "power" or "disk3"
+func (l LedLayout) Index(name string) (int, bool) {
+	for i, n := range l.Names() {
+		if n == strings.ToLower(name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// DiskLed returns the LED index for the zero-based disk bay, and false when
+// the layout has no such bay
+func (l LedLayout) DiskLed(bay int) (int, bool) {
+	if bay < 0 || bay >= l.DiskBays {
+		return 0, false
+	}
+	return firstDiskLedIndex + bay, true
+}
+
+// layoutForModel returns the layout for a model name such as "DXP4800" or
+// "DXP4800 Plus"
+func layoutForModel(model string) (LedLayout, error) {
+	name := strings.ToUpper(strings.ReplaceAll(model, " ", ""))
+	for _, l := range ledLayouts {
+		if strings.HasPrefix(name, l.Model) {
+			return l, nil
+		}
+	}
+	var models []string
+	for _, l := range ledLayouts {
+		models = append(models, l.Model)
+	}
+	return LedLayout{}, fmt.Errorf("unknown model %q (valid: %s)", model, strings.Join(models, ", "))
+}
+
+// layoutForDiskCount returns the smallest layout with room for disks, or the
+// largest layout when none has enough bays
+func layoutForDiskCount(disks int) LedLayout {
+	for _, l := range ledLayouts {
+		if l.DiskBays >= disks {
+			return l
+		}
+	}
+	return ledLayouts[len(ledLayouts)-1]
+}
+
+// resolveLedLayout returns the layout for the configured model, falling back
+// to one sized from the discovered disk count when model is unset
+func resolveLedLayout(model string, disks int) LedLayout {
+	if model != "" {
+		if l, err := layoutForModel(model); err == nil {
+			return l
+		}
+	}
+	return layoutForDiskCount(disks)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLayoutForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		bays  int
+		ok    bool
+	}{
+		{"DXP4800", 4, true},
+		{"dxp4800 plus", 4, true},
+		{"DXP8800 Plus", 8, true},
+		{"DXP6800 Pro", 6, true},
+		{"DXP2800", 2, true},
+		{"DS920", 0, false},
+	}
+	for _, tt := range tests {
+		l, err := layoutForModel(tt.model)
+		if (err == nil) != tt.ok || l.DiskBays != tt.bays {
+			t.Errorf("layoutForModel(%q) = %+v, %v, want %d bays ok=%v", tt.model, l, err, tt.bays, tt.ok)
+		}
+	}
+}
+
+func TestLedLayoutFourBay(t *testing.T) {
+	l, _ := layoutForModel("DXP4800")
+	if got, want := strings.Join(l.Names(), ","), "power,lan,disk1,disk2,disk3,disk4"; got != want {
+		t.Errorf("Names() = %s, want %s", got, want)
+	}
+	if i, ok := l.Index("disk4"); !ok || i != 5 {
+		t.Errorf("Index(disk4) = %d, %v, want 5, true", i, ok)
+	}
+	if _, ok := l.Index("disk5"); ok {
+		t.Error("expected no disk5 on a 4-bay layout")
+	}
+	if i, ok := l.DiskLed(0); !ok || i != 2 {
+		t.Errorf("DiskLed(0) = %d, %v, want 2, true", i, ok)
+	}
+	if _, ok := l.DiskLed(4); ok {
+		t.Error("expected no LED for bay 5 on a 4-bay layout")
+	}
+	if l.Valid(6) {
+		t.Error("expected index 6 invalid on a 4-bay layout")
+	}
+}
+
+func TestLedLayoutEightBay(t *testing.T) {
+	l, _ := layoutForModel("DXP8800")
+	if l.Count() != 10 {
+		t.Errorf("Count() = %d, want 10", l.Count())
+	}
+	if i, ok := l.DiskLed(7); !ok || i != 9 {
+		t.Errorf("DiskLed(7) = %d, %v, want 9, true", i, ok)
+	}
+	if i, ok := l.Index("power"); !ok || i != powerLedIndex {
+		t.Errorf("Index(power) = %d, %v, want %d, true", i, ok, powerLedIndex)
+	}
+	if i, ok := l.Index("LAN"); !ok || i != lanLedIndex {
+		t.Errorf("Index(LAN) = %d, %v, want %d, true", i, ok, lanLedIndex)
+	}
+}
+
+func TestResolveLedLayout(t *testing.T) {
+	tests := []struct {
+		model string
+		disks int
+		want  string
+	}{
+		{"", 3, "DXP4800"},
+		{"", 4, "DXP4800"},
+		{"", 5, "DXP6800"},
+		{"", 12, "DXP8800"},
+		{"DXP8800", 2, "DXP8800"},
+		{"bogus", 1, "DXP2800"},
+	}
+	for _, tt := range tests {
+		if got := resolveLedLayout(tt.model, tt.disks); got.Model != tt.want {
+			t.Errorf("resolveLedLayout(%q, %d) = %s, want %s", tt.model, tt.disks, got.Model, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package leds_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func Example() {
+	// NewUGreenLeds("") opens the auto-detected I2C device; the dry run
+	// controller used here only logs its commands
+	controller := leds.NewDryRunUGreenLeds()
+	defer controller.Close()
+
+	disk1 := 2
+	if err := controller.SetLedColor(disk1, 255, 0, 0); err != nil {
+		log.Fatal(err)
+	}
+	if err := controller.SetLedBrightness(disk1, 128); err != nil {
+		log.Fatal(err)
+	}
+	if err := controller.SetLedMode(disk1, leds.LedModeBlink, leds.BlinkParams(250, 750)); err != nil {
+		log.Fatal(err)
+	}
+
+	status, err := controller.GetLedStatus(disk1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %s, color (%d,%d,%d), on %dms off %dms\n", leds.LedNames[disk1], status.OpMode, status.ColorR, status.ColorG, status.ColorB, status.TOn, status.TOff)
+	// Output: disk1: blink, color (255,0,0), on 250ms off 750ms
+}
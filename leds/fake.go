@@ -0,0 +1,92 @@
+package leds
+
+import "sync"
+
+// FakeCommand is a command written to a FakeTransport
+type FakeCommand struct {
+	LedID   int
+	Command byte
+	Params  []byte
+}
+
+// FakeTransport is an in-memory Transport for tests, of this package and of
+// its importers. It records every command and simulates the controller
+// status, so writes confirm.
+type FakeTransport struct {
+	mu       sync.Mutex
+	commands []FakeCommand
+	status   map[int]LedStatus
+	reads    int
+}
+
+func NewFakeTransport() *FakeTransport {
+	return &FakeTransport{status: make(map[int]LedStatus)}
+}
+
+func (t *FakeTransport) WriteCommand(ledID int, command byte, params []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commands = append(t.commands, FakeCommand{ledID, command, append([]byte(nil), params...)})
+	status := t.status[ledID]
+	status.Available = true
+	applyLedCommand(&status, command, params)
+	t.status[ledID] = status
+	return nil
+}
+
+func (t *FakeTransport) ReadStatus(ledID int) (LedStatus, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reads++
+	return t.statusOf(ledID), nil
+}
+
+func (t *FakeTransport) Close() error {
+	return nil
+}
+
+// statusOf returns an LED's simulated status, off until written. Callers
+// hold mu.
+func (t *FakeTransport) statusOf(ledID int) LedStatus {
+	status, ok := t.status[ledID]
+	if !ok {
+		return LedStatus{Available: true, OpMode: "off"}
+	}
+	return status
+}
+
+// Commands returns the commands written so far
+func (t *FakeTransport) Commands() []FakeCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]FakeCommand(nil), t.commands...)
+}
+
+// Count returns the number of commands written so far
+func (t *FakeTransport) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.commands)
+}
+
+// Reads returns the number of status reads so far
+func (t *FakeTransport) Reads() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reads
+}
+
+// StatusOf returns an LED's simulated status without counting a read
+func (t *FakeTransport) StatusOf(ledID int) LedStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.statusOf(ledID)
+}
+
+// SetStatus sets the status an LED reads back, as if something other than
+// its writes changed it
+func (t *FakeTransport) SetStatus(ledID int, status LedStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status[ledID] = status
+}
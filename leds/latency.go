@@ -0,0 +1,89 @@
+package leds
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteLatencyBuckets are the upper bounds of the write latency histogram
+var WriteLatencyBuckets = []time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// latencyWindow is how many recent writes the rolling average covers
+const latencyWindow = 100
+
+// WriteLatency is a histogram of the wall-clock time successful writes took,
+// from the first attempt to confirmation, retries included
+type WriteLatency struct {
+	Counts []uint64 // cumulative, by WriteLatencyBuckets
+	Sum    time.Duration
+	Count  uint64
+}
+
+// latencyStats records write latencies. It has its own lock so status
+// readers don't wait behind controller I/O.
+type latencyStats struct {
+	mu     sync.Mutex
+	counts []uint64 // by bucket, not cumulative
+	sum    time.Duration
+	count  uint64
+	window [latencyWindow]time.Duration
+	next   int
+	filled int
+}
+
+func (s *latencyStats) record(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make([]uint64, len(WriteLatencyBuckets))
+	}
+	for i, bound := range WriteLatencyBuckets {
+		if d <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += d
+	s.count++
+	s.window[s.next] = d
+	s.next = (s.next + 1) % latencyWindow
+	s.filled = min(s.filled+1, latencyWindow)
+}
+
+// average returns the mean latency of the last latencyWindow writes
+func (s *latencyStats) average() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.filled == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range s.window[:s.filled] {
+		total += d
+	}
+	return total / time.Duration(s.filled)
+}
+
+func (s *latencyStats) histogram() WriteLatency {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := WriteLatency{Counts: make([]uint64, len(WriteLatencyBuckets)), Sum: s.sum, Count: s.count}
+	var cumulative uint64
+	for i, n := range s.counts {
+		cumulative += n
+		h.Counts[i] = cumulative
+	}
+	return h
+}
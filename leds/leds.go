@@ -0,0 +1,1262 @@
+// Package leds drives the front panel LEDs of UGREEN NAS devices through
+// the LED controller on the SMBus I2C bus.
+package leds
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	UGREEN_LED_I2C_ADDR      = 0x3a
+	I2C_SLAVE                = 0x0703
+	I2C_SMBUS                = 0x0720
+	I2C_SMBUS_READ           = 1
+	I2C_SMBUS_I2C_BLOCK_DATA = 8
+)
+
+// reopenAfterFailures is how many writes in a row must fail with transport
+// errors before the I2C device is reopened
+const reopenAfterFailures = 3
+
+// LedTiming controls write retries and the delays between I2C transactions.
+// Slow controllers may need longer delays to confirm writes.
+type LedTiming struct {
+	// Profile names an entry in LedTimingProfiles that fills the fields
+	// left unset
+	Profile string `yaml:"profile"`
+
+	MaxRetry          int           `yaml:"max_retry"`
+	ModificationDelay time.Duration `yaml:"modification_delay"` // after the first failed write
+	RetryDelay        time.Duration `yaml:"retry_delay"`        // after later failed writes and status reads
+	QueryDelay        time.Duration `yaml:"query_delay"`        // before reading back status
+
+	// BatchConfirm defers write confirmation to one status read per changed
+	// LED at the end of each poll, instead of reads after every write
+	BatchConfirm bool `yaml:"batch_confirm"`
+}
+
+// DefaultLedTiming works for the controllers tested so far
+var DefaultLedTiming = LedTiming{
+	MaxRetry:          5,
+	ModificationDelay: 500 * time.Microsecond,
+	RetryDelay:        500 * time.Microsecond,
+	QueryDelay:        500 * time.Microsecond,
+}
+
+// LedTimingProfiles are named timings for firmware revisions: fast for
+// controllers that confirm writes quickly, slow for those that miss
+// confirmations at the default delays
+var LedTimingProfiles = map[string]LedTiming{
+	"fast": {
+		MaxRetry:          5,
+		ModificationDelay: 200 * time.Microsecond,
+		RetryDelay:        200 * time.Microsecond,
+		QueryDelay:        200 * time.Microsecond,
+	},
+	"default": DefaultLedTiming,
+	"slow": {
+		MaxRetry:          8,
+		ModificationDelay: 2 * time.Millisecond,
+		RetryDelay:        2 * time.Millisecond,
+		QueryDelay:        2 * time.Millisecond,
+	},
+}
+
+// LedWriteStats counts LED write attempts since startup
+type LedWriteStats struct {
+	Writes   uint64 `json:"writes"`   // successful writes
+	Retries  uint64 `json:"retries"`  // extra attempts after a failed write or confirmation
+	Failures uint64 `json:"failures"` // writes that failed after all retries
+
+	// AvgLatencyMs is the mean time of the last 100 successful writes, from
+	// the first attempt to confirmation
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// LED controller command bytes
+const (
+	LedCmdBrightness = 0x01
+	LedCmdColor      = 0x02
+	LedCmdOnOff      = 0x03
+	LedCmdBlink      = 0x04
+	LedCmdBreath     = 0x05
+)
+
+// Exported LED mode constants
+const (
+	LedModeOff    = 0
+	LedModeOn     = 1
+	LedModeBlink  = 2
+	LedModeBreath = 3
+)
+
+// MaxTimingMs is the longest blink or breath period the 16-bit timing
+// parameters can encode
+const MaxTimingMs = 65535
+
+// BlinkParams encodes a blink of onMs on and offMs off as the 4-byte timing
+// parameters: big-endian period (on plus off), then big-endian on time
+func BlinkParams(onMs, offMs int) []byte {
+	periodMs := onMs + offMs
+	return []byte{
+		byte(periodMs >> 8), byte(periodMs),
+		byte(onMs >> 8), byte(onMs),
+	}
+}
+
+// BreathParams encodes a breath cycle of periodMs as the timing parameters
+// shared with blink. The LED brightens and dims over an even split of the
+// period.
+func BreathParams(periodMs int) []byte {
+	return BlinkParams(periodMs/2, periodMs-periodMs/2)
+}
+
+// LedNames names each LED by index: power, lan, then disk1 onward
+var LedNames = []string{
+	"power", "lan", "disk1", "disk2", "disk3", "disk4", "disk5", "disk6", "disk7", "disk8",
+}
+
+// GetMaxLedIndex returns the maximum valid LED index
+func GetMaxLedIndex() int {
+	return len(LedNames) - 1
+}
+
+// IsValidLedIndex checks if the given LED index is valid
+func IsValidLedIndex(index int) bool {
+	return index >= 0 && index < len(LedNames)
+}
+
+type i2cSmbusData struct {
+	block [34]byte
+}
+
+type i2cSmbusIoctlData struct {
+	readWrite byte
+	command   byte
+	size      uint32
+	data      uintptr
+}
+
+// LedStatus is an LED's state as read back from the controller
+type LedStatus struct {
+	Available  bool   `json:"available"`
+	OpMode     string `json:"op_mode"`
+	Brightness uint8  `json:"brightness"`
+	ColorR     uint8  `json:"r"`
+	ColorG     uint8  `json:"g"`
+	ColorB     uint8  `json:"b"`
+	TOn        uint16 `json:"t_on_ms"`
+	TOff       uint16 `json:"t_off_ms"`
+}
+
+type UGreenLeds struct {
+	mu              sync.Mutex // serializes controller I/O and guards the fields below
+	transport       Transport
+	lastLedStates   map[int]ledState
+	lastLedStatus   map[int]LedStatus
+	statusMu        sync.Mutex
+	colorCorrection []float64 // red, green, blue multipliers
+	timing          LedTiming
+	statusLayout    StatusLayout           // see SetStatusLayout
+	batching        bool                   // between BeginBatch and EndBatch
+	pending         map[int][]pendingWrite // unconfirmed writes in the batch
+	failedWrites    int                    // consecutive writes failed by transport errors
+
+	// reopener is the transport's, set once, so TryReopen needs no lock.
+	// reopenMu serializes TryReopen with reopen, so one's failure can't
+	// close the device the other just opened. stale asks the next
+	// BeginBatch to forget the written LED state after such a reopen.
+	reopener    reopener
+	reopenMu    sync.Mutex
+	openChecker openChecker // the transport's, set once, so IsOpen needs no lock
+	closed      atomic.Bool
+	stale       atomic.Bool
+
+	minWriteInterval time.Duration                // see SetMinWriteInterval
+	deferred         map[deferredKey]func() error // rate-limited writes, latest per key
+	now              func() time.Time
+
+	writes   atomic.Uint64
+	retries  atomic.Uint64
+	failures atomic.Uint64
+	latency  latencyStats
+}
+
+// NewUGreenLeds initializes and returns a new UGreenLeds instance
+func NewUGreenLeds(device string) (*UGreenLeds, error) {
+	if device == "" {
+		var err error
+		device, err = detectUGreenLedDevice()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Using auto-detected LED I2C device: %s", device)
+		log.Printf("Set device: %s in config.yaml to skip auto-detection if needed", device)
+	} else {
+		log.Printf("Using configured LED I2C device: %s", device)
+	}
+	t := &i2cTransport{device: device, address: UGREEN_LED_I2C_ADDR, layout: statusLayouts[StatusLayoutStandard]}
+	if err := t.Reopen(); err != nil {
+		return nil, err
+	}
+	return NewUGreenLedsWithTransport(t), nil
+}
+
+// openI2CDevice opens an I2C device for reading and writing; tests replace it
+var openI2CDevice = func(path string) (int, error) {
+	return syscall.Open(path, syscall.O_RDWR, 0600)
+}
+
+// i2cOpenError explains the common reasons an I2C device can't be opened
+func i2cOpenError(device string, err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOENT):
+		return fmt.Errorf("I2C device %q does not exist: the i2c-dev kernel module may not be loaded, try 'modprobe i2c-dev' (available: %s)", device, availableI2CDevices())
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return fmt.Errorf("permission denied opening I2C device %q: run as root or grant access to the device, or use --no-leds to run without LEDs", device)
+	}
+	return fmt.Errorf("failed to open I2C device %q: %w (available: %s)", device, err, availableI2CDevices())
+}
+
+// NewDryRunUGreenLeds returns a UGreenLeds that logs every command instead of writing to I2C
+func NewDryRunUGreenLeds() *UGreenLeds {
+	log.Printf("Dry run: LED commands will be logged, not written")
+	return NewUGreenLedsWithTransport(newDryRunTransport())
+}
+
+// NewNoLedsUGreenLeds returns a UGreenLeds that silently discards every command
+func NewNoLedsUGreenLeds() *UGreenLeds {
+	log.Printf("LEDs disabled: LED commands will be skipped")
+	t := newDryRunTransport()
+	t.quiet = true
+	return NewUGreenLedsWithTransport(t)
+}
+
+// NewUGreenLedsWithTransport returns a UGreenLeds that sends its commands
+// through t, for custom transports and tests
+func NewUGreenLedsWithTransport(t Transport) *UGreenLeds {
+	u := &UGreenLeds{
+		transport:     t,
+		lastLedStates: make(map[int]ledState),
+		lastLedStatus: make(map[int]LedStatus),
+		timing:        DefaultLedTiming,
+		statusLayout:  statusLayouts[StatusLayoutStandard],
+		deferred:      make(map[deferredKey]func() error),
+		now:           time.Now,
+	}
+	u.reopener, _ = t.(reopener)
+	u.openChecker, _ = t.(openChecker)
+	return u
+}
+
+func detectUGreenLedDevice() (string, error) {
+	paths, err := filepath.Glob("/dev/i2c-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to list I2C devices: %w", err)
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("no I2C devices found at /dev/i2c-*: the i2c-dev kernel module may not be loaded, try 'modprobe i2c-dev'")
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return i2cBusNumber(paths[i]) < i2cBusNumber(paths[j])
+	})
+	log.Printf("Discovered %d I2C devices: %s", len(paths), strings.Join(paths, ", "))
+
+	denied := 0
+	for _, path := range paths {
+		fd, err := openI2CDevice(path)
+		if err != nil {
+			if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) {
+				denied++
+			}
+			continue
+		}
+
+		if err := ioctlSetSlave(fd, UGREEN_LED_I2C_ADDR); err == nil && probeLedController(fd) {
+			syscall.Close(fd)
+			return path, nil
+		}
+		syscall.Close(fd)
+	}
+
+	if denied == len(paths) {
+		return "", fmt.Errorf("permission denied opening all %d I2C devices: run as root or grant access to /dev/i2c-*, or use --no-leds to run without LEDs", len(paths))
+	}
+	return "", fmt.Errorf("failed to auto-detect UGREEN LED controller at I2C address 0x%x across %d buses", UGREEN_LED_I2C_ADDR, len(paths))
+}
+
+// availableI2CDevices lists /dev/i2c-* for error messages
+func availableI2CDevices() string {
+	paths, _ := filepath.Glob("/dev/i2c-*")
+	if len(paths) == 0 {
+		return "none"
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return i2cBusNumber(paths[i]) < i2cBusNumber(paths[j])
+	})
+	return strings.Join(paths, ", ")
+}
+
+func i2cBusNumber(path string) int {
+	bus, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "i2c-"))
+	if err != nil {
+		return int(^uint(0) >> 1)
+	}
+	return bus
+}
+
+// probeLedController reports whether fd answers like the LED controller,
+// in any known status layout. All-zero blocks are skipped: they are a valid
+// "off" status, but also what an unrelated device that doesn't answer status
+// reads returns.
+func probeLedController(fd int) bool {
+	for _, name := range statusLayoutOrder {
+		layout := statusLayouts[name]
+		for id := range LedNames {
+			data, err := readLedStatusRaw(fd, layout, id)
+			if err == nil && verifyChecksum(layout, data) && slices.ContainsFunc(data, func(v byte) bool { return v != 0 }) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ErrClosed is returned by the reads and writes of a closed controller
+var ErrClosed = errors.New("LED controller closed")
+
+// Close closes the transport. Later reads and writes return ErrClosed.
+func (u *UGreenLeds) Close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closed.Store(true)
+	if u.transport != nil {
+		u.transport.Close()
+		u.transport = nil
+	}
+}
+
+// GetLedStatus reads the current status of an LED from the controller
+func (u *UGreenLeds) GetLedStatus(id int) (LedStatus, error) {
+	if !IsValidLedIndex(id) {
+		return LedStatus{}, fmt.Errorf("invalid LED index %d (valid range: 0-%d)", id, GetMaxLedIndex())
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.readStatus(id)
+}
+
+// readStatus reads an LED's status from the transport, or returns ErrClosed
+// once the controller is closed. Callers hold mu.
+func (u *UGreenLeds) readStatus(id int) (LedStatus, error) {
+	if u.transport == nil {
+		return LedStatus{}, ErrClosed
+	}
+	return u.transport.ReadStatus(id)
+}
+
+// ReadRawStatus reads an LED's raw status block from the controller without
+// decoding it, for debugging. Only the I2C transport supports it.
+func (u *UGreenLeds) ReadRawStatus(id int) ([]byte, error) {
+	if !IsValidLedIndex(id) {
+		return nil, fmt.Errorf("invalid LED index %d (valid range: 0-%d)", id, GetMaxLedIndex())
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.transport == nil {
+		return nil, ErrClosed
+	}
+	r, ok := u.transport.(rawStatusReader)
+	if !ok {
+		return nil, fmt.Errorf("raw status is only available from an I2C device")
+	}
+	return r.ReadRawStatus(id)
+}
+
+func (u *UGreenLeds) SetLedColor(id int, r, g, b byte) error {
+	if !IsValidLedIndex(id) {
+		return fmt.Errorf("invalid LED index %d (valid range: 0-%d)", id, GetMaxLedIndex())
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.transport == nil {
+		return ErrClosed
+	}
+	return u.setLedColor(id, r, g, b)
+}
+
+// SetColorCorrection sets the red, green, and blue multipliers applied to
+// every color before it is written. A nil slice disables correction.
+func (u *UGreenLeds) SetColorCorrection(correction []float64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.colorCorrection = correction
+}
+
+// SetTiming sets the retry count and I2C delays used for writes
+func (u *UGreenLeds) SetTiming(timing LedTiming) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.timing = timing
+}
+
+// SetMinWriteInterval sets the minimum time between writes of the same kind
+// (color, brightness, or mode) to one LED; 0 disables the limit. A change
+// within the interval is held, replaced by any later change of the same
+// kind, and written by the first EndBatch after the interval has passed.
+func (u *UGreenLeds) SetMinWriteInterval(interval time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.minWriteInterval = interval
+}
+
+// WriteStats returns the write, retry, and failure counts since startup
+func (u *UGreenLeds) WriteStats() LedWriteStats {
+	return LedWriteStats{
+		Writes:   u.writes.Load(),
+		Retries:  u.retries.Load(),
+		Failures: u.failures.Load(),
+
+		AvgLatencyMs: float64(u.latency.average()) / float64(time.Millisecond),
+	}
+}
+
+// WriteLatency returns the latency histogram of successful writes since
+// startup. Batched writes count until their batch is confirmed.
+func (u *UGreenLeds) WriteLatency() WriteLatency {
+	return u.latency.histogram()
+}
+
+// LastColor returns the last color successfully requested for an LED, before
+// color correction, and false if none was
+func (u *UGreenLeds) LastColor(id int) ([3]byte, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	state := u.lastLedStates[id]
+	return state.requested, state.colorSet
+}
+
+// LastLit returns the color, as requested, and the brightness last written
+// to an LED, and whether it is lit, on or blinking, with a known color
+func (u *UGreenLeds) LastLit(id int) ([3]byte, byte, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	state := u.lastLedStates[id]
+	lit := state.mode == LedModeOn || state.mode == LedModeBlink
+	return state.requested, state.brightness, lit && state.colorSet
+}
+
+// Status returns the status last read back from an LED after a write,
+// without any I2C access. It returns false if none has been read.
+func (u *UGreenLeds) Status(id int) (LedStatus, bool) {
+	u.statusMu.Lock()
+	defer u.statusMu.Unlock()
+	status, ok := u.lastLedStatus[id]
+	return status, ok
+}
+
+// RefreshStatus reads an LED's status from the controller and caches it
+func (u *UGreenLeds) RefreshStatus(id int) (LedStatus, error) {
+	if !IsValidLedIndex(id) {
+		return LedStatus{}, fmt.Errorf("invalid LED index %d (valid range: 0-%d)", id, GetMaxLedIndex())
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	status, err := u.readStatus(id)
+	if err != nil {
+		return LedStatus{}, err
+	}
+	u.statusMu.Lock()
+	u.lastLedStatus[id] = status
+	u.statusMu.Unlock()
+	return status, nil
+}
+
+// AllStatus returns a copy of the cached status of every LED read so far,
+// by LED index
+func (u *UGreenLeds) AllStatus() map[int]LedStatus {
+	u.statusMu.Lock()
+	defer u.statusMu.Unlock()
+	return maps.Clone(u.lastLedStatus)
+}
+
+// LedState is the last state written to an LED
+type LedState struct {
+	Index      int    `json:"index"`
+	Name       string `json:"name"`
+	Mode       string `json:"mode"`
+	R          byte   `json:"r"`
+	G          byte   `json:"g"`
+	B          byte   `json:"b"`
+	Brightness byte   `json:"brightness"`
+}
+
+var ledModeStrings = []string{"off", "on", "blink", "breath"}
+
+// LedStates returns the last state written to each LED, in index order.
+// LEDs never written are omitted.
+func (u *UGreenLeds) LedStates() []LedState {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var states []LedState
+	for id, name := range LedNames {
+		state, ok := u.lastLedStates[id]
+		if !ok {
+			continue
+		}
+		mode := "off"
+		if int(state.mode) < len(ledModeStrings) {
+			mode = ledModeStrings[state.mode]
+		}
+		states = append(states, LedState{
+			Index:      id,
+			Name:       name,
+			Mode:       mode,
+			R:          state.color[0],
+			G:          state.color[1],
+			B:          state.color[2],
+			Brightness: state.brightness,
+		})
+	}
+	return states
+}
+
+// SavedLed is an LED's state saved by SaveLed
+type SavedLed struct {
+	state ledState
+	known bool
+}
+
+// SaveLed returns an LED's state for RestoreLed: the state last written to
+// it, or for an LED never written, the state read back from the controller
+func (u *UGreenLeds) SaveLed(id int) SavedLed {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if state, ok := u.lastLedStates[id]; ok {
+		return SavedLed{state: state, known: true}
+	}
+	status, err := u.readStatus(id)
+	if err != nil || !status.Available {
+		return SavedLed{}
+	}
+	mode := slices.Index(ledModeStrings, status.OpMode)
+	if mode < 0 {
+		return SavedLed{}
+	}
+	// The color read back is already corrected, so it is written as is
+	state := ledState{
+		color:      [3]byte{status.ColorR, status.ColorG, status.ColorB},
+		colorSet:   true,
+		brightness: status.Brightness,
+		mode:       byte(mode),
+	}
+	state.requested = state.color
+	copy(state.params[:], BlinkParams(int(status.TOn), int(status.TOff)))
+	return SavedLed{state: state, known: true}
+}
+
+// RestoreLed writes an LED's state saved by SaveLed back to it. A state
+// that couldn't be saved restores the LED off.
+func (u *UGreenLeds) RestoreLed(id int, saved SavedLed) error {
+	if !IsValidLedIndex(id) {
+		return fmt.Errorf("invalid LED index %d (valid range: 0-%d)", id, GetMaxLedIndex())
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.transport == nil {
+		return ErrClosed
+	}
+	state := saved.state
+	if !saved.known {
+		return u.setLedMode(id, LedModeOff, nil)
+	}
+	if state.colorSet {
+		if err := u.setCorrectedColor(id, state.requested, state.color); err != nil {
+			return err
+		}
+	}
+	if err := u.setLedBrightness(id, state.brightness); err != nil {
+		return err
+	}
+	var params []byte
+	if state.mode == LedModeBlink || state.mode == LedModeBreath {
+		params = state.params[:]
+	}
+	return u.setLedMode(id, state.mode, params)
+}
+
+func (u *UGreenLeds) SetLedBrightness(id int, brightness byte) error {
+	if !IsValidLedIndex(id) {
+		return fmt.Errorf("invalid LED index %d (valid range: 0-%d)", id, GetMaxLedIndex())
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.transport == nil {
+		return ErrClosed
+	}
+	return u.setLedBrightness(id, brightness)
+}
+
+func (u *UGreenLeds) SetLedMode(id int, mode byte, params []byte) error {
+	if !IsValidLedIndex(id) {
+		return fmt.Errorf("invalid LED index %d (valid range: 0-%d)", id, GetMaxLedIndex())
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.transport == nil {
+		return ErrClosed
+	}
+	return u.setLedMode(id, mode, params)
+}
+
+// pendingWrite is a write awaiting batch confirmation
+type pendingWrite struct {
+	command byte
+	params  []byte
+	sent    time.Time
+}
+
+// BeginBatch starts deferring write confirmation when the timing enables
+// BatchConfirm. Writes until EndBatch are sent once and confirmed together.
+func (u *UGreenLeds) BeginBatch() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.stale.Swap(false) {
+		u.forgetWritten()
+	}
+	u.batching = u.timing.BatchConfirm
+}
+
+// EndBatch confirms the writes since BeginBatch with one status read per LED,
+// after a single query delay. An LED that fails confirmation has its writes
+// replayed with full per-write confirmation; the errors of any that still
+// fail are returned by LED index, and their state is forgotten so the next
+// poll rewrites them.
+func (u *UGreenLeds) EndBatch() map[int]error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	errs := u.flushDeferred()
+	u.batching = false
+	pending := u.pending
+	u.pending = nil
+	if len(pending) == 0 {
+		return errs
+	}
+
+	ids := make([]int, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	time.Sleep(u.timing.QueryDelay)
+	for _, id := range ids {
+		writes := pending[id]
+		status, err := u.readStatus(id)
+		if err == nil && u.lastLedStates[id].confirmedBy(status) {
+			u.writes.Add(uint64(len(writes)))
+			for _, w := range writes {
+				u.latency.record(time.Since(w.sent))
+			}
+			u.statusMu.Lock()
+			u.lastLedStatus[id] = status
+			u.statusMu.Unlock()
+			continue
+		}
+		for _, w := range writes {
+			if err := u.modifyLedWithRetry(id, w.command, w.params, nil); err != nil {
+				if errs == nil {
+					errs = make(map[int]error)
+				}
+				errs[id] = err
+				u.lastLedStates[id] = u.lastLedStates[id].unknown()
+				break
+			}
+		}
+		u.updateLedStatus(id)
+	}
+	return errs
+}
+
+// --- Internal methods ---
+
+// writeKind groups the commands that rate limiting coalesces: a held write
+// is replaced by a later one of the same kind
+type writeKind int
+
+const (
+	writeColor writeKind = iota
+	writeBrightness
+	writeMode
+	writeKinds
+)
+
+type deferredKey struct {
+	id   int
+	kind writeKind
+}
+
+// rateLimited reports whether a write of kind to id falls within
+// minWriteInterval of the last one, and if so holds write to run once the
+// interval has passed, replacing any write of the same kind already held
+func (u *UGreenLeds) rateLimited(id int, kind writeKind, write func() error) bool {
+	key := deferredKey{id, kind}
+	delete(u.deferred, key)
+	last := u.lastLedStates[id].lastWrite[kind]
+	if u.minWriteInterval <= 0 || last.IsZero() || u.now().Sub(last) >= u.minWriteInterval {
+		return false
+	}
+	u.deferred[key] = write
+	return true
+}
+
+// flushDeferred runs the held writes whose interval has passed, by LED and
+// then kind, and returns their errors by LED index
+func (u *UGreenLeds) flushDeferred() map[int]error {
+	if len(u.deferred) == 0 {
+		return nil
+	}
+	keys := slices.Collect(maps.Keys(u.deferred))
+	slices.SortFunc(keys, func(a, b deferredKey) int {
+		if a.id != b.id {
+			return a.id - b.id
+		}
+		return int(a.kind - b.kind)
+	})
+	var errs map[int]error
+	for _, key := range keys {
+		last := u.lastLedStates[key.id].lastWrite[key.kind]
+		if u.minWriteInterval > 0 && u.now().Sub(last) < u.minWriteInterval {
+			continue
+		}
+		write := u.deferred[key]
+		delete(u.deferred, key)
+		if err := write(); err != nil {
+			if errs == nil {
+				errs = make(map[int]error)
+			}
+			errs[key.id] = err
+		}
+	}
+	return errs
+}
+func (u *UGreenLeds) updateLedStatus(id int) {
+	if u.batching {
+		return // read once in EndBatch
+	}
+	status, err := u.readStatus(id)
+	if err == nil {
+		u.statusMu.Lock()
+		u.lastLedStatus[id] = status
+		u.statusMu.Unlock()
+	}
+}
+
+func (u *UGreenLeds) setLedColor(id int, r, g, b byte) error {
+	cr, cg, cb := correctColor(r, g, b, u.colorCorrection)
+	return u.setCorrectedColor(id, [3]byte{r, g, b}, [3]byte{cr, cg, cb})
+}
+
+// setCorrectedColor writes color, corrected from requested, to an LED
+func (u *UGreenLeds) setCorrectedColor(id int, requested, color [3]byte) error {
+	state := u.lastLedStates[id]
+	if state.color == color {
+		delete(u.deferred, deferredKey{id, writeColor})
+		return nil
+	}
+	if u.rateLimited(id, writeColor, func() error { return u.setCorrectedColor(id, requested, color) }) {
+		return nil
+	}
+	err := u.modifyLedWithRetry(id, LedCmdColor, color[:], nil)
+	if err == nil {
+		state.color = color
+		state.requested = requested
+		state.colorSet = true
+		state.lastWrite[writeColor] = u.now()
+		u.lastLedStates[id] = state
+		u.updateLedStatus(id)
+	}
+	return err
+}
+
+func (u *UGreenLeds) setLedBrightness(id int, brightness byte) error {
+	state := u.lastLedStates[id]
+	if state.brightness == brightness {
+		delete(u.deferred, deferredKey{id, writeBrightness})
+		return nil
+	}
+	if u.rateLimited(id, writeBrightness, func() error { return u.setLedBrightness(id, brightness) }) {
+		return nil
+	}
+	err := u.modifyLedWithRetry(id, LedCmdBrightness, []byte{brightness}, nil)
+	if err == nil {
+		state.brightness = brightness
+		state.lastWrite[writeBrightness] = u.now()
+		u.lastLedStates[id] = state
+		u.updateLedStatus(id)
+	}
+	return err
+}
+
+func (u *UGreenLeds) setLedMode(id int, mode byte, params []byte) error {
+	state := u.lastLedStates[id]
+	if state.mode == mode {
+		if mode == 0 || mode == 1 {
+			delete(u.deferred, deferredKey{id, writeMode})
+			return nil
+		}
+		if (mode == 2 || mode == 3) && params != nil && state.params == [4]byte{params[0], params[1], params[2], params[3]} {
+			delete(u.deferred, deferredKey{id, writeMode})
+			return nil
+		}
+	}
+	heldParams := slices.Clone(params)
+	if u.rateLimited(id, writeMode, func() error { return u.setLedMode(id, mode, heldParams) }) {
+		return nil
+	}
+	var err error
+	switch mode {
+	case 0: // off
+		err = u.modifyLedWithRetry(id, LedCmdOnOff, []byte{0}, nil)
+	case 1: // on
+		err = u.modifyLedWithRetry(id, LedCmdOnOff, []byte{1}, nil)
+	case 2: // blink
+		err = u.modifyLedWithRetry(id, LedCmdBlink, params, nil)
+	case 3: // breath
+		err = u.modifyLedWithRetry(id, LedCmdBreath, params, nil)
+	}
+	if err == nil {
+		state.mode = mode
+		state.lastWrite[writeMode] = u.now()
+		if params != nil && (mode == 2 || mode == 3) && len(params) == 4 {
+			state.params = [4]byte{params[0], params[1], params[2], params[3]}
+		} else {
+			state.params = [4]byte{}
+		}
+		u.lastLedStates[id] = state
+		u.updateLedStatus(id)
+	}
+	return err
+}
+
+// correctColor scales each channel by its multiplier, clamping to 0-255
+func correctColor(r, g, b byte, correction []float64) (byte, byte, byte) {
+	if len(correction) != 3 {
+		return r, g, b
+	}
+	scale := func(c byte, m float64) byte {
+		return byte(math.Max(0, math.Min(255, math.Round(float64(c)*m))))
+	}
+	return scale(r, correction[0]), scale(g, correction[1]), scale(b, correction[2])
+}
+
+// --- Low-level I2C and LED access functions ---
+
+// verifyChecksum reports whether data is a status block of layout whose
+// trailing big-endian sum matches its payload. The status block has no
+// header, so an LED that is off, black, and at zero brightness and timings
+// legitimately sums to 0.
+func verifyChecksum(layout StatusLayout, data []byte) bool {
+	if len(data) != layout.Len {
+		return false
+	}
+	sum, stored := layout.checksum(data)
+	return sum == stored
+}
+
+func parseLedStatus(layout StatusLayout, data []byte) LedStatus {
+	if !verifyChecksum(layout, data) {
+		return LedStatus{}
+	}
+	return decodeLedStatus(data)
+}
+
+// decodeLedStatus decodes a status block without checking its checksum
+func decodeLedStatus(data []byte) LedStatus {
+	status := LedStatus{}
+	opModes := []string{"off", "on", "blink", "breath"}
+	opModeIdx := int(data[0])
+	if opModeIdx < len(opModes) {
+		status.OpMode = opModes[opModeIdx]
+	} else {
+		status.OpMode = "unknown"
+	}
+	status.Brightness = data[1]
+	status.ColorR = data[2]
+	status.ColorG = data[3]
+	status.ColorB = data[4]
+	tHigh := binary.BigEndian.Uint16(data[5:7])
+	tLow := binary.BigEndian.Uint16(data[7:9])
+	status.TOn = tLow
+	status.TOff = tHigh - tLow
+	status.Available = true
+	return status
+}
+
+func readLedStatus(fd int, layout StatusLayout, ledID int) (LedStatus, error) {
+	data, err := readLedStatusRaw(fd, layout, ledID)
+	if err != nil {
+		return LedStatus{}, err
+	}
+	return parseLedStatus(layout, data), nil
+}
+
+// readLedStatusRaw reads an LED's status block of layout as returned by the
+// controller
+func readLedStatusRaw(fd int, layout StatusLayout, ledID int) ([]byte, error) {
+	cmd := 0x81 + byte(ledID)
+	var smbusData i2cSmbusData
+	ioctlData := i2cSmbusIoctlData{
+		readWrite: I2C_SMBUS_READ,
+		command:   cmd,
+		size:      I2C_SMBUS_I2C_BLOCK_DATA,
+		data:      uintptr(unsafe.Pointer(&smbusData)),
+	}
+	statusLen := layout.Len
+	smbusData.block[0] = byte(statusLen)
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		uintptr(fd),
+		uintptr(I2C_SMBUS),
+		uintptr(unsafe.Pointer(&ioctlData)),
+	)
+	if errno != 0 {
+		return nil, fmt.Errorf("ioctl error: %v", errno)
+	}
+	// Data follows the length byte
+	return append([]byte(nil), smbusData.block[1:1+statusLen]...), nil
+}
+
+// FormatStatusDump describes a raw status block for debugging: the raw
+// bytes, whether the checksum matches the selected layout, and the fields
+// decoded regardless
+func (u *UGreenLeds) FormatStatusDump(id int, raw []byte) string {
+	u.mu.Lock()
+	layout := u.statusLayout
+	u.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "LED %d (%s)\n", id, LedNames[id])
+	fmt.Fprintf(&b, "  raw:      % x\n", raw)
+	if len(raw) != layout.Len {
+		fmt.Fprintf(&b, "  length:   %d bytes, want %d\n", len(raw), layout.Len)
+		return b.String()
+	}
+	sum, stored := layout.checksum(raw)
+	result := "ok"
+	if !verifyChecksum(layout, raw) {
+		result = "BAD"
+	}
+	fmt.Fprintf(&b, "  checksum: %s (sum 0x%04x, stored 0x%04x)\n", result, sum, stored)
+	status := decodeLedStatus(raw)
+	fmt.Fprintf(&b, "  decoded:  mode=%s brightness=%d color=%d,%d,%d t_on=%dms t_off=%dms\n",
+		status.OpMode, status.Brightness, status.ColorR, status.ColorG, status.ColorB, status.TOn, status.TOff)
+	return b.String()
+}
+
+// encodeLedCommand builds the 12-byte block written to the controller: the
+// LED ID, a fixed header, the command, up to 4 params, and a big-endian
+// 16-bit sum of the preceding bytes. The sum is taken with the LED ID byte
+// still 0, so it doesn't depend on the LED.
+func encodeLedCommand(ledID int, command byte, params []byte) []byte {
+	data := []byte{
+		0x00,                   // placeholder for LED ID
+		0xa0,                   // fixed
+		0x01,                   // fixed
+		0x00,                   // fixed
+		0x00,                   // fixed
+		command,                // command
+		0x00, 0x00, 0x00, 0x00, // up to 4 params
+	}
+	copy(data[6:], params)
+
+	// Compute checksum
+	sum := 0
+	for _, b := range data {
+		sum += int(b)
+	}
+	data = append(data, byte(sum>>8), byte(sum&0xff))
+
+	// Now set LED ID in data[0] (after checksum is appended)
+	data[0] = byte(ledID)
+	return data
+}
+
+func writeLedCommand(fd int, ledID int, command byte, params []byte) error {
+	data := encodeLedCommand(ledID, command, params)
+
+	// Prepare SMBus block write
+	var smbusData i2cSmbusData
+	smbusData.block[0] = byte(len(data))
+	copy(smbusData.block[1:], data)
+	ioctlData := i2cSmbusIoctlData{
+		readWrite: 0, // write
+		command:   byte(ledID),
+		size:      I2C_SMBUS_I2C_BLOCK_DATA,
+		data:      uintptr(unsafe.Pointer(&smbusData)),
+	}
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		uintptr(fd),
+		uintptr(I2C_SMBUS),
+		uintptr(unsafe.Pointer(&ioctlData)),
+	)
+	if errno != 0 {
+		return fmt.Errorf("ioctl error: %v", errno)
+	}
+	return nil
+}
+
+func confirmStatus(t Transport, id int, wantOn *bool, timing LedTiming) bool {
+	for range timing.MaxRetry {
+		time.Sleep(timing.QueryDelay)
+		status, err := t.ReadStatus(id)
+		if err == nil && status.Available {
+			if wantOn == nil {
+				return true // for color/brightness, just check available
+			}
+			if (*wantOn && status.OpMode == "on") || (!*wantOn && status.OpMode == "off") {
+				return true
+			}
+		}
+		time.Sleep(timing.RetryDelay)
+	}
+	return false
+}
+
+func (u *UGreenLeds) modifyLedWithRetry(id int, command byte, params []byte, wantOn *bool) error {
+	// Validate LED index before attempting to modify
+	if !IsValidLedIndex(id) {
+		return fmt.Errorf("invalid LED index %d (valid range: 0-%d)", id, GetMaxLedIndex())
+	}
+
+	if u.transport == nil {
+		return ErrClosed // as for a write deferred past Close
+	}
+	start := time.Now()
+	if u.batching {
+		if err := u.transport.WriteCommand(id, command, params); err == nil {
+			if u.pending == nil {
+				u.pending = make(map[int][]pendingWrite)
+			}
+			u.pending[id] = append(u.pending[id], pendingWrite{command, append([]byte(nil), params...), start})
+			return nil
+		}
+		// Fall through to the confirmed path for writes that fail outright
+	}
+
+	timing := u.timing
+	var lastErr error
+	for retry := 0; retry < timing.MaxRetry; retry++ {
+		if retry > 0 {
+			u.retries.Add(1)
+		}
+		lastErr = u.transport.WriteCommand(id, command, params)
+		if lastErr == nil && confirmStatus(u.transport, id, wantOn, timing) {
+			u.writes.Add(1)
+			u.latency.record(time.Since(start))
+			u.failedWrites = 0
+			return nil
+		}
+		if retry == 0 {
+			time.Sleep(timing.ModificationDelay)
+		} else {
+			time.Sleep(timing.RetryDelay)
+		}
+	}
+	u.failures.Add(1)
+	if lastErr != nil {
+		u.failedWrites++
+		if u.failedWrites >= reopenAfterFailures {
+			u.reopen(fmt.Sprintf("%d failed writes", reopenAfterFailures))
+		}
+	}
+	return fmt.Errorf("failed to set %s after %d retries: %v", LedNames[id], timing.MaxRetry, lastErr)
+}
+
+// IsOpen reports whether the controller can be written: it isn't closed,
+// and its device is open, which a failed reopen leaves it not. It takes no
+// lock, so a write blocked in the driver doesn't block it.
+func (u *UGreenLeds) IsOpen() bool {
+	if u.closed.Load() {
+		return false
+	}
+	return u.openChecker == nil || u.openChecker.IsOpen()
+}
+
+// TryReopen reopens the transport, as after repeated write errors, logging
+// reason. It takes no lock, so a write blocked in the driver doesn't block
+// it: the blocked write ends on the old device and the next one uses the
+// new one. The written LED state is forgotten at the next BeginBatch, so
+// the following poll rewrites every LED.
+func (u *UGreenLeds) TryReopen(reason string) error {
+	if u.closed.Load() {
+		return ErrClosed
+	}
+	if u.reopener == nil {
+		return errors.New("controller can't be reopened")
+	}
+	u.reopenMu.Lock()
+	defer u.reopenMu.Unlock()
+	if err := u.reopener.Reopen(); err != nil {
+		return err
+	}
+	if u.closed.Load() {
+		// Close ran during the reopen, maybe before the new device was
+		// swapped in, so close that one too
+		u.reopener.Close()
+		return ErrClosed
+	}
+	log.Printf("Reopened LED controller after %s", reason)
+	u.stale.Store(true)
+	return nil
+}
+
+// reopen reopens the transport after repeated write errors, as when the I2C
+// controller was reset by suspend/resume or a driver reload and the old fd
+// fails every ioctl. The cached LED state is forgotten so the next poll
+// rewrites every LED. Callers hold mu.
+func (u *UGreenLeds) reopen(reason string) {
+	u.failedWrites = 0
+	if u.reopener == nil {
+		return
+	}
+	u.reopenMu.Lock()
+	defer u.reopenMu.Unlock()
+	if err := u.reopener.Reopen(); err != nil {
+		log.Printf("Error reopening LED controller: %v", err)
+		return
+	}
+	log.Printf("Reopened LED controller after %s", reason)
+	u.forgetWritten()
+}
+
+// forgetWritten forgets the state written to every LED, so the next writes
+// go to the controller. Callers hold mu.
+func (u *UGreenLeds) forgetWritten() {
+	for id, state := range u.lastLedStates {
+		u.lastLedStates[id] = state.unknown()
+	}
+	u.statusMu.Lock()
+	clear(u.lastLedStatus)
+	u.statusMu.Unlock()
+}
+
+type ledState struct {
+	color      [3]byte // as written, after color correction
+	requested  [3]byte // as requested, before color correction
+	colorSet   bool    // color and requested are known
+	brightness byte
+	mode       byte                  // 0=off, 1=on, 2=blink, 3=breath
+	params     [4]byte               // for blink/breath params
+	idleTicks  int                   // consecutive ticks without activity
+	litUntil   time.Time             // shown active at least until then, see debounceIdle
+	smoothed   float64               // active brightness average, 0 for none, see smoothBrightness
+	lastWrite  [writeKinds]time.Time // by kind, see rateLimited
+}
+
+// zeroStatus is what an all-zero status block decodes to: off, black, and
+// zero brightness and timings. A controller that didn't take the writes can
+// read back the same.
+var zeroStatus = LedStatus{Available: true, OpMode: "off"}
+
+// confirmedBy reports whether status, read back after a batch of writes,
+// confirms that the LED is in state. An all-zero status only confirms
+// writes that left the LED exactly so.
+func (s ledState) confirmedBy(status LedStatus) bool {
+	if !status.Available {
+		return false
+	}
+	if status != zeroStatus {
+		return true
+	}
+	return s.mode == LedModeOff && s.brightness == 0 && s.colorSet && s.color == [3]byte{} && s.params == [4]byte{}
+}
+
+// unknown returns state with the written color, brightness, and mode
+// forgotten, so the next write of each goes to the controller, and the
+// monitor's idle, smoothing, and rate limit tracking kept
+func (s ledState) unknown() ledState {
+	return ledState{idleTicks: s.idleTicks, litUntil: s.litUntil, smoothed: s.smoothed, lastWrite: s.lastWrite}
+}
+
+// debounceIdle records one tick of activity at now for state and reports
+// whether the LED should show idle. Activity shows immediately; idle only
+// shows after threshold consecutive idle ticks, so brief gaps don't flicker,
+// and no sooner than minOn after the last activity, so brief bursts stay
+// visible.
+func debounceIdle(state *ledState, active bool, threshold int, now time.Time, minOn time.Duration) bool {
+	if active {
+		state.idleTicks = 0
+		state.litUntil = now.Add(minOn)
+		return false
+	}
+	if state.idleTicks < threshold {
+		state.idleTicks++
+	}
+	idle := state.idleTicks >= threshold && !now.Before(state.litUntil)
+	if idle {
+		// The next burst starts from its own brightness
+		state.smoothed = 0
+	}
+	return idle
+}
+
+// DebounceIdle records one tick of activity at now for an LED and reports
+// whether it should show idle, see debounceIdle
+func (u *UGreenLeds) DebounceIdle(id int, active bool, threshold int, now time.Time, minOn time.Duration) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	state := u.lastLedStates[id]
+	idle := debounceIdle(&state, active, threshold, now, minOn)
+	u.lastLedStates[id] = state
+	return idle
+}
+
+// smoothBrightness folds target into state's moving average of active
+// brightness, smoothed = alpha*target + (1-alpha)*smoothed, and returns it.
+// The first tick of a burst starts at target; an alpha of 1 (or <= 0) is
+// unsmoothed.
+func smoothBrightness(state *ledState, target byte, alpha float64) byte {
+	if state.smoothed == 0 || alpha <= 0 || alpha >= 1 {
+		state.smoothed = float64(target)
+	} else {
+		state.smoothed = alpha*float64(target) + (1-alpha)*state.smoothed
+	}
+	return byte(math.Round(state.smoothed))
+}
+
+// SmoothBrightness smooths an LED's active brightness toward target, see
+// smoothBrightness
+func (u *UGreenLeds) SmoothBrightness(id int, target byte, alpha float64) byte {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	state := u.lastLedStates[id]
+	brightness := smoothBrightness(&state, target, alpha)
+	u.lastLedStates[id] = state
+	return brightness
+}
+
+func ioctlSetSlave(fd int, addr int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(I2C_SLAVE), uintptr(addr))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
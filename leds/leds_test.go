@@ -0,0 +1,488 @@
+package leds
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBreathParams(t *testing.T) {
+	tests := []struct {
+		periodMs int
+		want     []byte
+	}{
+		{200, []byte{0x00, 0xc8, 0x00, 0x64}},
+		{2000, []byte{0x07, 0xd0, 0x03, 0xe8}},
+		{65535, []byte{0xff, 0xff, 0x7f, 0xff}},
+	}
+	for _, tt := range tests {
+		if got := BreathParams(tt.periodMs); !bytes.Equal(got, tt.want) {
+			t.Errorf("BreathParams(%d) = % x, want % x", tt.periodMs, got, tt.want)
+		}
+	}
+}
+
+func TestBlinkParams(t *testing.T) {
+	tests := []struct {
+		onMs, offMs int
+		want        []byte
+	}{
+		{100, 100, []byte{0x00, 0xc8, 0x00, 0x64}},
+		{300, 700, []byte{0x03, 0xe8, 0x01, 0x2c}}, // on time above 255ms uses both bytes
+		{1000, 24, []byte{0x04, 0x00, 0x03, 0xe8}},
+		{65000, 535, []byte{0xff, 0xff, 0xfd, 0xe8}},
+	}
+	for _, tt := range tests {
+		if got := BlinkParams(tt.onMs, tt.offMs); !bytes.Equal(got, tt.want) {
+			t.Errorf("BlinkParams(%d, %d) = % x, want % x", tt.onMs, tt.offMs, got, tt.want)
+		}
+	}
+}
+
+func TestCorrectColor(t *testing.T) {
+	tests := []struct {
+		rgb        [3]byte
+		correction []float64
+		want       [3]byte
+	}{
+		{[3]byte{255, 255, 255}, nil, [3]byte{255, 255, 255}},
+		{[3]byte{255, 255, 255}, []float64{1.0, 0.9, 0.5}, [3]byte{255, 230, 128}},
+		{[3]byte{200, 100, 0}, []float64{1.5, 3.0, 2.0}, [3]byte{255, 255, 0}}, // clamped
+		{[3]byte{10, 20, 30}, []float64{0, 0, 0}, [3]byte{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		r, g, b := correctColor(tt.rgb[0], tt.rgb[1], tt.rgb[2], tt.correction)
+		if got := [3]byte{r, g, b}; got != tt.want {
+			t.Errorf("correctColor(%v, %v) = %v, want %v", tt.rgb, tt.correction, got, tt.want)
+		}
+	}
+}
+
+func TestSetLedColorAppliesCorrection(t *testing.T) {
+	transport := NewFakeTransport()
+	leds := NewUGreenLedsWithTransport(transport)
+	leds.SetColorCorrection([]float64{1.0, 0.5, 2.0})
+
+	if err := leds.SetLedColor(2, 200, 200, 200); err != nil {
+		t.Fatalf("SetLedColor: %v", err)
+	}
+	if got := transport.commands[0].Params; !bytes.Equal(got, []byte{200, 100, 255}) {
+		t.Errorf("expected corrected color [200 100 255], got %v", got)
+	}
+}
+
+func TestDebounceIdle(t *testing.T) {
+	var state ledState
+	now := time.Now()
+	// blip, two idle ticks, blip, then a long idle stretch
+	pattern := []bool{true, false, false, true, false, false, false, false}
+	want := []bool{false, false, false, false, false, false, true, true}
+	for i, active := range pattern {
+		if got := debounceIdle(&state, active, 3, now, 0); got != want[i] {
+			t.Errorf("tick %d (active=%v): idle = %v, want %v", i, active, got, want[i])
+		}
+	}
+}
+
+func TestDebounceIdleThresholdOne(t *testing.T) {
+	var state ledState
+	now := time.Now()
+	if debounceIdle(&state, true, 1, now, 0) {
+		t.Error("expected active tick to show active")
+	}
+	if !debounceIdle(&state, false, 1, now, 0) {
+		t.Error("expected threshold 1 to show idle on the first idle tick")
+	}
+}
+
+func TestSmoothBrightness(t *testing.T) {
+	var state ledState
+	if got := smoothBrightness(&state, 32, 0.5); got != 32 {
+		t.Fatalf("expected the first tick to start at its target, got %d", got)
+	}
+	// a step to 255 closes half the remaining gap each tick
+	want := []byte{144, 199, 227, 241, 248, 252}
+	for i, w := range want {
+		if got := smoothBrightness(&state, 255, 0.5); got != w {
+			t.Errorf("tick %d: brightness = %d, want %d", i, got, w)
+		}
+	}
+
+	if !debounceIdle(&state, false, 1, time.Now(), 0) {
+		t.Fatal("expected idle")
+	}
+	if got := smoothBrightness(&state, 64, 0.5); got != 64 {
+		t.Errorf("expected a burst after idle to start at its target, got %d", got)
+	}
+	if got := smoothBrightness(&state, 200, 1); got != 200 {
+		t.Errorf("expected alpha 1 to be unsmoothed, got %d", got)
+	}
+}
+
+func TestDebounceIdleMinOn(t *testing.T) {
+	var state ledState
+	const tick = 50 * time.Millisecond
+	start := time.Now()
+	// a one-tick burst, then idle ticks: held for 200ms, well past 1 idle tick
+	pattern := []bool{true, false, false, false, false, false}
+	want := []bool{false, false, false, false, true, true}
+	for i, active := range pattern {
+		now := start.Add(time.Duration(i) * tick)
+		if got := debounceIdle(&state, active, 1, now, 200*time.Millisecond); got != want[i] {
+			t.Errorf("tick %d (active=%v): idle = %v, want %v", i, active, got, want[i])
+		}
+	}
+}
+
+func TestNewUGreenLedsOpenErrors(t *testing.T) {
+	orig := openI2CDevice
+	defer func() { openI2CDevice = orig }()
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{syscall.ENOENT, "modprobe i2c-dev"},
+		{syscall.EACCES, "run as root"},
+		{syscall.EPERM, "run as root"},
+		{syscall.EBUSY, "failed to open I2C device"},
+	}
+	for _, tt := range tests {
+		openI2CDevice = func(string) (int, error) { return -1, tt.err }
+		_, err := NewUGreenLeds("/dev/i2c-9")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("open error %v: got %v, want message containing %q", tt.err, err, tt.want)
+		}
+	}
+}
+
+func TestNoLedsUGreenLeds(t *testing.T) {
+	leds := NewNoLedsUGreenLeds()
+	if err := leds.SetLedColor(2, 255, 0, 0); err != nil {
+		t.Errorf("expected writes to succeed without LEDs, got %v", err)
+	}
+	if err := leds.SetLedMode(2, LedModeOn, nil); err != nil {
+		t.Errorf("expected writes to succeed without LEDs, got %v", err)
+	}
+}
+
+// unavailableTransport reports one LED as unavailable
+type unavailableTransport struct {
+	*FakeTransport
+	id int
+}
+
+func (t *unavailableTransport) ReadStatus(ledID int) (LedStatus, error) {
+	status, err := t.FakeTransport.ReadStatus(ledID)
+	if ledID == t.id {
+		status.Available = false
+	}
+	return status, err
+}
+
+func TestBatchConfirm(t *testing.T) {
+	transport := NewFakeTransport()
+	leds := NewUGreenLedsWithTransport(transport)
+	leds.SetTiming(LedTiming{MaxRetry: 3, BatchConfirm: true})
+
+	leds.BeginBatch()
+	for id := 2; id <= 9; id++ {
+		leds.SetLedColor(id, 255, 0, 0)
+		leds.SetLedBrightness(id, 128)
+	}
+	if transport.reads != 0 {
+		t.Errorf("expected no status reads before EndBatch, got %d", transport.reads)
+	}
+	if errs := leds.EndBatch(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if transport.reads != 8 {
+		t.Errorf("expected one status read per LED, got %d", transport.reads)
+	}
+	if got := leds.WriteStats(); got.Writes != 16 || got.Retries != 0 {
+		t.Errorf("expected 16 confirmed writes, got %+v", got)
+	}
+
+	// Without a batch every write is confirmed and then re-read
+	transport.reads = 0
+	leds.SetLedColor(2, 0, 255, 0)
+	if transport.reads != 2 {
+		t.Errorf("expected 2 status reads for an unbatched write, got %d", transport.reads)
+	}
+}
+
+func TestBatchConfirmDisabled(t *testing.T) {
+	transport := NewFakeTransport()
+	leds := NewUGreenLedsWithTransport(transport)
+	leds.SetTiming(LedTiming{MaxRetry: 3})
+
+	leds.BeginBatch()
+	leds.SetLedColor(2, 255, 0, 0)
+	if transport.reads != 2 {
+		t.Errorf("expected writes confirmed immediately without batch_confirm, got %d reads", transport.reads)
+	}
+	if errs := leds.EndBatch(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestBatchConfirmFailure(t *testing.T) {
+	transport := &unavailableTransport{NewFakeTransport(), 3}
+	leds := NewUGreenLedsWithTransport(transport)
+	leds.SetTiming(LedTiming{MaxRetry: 2, BatchConfirm: true})
+	leds.SmoothBrightness(3, 200, 1)
+
+	leds.BeginBatch()
+	leds.SetLedColor(2, 255, 0, 0)
+	leds.SetLedColor(3, 255, 0, 0)
+	errs := leds.EndBatch()
+	if len(errs) != 1 || errs[3] == nil {
+		t.Fatalf("expected an error for LED 3 only, got %v", errs)
+	}
+	if got := leds.WriteStats(); got.Writes != 1 || got.Failures != 1 {
+		t.Errorf("expected 1 write and 1 failure, got %+v", got)
+	}
+
+	// The failed LED's state is forgotten, so the same color is written again
+	before := transport.Count()
+	leds.SetLedColor(3, 255, 0, 0)
+	if transport.Count() == before {
+		t.Error("expected the failed LED to be rewritten")
+	}
+	// The monitor's brightness average is kept
+	if got := leds.SmoothBrightness(3, 100, 0.5); got != 150 {
+		t.Errorf("expected the smoothed brightness kept, got %d", got)
+	}
+}
+
+// zeroTransport reads back an all-zero status block for every LED, as a
+// controller that ignores writes can
+type zeroTransport struct {
+	*FakeTransport
+}
+
+func (t *zeroTransport) ReadStatus(ledID int) (LedStatus, error) {
+	return zeroStatus, nil
+}
+
+func TestBatchConfirmZeroStatus(t *testing.T) {
+	transport := &zeroTransport{NewFakeTransport()}
+	leds := NewUGreenLedsWithTransport(transport)
+	leds.SetTiming(LedTiming{MaxRetry: 1, BatchConfirm: true})
+
+	leds.BeginBatch()
+	leds.SetLedColor(2, 255, 0, 0)
+	leds.SetLedColor(3, 0, 0, 0)
+	leds.SetLedMode(3, LedModeOff, nil)
+	writes := func(id int) int {
+		n := 0
+		for _, c := range transport.commands {
+			if c.LedID == id {
+				n++
+			}
+		}
+		return n
+	}
+	before2, before3 := writes(2), writes(3)
+	leds.EndBatch()
+
+	// A red LED reading back all zero isn't confirmed, so it is rewritten
+	if writes(2) == before2 {
+		t.Error("expected the all-zero readback of a red LED to be unconfirmed")
+	}
+	// An LED written off and black reads back exactly all zero
+	if writes(3) != before3 {
+		t.Errorf("expected the all-zero readback of an off LED to confirm it, got %d rewrites", writes(3)-before3)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	u := NewUGreenLedsWithTransport(NewFakeTransport())
+	u.SetTiming(LedTiming{MaxRetry: 1, BatchConfirm: true})
+	if err := u.SetLedColor(2, 255, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	saved := u.SaveLed(2)
+	u.BeginBatch()
+	if err := u.SetLedBrightness(2, 64); err != nil {
+		t.Fatal(err)
+	}
+	u.Close()
+
+	// A write sent before Close is confirmed after it
+	if errs := u.EndBatch(); !errors.Is(errs[2], ErrClosed) {
+		t.Errorf("EndBatch after Close = %v, want ErrClosed for disk1", errs)
+	}
+	calls := map[string]error{
+		"SetLedColor":      u.SetLedColor(2, 255, 0, 0), // unchanged, but still refused
+		"SetLedBrightness": u.SetLedBrightness(2, 128),
+		"SetLedMode":       u.SetLedMode(2, LedModeOn, nil),
+		"RestoreLed":       u.RestoreLed(2, saved),
+		"TryReopen":        u.TryReopen("test"),
+	}
+	_, calls["GetLedStatus"] = u.GetLedStatus(2)
+	_, calls["RefreshStatus"] = u.RefreshStatus(2)
+	_, calls["ReadRawStatus"] = u.ReadRawStatus(2)
+	for name, err := range calls {
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("%s after Close = %v, want ErrClosed", name, err)
+		}
+	}
+	if u.IsOpen() {
+		t.Error("closed controller reported open")
+	}
+	u.Close() // closing again is harmless
+}
+
+func TestEncodeLedCommand(t *testing.T) {
+	// Expected blocks are worked out by hand from the protocol: the checksum
+	// is 0xa0 + 0x01 + command + params, excluding the LED ID
+	tests := []struct {
+		name    string
+		ledID   int
+		command byte
+		params  []byte
+		want    []byte
+	}{
+		{"color", 2, LedCmdColor, []byte{255, 128, 0},
+			[]byte{0x02, 0xa0, 0x01, 0x00, 0x00, 0x02, 0xff, 0x80, 0x00, 0x00, 0x02, 0x22}},
+		{"brightness", 3, LedCmdBrightness, []byte{128},
+			[]byte{0x03, 0xa0, 0x01, 0x00, 0x00, 0x01, 0x80, 0x00, 0x00, 0x00, 0x01, 0x22}},
+		{"mode on", 0, LedCmdOnOff, []byte{1},
+			[]byte{0x00, 0xa0, 0x01, 0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x00, 0x00, 0xa5}},
+		{"mode off", 1, LedCmdOnOff, []byte{0},
+			[]byte{0x01, 0xa0, 0x01, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0xa4}},
+		{"blink", 9, LedCmdBlink, BlinkParams(100, 100),
+			[]byte{0x09, 0xa0, 0x01, 0x00, 0x00, 0x04, 0x00, 0xc8, 0x00, 0x64, 0x01, 0xd1}},
+		{"breath", 1, LedCmdBreath, BreathParams(2000),
+			[]byte{0x01, 0xa0, 0x01, 0x00, 0x00, 0x05, 0x07, 0xd0, 0x03, 0xe8, 0x02, 0x68}},
+	}
+	for _, tt := range tests {
+		if got := encodeLedCommand(tt.ledID, tt.command, tt.params); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: encodeLedCommand() = % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeLedCommandChecksumIgnoresLedID(t *testing.T) {
+	want := encodeLedCommand(0, LedCmdColor, []byte{1, 2, 3})
+	for id := range LedNames {
+		got := encodeLedCommand(id, LedCmdColor, []byte{1, 2, 3})
+		if got[0] != byte(id) {
+			t.Errorf("LED %d: ID byte = %d", id, got[0])
+		}
+		if !bytes.Equal(got[1:], want[1:]) {
+			t.Errorf("LED %d: % x differs from LED 0 beyond the ID byte: % x", id, got, want)
+		}
+	}
+}
+
+func TestFormatStatusDump(t *testing.T) {
+	u := NewUGreenLedsWithTransport(NewFakeTransport())
+	// disk1 on at brightness 128, red, blinking 500ms on / 500ms off
+	raw := []byte{0x01, 0x80, 0xff, 0x00, 0x00, 0x03, 0xe8, 0x01, 0xf4, 0x03, 0x60}
+	want := "LED 2 (disk1)\n" +
+		"  raw:      01 80 ff 00 00 03 e8 01 f4 03 60\n" +
+		"  checksum: ok (sum 0x0360, stored 0x0360)\n" +
+		"  decoded:  mode=on brightness=128 color=255,0,0 t_on=500ms t_off=500ms\n"
+	if got := u.FormatStatusDump(2, raw); got != want {
+		t.Errorf("FormatStatusDump =\n%s\nwant\n%s", got, want)
+	}
+
+	// a bad checksum is reported, with the fields still decoded
+	raw[10] = 0x61
+	want = "LED 2 (disk1)\n" +
+		"  raw:      01 80 ff 00 00 03 e8 01 f4 03 61\n" +
+		"  checksum: BAD (sum 0x0360, stored 0x0361)\n" +
+		"  decoded:  mode=on brightness=128 color=255,0,0 t_on=500ms t_off=500ms\n"
+	if got := u.FormatStatusDump(2, raw); got != want {
+		t.Errorf("FormatStatusDump =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := u.FormatStatusDump(0, raw[:4]); got != "LED 0 (power)\n  raw:      01 80 ff 00\n  length:   4 bytes, want 11\n" {
+		t.Errorf("unexpected dump of a short block:\n%s", got)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"off, black, zero timings", make([]byte, 11), true},
+		{"on, red", []byte{0x01, 0x80, 0xff, 0x00, 0x00, 0x03, 0xe8, 0x01, 0xf4, 0x03, 0x60}, true},
+		{"wrong sum", []byte{0x01, 0x80, 0xff, 0x00, 0x00, 0x03, 0xe8, 0x01, 0xf4, 0x03, 0x61}, false},
+		{"short", []byte{0x00, 0x00}, false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		if got := verifyChecksum(statusLayouts[StatusLayoutStandard], tt.data); got != tt.want {
+			t.Errorf("%s: verifyChecksum = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseLedStatusOff(t *testing.T) {
+	got := parseLedStatus(statusLayouts[StatusLayoutStandard], make([]byte, 11))
+	want := LedStatus{Available: true, OpMode: "off"}
+	if got != want {
+		t.Errorf("parseLedStatus(off) = %+v, want %+v", got, want)
+	}
+}
+
+func TestMinWriteInterval(t *testing.T) {
+	transport := NewFakeTransport()
+	u := NewUGreenLedsWithTransport(transport)
+	now := time.Unix(1000, 0)
+	u.now = func() time.Time { return now }
+	u.SetMinWriteInterval(100 * time.Millisecond)
+	step := func(d time.Duration) {
+		now = now.Add(d)
+	}
+
+	// the first change of each kind is written immediately
+	u.SetLedMode(2, LedModeOn, nil)
+	u.SetLedBrightness(2, 10)
+	// rapid changes within the interval are held, latest value wins
+	for i := range 10 {
+		step(5 * time.Millisecond)
+		u.SetLedBrightness(2, byte(20+i))
+		u.SetLedMode(2, []byte{LedModeOff, LedModeOn}[i%2], nil)
+	}
+	if got := transport.Count(); got != 2 {
+		t.Fatalf("expected only the first 2 writes within the interval, got %d", got)
+	}
+	if errs := u.EndBatch(); errs != nil || transport.Count() != 2 {
+		t.Fatalf("expected held writes to wait for the interval, got %d writes, errors %v", transport.Count(), errs)
+	}
+
+	step(50 * time.Millisecond)
+	if errs := u.EndBatch(); errs != nil {
+		t.Fatal(errs)
+	}
+	// the mode flapped back to on, so only the latest brightness is written
+	want := []FakeCommand{
+		{2, LedCmdOnOff, []byte{1}},
+		{2, LedCmdBrightness, []byte{10}},
+		{2, LedCmdBrightness, []byte{29}},
+	}
+	if len(transport.commands) != len(want) {
+		t.Fatalf("commands = %+v, want %+v", transport.commands, want)
+	}
+	for i, w := range want {
+		got := transport.commands[i]
+		if got.LedID != w.LedID || got.Command != w.Command || !bytes.Equal(got.Params, w.Params) {
+			t.Errorf("command %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	// other LEDs aren't limited by LED 2's writes
+	u.SetLedBrightness(3, 10)
+	if transport.Count() != 4 {
+		t.Errorf("expected LED 3 to be written immediately, got %d writes", transport.Count())
+	}
+}
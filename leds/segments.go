@@ -0,0 +1,154 @@
+package leds
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// LedSegment assigns a range of LED IDs to one controller, for units whose
+// LEDs are split across controllers or I2C buses
+type LedSegment struct {
+	Device  string // I2C device, e.g. /dev/i2c-1
+	Address int    // I2C address, UGREEN_LED_I2C_ADDR if 0
+	First   int    // first LED ID on the controller
+	Last    int    // last LED ID on the controller
+	Base    int    // the controller's own index for First
+}
+
+// ValidateSegments checks that segments have a device and a valid address,
+// and cover valid, non-overlapping LED ranges
+func ValidateSegments(segments []LedSegment) error {
+	owner := make(map[int]int)
+	for i, seg := range segments {
+		if seg.Device == "" {
+			return fmt.Errorf("segment %d: device unset", i)
+		}
+		if seg.Address != 0 && (seg.Address < 0x08 || seg.Address > 0x77) {
+			return fmt.Errorf("segment %d: invalid I2C address 0x%02x (valid range: 0x08-0x77)", i, seg.Address)
+		}
+		if !IsValidLedIndex(seg.First) || !IsValidLedIndex(seg.Last) || seg.First > seg.Last {
+			return fmt.Errorf("segment %d: invalid LED range %d-%d (valid range: 0-%d)", i, seg.First, seg.Last, GetMaxLedIndex())
+		}
+		if seg.Base < 0 || seg.Base+seg.Last-seg.First > GetMaxLedIndex() {
+			return fmt.Errorf("segment %d: invalid base %d for %d LEDs", i, seg.Base, seg.Last-seg.First+1)
+		}
+		for id := seg.First; id <= seg.Last; id++ {
+			if other, ok := owner[id]; ok {
+				return fmt.Errorf("segment %d: %s already assigned to segment %d", i, LedNames[id], other)
+			}
+			owner[id] = i
+		}
+	}
+	return nil
+}
+
+// segmentRoute is a segment with its open transport
+type segmentRoute struct {
+	first, last, base int
+	transport         Transport
+}
+
+// segmentedTransport dispatches each LED to the controller of its segment,
+// translating the LED ID to the controller's own index
+type segmentedTransport struct {
+	routes []segmentRoute
+}
+
+// route returns the transport and controller index of an LED
+func (t *segmentedTransport) route(ledID int) (Transport, int, error) {
+	for _, r := range t.routes {
+		if ledID >= r.first && ledID <= r.last {
+			return r.transport, r.base + ledID - r.first, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no controller segment for LED %d", ledID)
+}
+
+func (t *segmentedTransport) WriteCommand(ledID int, command byte, params []byte) error {
+	transport, id, err := t.route(ledID)
+	if err != nil {
+		return err
+	}
+	return transport.WriteCommand(id, command, params)
+}
+
+func (t *segmentedTransport) ReadStatus(ledID int) (LedStatus, error) {
+	transport, id, err := t.route(ledID)
+	if err != nil {
+		return LedStatus{}, err
+	}
+	return transport.ReadStatus(id)
+}
+
+func (t *segmentedTransport) ReadRawStatus(ledID int) ([]byte, error) {
+	transport, id, err := t.route(ledID)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := transport.(rawStatusReader)
+	if !ok {
+		return nil, fmt.Errorf("raw status not supported for LED %d", ledID)
+	}
+	return raw.ReadRawStatus(id)
+}
+
+// Reopen reopens every controller, since a reset may have hit any of them
+func (t *segmentedTransport) Reopen() error {
+	var errs []error
+	for _, r := range t.routes {
+		if ro, ok := r.transport.(reopener); ok {
+			errs = append(errs, ro.Reopen())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *segmentedTransport) setStatusLayout(layout StatusLayout) {
+	for _, r := range t.routes {
+		if s, ok := r.transport.(statusLayoutSetter); ok {
+			s.setStatusLayout(layout)
+		}
+	}
+}
+
+// IsOpen reports whether every controller is open
+func (t *segmentedTransport) IsOpen() bool {
+	for _, r := range t.routes {
+		if c, ok := r.transport.(openChecker); ok && !c.IsOpen() {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *segmentedTransport) Close() error {
+	var errs []error
+	for _, r := range t.routes {
+		errs = append(errs, r.transport.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// NewSegmentedUGreenLeds opens one controller per segment and routes each
+// LED to its segment's controller
+func NewSegmentedUGreenLeds(segments []LedSegment) (*UGreenLeds, error) {
+	if err := ValidateSegments(segments); err != nil {
+		return nil, err
+	}
+	t := &segmentedTransport{}
+	for _, seg := range segments {
+		address := seg.Address
+		if address == 0 {
+			address = UGREEN_LED_I2C_ADDR
+		}
+		i2c := &i2cTransport{device: seg.Device, address: address, layout: statusLayouts[StatusLayoutStandard]}
+		if err := i2c.Reopen(); err != nil {
+			t.Close()
+			return nil, err
+		}
+		log.Printf("Using LED controller %s at 0x%02x for %s-%s", seg.Device, address, LedNames[seg.First], LedNames[seg.Last])
+		t.routes = append(t.routes, segmentRoute{first: seg.First, last: seg.Last, base: seg.Base, transport: i2c})
+	}
+	return NewUGreenLedsWithTransport(t), nil
+}
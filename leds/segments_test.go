@@ -0,0 +1,82 @@
+package leds
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSegmentedTransportRouting(t *testing.T) {
+	first, second := NewFakeTransport(), NewFakeTransport()
+	st := &segmentedTransport{routes: []segmentRoute{
+		{first: 0, last: 5, base: 0, transport: first},
+		{first: 6, last: 9, base: 0, transport: second},
+	}}
+
+	tests := []struct {
+		ledID     int
+		transport Transport
+		index     int
+	}{
+		{0, first, 0},
+		{5, first, 5},
+		{6, second, 0},
+		{9, second, 3},
+	}
+	for _, tt := range tests {
+		transport, index, err := st.route(tt.ledID)
+		if err != nil || transport != tt.transport || index != tt.index {
+			t.Errorf("route(%d) = %p, %d, %v; want %p, %d", tt.ledID, transport, index, err, tt.transport, tt.index)
+		}
+	}
+
+	u := NewUGreenLedsWithTransport(st)
+	if err := u.SetLedColor(7, 255, 0, 0); err != nil {
+		t.Fatalf("SetLedColor: %v", err)
+	}
+	if len(first.commands) != 0 {
+		t.Errorf("first controller got %+v, want nothing", first.commands)
+	}
+	if len(second.commands) != 1 || second.commands[0].LedID != 1 {
+		t.Errorf("second controller got %+v, want one command for its LED 1", second.commands)
+	}
+	if status, err := u.GetLedStatus(7); err != nil || status.ColorR != 255 {
+		t.Errorf("GetLedStatus(7) = %+v, %v; want red from the second controller", status, err)
+	}
+}
+
+func TestSegmentedTransportUnassigned(t *testing.T) {
+	st := &segmentedTransport{routes: []segmentRoute{{first: 2, last: 9, base: 2, transport: NewFakeTransport()}}}
+	if _, _, err := st.route(0); err == nil {
+		t.Error("route(0) succeeded without a segment")
+	}
+	if err := st.WriteCommand(1, LedCmdOnOff, []byte{1}); err == nil {
+		t.Error("WriteCommand(1) succeeded without a segment")
+	}
+}
+
+func TestValidateSegments(t *testing.T) {
+	valid := []LedSegment{
+		{Device: "/dev/i2c-1", First: 0, Last: 5},
+		{Device: "/dev/i2c-2", Address: 0x3b, First: 6, Last: 9},
+	}
+	if err := ValidateSegments(valid); err != nil {
+		t.Errorf("ValidateSegments(valid) = %v", err)
+	}
+
+	tests := []struct {
+		segments []LedSegment
+		want     string
+	}{
+		{[]LedSegment{{First: 0, Last: 9}}, "device unset"},
+		{[]LedSegment{{Device: "/dev/i2c-1", Address: 0x80, First: 0, Last: 9}}, "invalid I2C address"},
+		{[]LedSegment{{Device: "/dev/i2c-1", First: 5, Last: 2}}, "invalid LED range"},
+		{[]LedSegment{{Device: "/dev/i2c-1", First: 0, Last: 10}}, "invalid LED range"},
+		{[]LedSegment{{Device: "/dev/i2c-1", First: 6, Last: 9, Base: 7}}, "invalid base"},
+		{[]LedSegment{{Device: "/dev/i2c-1", First: 0, Last: 5}, {Device: "/dev/i2c-2", First: 5, Last: 9}}, "disk4 already assigned"},
+	}
+	for _, tt := range tests {
+		if err := ValidateSegments(tt.segments); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ValidateSegments(%+v) = %v, want containing %q", tt.segments, err, tt.want)
+		}
+	}
+}
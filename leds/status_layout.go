@@ -0,0 +1,68 @@
+package leds
+
+import "fmt"
+
+// Status block layouts, by firmware_status_layout name. Every layout starts
+// with the same 9 fields; they differ in what follows.
+const (
+	StatusLayoutStandard = "standard" // 11 bytes: fields, 16-bit sum
+	StatusLayoutShort    = "short"    // 10 bytes: fields, 8-bit sum
+	StatusLayoutPadded   = "padded"   // 12 bytes: fields, a reserved byte, 16-bit sum
+)
+
+// StatusLayout describes the status block a controller firmware returns
+type StatusLayout struct {
+	Len         int // block size, checksum included
+	ChecksumLen int // size of the trailing big-endian sum of the preceding bytes, 1 or 2
+}
+
+var statusLayouts = map[string]StatusLayout{
+	StatusLayoutStandard: {Len: 11, ChecksumLen: 2},
+	StatusLayoutShort:    {Len: 10, ChecksumLen: 1},
+	StatusLayoutPadded:   {Len: 12, ChecksumLen: 2},
+}
+
+// statusLayoutOrder is the order auto-detection tries the layouts in
+var statusLayoutOrder = []string{StatusLayoutStandard, StatusLayoutShort, StatusLayoutPadded}
+
+// statusLayoutSetter is implemented by transports that read status blocks
+// themselves
+type statusLayoutSetter interface {
+	setStatusLayout(layout StatusLayout)
+}
+
+// IsValidStatusLayout checks if name is a known status layout
+func IsValidStatusLayout(name string) bool {
+	_, ok := statusLayouts[name]
+	return ok
+}
+
+// SetStatusLayout selects the status block layout of the controller
+// firmware by name, for every controller behind u. Auto-detection doesn't
+// need it: it accepts a controller answering in any known layout.
+func (u *UGreenLeds) SetStatusLayout(name string) error {
+	layout, ok := statusLayouts[name]
+	if !ok {
+		return fmt.Errorf("unknown status layout %q", name)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.statusLayout = layout
+	if s, ok := u.transport.(statusLayoutSetter); ok {
+		s.setStatusLayout(layout)
+	}
+	return nil
+}
+
+// checksum returns the sum of the block's payload, truncated to the
+// checksum size, and the sum stored in the block
+func (l StatusLayout) checksum(data []byte) (sum, stored int) {
+	payload := l.Len - l.ChecksumLen
+	for _, v := range data[:payload] {
+		sum += int(v)
+	}
+	for _, v := range data[payload:l.Len] {
+		stored = stored<<8 | int(v)
+	}
+	return sum & (1<<(8*l.ChecksumLen) - 1), stored
+}
@@ -0,0 +1,61 @@
+package leds
+
+import "testing"
+
+func TestStatusLayouts(t *testing.T) {
+	want := LedStatus{Available: true, OpMode: "on", Brightness: 128, ColorR: 255, TOn: 500, TOff: 500}
+	// disk1 on at brightness 128, red, blinking 500ms on / 500ms off
+	fields := []byte{0x01, 0x80, 0xff, 0x00, 0x00, 0x03, 0xe8, 0x01, 0xf4}
+
+	tests := []struct {
+		layout string
+		block  []byte
+	}{
+		{StatusLayoutStandard, append(append([]byte(nil), fields...), 0x03, 0x60)},
+		{StatusLayoutShort, append(append([]byte(nil), fields...), 0x60)},
+		{StatusLayoutPadded, append(append([]byte(nil), fields...), 0x07, 0x03, 0x67)},
+	}
+	for _, tt := range tests {
+		layout := statusLayouts[tt.layout]
+		if got := parseLedStatus(layout, tt.block); got != want {
+			t.Errorf("%s: parseLedStatus = %+v, want %+v", tt.layout, got, want)
+		}
+		// Blocks of the other layouts are rejected by length
+		for _, other := range tests {
+			if other.layout == tt.layout {
+				continue
+			}
+			if got := parseLedStatus(layout, other.block); got.Available {
+				t.Errorf("%s: parsed a %d-byte %s block as %+v", tt.layout, len(other.block), other.layout, got)
+			}
+		}
+		bad := append([]byte(nil), tt.block...)
+		bad[len(bad)-1]++
+		if got := parseLedStatus(layout, bad); got.Available {
+			t.Errorf("%s: parsed a block with a bad checksum as %+v", tt.layout, got)
+		}
+	}
+
+}
+
+func TestSetStatusLayout(t *testing.T) {
+	first, second := &i2cTransport{}, &i2cTransport{}
+	u := NewUGreenLedsWithTransport(&segmentedTransport{routes: []segmentRoute{
+		{first: 0, last: 1, transport: first},
+		{first: 2, last: 5, transport: second},
+	}})
+	if err := u.SetStatusLayout(StatusLayoutPadded); err != nil {
+		t.Fatalf("SetStatusLayout: %v", err)
+	}
+	padded := statusLayouts[StatusLayoutPadded]
+	if u.statusLayout != padded || first.layout != padded || second.layout != padded {
+		t.Errorf("layouts %+v, %+v, %+v after SetStatusLayout, want %+v", u.statusLayout, first.layout, second.layout, padded)
+	}
+
+	if err := u.SetStatusLayout("long"); err == nil {
+		t.Error("SetStatusLayout accepted an unknown layout")
+	}
+	if u.statusLayout != padded || first.layout != padded {
+		t.Error("unknown layout changed the selected layout")
+	}
+}
@@ -0,0 +1,212 @@
+package leds
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// Transport performs the raw command and status I/O with the LED controller
+type Transport interface {
+	WriteCommand(ledID int, command byte, params []byte) error
+	ReadStatus(ledID int) (LedStatus, error)
+	Close() error
+}
+
+// reopener is implemented by transports that can recover from a reset
+// controller by reopening their device
+type reopener interface {
+	Reopen() error
+	Close() error
+}
+
+// rawStatusReader is implemented by transports that can return an LED's
+// undecoded status block
+type rawStatusReader interface {
+	ReadRawStatus(ledID int) ([]byte, error)
+}
+
+// openChecker is implemented by transports that can lose their device, as
+// when a reopen fails
+type openChecker interface {
+	IsOpen() bool
+}
+
+// i2cTransport talks to the LED controller over an I2C device
+type i2cTransport struct {
+	fd      atomic.Int32 // 0 when closed; swapped by Reopen, see there
+	device  string
+	address int
+	layout  StatusLayout // guarded by the owning UGreenLeds' mu
+}
+
+// errI2CNotOpen is returned by I/O on an i2cTransport left closed, as by a
+// failed reopen, instead of sending the ioctl to whatever fd 0 is
+var errI2CNotOpen = errors.New("I2C device not open")
+
+// openFd returns the device's fd, or errI2CNotOpen when it is closed
+func (t *i2cTransport) openFd() (int, error) {
+	fd := t.fd.Load()
+	if fd <= 0 {
+		return 0, errI2CNotOpen
+	}
+	return int(fd), nil
+}
+
+func (t *i2cTransport) WriteCommand(ledID int, command byte, params []byte) error {
+	fd, err := t.openFd()
+	if err != nil {
+		return err
+	}
+	return writeLedCommand(fd, ledID, command, params)
+}
+
+func (t *i2cTransport) ReadStatus(ledID int) (LedStatus, error) {
+	fd, err := t.openFd()
+	if err != nil {
+		return LedStatus{}, err
+	}
+	return readLedStatus(fd, t.layout, ledID)
+}
+
+func (t *i2cTransport) ReadRawStatus(ledID int) ([]byte, error) {
+	fd, err := t.openFd()
+	if err != nil {
+		return nil, err
+	}
+	return readLedStatusRaw(fd, t.layout, ledID)
+}
+
+func (t *i2cTransport) setStatusLayout(layout StatusLayout) {
+	t.layout = layout
+}
+
+func (t *i2cTransport) IsOpen() bool {
+	return t.fd.Load() > 0
+}
+
+func (t *i2cTransport) Close() error {
+	fd := t.fd.Swap(0)
+	if fd <= 0 {
+		return nil
+	}
+	return syscall.Close(int(fd))
+}
+
+// Reopen opens the device again, re-setting the slave address, and swaps
+// the new fd in before closing the old one. It is safe during a transfer:
+// the transfer finishes or fails on the old file, and the next one uses the
+// new fd. A failed reopen leaves the transport closed.
+func (t *i2cTransport) Reopen() error {
+	fd, err := openI2CDevice(t.device)
+	if err != nil {
+		t.Close()
+		return i2cOpenError(t.device, err)
+	}
+	if err := ioctlSetSlave(fd, t.address); err != nil {
+		syscall.Close(fd)
+		t.Close()
+		return fmt.Errorf("failed to set I2C slave: %w", err)
+	}
+	if old := t.fd.Swap(int32(fd)); old > 0 {
+		syscall.Close(int(old))
+	}
+	return nil
+}
+
+// dryRunTransport logs every command and simulates the controller status,
+// so writes confirm without any I2C access
+type dryRunTransport struct {
+	mu     sync.Mutex
+	status map[int]LedStatus
+	quiet  bool // don't log commands
+}
+
+func newDryRunTransport() *dryRunTransport {
+	return &dryRunTransport{status: make(map[int]LedStatus)}
+}
+
+func (t *dryRunTransport) WriteCommand(ledID int, command byte, params []byte) error {
+	if !t.quiet {
+		log.Printf("dry-run: %s %s", LedNames[ledID], describeLedCommand(command, params))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.status[ledID]
+	if !ok {
+		status = LedStatus{Available: true, OpMode: "off"}
+	}
+	applyLedCommand(&status, command, params)
+	t.status[ledID] = status
+	return nil
+}
+
+func (t *dryRunTransport) ReadStatus(ledID int) (LedStatus, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.status[ledID]
+	if !ok {
+		return LedStatus{Available: true, OpMode: "off"}, nil
+	}
+	return status, nil
+}
+
+func (t *dryRunTransport) Close() error {
+	return nil
+}
+
+// describeLedCommand decodes a command and its params for logging
+func describeLedCommand(command byte, params []byte) string {
+	p := make([]byte, 4)
+	copy(p, params)
+	switch command {
+	case LedCmdBrightness:
+		return fmt.Sprintf("brightness=%d", p[0])
+	case LedCmdColor:
+		return fmt.Sprintf("color=(%d,%d,%d)", p[0], p[1], p[2])
+	case LedCmdOnOff:
+		if p[0] == 0 {
+			return "mode=off"
+		}
+		return "mode=on"
+	case LedCmdBlink, LedCmdBreath:
+		mode := "blink"
+		if command == LedCmdBreath {
+			mode = "breath"
+		}
+		high := binary.BigEndian.Uint16(p[0:2])
+		on := binary.BigEndian.Uint16(p[2:4])
+		return fmt.Sprintf("mode=%s on=%dms off=%dms", mode, on, high-on)
+	}
+	return fmt.Sprintf("command=0x%02x params=% x", command, params)
+}
+
+// applyLedCommand updates a status as the controller would after a command
+func applyLedCommand(status *LedStatus, command byte, params []byte) {
+	p := make([]byte, 4)
+	copy(p, params)
+	switch command {
+	case LedCmdBrightness:
+		status.Brightness = p[0]
+	case LedCmdColor:
+		status.ColorR, status.ColorG, status.ColorB = p[0], p[1], p[2]
+	case LedCmdOnOff:
+		status.OpMode = "off"
+		if p[0] != 0 {
+			status.OpMode = "on"
+		}
+	case LedCmdBlink, LedCmdBreath:
+		status.OpMode = "blink"
+		if command == LedCmdBreath {
+			status.OpMode = "breath"
+		}
+		high := binary.BigEndian.Uint16(p[0:2])
+		status.TOn = binary.BigEndian.Uint16(p[2:4])
+		status.TOff = high - status.TOn
+	}
+}
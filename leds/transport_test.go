@@ -0,0 +1,331 @@
+package leds
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDryRunTransport(t *testing.T) {
+	leds := NewUGreenLedsWithTransport(newDryRunTransport())
+	defer leds.Close()
+
+	if err := leds.SetLedColor(2, 255, 0, 0); err != nil {
+		t.Fatalf("SetLedColor: %v", err)
+	}
+	if err := leds.SetLedBrightness(2, 128); err != nil {
+		t.Fatalf("SetLedBrightness: %v", err)
+	}
+	if err := leds.SetLedMode(2, LedModeBlink, []byte{0x00, 0xc8, 0x00, 0x64}); err != nil {
+		t.Fatalf("SetLedMode: %v", err)
+	}
+
+	status, err := leds.GetLedStatus(2)
+	if err != nil {
+		t.Fatalf("GetLedStatus: %v", err)
+	}
+	want := LedStatus{Available: true, OpMode: "blink", Brightness: 128, ColorR: 255, TOn: 100, TOff: 100}
+	if status != want {
+		t.Errorf("expected status %+v, got %+v", want, status)
+	}
+}
+
+func TestSetLedSkipsUnchangedWrites(t *testing.T) {
+	transport := NewFakeTransport()
+	leds := NewUGreenLedsWithTransport(transport)
+
+	leds.SetLedColor(3, 1, 2, 3)
+	leds.SetLedColor(3, 1, 2, 3)
+	leds.SetLedBrightness(3, 64)
+	leds.SetLedBrightness(3, 64)
+	if got := transport.Count(); got != 2 {
+		t.Errorf("expected 2 writes, got %d", got)
+	}
+}
+
+func TestDescribeLedCommand(t *testing.T) {
+	tests := []struct {
+		command byte
+		params  []byte
+		want    string
+	}{
+		{LedCmdBrightness, []byte{64}, "brightness=64"},
+		{LedCmdColor, []byte{255, 0, 16}, "color=(255,0,16)"},
+		{LedCmdOnOff, []byte{0}, "mode=off"},
+		{LedCmdOnOff, []byte{1}, "mode=on"},
+		{LedCmdBlink, []byte{0x00, 0xc8, 0x00, 0x64}, "mode=blink on=100ms off=100ms"},
+		{LedCmdBreath, BreathParams(2000), "mode=breath on=1000ms off=1000ms"},
+	}
+	for _, tt := range tests {
+		if got := describeLedCommand(tt.command, tt.params); got != tt.want {
+			t.Errorf("describeLedCommand(0x%02x, % x) = %q, want %q", tt.command, tt.params, got, tt.want)
+		}
+	}
+}
+
+// resettingTransport fails every write until it is reopened, like an I2C
+// fd after the controller resets
+type resettingTransport struct {
+	*FakeTransport
+	broken  bool
+	reopens int
+}
+
+func (t *resettingTransport) WriteCommand(ledID int, command byte, params []byte) error {
+	if t.broken {
+		return errors.New("ioctl error: remote I/O error")
+	}
+	return t.FakeTransport.WriteCommand(ledID, command, params)
+}
+
+func (t *resettingTransport) Reopen() error {
+	t.reopens++
+	t.broken = false
+	return nil
+}
+
+func TestReopenAfterRepeatedFailures(t *testing.T) {
+	transport := &resettingTransport{FakeTransport: NewFakeTransport()}
+	leds := NewUGreenLedsWithTransport(transport)
+	leds.SetTiming(LedTiming{MaxRetry: 2})
+
+	if err := leds.SetLedColor(2, 255, 0, 0); err != nil {
+		t.Fatalf("SetLedColor: %v", err)
+	}
+
+	transport.broken = true
+	for i := range reopenAfterFailures {
+		if err := leds.SetLedBrightness(2, byte(10+i)); err == nil {
+			t.Fatalf("write %d: expected an error while broken", i)
+		}
+		if i < reopenAfterFailures-1 && transport.reopens != 0 {
+			t.Fatalf("write %d: reopened early", i)
+		}
+	}
+	if transport.reopens != 1 {
+		t.Fatalf("expected one reopen after %d failures, got %d", reopenAfterFailures, transport.reopens)
+	}
+
+	// The cached state was forgotten, so an unchanged color is rewritten
+	before := transport.Count()
+	if err := leds.SetLedColor(2, 255, 0, 0); err != nil {
+		t.Fatalf("SetLedColor after reopen: %v", err)
+	}
+	if transport.Count() != before+1 {
+		t.Errorf("expected the color to be rewritten after reopening")
+	}
+}
+
+// unconfirmedTransport accepts writes but never confirms them
+type unconfirmedTransport struct {
+	*resettingTransport
+}
+
+func (t *unconfirmedTransport) ReadStatus(ledID int) (LedStatus, error) {
+	return LedStatus{}, nil
+}
+
+func TestNoReopenAfterConfirmFailures(t *testing.T) {
+	transport := &unconfirmedTransport{&resettingTransport{FakeTransport: NewFakeTransport()}}
+	leds := NewUGreenLedsWithTransport(transport)
+	leds.SetTiming(LedTiming{MaxRetry: 2})
+
+	for i := range reopenAfterFailures + 1 {
+		leds.SetLedBrightness(2, byte(10+i))
+	}
+	if transport.reopens != 0 {
+		t.Errorf("expected no reopen when writes succeed but don't confirm, got %d", transport.reopens)
+	}
+}
+
+func TestStatusCache(t *testing.T) {
+	transport := NewFakeTransport()
+	leds := NewUGreenLedsWithTransport(transport)
+
+	if _, ok := leds.Status(2); ok {
+		t.Errorf("expected no cached status before any write")
+	}
+	if err := leds.SetLedColor(2, 255, 0, 0); err != nil {
+		t.Fatalf("SetLedColor: %v", err)
+	}
+
+	reads := transport.reads
+	status, ok := leds.Status(2)
+	if !ok || !status.Available || status.ColorR != 255 {
+		t.Errorf("Status(2) = %+v, %v; want the red status read after the write", status, ok)
+	}
+	all := leds.AllStatus()
+	if len(all) != 1 || all[2] != status {
+		t.Errorf("AllStatus() = %+v, want only LED 2", all)
+	}
+	if transport.reads != reads {
+		t.Errorf("expected Status and AllStatus not to read the controller, got %d reads", transport.reads-reads)
+	}
+
+	// A change behind the cache's back shows only after a refresh
+	transport.status[2] = LedStatus{Available: true, OpMode: "on", ColorB: 255}
+	if status, _ := leds.Status(2); status.ColorB != 0 {
+		t.Errorf("expected the cached status before a refresh, got %+v", status)
+	}
+	refreshed, err := leds.RefreshStatus(2)
+	if err != nil || refreshed.ColorB != 255 {
+		t.Fatalf("RefreshStatus(2) = %+v, %v; want blue", refreshed, err)
+	}
+	if status, _ := leds.Status(2); status != refreshed {
+		t.Errorf("Status(2) = %+v after refresh, want %+v", status, refreshed)
+	}
+
+	// The snapshot is a copy
+	all[3] = LedStatus{}
+	if _, ok := leds.Status(3); ok {
+		t.Errorf("expected AllStatus to return a copy")
+	}
+	if _, err := leds.RefreshStatus(42); err == nil {
+		t.Errorf("expected an error for an invalid LED index")
+	}
+}
+
+// slowTransport takes delay for every command write
+type slowTransport struct {
+	*FakeTransport
+	delay time.Duration
+}
+
+func (t *slowTransport) WriteCommand(ledID int, command byte, params []byte) error {
+	time.Sleep(t.delay)
+	return t.FakeTransport.WriteCommand(ledID, command, params)
+}
+
+func TestWriteLatency(t *testing.T) {
+	transport := &slowTransport{FakeTransport: NewFakeTransport(), delay: 3 * time.Millisecond}
+	leds := NewUGreenLedsWithTransport(transport)
+	leds.SetTiming(LedTiming{MaxRetry: 2})
+
+	for i := range 2 {
+		if err := leds.SetLedBrightness(2, byte(10+i)); err != nil {
+			t.Fatalf("SetLedBrightness: %v", err)
+		}
+	}
+	latency := leds.WriteLatency()
+	if latency.Count != 2 || latency.Sum < 2*transport.delay {
+		t.Errorf("expected 2 writes taking at least %s, got %+v", 2*transport.delay, latency)
+	}
+	// No write fits the 0.5ms, 1ms, or 2.5ms buckets
+	if latency.Counts[2] != 0 || latency.Counts[len(latency.Counts)-1] != 2 {
+		t.Errorf("unexpected bucket counts %v", latency.Counts)
+	}
+	if avg := leds.WriteStats().AvgLatencyMs; avg < 3 {
+		t.Errorf("expected an average of at least 3ms, got %v", avg)
+	}
+
+	// Batched writes count until their batch is confirmed
+	leds.SetTiming(LedTiming{MaxRetry: 2, BatchConfirm: true, QueryDelay: 5 * time.Millisecond})
+	leds.BeginBatch()
+	leds.SetLedBrightness(3, 50)
+	leds.EndBatch()
+	latency = leds.WriteLatency()
+	if latency.Count != 3 || latency.Counts[3] != 2 {
+		t.Errorf("expected the batched write to take over 5ms, got %+v", latency)
+	}
+}
+
+func TestTryReopen(t *testing.T) {
+	transport := &resettingTransport{FakeTransport: NewFakeTransport()}
+	leds := NewUGreenLedsWithTransport(transport)
+
+	if err := leds.SetLedColor(2, 255, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	leds.mu.Lock() // a write blocked in the driver
+	err := leds.TryReopen("test")
+	leds.mu.Unlock()
+	if err != nil || transport.reopens != 1 {
+		t.Fatalf("TryReopen = %v with %d reopens, want one reopen while busy", err, transport.reopens)
+	}
+
+	// The next batch rewrites the unchanged color
+	before := transport.Count()
+	leds.BeginBatch()
+	leds.SetLedColor(2, 255, 0, 0)
+	leds.EndBatch()
+	if transport.Count() == before {
+		t.Error("expected the LED rewritten after the reopen")
+	}
+
+	leds.Close()
+	if err := leds.TryReopen("test"); err == nil || transport.reopens != 1 {
+		t.Errorf("TryReopen = %v with %d reopens after Close, want an error", err, transport.reopens)
+	}
+}
+
+func TestIsOpen(t *testing.T) {
+	i2c := &i2cTransport{}
+	i2c.fd.Store(7)
+	u := NewUGreenLedsWithTransport(i2c)
+	if !u.IsOpen() {
+		t.Error("open I2C controller reported closed")
+	}
+	i2c.fd.Store(0) // as after a failed reopen
+	if u.IsOpen() {
+		t.Error("I2C controller without an fd reported open")
+	}
+
+	u = NewUGreenLedsWithTransport(NewFakeTransport())
+	if !u.IsOpen() {
+		t.Error("transport without an fd reported closed")
+	}
+	u.Close()
+	if u.IsOpen() {
+		t.Error("closed controller reported open")
+	}
+}
+
+func TestI2CTransportNotOpen(t *testing.T) {
+	orig := openI2CDevice
+	defer func() { openI2CDevice = orig }()
+	openI2CDevice = func(string) (int, error) { return -1, syscall.ENOENT }
+
+	i2c := &i2cTransport{device: "/dev/i2c-9", layout: statusLayouts[StatusLayoutStandard]}
+	if err := i2c.Reopen(); err == nil {
+		t.Fatal("Reopen succeeded without a device")
+	}
+	// The failed reopen leaves no fd, and I/O fails instead of going to fd 0
+	if err := i2c.WriteCommand(2, LedCmdBrightness, []byte{10}); !errors.Is(err, errI2CNotOpen) {
+		t.Errorf("WriteCommand = %v, want %v", err, errI2CNotOpen)
+	}
+	if _, err := i2c.ReadStatus(2); !errors.Is(err, errI2CNotOpen) {
+		t.Errorf("ReadStatus = %v, want %v", err, errI2CNotOpen)
+	}
+	if _, err := i2c.ReadRawStatus(2); !errors.Is(err, errI2CNotOpen) {
+		t.Errorf("ReadRawStatus = %v, want %v", err, errI2CNotOpen)
+	}
+}
+
+// closingTransport closes its controller in the middle of a reopen
+type closingTransport struct {
+	*FakeTransport
+	u      *UGreenLeds
+	closes int
+}
+
+func (t *closingTransport) Reopen() error {
+	t.u.Close()
+	return nil
+}
+
+func (t *closingTransport) Close() error {
+	t.closes++
+	return nil
+}
+
+func TestTryReopenDuringClose(t *testing.T) {
+	transport := &closingTransport{FakeTransport: NewFakeTransport()}
+	transport.u = NewUGreenLedsWithTransport(transport)
+	// Close's own close can come before the new device is swapped in, so
+	// TryReopen closes it again
+	if err := transport.u.TryReopen("test"); !errors.Is(err, ErrClosed) || transport.closes != 2 {
+		t.Errorf("TryReopen = %v with %d closes, want ErrClosed after closing the new device", err, transport.closes)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// Log formats for --log-format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// setupLogging sends log and slog output to w in format. JSON output has one
+// object per line with level, msg, and any structured fields; messages
+// from log.Printf arrive as level INFO with the formatted text as msg.
+func setupLogging(format string, w io.Writer) error {
+	switch format {
+	case LogFormatText:
+		log.SetOutput(w)
+	case LogFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{AddSource: true})))
+	default:
+		return fmt.Errorf("unknown log format %q (valid: %s, %s)", format, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
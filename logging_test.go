@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestJSONLogging(t *testing.T) {
+	prev, prevFlags := slog.Default(), log.Flags()
+	defer func() {
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(prevFlags)
+	}()
+
+	var buf bytes.Buffer
+	if err := setupLogging(LogFormatJSON, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	am := &ActivityMonitor{ledErrors: newErrorLimiter(ledErrorLogInterval)}
+	am.logLedError(3, errors.New("bus error"))
+	log.Printf("plain message")
+
+	var entries []map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 log lines, got %d: %s", len(entries), buf.String())
+	}
+
+	ledErr := entries[0]
+	for key, want := range map[string]any{
+		"level":  "ERROR",
+		"msg":    "error updating LED",
+		"led_id": 3.0,
+		"led":    "disk2",
+		"error":  "bus error",
+	} {
+		if ledErr[key] != want {
+			t.Errorf("%s = %v, want %v", key, ledErr[key], want)
+		}
+	}
+	if entries[1]["level"] != "INFO" || entries[1]["msg"] != "plain message" {
+		t.Errorf("expected log.Printf as an INFO entry, got %v", entries[1])
+	}
+}
+
+func TestSetupLoggingUnknownFormat(t *testing.T) {
+	if err := setupLogging("xml", &bytes.Buffer{}); err == nil {
+		t.Error("expected error for unknown log format")
+	}
+}
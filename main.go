@@ -1,30 +1,79 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"math"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/devilmonastery/configloader"
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
 )
 
 var (
-	confFile = flag.String("config", "config.yaml", "path to the config file")
-	device   = flag.String("device", "", "I2C device path override")
+	confFile  = flag.String("config", "config.yaml", "path to the config file")
+	device    = flag.String("device", "", "I2C device path override")
+	dryRun    = flag.Bool("dry-run", false, "log LED commands instead of writing to I2C")
+	noLeds    = flag.Bool("no-leds", false, "run discovery and monitoring without touching the LEDs")
+	setLed    = flag.String("set", "", "set one LED and exit, e.g. disk1:on:255,0,0:brightness=128")
+	logFormat = flag.String("log-format", LogFormatText, "log output format: text or json")
+	selfTest  = flag.Bool("selftest", false, "cycle every LED through red, green, and blue before monitoring")
+	showVer   = flag.Bool("version", false, "print the version and exit")
+	dumpStat  = flag.Bool("dump-status", false, "print the raw and decoded status of every LED and exit, without changing any LED")
+	demo      = flag.Bool("demo", false, "sweep a dot across the disk LEDs instead of showing activity, until interrupted")
+	demoStep  = flag.Duration("demo-step", defaultDemoStep, "time the --demo dot spends on each disk LED")
+	listDisks = flag.Bool("list-disks", false, "print the discovered disks as JSON and exit, without touching the LEDs")
 )
 
 // ActivityMonitor encapsulates disk and network activity monitoring and LED control
 type ActivityMonitor struct {
-	disks          []DiskInfo
-	leds           *UGreenLeds
-	maxActivity    uint64
-	maxLanActivity uint64
-	configLoader   *configloader.ConfigLoader[Config]
+	disks           []DiskInfo // written by the monitor loop under disksMu, see diskList
+	disksMu         sync.RWMutex
+	rediscovered    chan []DiskInfo // changed disk sets from rediscoverLoop
+	layout          LedLayout
+	diskWarnings    []DiscoveryWarning
+	leds            *leds.UGreenLeds // set to nil by Close under ledsMu, see controller
+	ledsMu          sync.RWMutex
+	maxActivity     uint64
+	maxLanActivity  uint64
+	maxPoolActivity uint64    // see updatePoolLed
+	lastActivity    time.Time // last tick with disk or network activity, see noteActivity
+	ambient         bool      // dimmed for inactivity, see updateBrightnessCap
+	metricPeaks     map[string]float64
+	ledErrors       *errorLimiter
+	netErrors       *errorLimiter // malformed /proc/net/dev warnings
+	events          *eventPublisher
+	hub             *statusHub
+	health          *diskHealthMap
+	seen            *diskSeenTracker
+	history         *diskHistory
+	warnings        warningList     // non-fatal startup problems, see warn
+	overrides       overrideMap     // LEDs held by POST /override
+	ledFailures     ledFailures     // LEDs disabled by repeated write failures
+	watchdog        watchdog        // petted each tick, see watchdogLoop
+	summary         activitySummary // activity totals logged by summaryLoop
+	scrubbing       atomic.Bool     // a ZFS scrub is running, see scrubLoop
+	brightnessCap   atomic.Uint32   // 0 for none, see capBrightness
+	standby         *diskStandbyMap
+	link            *linkState
+	netTotals       networkReader // nil reads /proc/net/dev
+	lastWrites      uint64        // LED writes at the last status broadcast
+	fades           []colorFade
+	noLedWarned     map[string]bool    // disks warned about having no LED in the layout
+	hashedColors    map[string][3]byte // by disk name, see hashedColor
+	updateOffset    int                // first disk updated next tick, see diskUpdateOrder
+	configLoader    *configloader.ConfigLoader[Config]
 }
 
 func NewActivityMonitor(configPath string) (*ActivityMonitor, error) {
@@ -33,58 +82,130 @@ func NewActivityMonitor(configPath string) (*ActivityMonitor, error) {
 	if err != nil {
 		log.Fatalf("error reading config at %q: %v", *confFile, err)
 	}
+	if configLoader.Config() == nil {
+		return nil, fmt.Errorf("no valid config at %q", configPath)
+	}
 
-	disks, err := discoverDisks()
+	if delay := configLoader.Config().StartupDelay; delay > 0 {
+		log.Printf("Waiting %s before disk discovery", delay)
+		time.Sleep(delay)
+	}
+	disks, diskWarnings, err := settleDisks(discoverDisks, diskSettleInterval, configLoader.Config().DiskSettleTimeout, time.Sleep)
 	if err != nil {
 		return nil, fmt.Errorf("error discovering disks: %v", err)
 	}
+	if len(diskWarnings) > 0 {
+		log.Printf("Disk discovery: %s", discoverySummary(disks, diskWarnings))
+	}
+	disks, excluded := excludeDisks(disks, configLoader.Config().ExcludeDisks)
+	for _, disk := range excluded {
+		log.Printf("Excluding disk %s (%s) from the LEDs", disk.Name, disk.Serial)
+	}
+	var missingOrder []string
+	if order := configLoader.Config().DiskOrder; len(order) > 0 {
+		disks, missingOrder = orderDisks(disks, order)
+	}
 
-	leds, err := NewConfiguredUGreenLeds(configPath, *device)
+	controller, err := NewConfiguredUGreenLeds(configPath, *device, *dryRun, *noLeds)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize LEDs: %v", err)
 	}
 
-	return &ActivityMonitor{
+	am := &ActivityMonitor{
 		configLoader: configLoader,
 		disks:        disks,
-		leds:         leds,
-	}, nil
+		layout:       resolveLedLayout(configLoader.Config().Model, len(disks)),
+		diskWarnings: diskWarnings,
+		leds:         controller,
+		metricPeaks:  make(map[string]float64),
+		ledErrors:    newErrorLimiter(ledErrorLogInterval),
+		netErrors:    newErrorLimiter(ledErrorLogInterval),
+		hub:          newStatusHub(),
+		health:       newDiskHealthMap(),
+		standby:      newDiskStandbyMap(),
+		rediscovered: make(chan []DiskInfo),
+		seen:         newDiskSeenTracker(),
+		history:      newDiskHistory(),
+		link:         newLinkState(),
+	}
+	am.recordInitWarnings(diskWarnings, missingOrder)
+	if path := *configLoader.Config().StateFile; path != "" {
+		if err := am.restoreState(path); err == nil {
+			log.Printf("Restored brightness scaling from %s", path)
+		} else if !os.IsNotExist(err) {
+			log.Printf("Ignoring state file: %v", err)
+		}
+	}
+	return am, nil
 }
 
-func NewConfiguredUGreenLeds(configPath string, deviceOverride string) (*UGreenLeds, error) {
+func NewConfiguredUGreenLeds(configPath string, deviceOverride string, dryRun, noLeds bool) (*leds.UGreenLeds, error) {
 	configLoader, err := NewConfigLoader(configPath)
 	if err != nil {
 		return nil, err
 	}
 
 	conf := configLoader.Config()
-	if deviceOverride != "" {
-		conf.Device = deviceOverride
+	if conf == nil {
+		return nil, fmt.Errorf("no valid config at %q", configPath)
 	}
-	return NewUGreenLeds(conf.Device)
+
+	var controller *leds.UGreenLeds
+	switch {
+	case noLeds:
+		controller = leds.NewNoLedsUGreenLeds()
+	case dryRun:
+		controller = leds.NewDryRunUGreenLeds()
+	default:
+		if deviceOverride != "" {
+			conf.Device = deviceOverride
+			conf.ledSegments = nil
+		}
+		if len(conf.ledSegments) > 0 {
+			controller, err = leds.NewSegmentedUGreenLeds(conf.ledSegments)
+		} else {
+			controller, err = leds.NewUGreenLeds(conf.Device)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := controller.SetStatusLayout(conf.FirmwareStatusLayout); err != nil {
+		controller.Close()
+		return nil, err
+	}
+	controller.SetColorCorrection(conf.ColorCorrection)
+	controller.SetTiming(conf.I2CTiming)
+	controller.SetMinWriteInterval(conf.MinWriteInterval())
+	return controller, nil
 }
 
+// Close applies the shutdown state, for exits that skip MonitorCtx such as
+// --demo, and releases the event publisher and LED controller
 func (am *ActivityMonitor) Close() {
+	if am.events != nil {
+		am.events.Close()
+		am.events = nil
+	}
 	if am.leds != nil {
+		if am.configLoader != nil {
+			if conf := am.configLoader.Config(); conf != nil {
+				am.applyShutdownState(conf)
+			}
+		}
 		am.leds.Close()
+		am.ledsMu.Lock()
 		am.leds = nil
+		am.ledsMu.Unlock()
 	}
 }
 
-func (am *ActivityMonitor) brightnessForActivity(activity uint64, maxActivity uint64) byte {
-	if maxActivity < activity {
-		maxActivity = activity
-	}
-
-	if activity == 0 {
-		return 0 // No activity, no brightness
-	}
-
-	val := 127 + int(float64(activity)/float64(maxActivity)*128)
-	if val > 255 {
-		val = 255
-	}
-	return byte(val)
+// controller returns the LED controller, or nil once closed, for the HTTP
+// handlers that run concurrently with Close
+func (am *ActivityMonitor) controller() *leds.UGreenLeds {
+	am.ledsMu.RLock()
+	defer am.ledsMu.RUnlock()
+	return am.leds
 }
 
 // rainbowColor returns an RGB color for a given LED index and total number of LEDs, cycling the rainbow right-to-left over time.
@@ -129,157 +250,501 @@ func hsvToRgb(h, s, v float64) (r, g, b byte) {
 	return byte(rr * 255), byte(gg * 255), byte(bb * 255)
 }
 
+// Monitor drives the disk and LAN LEDs until the process exits
 func (am *ActivityMonitor) Monitor() {
+	am.MonitorCtx(context.Background())
+}
+
+// MonitorCtx drives the disk and LAN LEDs until ctx is cancelled, then turns
+// them off, saves the brightness scaling, and returns
+func (am *ActivityMonitor) MonitorCtx(ctx context.Context) {
 	conf := am.configLoader.Config()
 	subscriber := am.configLoader.Subscribe()
 
-	ticker := time.NewTicker(conf.PollInterval * time.Millisecond)
+	ticker := time.NewTicker(conf.PollInterval)
 	defer ticker.Stop()
-
-	devices := []string{}
-	for _, disk := range am.disks {
-		devices = append(devices, disk.Name)
+	saveTicker := time.NewTicker(conf.StateSaveInterval)
+	defer saveTicker.Stop()
+
+	// Bays without a disk, or whose disk is excluded, have nothing driving
+	// their LEDs, so don't leave them showing a previous run's activity
+	driven := am.diskLeds(conf)
+	for i := range am.layout.DiskBays {
+		if !driven[firstDiskLedIndex+i] {
+			am.setLedMode(firstDiskLedIndex+i, leds.LedModeOff, nil)
+		}
 	}
-
-	prevStats, _ := getDiskActivity(devices)
-	lastRxTotal, lastTxTotal, err := am.getNetworkActivityAll()
-	if err != nil {
-		log.Printf("Error reading network activity: %v", err)
+	diskStats := am.newActivityReader(conf, am.disks)
+	prevStats, _ := diskStats.Read()
+	// Reused every tick, nothing keeps them past it
+	deltas := make(map[string]DiskActivity)
+	metrics := make(map[string]DiskMetrics)
+	am.watchdog.pet(time.Now())
+	prevTime := time.Now()
+	am.noteActivity(prevTime, false)
+	am.updateBrightnessCap(conf, prevTime)
+	var lastRxTotal, lastTxTotal uint64
+	var lastSkipped string // interfaces left out of the last totals
+	if *conf.EnableLanLed {
+		lastRxTotal, lastTxTotal, lastSkipped = am.readNetworkBaseline(conf)
+	} else {
+		am.setLedMode(lanLedIndex, leds.LedModeOff, nil)
 	}
 
 	for {
 		select {
+		case <-ctx.Done():
+			am.applyShutdownState(conf)
+			if path := *conf.StateFile; path != "" {
+				if err := am.persistState(path); err != nil {
+					log.Printf("Error saving state: %v", err)
+				}
+			}
+			return
 		case newconf := <-subscriber:
+			if lanWasEnabled := *conf.EnableLanLed; lanWasEnabled != *newconf.EnableLanLed {
+				if lanWasEnabled {
+					am.setLedMode(lanLedIndex, leds.LedModeOff, nil)
+				} else {
+					lastRxTotal, lastTxTotal, lastSkipped = am.readNetworkBaseline(&newconf)
+				}
+			}
 			conf = &newconf
-			log.Printf("new config, %#v", conf)
-			log.Printf("PollInterval %dms, RainbowCycleTime %s", conf.PollInterval.Milliseconds(), conf.RainbowCycleTime)
+			slog.Info("config reloaded", "poll_interval", conf.PollInterval, "rainbow_cycle_time", conf.RainbowCycleTime, "color_mode", conf.ColorMode, "idle_mode", conf.IdleMode)
 			ticker.Reset(conf.PollInterval)
+			am.leds.SetColorCorrection(conf.ColorCorrection)
+			am.leds.SetTiming(conf.I2CTiming)
+			am.leds.SetMinWriteInterval(conf.MinWriteInterval())
+			if err := am.leds.SetStatusLayout(conf.FirmwareStatusLayout); err != nil {
+				log.Printf("Error setting firmware_status_layout: %v", err)
+			}
+			saveTicker.Reset(conf.StateSaveInterval)
+			am.layout = resolveLedLayout(conf.Model, len(am.disks))
+			clear(am.noLedWarned)
+		case disks := <-am.rediscovered:
+			am.setDisks(conf, disks)
+			diskStats = am.newActivityReader(conf, disks)
+			prevStats, _ = diskStats.Read()
+		case <-saveTicker.C:
+			if path := *conf.StateFile; path != "" {
+				if err := am.persistState(path); err != nil {
+					log.Printf("Error saving state: %v", err)
+				}
+			}
 		case <-ticker.C:
+			am.watchdog.pet(time.Now())
+			am.leds.BeginBatch()
 			rainbowTime := conf.RainbowCycleTime.Seconds()
 			if rainbowTime <= 0 {
 				rainbowTime = 4 // default to 4 seconds if not set
 			}
 
 			// Set Disk activity lights
-			currStats, _ := getDiskActivity(devices)
-			deltas := make(map[string]DiskActivity)
-			for dev, curr := range currStats {
-				prev := prevStats[dev]
-				reads := curr.Reads - prev.Reads
-				writes := curr.Writes - prev.Writes
-				activity := reads + writes
-				if activity > am.maxActivity {
-					am.maxActivity = activity
-				}
-				deltas[dev] = DiskActivity{Reads: reads, Writes: writes, Activity: activity}
-				// log.Printf("deltas for %s: activity:%d max:%d, bright:%d", dev, activity, am.maxActivity, am.brightnessForActivity(activity, am.maxActivity))
+			currStats, _ := diskStats.Read()
+			am.recordSeen(currStats)
+			now := time.Now()
+			interval := now.Sub(prevTime)
+			prevTime = now
+			diskDeltasInto(deltas, prevStats, currStats)
+			applyActivityCount(deltas, conf.Count)
+			if conf.CountDiscards {
+				applyDiscards(deltas)
 			}
-			for i, disk := range am.disks {
-				// Control LEDs for available disks (disk1-disk8 are indices 2-9)
-				ledIndex := i + 2
-				if !IsValidLedIndex(ledIndex) {
-					// Skip disks that don't have corresponding LEDs
-					log.Printf("Warning: Disk %d (%s) has no corresponding LED (only %d disk LEDs available)", i+1, disk.Name, GetMaxLedIndex()-1)
-					continue
+			applyActivityFloor(deltas, conf.ActivityFloor)
+			applyActivityWeights(deltas, *conf.ReadWeight, *conf.WriteWeight)
+			clear(metrics)
+			formula := conf.activityFormula()
+			decayMetricPeaks(am.metricPeaks, conf.ActivityDecay)
+			var tickMax uint64
+			for dev, delta := range deltas {
+				activity := delta.Activity
+				if activity > tickMax {
+					tickMax = activity
 				}
-
-				am.leds.SetLedMode(ledIndex, LedModeOn, nil)
-				dev := disk.Name
-				delta := deltas[dev]
-				if delta.Activity == 0 {
-					if !*conf.EnableRainbow {
-						am.leds.SetLedMode(ledIndex, LedModeOff, nil)
-					} else {
-						// Use rainbow color for inactive disks
-						r, g, b := am.rainbowColor(i+1, 1+len(am.disks), rainbowTime)
-						am.leds.SetLedColor(ledIndex, r, g, b)
-						am.leds.SetLedBrightness(ledIndex, *conf.RainbowBrightness)
+				prev, ok := prevStats[dev]
+				if ok && (len(formula) > 0 || conf.GreenMetric != "") {
+					metrics[dev] = diskMetrics(countedActivity(prev, conf.Count), countedActivity(currStats[dev], conf.Count), interval)
+					updatePeaks(am.metricPeaks, metrics[dev])
+				}
+				slog.Debug("disk activity", "disk", dev, "activity", activity, "reads", delta.Reads, "writes", delta.Writes)
+			}
+			am.maxActivity = decayPeak(am.maxActivity, tickMax, conf.ActivityDecay)
+			am.noteActivity(now, tickMax > 0)
+
+			// Read the network before writing any LED, so that network
+			// activity ending ambient dimming lifts the cap for every LED
+			var rxDelta, txDelta uint64
+			var netErr error
+			if *conf.EnableLanLed {
+				var rxTotal, txTotal uint64
+				var skipped string
+				rxTotal, txTotal, skipped, netErr = am.readNetworkTotals(conf)
+				if netErr == nil {
+					if skipped != lastSkipped {
+						// The totals sum different interfaces, so only the next
+						// tick's deltas mean anything
+						lastRxTotal, lastTxTotal, lastSkipped = rxTotal, txTotal, skipped
 					}
-				} else {
-					am.leds.SetLedMode(ledIndex, LedModeOn, nil)
-					am.leds.SetLedColor(ledIndex, 255, 255, 255)
-					brightness := am.brightnessForActivity(delta.Activity, am.maxActivity)
-					am.leds.SetLedBrightness(ledIndex, brightness)
+					rxDelta = counterDelta(lastRxTotal, rxTotal)
+					lastRxTotal = rxTotal
+					txDelta = counterDelta(lastTxTotal, txTotal)
+					lastTxTotal = txTotal
+					am.noteActivity(now, rxDelta+txDelta > 0)
 				}
 			}
+			am.updateBrightnessCap(conf, now)
+			am.applyOverrides(now)
+			am.updateDiskLeds(conf, now, interval, deltas, metrics, rainbowTime)
+			am.history.record(now, am.diskList(), deltas, conf.HistoryLen)
+			if conf.PowerLedMode == PowerLedModePoolActivity {
+				am.updatePoolLed(conf, now, deltas)
+			}
 			prevStats = currStats
+			event := am.activityEvent(now, deltas)
 
-			// Set Network activity lights
-			rxTotal, txTotal, err := am.getNetworkActivityAll()
-			if err != nil {
-				log.Printf("Error reading network activity: %v", err)
+			if !*conf.EnableLanLed {
+				if conf.LanLedSource == LanLedSourceDiskTotal {
+					am.updateLanDiskLed(conf, now, deltas, rainbowTime)
+				}
+				am.tickDone(conf, event)
 				continue
 			}
-			rxDelta := rxTotal - lastRxTotal
-			lastRxTotal = rxTotal
-			txDelta := txTotal - lastTxTotal
-			lastTxTotal = txTotal
 
-			total := rxDelta + txDelta
-			if total > am.maxLanActivity {
-				am.maxLanActivity = total
+			// Set Network activity lights
+			if netErr != nil {
+				slog.Error("error reading network activity", "error", netErr)
+				am.tickDone(conf, event)
+				continue
 			}
+			event.Network = &NetworkEvent{RxBytes: rxDelta, TxBytes: txDelta}
 
-			lanLedID := 1 // "lan" is index 1 in ledNames
-			//log.Printf("deltas for net: activity:%d max:%d, bright:%d", total, am.maxLanActivity, brightness)
-
-			if total == 0 {
-				if !*conf.EnableRainbow {
-					am.leds.SetLedMode(lanLedID, LedModeOff, nil)
-				} else {
-					r, g, b := am.rainbowColor(0, 1+len(am.disks), rainbowTime)
-					am.leds.SetLedColor(lanLedID, r, g, b)
-					am.leds.SetLedBrightness(lanLedID, *conf.RainbowBrightness)
-				}
-			} else {
+			total := rxDelta + txDelta
+			am.maxLanActivity = decayPeak(am.maxLanActivity, total, conf.ActivityDecay)
+
+			lanLedID := lanLedIndex
+			slog.Debug("lan activity", "led_id", lanLedID, "activity", total, "rx", rxDelta, "tx", txDelta, "max_activity", am.maxLanActivity)
+
+			lanIdle := am.leds.DebounceIdle(lanLedID, total > 0, conf.IdleTicks, now, conf.MinOn())
+			linkUp := am.link.Up(now, conf.NetworkInterfaces, conf.NetworkExcludePrefixes)
+			switch lanDisplayFor(linkUp, total > 0, lanIdle) {
+			case lanDisplayHold:
+				// Keep blinking through short gaps
+			case lanDisplayDown:
+				am.setLedMode(lanLedID, leds.LedModeOff, nil)
+			case lanDisplayIdle:
+				am.showLanIdle(conf, rainbowTime)
+			case lanDisplayActive:
 				// am.leds.SetLedColor(lanLedID, r, g, b)
-				brightness := am.brightnessForActivity(total, am.maxLanActivity)
-				am.leds.SetLedColor(lanLedID, 255, 255, 255)
-				am.leds.SetLedBrightness(lanLedID, brightness)
-				// Blink: on blinkMs, off blinkMs
-				onMs := 100
-				offMs := 100
-				high := onMs + offMs
-				params := []byte{
-					byte(high >> 8), byte(high),
-					byte(onMs >> 8), byte(onMs),
+				brightness := scaleBrightness(total, am.maxLanActivity, conf.BrightnessCurve, conf.BrightnessGamma)
+				if conf.LanScale == LanScaleLink {
+					if speed := am.link.Speed(now, conf.NetworkInterfaces, conf.NetworkExcludePrefixes, conf.NetworkBondCount); speed > 0 {
+						level := linkLevel(rxDelta, txDelta, interval, speed)
+						brightness = brightnessForLevel(shapeLevel(conf.BrightnessCurve, level, conf.BrightnessGamma))
+					}
 				}
-				am.leds.SetLedMode(lanLedID, LedModeBlink, params)
+				am.setLedColor(lanLedID, 255, 255, 255)
+				am.setLedBrightness(lanLedID, brightness)
+				am.setLedMode(lanLedID, leds.LedModeBlink, leds.BlinkParams(conf.LanBlinkOnMs, conf.LanBlinkOffMs))
 			}
+			am.tickDone(conf, event)
 		}
 	}
 }
 
-func (am *ActivityMonitor) getNetworkActivityAll() (rxTotal, txTotal uint64, err error) {
-	data, err := os.ReadFile("/proc/net/dev")
+// networkTotals reads the summed network counters of the included interfaces
+func (am *ActivityMonitor) networkTotals(conf *Config) (rx, tx uint64, err error) {
+	if am.netTotals != nil {
+		return am.netTotals(conf.NetworkInterfaces, conf.NetworkExcludePrefixes, conf.NetworkBondCount)
+	}
+	return getNetworkTotals(am.link.Bonds(time.Now()), conf.NetworkInterfaces, conf.NetworkExcludePrefixes, conf.NetworkBondCount)
+}
+
+// readNetworkTotals reads the network totals as networkTotals does, and
+// logs malformed /proc/net/dev lines at most once per ledErrorLogInterval.
+// skipped lists the interfaces left out of the totals for them.
+func (am *ActivityMonitor) readNetworkTotals(conf *Config) (rx, tx uint64, skipped string, err error) {
+	rx, tx, err = am.networkTotals(conf)
+	var malformed *malformedNetDevError
+	if !errors.As(err, &malformed) {
+		return rx, tx, "", err
+	}
+	if ok, suppressed := am.netErrors.allow(0, time.Now()); ok {
+		slog.Warn("skipping malformed network counters", "error", err, "suppressed", suppressed)
+	}
+	return rx, tx, strings.Join(malformed.ifaces, ","), nil
+}
+
+// readNetworkBaseline reads the network counters that the first tick's
+// deltas are measured from
+func (am *ActivityMonitor) readNetworkBaseline(conf *Config) (rx, tx uint64, skipped string) {
+	rx, tx, skipped, err := am.readNetworkTotals(conf)
 	if err != nil {
-		return 0, 0, err
+		log.Printf("Error reading network activity: %v", err)
 	}
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if !strings.Contains(line, ":") {
-			continue
-		}
-		iface := strings.SplitN(line, ":", 2)[0]
-		if iface == "lo" || strings.HasPrefix(iface, "veth") || strings.HasPrefix(iface, "docker") {
-			continue // skip loopback and common virtuals
-		}
-		fields := strings.Fields(line[strings.Index(line, ":")+1:])
-		if len(fields) < 9 {
+	return rx, tx, skipped
+}
+
+// diskUpdateOrder returns the order of the disk indices to update this tick.
+// In round-robin order each tick starts one disk later than the last, so no
+// LED is always the last written over slow I2C.
+func (am *ActivityMonitor) diskUpdateOrder(conf *Config) []int {
+	order := make([]int, len(am.disks))
+	start := 0
+	if conf.LedUpdateOrder == LedUpdateOrderRoundRobin && len(am.disks) > 0 {
+		start = am.updateOffset % len(am.disks)
+		am.updateOffset = start + 1
+	}
+	for i := range order {
+		order[i] = (start + i) % len(order)
+	}
+	return order
+}
+
+// updateDiskLeds drives the LED of every disk for the tick at now. Disks
+// beyond the LED layout, such as a fifth disk on a 4-bay model, are skipped
+// without writing to LEDs the model doesn't have, and warned about once.
+func (am *ActivityMonitor) updateDiskLeds(conf *Config, now time.Time, interval time.Duration, deltas map[string]DiskActivity, metrics map[string]DiskMetrics, rainbowTime float64) {
+	for _, i := range am.diskUpdateOrder(conf) {
+		disk := am.disks[i]
+		ledIndex, ok := diskLedIndex(i, disk, conf.DiskLedMap, am.layout, conf.ReverseLeds)
+		if !ok {
+			if !am.noLedWarned[disk.Name] {
+				slog.Warn("disk has no corresponding LED", "disk", disk.Name, "disk_number", i+1, "disk_leds", am.layout.DiskBays, "model", am.layout.Model)
+				if am.noLedWarned == nil {
+					am.noLedWarned = make(map[string]bool)
+				}
+				am.noLedWarned[disk.Name] = true
+			}
 			continue
 		}
-		rxBytes, _ := strconv.ParseUint(fields[0], 10, 64)
-		txBytes, _ := strconv.ParseUint(fields[8], 10, 64)
-		rxTotal += rxBytes
-		txTotal += txBytes
+
+		delta, ok := deltas[disk.Name]
+		am.updateDiskLed(conf, now, interval, ledIndex, disk, delta, ok, metrics[disk.Name], rainbowTime)
+	}
+}
+
+// updateDiskLed drives one disk's LED for the tick at now from its activity
+// delta over interval, the time since the previous tick. present is false
+// when the disk is missing from /proc/diskstats.
+func (am *ActivityMonitor) updateDiskLed(conf *Config, now time.Time, interval time.Duration, ledIndex int, disk DiskInfo, delta DiskActivity, present bool, metrics DiskMetrics, rainbowTime float64) {
+	if am.showHealth(ledIndex, disk) {
+		// SMART problems take precedence over activity
+		am.cancelFade(ledIndex)
+		return
+	}
+	if !present {
+		// Disk missing from /proc/diskstats (removed or renamed)
+		am.cancelFade(ledIndex)
+		am.setLedMode(ledIndex, leds.LedModeOff, nil)
+		return
+	}
+	if am.showStandby(conf, ledIndex, disk, delta.Activity > 0) {
+		am.cancelFade(ledIndex)
+		return
+	}
+	idle := am.leds.DebounceIdle(ledIndex, delta.Activity > 0, conf.IdleTicks, now, conf.MinOn())
+	if delta.Activity == 0 && !idle {
+		// Hold the last activity display, and any fade to it, through
+		// short gaps and min_on_ms
+		return
+	}
+	if idle {
+		am.cancelFade(ledIndex)
+		am.showDiskIdle(conf, ledIndex, rainbowTime)
+		return
+	}
+
+	activity, maxActivity := delta.Activity, am.maxActivity
+	if conf.DiskMaxBytesPerSec > 0 {
+		// Rates above the known maximum show full brightness
+		activity, maxActivity = bytesPerSecond(delta.Activity, interval), conf.DiskMaxBytesPerSec
+	}
+	level := curveLevel(conf.BrightnessCurve, activity, maxActivity, conf.BrightnessGamma)
+	if formula := conf.activityFormula(); len(formula) > 0 {
+		level = shapeLevel(conf.BrightnessCurve, formulaLevel(formula, metrics, am.metricPeaks), conf.BrightnessGamma)
+	}
+	var green float64
+	if conf.GreenMetric != "" {
+		green = conf.GreenWeight * metricLevel(conf.GreenMetric, metrics, am.metricPeaks)
+	}
+	r, g, b := colorForActivity(delta.Reads, delta.Writes, level, green, conf.colorOptions())
+	// per_disk and hashed identify the disk by color; brightness alone
+	// shows activity
+	switch conf.ColorMode {
+	case ColorModePerDisk:
+		color := conf.diskColor(ledIndex, disk)
+		r, g, b = color[0], color[1], color[2]
+	case ColorModeHashed:
+		color := am.hashedColor(disk)
+		r, g, b = color[0], color[1], color[2]
+	}
+	if conf.nvmeColorTint != nil && disk.Type == DiskTypeNVMe {
+		tinted := tintColor([3]byte{r, g, b}, *conf.nvmeColorTint, conf.NvmeTintStrength)
+		r, g, b = tinted[0], tinted[1], tinted[2]
+	}
+	brightness := am.leds.SmoothBrightness(ledIndex, brightnessForLevel(level), conf.BrightnessSmoothing)
+	// Color and brightness before the mode, so an LED turning on shows them
+	am.fadeLed(ledIndex, [3]byte{r, g, b}, am.scrubCap(conf, brightness), conf.Transition(), conf.PollInterval)
+	if conf.ActiveMode == ActiveModePulse {
+		period := pulsePeriodMs(level)
+		am.setLedMode(ledIndex, leds.LedModeBlink, leds.BlinkParams(period/2, period-period/2))
+	} else {
+		am.setLedMode(ledIndex, leds.LedModeOn, nil)
+	}
+}
+
+// showDiskIdle drives a disk LED with no recent activity
+func (am *ActivityMonitor) showDiskIdle(conf *Config, ledIndex int, rainbowTime float64) {
+	switch conf.IdleMode {
+	case IdleModeOff:
+		am.setLedMode(ledIndex, leds.LedModeOff, nil)
+	case IdleModeBreath:
+		am.setLedColor(ledIndex, 255, 255, 255)
+		am.setLedBrightness(ledIndex, *conf.IdleBrightness)
+		am.setLedMode(ledIndex, leds.LedModeBreath, leds.BreathParams(int(conf.IdleBreathPeriod.Milliseconds())))
+	case IdleModeSolid:
+		// Unchanged settings aren't rewritten, so a held color costs no I2C writes
+		am.setLedColor(ledIndex, conf.idleColor[0], conf.idleColor[1], conf.idleColor[2])
+		am.setLedBrightness(ledIndex, *conf.IdleBrightness)
+		am.setLedMode(ledIndex, leds.LedModeOn, nil)
+	default:
+		// Use rainbow color for inactive disks
+		am.setLedMode(ledIndex, leds.LedModeOn, nil)
+		r, g, b := am.rainbowColor(ledIndex-1, 1+len(am.disks), rainbowTime)
+		am.setLedColor(ledIndex, r, g, b)
+		am.setLedBrightness(ledIndex, *conf.RainbowBrightness)
+	}
+}
+
+// turnOffLeds turns off the disk and LAN LEDs driven by the monitor
+func (am *ActivityMonitor) turnOffLeds() {
+	am.setLedMode(lanLedIndex, leds.LedModeOff, nil)
+	for i := range am.layout.DiskBays {
+		am.setLedMode(firstDiskLedIndex+i, leds.LedModeOff, nil)
+	}
+}
+
+// showLanIdle drives the LAN LED while the link is up without traffic
+func (am *ActivityMonitor) showLanIdle(conf *Config, rainbowTime float64) {
+	switch conf.LanIdleMode {
+	case LanIdleModeOff:
+		am.setLedMode(lanLedIndex, leds.LedModeOff, nil)
+	case LanIdleModeOn:
+		am.setLedColor(lanLedIndex, 255, 255, 255)
+		am.setLedBrightness(lanLedIndex, *conf.LanIdleBrightness)
+		am.setLedMode(lanLedIndex, leds.LedModeOn, nil)
+	case LanIdleModeBreath:
+		am.setLedColor(lanLedIndex, 255, 255, 255)
+		am.setLedBrightness(lanLedIndex, *conf.LanIdleBrightness)
+		am.setLedMode(lanLedIndex, leds.LedModeBreath, leds.BreathParams(int(conf.IdleBreathPeriod.Milliseconds())))
+	default:
+		am.setLedMode(lanLedIndex, leds.LedModeOn, nil)
+		r, g, b := am.rainbowColor(0, 1+len(am.disks), rainbowTime)
+		am.setLedColor(lanLedIndex, r, g, b)
+		am.setLedBrightness(lanLedIndex, *conf.RainbowBrightness)
+	}
+}
+
+// tickDone runs the tick's color fades, confirms its batched LED writes,
+// publishes its activity, and, when the tick changed an LED, pushes the new
+// status to WebSocket clients
+func (am *ActivityMonitor) tickDone(conf *Config, event ActivityEvent) {
+	am.runFades()
+	for id, err := range am.leds.EndBatch() {
+		am.logLedError(id, err)
+	}
+	am.publish(event)
+	if conf.SummaryInterval > 0 {
+		am.summary.add(event, am.maxActivity)
+	}
+	if writes := am.leds.WriteStats().Writes; writes != am.lastWrites {
+		am.lastWrites = writes
+		am.broadcastStatus()
+	}
+}
+
+// diskLedIndex returns the LED index driven by the i'th discovered disk,
+// preferring an explicit disk_led_map entry for the disk's serial. With
+// reverse, positional disks count from the last bay of the layout. It returns
+// false when the layout has no LED for the disk.
+func diskLedIndex(i int, disk DiskInfo, ledMap map[string]int, layout LedLayout, reverse bool) (int, bool) {
+	if index, ok := ledMap[disk.Serial]; ok && disk.Serial != "" {
+		return index, layout.Valid(index)
+	}
+	if reverse {
+		i = layout.DiskBays - 1 - i
 	}
-	return rxTotal, txTotal, nil
+	return layout.DiskLed(i)
 }
 
 func main() {
 	flag.Parse()
+	if *showVer {
+		fmt.Println(versionString())
+		return
+	}
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
+	if err := setupLogging(*logFormat, os.Stderr); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *demo && *demoStep <= 0 {
+		fmt.Printf("Invalid --demo-step %s: must be positive\n", *demoStep)
+		os.Exit(1)
+	}
+
+	if *setLed != "" {
+		setting, err := parseLedSetting(*setLed)
+		if err != nil {
+			fmt.Printf("Invalid --set: %v\n", err)
+			os.Exit(1)
+		}
+		controller, err := NewConfiguredUGreenLeds(*confFile, *device, *dryRun, *noLeds)
+		if err != nil {
+			log.Fatalf("Failed to open LEDs: %v", err)
+		}
+		defer controller.Close()
+		if err := applyLedSetting(controller, setting); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Set %s\n", *setLed)
+		return
+	}
+
+	if *listDisks {
+		disks, warnings, err := discoverDisks()
+		if err != nil {
+			log.Fatalf("Disk discovery failed: %v", err)
+		}
+		for _, w := range warnings {
+			log.Printf("Warning: skipped %s", w)
+		}
+		out, err := disksJSON(disks)
+		if err != nil {
+			log.Fatalf("Failed to format disks: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	if *dumpStat {
+		controller, err := NewConfiguredUGreenLeds(*confFile, *device, *dryRun, *noLeds)
+		if err != nil {
+			log.Fatalf("Failed to open LEDs: %v", err)
+		}
+		defer controller.Close()
+		for id := range leds.LedNames {
+			raw, err := controller.ReadRawStatus(id)
+			if err != nil {
+				fmt.Printf("LED %d (%s): error: %v\n", id, leds.LedNames[id], err)
+				continue
+			}
+			fmt.Print(controller.FormatStatusDump(id, raw))
+		}
+		return
+	}
 
 	if len(flag.Args()) > 0 {
 		cmd := flag.Arg(0)
@@ -294,12 +759,12 @@ func main() {
 				fmt.Printf("Invalid led_id: %v\n", err)
 				os.Exit(1)
 			}
-			leds, err := NewConfiguredUGreenLeds(*confFile, *device)
+			controller, err := NewConfiguredUGreenLeds(*confFile, *device, *dryRun, *noLeds)
 			if err != nil {
 				log.Fatalf("Failed to open LEDs: %v", err)
 			}
-			defer leds.Close()
-			status, err := readLedStatus(leds.fd, ledID)
+			defer controller.Close()
+			status, err := controller.GetLedStatus(ledID)
 			if err != nil {
 				fmt.Printf("Error reading LED %d: %v\n", ledID, err)
 				os.Exit(1)
@@ -316,20 +781,20 @@ func main() {
 			g, _ := strconv.Atoi(flag.Arg(3))
 			b, _ := strconv.Atoi(flag.Arg(4))
 			brightness, _ := strconv.Atoi(flag.Arg(5))
-			leds, err := NewConfiguredUGreenLeds(*confFile, *device)
+			controller, err := NewConfiguredUGreenLeds(*confFile, *device, *dryRun, *noLeds)
 			if err != nil {
 				log.Fatalf("Failed to open LEDs: %v", err)
 			}
-			defer leds.Close()
-			if err := leds.SetLedColor(ledID, byte(r), byte(g), byte(b)); err != nil {
+			defer controller.Close()
+			if err := controller.SetLedColor(ledID, byte(r), byte(g), byte(b)); err != nil {
 				fmt.Printf("Error setting color: %v\n", err)
 				os.Exit(1)
 			}
-			if err := leds.SetLedBrightness(ledID, byte(brightness)); err != nil {
+			if err := controller.SetLedBrightness(ledID, byte(brightness)); err != nil {
 				fmt.Printf("Error setting brightness: %v\n", err)
 				os.Exit(1)
 			}
-			if err := leds.SetLedMode(ledID, LedModeOn, nil); err != nil {
+			if err := controller.SetLedMode(ledID, leds.LedModeOn, nil); err != nil {
 				fmt.Printf("Error setting mode: %v\n", err)
 				os.Exit(1)
 			}
@@ -340,14 +805,69 @@ func main() {
 		os.Exit(1)
 	}
 
+	log.Printf("Starting %s", versionString())
 	am, err := NewActivityMonitor(*confFile)
 	if err != nil {
 		log.Fatalf("Failed to create ActivityMonitor: %v", err)
 	}
 	fmt.Printf("Discovered %d Disks:\n", len(am.disks))
 	for i, disk := range am.disks {
-		fmt.Printf("Disk%d: %s (HCTL: %s, Serial: %s Path:%s)\n", i+1, disk.Name, disk.HCTL, disk.Serial, disk.Path)
+		fmt.Printf("Disk%d: %s (Type: %s, HCTL: %s, Serial: %s Path:%s)\n", i+1, disk.Name, disk.Type, disk.HCTL, disk.Serial, disk.Path)
+	}
+	fmt.Printf("LED layout: %s (%d disk LEDs)\n", am.layout.Model, am.layout.DiskBays)
+	defer am.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if conf := am.configLoader.Config(); *selfTest || conf.StartupSelftest {
+		err := runSelfTest(ctx, am.leds, am.layout, conf.SelftestStep)
+		if ctx.Err() != nil {
+			log.Printf("Self-test interrupted, exiting")
+			return
+		}
+		if err != nil {
+			log.Printf("Self-test failed: %v", err)
+		}
+	}
+	if *demo {
+		am.runDemo(ctx, *demoStep)
+		return
+	}
+	if addr := am.configLoader.Config().StatusListen; addr != "" {
+		go func() {
+			if err := am.ServeStatus(addr); err != nil {
+				log.Printf("Status endpoint stopped: %v", err)
+			}
+		}()
+	}
+	if conf := am.configLoader.Config(); conf.MQTTBroker != "" {
+		log.Printf("Publishing activity to %s on %s", conf.MQTTBroker, conf.MQTTTopic)
+		am.events = newEventPublisher(newMQTTPublisher(conf.MQTTBroker), conf.MQTTTopic, mqttEventBuffer)
+	}
+	if *am.configLoader.Config().SmartHealth {
+		if reader, err := newSmartctlReader(); err != nil {
+			am.warn("SMART health disabled: %v", err)
+		} else {
+			go am.smartLoop(ctx, reader)
+		}
+	}
+	if conf := am.configLoader.Config(); conf.ScrubBrightnessCap > 0 {
+		if detector, err := scrubDetector(conf); err != nil {
+			am.warn("scrub detection disabled: %v", err)
+		} else {
+			go am.scrubLoop(ctx, detector)
+		}
+	}
+	go am.rediscoverLoop(ctx, discoverOrderedDisks)
+	if am.configLoader.Config().StandbyLedMode != StandbyLedModeIgnore {
+		go am.standbyLoop(ctx, powerStateReader())
 	}
+	var wg sync.WaitGroup
+	wg.Go(func() { am.powerLoop(ctx) })
+	wg.Go(func() { am.watchdogLoop(ctx) })
+	go am.summaryLoop(ctx)
 	log.Println("Starting activity monitoring...")
-	am.Monitor()
+	am.MonitorCtx(ctx)
+	wg.Wait()
+	log.Println("Stopped activity monitoring")
 }
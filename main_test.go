@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/configloader"
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestDiskLedIndex(t *testing.T) {
+	ledMap := map[string]int{"WD-123": 7}
+	eightBay := layoutForDiskCount(8)
+	fourBay := layoutForDiskCount(4)
+
+	tests := []struct {
+		name   string
+		i      int
+		disk   DiskInfo
+		layout LedLayout
+		want   int
+		ok     bool
+	}{
+		{"mapped", 0, DiskInfo{Serial: "WD-123"}, eightBay, 7, true},
+		{"unmapped disk2", 1, DiskInfo{Serial: "WD-456"}, eightBay, 3, true},
+		{"no serial", 0, DiskInfo{}, eightBay, 2, true},
+		{"disk8 on 8-bay", 7, DiskInfo{}, eightBay, 9, true},
+		{"disk4 on 4-bay", 3, DiskInfo{}, fourBay, 5, true},
+		{"disk5 on 4-bay", 4, DiskInfo{}, fourBay, 0, false},
+		{"mapped past 4-bay", 0, DiskInfo{Serial: "WD-123"}, fourBay, 7, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := diskLedIndex(tt.i, tt.disk, ledMap, tt.layout, false)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("diskLedIndex() = %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDiskLedIndexReversed(t *testing.T) {
+	ledMap := map[string]int{"WD-123": 3}
+	tests := []struct {
+		bays int
+		want []int // LED per disk, -1 for none
+	}{
+		{4, []int{5, 4, 3, 2, -1}},
+		{6, []int{7, 6, 5, 4, 3, 2, -1}},
+	}
+	for _, tt := range tests {
+		layout := layoutForDiskCount(tt.bays)
+		for i, want := range tt.want {
+			got, ok := diskLedIndex(i, DiskInfo{}, ledMap, layout, true)
+			if ok != (want >= 0) || (ok && got != want) {
+				t.Errorf("%d bays: diskLedIndex(%d) = %d, %v, want %d", tt.bays, i, got, ok, want)
+			}
+		}
+		// disk_led_map entries are not mirrored
+		if got, ok := diskLedIndex(0, DiskInfo{Serial: "WD-123"}, ledMap, layout, true); !ok || got != 3 {
+			t.Errorf("%d bays: mapped disk = %d, %v, want 3", tt.bays, got, ok)
+		}
+	}
+}
+
+// newTestMonitor returns a monitor for disks with everything the monitor
+// loop needs, driving a fake LED controller. Tests that run the loop set a
+// config loader, see newTestConfigLoader.
+func newTestMonitor(t *testing.T, disks ...DiskInfo) *ActivityMonitor {
+	t.Helper()
+	return &ActivityMonitor{
+		disks:       disks,
+		layout:      resolveLedLayout("", len(disks)),
+		leds:        leds.NewUGreenLedsWithTransport(leds.NewFakeTransport()),
+		metricPeaks: make(map[string]float64),
+		ledErrors:   newErrorLimiter(ledErrorLogInterval),
+		netErrors:   newErrorLimiter(ledErrorLogInterval),
+		hub:         newStatusHub(),
+		health:      newDiskHealthMap(),
+		standby:     newDiskStandbyMap(),
+		seen:        newDiskSeenTracker(),
+		history:     newDiskHistory(),
+		link:        newLinkState(),
+	}
+}
+
+// newTestConfigLoader returns a config loader for a temporary file holding
+// config
+func newTestConfigLoader(t *testing.T, config string) *configloader.ConfigLoader[Config] {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loader, err := NewConfigLoader(path)
+	if err != nil {
+		t.Fatalf("failed to create config loader: %v", err)
+	}
+	return loader
+}
+
+func TestMonitorCtxCancel(t *testing.T) {
+	loader := newTestConfigLoader(t, "poll_interval: 10ms\nstate_file: \"\"\n")
+	am := newTestMonitor(t)
+	am.configLoader = loader
+	if err := am.leds.SetLedMode(firstDiskLedIndex, leds.LedModeOn, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		am.MonitorCtx(ctx)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("MonitorCtx did not return after cancel")
+	}
+	for _, state := range am.leds.LedStates() {
+		if state.Index != powerLedIndex && state.Mode != "off" {
+			t.Errorf("%s left %s after cancel", state.Name, state.Mode)
+		}
+	}
+}
+
+func TestMonitorCtxLanLedDisabled(t *testing.T) {
+	loader := newTestConfigLoader(t, "poll_interval: 10ms\nstate_file: \"\"\nenable_lan_led: false\n")
+	var reads atomic.Int32
+	am := newTestMonitor(t)
+	am.configLoader = loader
+	am.netTotals = func(ifaces, excludePrefixes []string, bondCount string) (uint64, uint64, error) {
+		reads.Add(1)
+		return 0, 0, nil
+	}
+	// Start from a blinking LAN LED, as left by a previous run
+	if err := am.leds.SetLedMode(lanLedIndex, leds.LedModeBlink, leds.BlinkParams(100, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	am.MonitorCtx(ctx)
+
+	if n := reads.Load(); n != 0 {
+		t.Errorf("expected no network reads with enable_lan_led: false, got %d", n)
+	}
+	for _, state := range am.leds.LedStates() {
+		if state.Index == lanLedIndex && state.Mode != "off" {
+			t.Errorf("LAN LED left %s", state.Mode)
+		}
+	}
+}
+
+func TestMonitorCtxNoDisks(t *testing.T) {
+	loader := newTestConfigLoader(t, "poll_interval: 10ms\nstate_file: \"\"\n")
+	var rx atomic.Uint64
+	am := newTestMonitor(t)
+	am.configLoader = loader
+	am.link = &linkState{root: t.TempDir(), interval: linkStateInterval} // no sysfs: link assumed up
+	am.netTotals = func(ifaces, excludePrefixes []string, bondCount string) (uint64, uint64, error) {
+		return rx.Add(1500), 0, nil
+	}
+	// A disk LED left on by a previous run
+	if err := am.leds.SetLedMode(firstDiskLedIndex, leds.LedModeOn, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	lanMode := func() string {
+		for _, state := range am.leds.LedStates() {
+			if state.Index == lanLedIndex {
+				return state.Mode
+			}
+		}
+		return ""
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		am.MonitorCtx(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for lanMode() != "blink" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if mode := lanMode(); mode != "blink" {
+		t.Errorf("LAN LED %q with traffic and no disks, want blink", mode)
+	}
+	for _, state := range am.leds.LedStates() {
+		if state.Index >= firstDiskLedIndex && state.Mode != "off" {
+			t.Errorf("%s left %s with no disks", state.Name, state.Mode)
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestUpdateDiskLed(t *testing.T) {
+	brightness := byte(40)
+	conf := &Config{
+		PollInterval:      50 * time.Millisecond,
+		IdleMode:          IdleModeOff,
+		IdleTicks:         2,
+		ColorMode:         ColorModeRWBlend,
+		BrightnessCurve:   BrightnessCurveLinear,
+		RainbowBrightness: &brightness,
+	}
+	disk := DiskInfo{Name: "sda"}
+	ledIndex := firstDiskLedIndex
+	am := newTestMonitor(t, disk)
+	am.maxActivity = 1000
+	ledState := func() leds.LedState {
+		t.Helper()
+		for _, state := range am.leds.LedStates() {
+			if state.Index == ledIndex {
+				return state
+			}
+		}
+		t.Fatal("disk LED never written")
+		return leds.LedState{}
+	}
+
+	steps := []struct {
+		name       string
+		delta      DiskActivity
+		present    bool
+		wantMode   string
+		wantColor  [3]byte
+		brightness byte
+	}{
+		{"writes at peak", DiskActivity{Activity: 1000, Writes: 1000}, true, "on", [3]byte{255, 0, 0}, maxActiveBrightness},
+		{"reads at half peak", DiskActivity{Activity: 500, Reads: 500}, true, "on", [3]byte{0, 0, 255}, brightnessForLevel(0.5)},
+		{"short gap holds", DiskActivity{}, true, "on", [3]byte{0, 0, 255}, brightnessForLevel(0.5)},
+		{"idle", DiskActivity{}, true, "off", [3]byte{0, 0, 255}, brightnessForLevel(0.5)},
+		{"active again", DiskActivity{Activity: 1000, Reads: 1000}, true, "on", [3]byte{0, 0, 255}, maxActiveBrightness},
+		{"missing from diskstats", DiskActivity{}, false, "off", [3]byte{0, 0, 255}, maxActiveBrightness},
+	}
+	now := time.Now()
+	for _, step := range steps {
+		now = now.Add(conf.PollInterval)
+		am.updateDiskLed(conf, now, conf.PollInterval, ledIndex, disk, step.delta, step.present, DiskMetrics{}, 3)
+		state := ledState()
+		if state.Mode != step.wantMode || [3]byte{state.R, state.G, state.B} != step.wantColor || state.Brightness != step.brightness {
+			t.Errorf("%s: LED = %+v, want mode %s color %v brightness %d", step.name, state, step.wantMode, step.wantColor, step.brightness)
+		}
+	}
+
+	am.health.set(disk.Name, HealthFailed)
+	am.updateDiskLed(conf, now, conf.PollInterval, ledIndex, disk, DiskActivity{Activity: 1000, Writes: 1000}, true, DiskMetrics{}, 3)
+	if state := ledState(); state.Mode != "blink" || [3]byte{state.R, state.G, state.B} != [3]byte{255, 0, 0} {
+		t.Errorf("failed disk: LED = %+v, want red blink", state)
+	}
+}
+
+func TestUpdateDiskLedIdleSolid(t *testing.T) {
+	brightness := byte(24)
+	conf := &Config{
+		PollInterval:   50 * time.Millisecond,
+		IdleMode:       IdleModeSolid,
+		IdleTicks:      1,
+		IdleBrightness: &brightness,
+		idleColor:      [3]byte{0, 64, 128},
+	}
+	disk := DiskInfo{Name: "sda"}
+	transport := leds.NewFakeTransport()
+	am := newTestMonitor(t, disk)
+	am.leds = leds.NewUGreenLedsWithTransport(transport)
+
+	now := time.Now()
+	am.updateDiskLed(conf, now, conf.PollInterval, firstDiskLedIndex, disk, DiskActivity{}, true, DiskMetrics{}, 0)
+	for _, state := range am.leds.LedStates() {
+		if state.Index == firstDiskLedIndex && (state.Mode != "on" || [3]byte{state.R, state.G, state.B} != conf.idleColor || state.Brightness != brightness) {
+			t.Errorf("idle LED = %+v, want solid %v at %d", state, conf.idleColor, brightness)
+		}
+	}
+
+	writes := transport.Count()
+	if writes == 0 {
+		t.Fatal("expected the idle color to be written")
+	}
+	for range 10 {
+		now = now.Add(conf.PollInterval)
+		am.updateDiskLed(conf, now, conf.PollInterval, firstDiskLedIndex, disk, DiskActivity{}, true, DiskMetrics{}, 0)
+	}
+	if extra := transport.Commands()[writes:]; len(extra) > 0 {
+		t.Errorf("expected no writes while idle solid is held, got %+v", extra)
+	}
+}
+
+func TestUpdateDiskLedMinOn(t *testing.T) {
+	brightness := byte(40)
+	conf := &Config{
+		PollInterval:      50 * time.Millisecond,
+		IdleMode:          IdleModeOff,
+		IdleTicks:         1,
+		MinOnMs:           200,
+		ColorMode:         ColorModeRWBlend,
+		BrightnessCurve:   BrightnessCurveLinear,
+		RainbowBrightness: &brightness,
+	}
+	disk := DiskInfo{Name: "sda"}
+	am := newTestMonitor(t, disk)
+	am.maxActivity = 1000
+
+	// A one-poll burst of writes, then nothing
+	start := time.Now()
+	burst := DiskActivity{Activity: 1000, Writes: 1000}
+	am.updateDiskLed(conf, start, conf.PollInterval, firstDiskLedIndex, disk, burst, true, DiskMetrics{}, 3)
+	for tick := 1; tick <= 5; tick++ {
+		now := start.Add(time.Duration(tick) * conf.PollInterval)
+		am.updateDiskLed(conf, now, conf.PollInterval, firstDiskLedIndex, disk, DiskActivity{}, true, DiskMetrics{}, 3)
+		state := am.leds.LedStates()[0]
+		held := now.Sub(start) < conf.MinOn()
+		if held && (state.Mode != "on" || [3]byte{state.R, state.G, state.B} != [3]byte{255, 0, 0}) {
+			t.Errorf("%s after the burst: LED = %+v, want held red", now.Sub(start), state)
+		}
+		if !held && state.Mode != "off" {
+			t.Errorf("%s after the burst: LED = %+v, want off", now.Sub(start), state)
+		}
+	}
+}
+
+func TestUpdateDiskLedsSkipsMissingLeds(t *testing.T) {
+	brightness := byte(40)
+	conf := &Config{
+		Model:             "DXP4800",
+		IdleMode:          IdleModeRainbow,
+		IdleTicks:         1,
+		ColorMode:         ColorModeWhite,
+		BrightnessCurve:   BrightnessCurveLinear,
+		RainbowBrightness: &brightness,
+		DiskLedMap:        map[string]int{"SER6": 7}, // disk6 mapped to a bay the model lacks
+	}
+	var disks []DiskInfo
+	deltas := make(map[string]DiskActivity)
+	for i := range 6 {
+		disk := DiskInfo{Name: fmt.Sprintf("sd%c", 'a'+i), Serial: fmt.Sprintf("SER%d", i+1)}
+		disks = append(disks, disk)
+		deltas[disk.Name] = DiskActivity{Activity: 1000, Writes: 1000}
+	}
+	transport := leds.NewFakeTransport()
+	am := newTestMonitor(t, disks...)
+	am.layout = resolveLedLayout(conf.Model, len(disks))
+	am.leds = leds.NewUGreenLedsWithTransport(transport)
+	am.maxActivity = 1000
+
+	now := time.Now()
+	am.updateDiskLeds(conf, now, conf.PollInterval, deltas, nil, 3)
+	am.updateDiskLeds(conf, now.Add(time.Second), conf.PollInterval, map[string]DiskActivity{}, nil, 3)
+
+	written := make(map[int]bool)
+	for _, cmd := range transport.Commands() {
+		written[cmd.LedID] = true
+	}
+	for ledIndex := firstDiskLedIndex; ledIndex < firstDiskLedIndex+4; ledIndex++ {
+		if !written[ledIndex] {
+			t.Errorf("%s: expected writes", leds.LedNames[ledIndex])
+		}
+	}
+	for _, ledIndex := range []int{6, 7} {
+		if written[ledIndex] {
+			t.Errorf("%s: expected no writes on a 4-bay layout", leds.LedNames[ledIndex])
+		}
+	}
+	if len(am.noLedWarned) != 2 || !am.noLedWarned["sde"] || !am.noLedWarned["sdf"] {
+		t.Errorf("expected one warning each for sde and sdf, got %v", am.noLedWarned)
+	}
+}
+
+func TestDiskUpdateOrder(t *testing.T) {
+	am := &ActivityMonitor{disks: make([]DiskInfo, 3)}
+
+	sequential := &Config{LedUpdateOrder: LedUpdateOrderSequential}
+	for tick := range 2 {
+		if got := am.diskUpdateOrder(sequential); !slices.Equal(got, []int{0, 1, 2}) {
+			t.Errorf("sequential tick %d: order = %v, want [0 1 2]", tick, got)
+		}
+	}
+
+	roundRobin := &Config{LedUpdateOrder: LedUpdateOrderRoundRobin}
+	want := [][]int{{0, 1, 2}, {1, 2, 0}, {2, 0, 1}, {0, 1, 2}}
+	for tick, w := range want {
+		if got := am.diskUpdateOrder(roundRobin); !slices.Equal(got, w) {
+			t.Errorf("round-robin tick %d: order = %v, want %v", tick, got, w)
+		}
+	}
+
+	// A disk removed mid-rotation keeps the offset in range
+	am.disks = am.disks[:2]
+	if got := am.diskUpdateOrder(roundRobin); !slices.Equal(got, []int{1, 0}) {
+		t.Errorf("after removal: order = %v, want [1 0]", got)
+	}
+}
+
+func TestShowIdleDim(t *testing.T) {
+	loader := newTestConfigLoader(t, "show_idle_dim: true\nidle_ticks: 1\n")
+	conf := loader.Config()
+	if conf.IdleMode != IdleModeSolid || *conf.IdleBrightness != defaultIdleDimBrightness {
+		t.Fatalf("idle_mode = %s at %d, want solid at %d", conf.IdleMode, *conf.IdleBrightness, defaultIdleDimBrightness)
+	}
+
+	busy, quiet := DiskInfo{Name: "sda"}, DiskInfo{Name: "sdb"}
+	am := newTestMonitor(t, busy, quiet)
+	am.maxActivity = 1000
+	now := time.Now()
+	am.updateDiskLed(conf, now, conf.PollInterval, firstDiskLedIndex, busy, DiskActivity{Reads: 1000, Activity: 1000}, true, DiskMetrics{}, 0)
+	am.updateDiskLed(conf, now, conf.PollInterval, firstDiskLedIndex+1, quiet, DiskActivity{}, true, DiskMetrics{}, 0)
+
+	r, g, b := colorForActivity(1000, 0, 1, 0, conf.colorOptions())
+	want := map[int]leds.LedState{
+		firstDiskLedIndex:     {Mode: "on", R: r, G: g, B: b, Brightness: brightnessForLevel(1)},
+		firstDiskLedIndex + 1: {Mode: "on", R: 255, G: 255, B: 255, Brightness: defaultIdleDimBrightness},
+	}
+	for _, state := range am.leds.LedStates() {
+		w, ok := want[state.Index]
+		if !ok {
+			continue
+		}
+		w.Index, w.Name = state.Index, state.Name
+		if state != w {
+			t.Errorf("%s = %+v, want %+v", state.Name, state, w)
+		}
+	}
+}
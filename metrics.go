@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// writeMetrics writes the LED write counters and latency histogram in the
+// Prometheus text format
+func writeMetrics(w io.Writer, stats leds.LedWriteStats, latency leds.WriteLatency) {
+	counters := []struct {
+		name, help string
+		value      uint64
+	}{
+		{"ugreen_led_writes_total", "LED writes confirmed by the controller.", stats.Writes},
+		{"ugreen_led_write_retries_total", "Extra LED write attempts after a failed write or confirmation.", stats.Retries},
+		{"ugreen_led_write_failures_total", "LED writes that failed after all retries.", stats.Failures},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value)
+	}
+
+	const name = "ugreen_led_write_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time from the first attempt of an LED write to its confirmation.\n# TYPE %s histogram\n", name, name)
+	for i, bound := range leds.WriteLatencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), latency.Counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, latency.Count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(latency.Sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, latency.Count)
+}
+
+// handleMetrics serves the LED write metrics, or 503 once the LED
+// controller is closed
+func (am *ActivityMonitor) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	controller := am.controller()
+	if controller == nil {
+		http.Error(w, "LED controller not open", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, controller.WriteStats(), controller.WriteLatency())
+}
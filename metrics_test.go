@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	counts := make([]uint64, len(leds.WriteLatencyBuckets))
+	for i := range counts {
+		counts[i] = 4
+	}
+	counts[0], counts[1] = 1, 3
+	var out strings.Builder
+	writeMetrics(&out, leds.LedWriteStats{Writes: 5, Retries: 2, Failures: 1}, leds.WriteLatency{Counts: counts, Sum: 12 * time.Millisecond, Count: 5})
+
+	for _, want := range []string{
+		"# TYPE ugreen_led_writes_total counter\nugreen_led_writes_total 5\n",
+		"ugreen_led_write_retries_total 2\n",
+		"ugreen_led_write_failures_total 1\n",
+		"# TYPE ugreen_led_write_duration_seconds histogram\n",
+		`ugreen_led_write_duration_seconds_bucket{le="0.0005"} 1` + "\n",
+		`ugreen_led_write_duration_seconds_bucket{le="0.001"} 3` + "\n",
+		`ugreen_led_write_duration_seconds_bucket{le="1"} 4` + "\n",
+		`ugreen_led_write_duration_seconds_bucket{le="+Inf"} 5` + "\n",
+		"ugreen_led_write_duration_seconds_sum 0.012\n",
+		"ugreen_led_write_duration_seconds_count 5\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHandleMetricsClosed(t *testing.T) {
+	am := newTestMonitor(t)
+	rec := httptest.NewRecorder()
+	am.handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("open controller: status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	am.leds = nil
+	rec = httptest.NewRecorder()
+	am.handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("closed controller: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
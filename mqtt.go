@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTTopic     = "truenas-leds/activity"
+	mqttEventBuffer      = 16
+	mqttConnectTimeout   = 10 * time.Second
+	mqttPublishTimeout   = 5 * time.Second
+	mqttErrorLogInterval = time.Minute
+)
+
+// ActivityEvent is the JSON payload published for each monitor tick
+type ActivityEvent struct {
+	Time    time.Time     `json:"time"`
+	Disks   []DiskEvent   `json:"disks"`
+	Network *NetworkEvent `json:"network,omitempty"`
+}
+
+// DiskEvent is one disk's activity during a tick
+type DiskEvent struct {
+	Name       string `json:"name"`
+	Serial     string `json:"serial,omitempty"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+}
+
+// NetworkEvent is the LAN activity during a tick
+type NetworkEvent struct {
+	RxBytes uint64 `json:"rx_bytes"`
+	TxBytes uint64 `json:"tx_bytes"`
+}
+
+// Publisher sends a payload to a topic
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+	Close()
+}
+
+// eventPublisher queues activity events on a buffered channel and publishes
+// them from its own goroutine, so a slow or unreachable broker never blocks
+// the monitor loop. Events are dropped while the queue is full.
+type eventPublisher struct {
+	pub     Publisher
+	topic   string
+	events  chan ActivityEvent
+	dropped atomic.Uint64
+	errors  *errorLimiter
+	done    chan struct{}
+}
+
+func newEventPublisher(pub Publisher, topic string, buffer int) *eventPublisher {
+	p := &eventPublisher{
+		pub:    pub,
+		topic:  topic,
+		events: make(chan ActivityEvent, buffer),
+		errors: newErrorLimiter(mqttErrorLogInterval),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Send queues an event without blocking
+func (p *eventPublisher) Send(event ActivityEvent) {
+	select {
+	case p.events <- event:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// Close stops publishing once the queued events are sent
+func (p *eventPublisher) Close() {
+	close(p.events)
+	<-p.done
+	p.pub.Close()
+}
+
+func (p *eventPublisher) run() {
+	defer close(p.done)
+	for event := range p.events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Error encoding activity event: %v", err)
+			continue
+		}
+		if err := p.pub.Publish(p.topic, payload); err != nil {
+			if ok, suppressed := p.errors.allow(0, time.Now()); ok {
+				log.Printf("Error publishing to %s: %v (%d similar errors suppressed, %d events dropped)", p.topic, err, suppressed, p.dropped.Load())
+			}
+		}
+	}
+}
+
+// mqttPublisher publishes to an MQTT broker, connecting on first use and
+// reconnecting on the next publish after a failure
+type mqttPublisher struct {
+	broker string
+	client mqtt.Client
+}
+
+func newMQTTPublisher(broker string) *mqttPublisher {
+	return &mqttPublisher{broker: broker}
+}
+
+func (m *mqttPublisher) connect() error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(m.broker).
+		SetClientID(fmt.Sprintf("truenas-leds-%d", time.Now().UnixNano())).
+		SetConnectTimeout(mqttConnectTimeout).
+		SetAutoReconnect(false)
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttConnectTimeout) {
+		return fmt.Errorf("timed out connecting to %s", m.broker)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("error connecting to %s: %w", m.broker, err)
+	}
+	log.Printf("Connected to MQTT broker %s", m.broker)
+	m.client = client
+	return nil
+}
+
+func (m *mqttPublisher) Publish(topic string, payload []byte) error {
+	if m.client == nil || !m.client.IsConnectionOpen() {
+		if err := m.connect(); err != nil {
+			return err
+		}
+	}
+	token := m.client.Publish(topic, 0, false, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		m.reset()
+		return fmt.Errorf("timed out publishing to %s", m.broker)
+	}
+	if err := token.Error(); err != nil {
+		m.reset()
+		return err
+	}
+	return nil
+}
+
+// reset drops the connection so the next publish reconnects
+func (m *mqttPublisher) reset() {
+	if m.client != nil {
+		m.client.Disconnect(0)
+		m.client = nil
+	}
+}
+
+func (m *mqttPublisher) Close() {
+	if m.client != nil {
+		m.client.Disconnect(250)
+		m.client = nil
+	}
+}
+
+// publish queues event when an MQTT broker is configured
+func (am *ActivityMonitor) publish(event ActivityEvent) {
+	if am.events != nil {
+		am.events.Send(event)
+	}
+}
+
+// activityEvent builds the event for a tick from the per-disk deltas
+func (am *ActivityMonitor) activityEvent(now time.Time, deltas map[string]DiskActivity) ActivityEvent {
+	event := ActivityEvent{Time: now, Disks: make([]DiskEvent, 0, len(am.disks))}
+	for _, disk := range am.disks {
+		delta, ok := deltas[disk.Name]
+		if !ok {
+			continue
+		}
+		event.Disks = append(event.Disks, DiskEvent{
+			Name:       disk.Name,
+			Serial:     disk.Serial,
+			ReadBytes:  delta.Reads,
+			WriteBytes: delta.Writes,
+		})
+	}
+	return event
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// fakePublisher records published payloads
+type fakePublisher struct {
+	payloads chan []byte
+	block    chan struct{}
+}
+
+func (p *fakePublisher) Publish(topic string, payload []byte) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.payloads <- payload
+	return nil
+}
+
+func (p *fakePublisher) Close() {}
+
+func TestEventPublisherPayload(t *testing.T) {
+	pub := &fakePublisher{payloads: make(chan []byte, 1)}
+	events := newEventPublisher(pub, "test/activity", 1)
+	defer events.Close()
+
+	am := &ActivityMonitor{disks: []DiskInfo{
+		{Name: "sda", Serial: "WD-123"},
+		{Name: "sdb"},
+	}}
+	event := am.activityEvent(time.Unix(1700000000, 0).UTC(), map[string]DiskActivity{
+		"sda": {Reads: 512, Writes: 1024, Activity: 1536},
+	})
+	event.Network = &NetworkEvent{RxBytes: 10, TxBytes: 20}
+	events.Send(event)
+
+	var payload []byte
+	select {
+	case payload = <-pub.payloads:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for publish")
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(payload, &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", payload, err)
+	}
+	if got["time"] != "2023-11-14T22:13:20Z" {
+		t.Errorf("time = %v", got["time"])
+	}
+	disks, ok := got["disks"].([]any)
+	if !ok || len(disks) != 1 {
+		t.Fatalf("expected one disk (sdb has no stats), got %v", got["disks"])
+	}
+	disk := disks[0].(map[string]any)
+	if disk["name"] != "sda" || disk["serial"] != "WD-123" || disk["read_bytes"] != 512.0 || disk["write_bytes"] != 1024.0 {
+		t.Errorf("unexpected disk entry %v", disk)
+	}
+	network := got["network"].(map[string]any)
+	if network["rx_bytes"] != 10.0 || network["tx_bytes"] != 20.0 {
+		t.Errorf("unexpected network entry %v", network)
+	}
+}
+
+func TestEventPublisherSendDoesNotBlock(t *testing.T) {
+	pub := &fakePublisher{payloads: make(chan []byte, 10), block: make(chan struct{})}
+	events := newEventPublisher(pub, "test/activity", 1)
+
+	done := make(chan struct{})
+	go func() {
+		for range 5 {
+			events.Send(ActivityEvent{})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Send blocked on a stalled publisher")
+	}
+	if events.dropped.Load() == 0 {
+		t.Error("expected events to be dropped while the publisher is stalled")
+	}
+	close(pub.block)
+	events.Close()
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LAN LED sources
+const (
+	LanLedSourceNetwork   = "network"    // network traffic
+	LanLedSourceDiskTotal = "disk_total" // summed disk activity
+	LanLedSourceOff       = "off"
+)
+
+// LAN LED brightness scales
+const (
+	LanScaleLink = "link" // fraction of link speed, falling back to peak
+	LanScalePeak = "peak" // fraction of the running peak
+)
+
+// LAN LED displays while the link is up but idle
+const (
+	LanIdleModeRainbow = "rainbow"
+	LanIdleModeOff     = "off"
+	LanIdleModeOn      = "on"     // steady white
+	LanIdleModeBreath  = "breath" // breathing white
+)
+
+// linkStateInterval is how often interface operstate is reread
+const linkStateInterval = 5 * time.Second
+
+// lanDisplay is what the LAN LED shows for a tick
+type lanDisplay int
+
+const (
+	lanDisplayDown   lanDisplay = iota // no included interface is up: off
+	lanDisplayIdle                     // link up, no traffic: lan_idle_mode
+	lanDisplayActive                   // traffic: blink
+	lanDisplayHold                     // short gap in traffic: leave as is
+)
+
+// lanDisplayFor picks the LAN LED display from the link state, whether the
+// tick saw traffic, and whether the LED has debounced to idle
+func lanDisplayFor(linkUp, active, idle bool) lanDisplay {
+	switch {
+	case !linkUp:
+		return lanDisplayDown
+	case idle:
+		return lanDisplayIdle
+	case !active:
+		return lanDisplayHold
+	}
+	return lanDisplayActive
+}
+
+// defaultNetworkExcludePrefixes skips loopback and common virtual interfaces
+var defaultNetworkExcludePrefixes = []string{"lo", "veth", "docker"}
+
+// NetActivity holds the byte counters for a network interface
+type NetActivity struct {
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// includeInterface reports whether iface should count toward LAN activity.
+// When ifaces is set only those interfaces are included; otherwise any
+// interface not matching an exclude prefix is. Entries of both may also be
+// glob patterns such as "eth*" or "enp?s0".
+func includeInterface(iface string, ifaces, excludePrefixes []string) bool {
+	if len(ifaces) > 0 {
+		for _, pattern := range ifaces {
+			if matched, _ := path.Match(pattern, iface); matched {
+				return true
+			}
+		}
+		return false
+	}
+	for _, prefix := range excludePrefixes {
+		if matched, _ := path.Match(prefix, iface); matched || strings.HasPrefix(iface, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateInterfacePatterns checks the glob syntax of the interface lists
+func validateInterfacePatterns(ifaces, excludePrefixes []string) error {
+	for _, pattern := range append(slices.Clone(ifaces), excludePrefixes...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Which side of a bonded interface counts toward LAN activity, since the
+// bond and its members report the same traffic
+const (
+	NetworkBondCountBond    = "bond"    // the bond only
+	NetworkBondCountMembers = "members" // the member NICs only
+)
+
+// readBonds returns the members of each bond under root, from
+// /sys/class/net/<bond>/bonding/slaves
+func readBonds(root string) map[string][]string {
+	netDir := filepath.Join(root, "sys/class/net")
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return nil
+	}
+	bonds := make(map[string][]string)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(netDir, entry.Name(), "bonding/slaves"))
+		if err != nil {
+			continue // not a bond
+		}
+		bonds[entry.Name()] = strings.Fields(string(data))
+	}
+	return bonds
+}
+
+// bondSkips returns the included interfaces whose traffic is already counted
+// through the other side of their bond: members of an included bond when
+// count is bond, or bonds with an included member when count is members
+func bondSkips(bonds map[string][]string, ifaces, excludePrefixes []string, count string) map[string]bool {
+	skip := make(map[string]bool)
+	for bond, members := range bonds {
+		if !includeInterface(bond, ifaces, excludePrefixes) {
+			continue
+		}
+		for _, member := range members {
+			if !includeInterface(member, ifaces, excludePrefixes) {
+				continue
+			}
+			if count == NetworkBondCountMembers {
+				skip[bond] = true
+			} else {
+				skip[member] = true
+			}
+		}
+	}
+	return skip
+}
+
+// getNetworkActivity reads /proc/net/dev and returns the counters for each
+// included interface, counting each bond in bonds once as bondCount selects
+func getNetworkActivity(bonds map[string][]string, ifaces, excludePrefixes []string, bondCount string) (map[string]NetActivity, error) {
+	data, err := os.ReadFile("/proc/net/dev")
+	if err != nil {
+		return map[string]NetActivity{}, err
+	}
+	return parseNetDev(data, ifaces, excludePrefixes, bondSkips(bonds, ifaces, excludePrefixes, bondCount))
+}
+
+// malformedNetDevError reports the interfaces whose /proc/net/dev lines
+// parseNetDev skipped. It is returned with the other interfaces' counters.
+type malformedNetDevError struct {
+	ifaces []string
+	errs   []error
+}
+
+func (e *malformedNetDevError) Error() string {
+	return errors.Join(e.errs...).Error()
+}
+
+// parseNetDev parses /proc/net/dev, returning the receive and transmit byte
+// counters of each included interface not in skip. The two header lines are
+// skipped. An interface line without both counters is skipped too, and
+// reported in a *malformedNetDevError.
+func parseNetDev(data []byte, ifaces, excludePrefixes []string, skip map[string]bool) (map[string]NetActivity, error) {
+	stats := make(map[string]NetActivity)
+	var malformed *malformedNetDevError
+	skipLine := func(iface string, err error) {
+		if malformed == nil {
+			malformed = &malformedNetDevError{}
+		}
+		malformed.ifaces = append(malformed.ifaces, iface)
+		malformed.errs = append(malformed.errs, err)
+	}
+	var fields [9][]byte
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		name, counters, found := bytes.Cut(line, []byte(":"))
+		if !found {
+			continue // header or blank line
+		}
+		iface := string(bytes.TrimSpace(name))
+		if !includeInterface(iface, ifaces, excludePrefixes) || skip[iface] {
+			continue
+		}
+		// Receive bytes, packets, errs, drop, fifo, frame, compressed,
+		// multicast, then transmit bytes
+		if n := splitFields(counters, fields[:]); n < len(fields) {
+			skipLine(iface, fmt.Errorf("malformed /proc/net/dev line for %s: %d fields", iface, len(bytes.Fields(counters))))
+			continue
+		}
+		rxBytes, ok := parseCounter(fields[0])
+		if !ok {
+			skipLine(iface, fmt.Errorf("malformed /proc/net/dev receive bytes for %s: %q", iface, fields[0]))
+			continue
+		}
+		txBytes, ok := parseCounter(fields[8])
+		if !ok {
+			skipLine(iface, fmt.Errorf("malformed /proc/net/dev transmit bytes for %s: %q", iface, fields[8]))
+			continue
+		}
+		stats[iface] = NetActivity{RxBytes: rxBytes, TxBytes: txBytes}
+	}
+	if malformed != nil {
+		return stats, malformed
+	}
+	return stats, nil
+}
+
+// networkReader reads the summed counters of the included interfaces, as
+// getNetworkTotals does
+type networkReader func(ifaces, excludePrefixes []string, bondCount string) (rxTotal, txTotal uint64, err error)
+
+// getNetworkTotals sums the counters of all included interfaces. Skipped
+// malformed lines are reported with the sum of the others, see parseNetDev.
+func getNetworkTotals(bonds map[string][]string, ifaces, excludePrefixes []string, bondCount string) (rxTotal, txTotal uint64, err error) {
+	stats, err := getNetworkActivity(bonds, ifaces, excludePrefixes, bondCount)
+	var malformed *malformedNetDevError
+	if err != nil && !errors.As(err, &malformed) {
+		return 0, 0, err
+	}
+	for _, s := range stats {
+		rxTotal += s.RxBytes
+		txTotal += s.TxBytes
+	}
+	return rxTotal, txTotal, err
+}
+
+// readLinkSpeed returns the summed link speed in Mbit/s of the included
+// interfaces under root that report one, counting each bond in bonds once as
+// bondCount selects. Virtual and down interfaces report none, so 0 means no
+// speed is known.
+func readLinkSpeed(root string, bonds map[string][]string, ifaces, excludePrefixes []string, bondCount string) int {
+	netDir := filepath.Join(root, "sys/class/net")
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return 0
+	}
+	skip := bondSkips(bonds, ifaces, excludePrefixes, bondCount)
+	total := 0
+	for _, entry := range entries {
+		iface := entry.Name()
+		if !includeInterface(iface, ifaces, excludePrefixes) || skip[iface] {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(netDir, iface, "speed"))
+		if err != nil {
+			continue
+		}
+		if speed, ok := parseLinkSpeed(data); ok {
+			total += speed
+		}
+	}
+	return total
+}
+
+// parseLinkSpeed parses a sysfs speed file; down links report -1
+func parseLinkSpeed(data []byte) (int, bool) {
+	speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || speed <= 0 {
+		return 0, false
+	}
+	return speed, true
+}
+
+// linkLevel returns the busier direction's throughput over interval as a
+// fraction of a full-duplex link of speedMbps, in 0..1
+func linkLevel(rxBytes, txBytes uint64, interval time.Duration, speedMbps int) float64 {
+	if speedMbps <= 0 || interval <= 0 {
+		return 0
+	}
+	bits := float64(max(rxBytes, txBytes)) * 8
+	bitsPerSec := bits / interval.Seconds()
+	return math.Min(bitsPerSec/(float64(speedMbps)*1e6), 1)
+}
+
+// linkState caches whether any included interface is up, rereading
+// operstate at most once per interval, and likewise the bonds and the link
+// speed, which sysfs would otherwise be read for every tick
+type linkState struct {
+	root     string
+	interval time.Duration
+	checked  time.Time
+	up       bool
+
+	bondsChecked time.Time
+	bonds        map[string][]string
+	speedChecked time.Time
+	speed        int
+}
+
+func newLinkState() *linkState {
+	return &linkState{root: "/", interval: linkStateInterval}
+}
+
+// Up reports whether any included interface is up as of the last check
+func (l *linkState) Up(now time.Time, ifaces, excludePrefixes []string) bool {
+	if l.checked.IsZero() || now.Sub(l.checked) >= l.interval {
+		up := readLinkUp(l.root, ifaces, excludePrefixes)
+		if !l.checked.IsZero() && up != l.up {
+			slog.Info("LAN link state changed", "up", up)
+		}
+		l.up = up
+		l.checked = now
+	}
+	return l.up
+}
+
+// Bonds returns the bonds and their members as of the last check
+func (l *linkState) Bonds(now time.Time) map[string][]string {
+	if l.bondsChecked.IsZero() || now.Sub(l.bondsChecked) >= l.interval {
+		l.bonds = readBonds(l.root)
+		l.bondsChecked = now
+	}
+	return l.bonds
+}
+
+// Speed returns the summed link speed of the included interfaces as of the
+// last check, see readLinkSpeed
+func (l *linkState) Speed(now time.Time, ifaces, excludePrefixes []string, bondCount string) int {
+	if l.speedChecked.IsZero() || now.Sub(l.speedChecked) >= l.interval {
+		l.speed = readLinkSpeed(l.root, l.Bonds(now), ifaces, excludePrefixes, bondCount)
+		l.speedChecked = now
+	}
+	return l.speed
+}
+
+// readLinkUp reports whether any included interface under root has an
+// operstate of up, or unknown with carrier, as some drivers report. When
+// /sys/class/net can't be read the link is assumed up.
+func readLinkUp(root string, ifaces, excludePrefixes []string) bool {
+	netDir := filepath.Join(root, "sys/class/net")
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return true
+	}
+	for _, entry := range entries {
+		iface := entry.Name()
+		if !includeInterface(iface, ifaces, excludePrefixes) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(netDir, iface, "operstate"))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(string(data)) {
+		case "up":
+			return true
+		case "unknown":
+			if carrier, err := os.ReadFile(filepath.Join(netDir, iface, "carrier")); err == nil && strings.TrimSpace(string(carrier)) == "1" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestIncludeInterface(t *testing.T) {
+	tests := []struct {
+		iface   string
+		ifaces  []string
+		exclude []string
+		want    bool
+	}{
+		{"eno1", nil, defaultNetworkExcludePrefixes, true},
+		{"lo", nil, defaultNetworkExcludePrefixes, false},
+		{"veth1234", nil, defaultNetworkExcludePrefixes, false},
+		{"docker0", nil, defaultNetworkExcludePrefixes, false},
+		{"docker0", nil, []string{}, true},
+		{"eno1", []string{"eno2"}, defaultNetworkExcludePrefixes, false},
+		{"eno2", []string{"eno2"}, defaultNetworkExcludePrefixes, true},
+		{"veth1234", []string{"veth1234"}, defaultNetworkExcludePrefixes, true},
+		{"eth1", []string{"eth*"}, nil, true},
+		{"bond0", []string{"eth*"}, nil, false},
+		{"enp3s0", nil, []string{"enp?s0"}, false},
+		{"enp3s1", nil, []string{"enp?s0"}, true},
+	}
+	for _, tt := range tests {
+		if got := includeInterface(tt.iface, tt.ifaces, tt.exclude); got != tt.want {
+			t.Errorf("includeInterface(%q, %v, %v) = %v, want %v", tt.iface, tt.ifaces, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+const sampleNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 8841931   54321    0    0    0     0          0         0  8841931   54321    0    0    0     0       0          0
+enp2s0: 987654321 765432    0   12    0     0          0      4321 123456789  234567    0    0    0     0       0          0
+vethab12cd: 2048      16    0    0    0     0          0         0     4096      32    0    0    0     0       0          0
+`
+
+func TestParseNetDev(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifaces  []string
+		exclude []string
+		want    map[string]NetActivity
+	}{
+		{"default excludes", nil, defaultNetworkExcludePrefixes, map[string]NetActivity{
+			"enp2s0": {RxBytes: 987654321, TxBytes: 123456789},
+		}},
+		{"no excludes", nil, nil, map[string]NetActivity{
+			"lo":         {RxBytes: 8841931, TxBytes: 8841931},
+			"enp2s0":     {RxBytes: 987654321, TxBytes: 123456789},
+			"vethab12cd": {RxBytes: 2048, TxBytes: 4096},
+		}},
+		{"explicit interface", []string{"vethab12cd"}, defaultNetworkExcludePrefixes, map[string]NetActivity{
+			"vethab12cd": {RxBytes: 2048, TxBytes: 4096},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := parseNetDev([]byte(sampleNetDev), tt.ifaces, tt.exclude, nil)
+		if err != nil {
+			t.Fatalf("%s: parseNetDev() error: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseNetDev() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// sampleBondNetDev has bond0 over eth0 and eth1, which report the same
+// traffic split between them
+const sampleBondNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 8841931   54321    0    0    0     0          0         0  8841931   54321    0    0    0     0       0          0
+  eth0: 600000    600    0    0    0     0          0         0   200000     200    0    0    0     0       0          0
+  eth1: 400000    400    0    0    0     0          0         0   100000     100    0    0    0     0       0          0
+ bond0: 1000000  1000    0    0    0     0          0         0   300000     300    0    0    0     0       0          0
+`
+
+func TestParseNetDevBond(t *testing.T) {
+	root := t.TempDir()
+	for _, iface := range []string{"lo", "eth0", "eth1", "bond0"} {
+		if err := os.MkdirAll(filepath.Join(root, "sys/class/net", iface), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "sys/class/net/bond0/bonding"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sys/class/net/bond0/bonding/slaves"), []byte("eth0 eth1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bonds := readBonds(root)
+	if !reflect.DeepEqual(bonds, map[string][]string{"bond0": {"eth0", "eth1"}}) {
+		t.Fatalf("readBonds() = %v", bonds)
+	}
+
+	tests := []struct {
+		name   string
+		ifaces []string
+		count  string
+		want   map[string]NetActivity
+	}{
+		{"bond only", nil, NetworkBondCountBond, map[string]NetActivity{
+			"bond0": {RxBytes: 1000000, TxBytes: 300000},
+		}},
+		{"members only", nil, NetworkBondCountMembers, map[string]NetActivity{
+			"eth0": {RxBytes: 600000, TxBytes: 200000},
+			"eth1": {RxBytes: 400000, TxBytes: 100000},
+		}},
+		// a member listed without its bond still counts
+		{"explicit member", []string{"eth0"}, NetworkBondCountBond, map[string]NetActivity{
+			"eth0": {RxBytes: 600000, TxBytes: 200000},
+		}},
+	}
+	for _, tt := range tests {
+		skip := bondSkips(bonds, tt.ifaces, defaultNetworkExcludePrefixes, tt.count)
+		got, err := parseNetDev([]byte(sampleBondNetDev), tt.ifaces, defaultNetworkExcludePrefixes, skip)
+		if err != nil {
+			t.Fatalf("%s: parseNetDev() error: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseNetDev() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseNetDevMalformed(t *testing.T) {
+	for _, line := range []string{
+		"eth0: 100 1 0 0\n",
+		"eth0: 100 1 0 0 0 0 0 0 x 1 0 0 0 0 0 0\n",
+		"eth0: -5 1 0 0 0 0 0 0 100 1 0 0 0 0 0 0\n",
+	} {
+		// The bad line is skipped and the others still counted
+		data := line + "eth1: 100 1 0 0 0 0 0 0 200 1 0 0 0 0 0 0\n"
+		got, err := parseNetDev([]byte(data), nil, nil, nil)
+		var malformed *malformedNetDevError
+		if !errors.As(err, &malformed) || !slices.Equal(malformed.ifaces, []string{"eth0"}) {
+			t.Errorf("%q: expected eth0 reported malformed, got %v", line, err)
+		}
+		if want := map[string]NetActivity{"eth1": {RxBytes: 100, TxBytes: 200}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: parseNetDev() = %v, want %v", line, got, want)
+		}
+	}
+	// Malformed lines of excluded interfaces don't matter
+	if _, err := parseNetDev([]byte("lo: 100\n"), nil, defaultNetworkExcludePrefixes, nil); err != nil {
+		t.Errorf("expected an excluded malformed line to be skipped, got %v", err)
+	}
+}
+
+func TestReadNetworkTotalsMalformed(t *testing.T) {
+	am := newTestMonitor(t)
+	am.netTotals = func(ifaces, excludePrefixes []string, bondCount string) (uint64, uint64, error) {
+		return 100, 200, &malformedNetDevError{ifaces: []string{"eth0"}, errs: []error{errors.New("bad line")}}
+	}
+	for range 2 {
+		rx, tx, skipped, err := am.readNetworkTotals(&Config{})
+		if rx != 100 || tx != 200 || skipped != "eth0" || err != nil {
+			t.Errorf("readNetworkTotals() = %d, %d, %q, %v, want the other totals and eth0 skipped", rx, tx, skipped, err)
+		}
+	}
+	if ok, suppressed := am.netErrors.allow(0, time.Now()); ok || suppressed != 0 {
+		t.Error("expected the repeated warning suppressed")
+	}
+}
+
+func TestParseLinkSpeed(t *testing.T) {
+	tests := []struct {
+		data string
+		want int
+		ok   bool
+	}{
+		{"1000\n", 1000, true},
+		{"10000\n", 10000, true},
+		{"-1\n", 0, false}, // link down
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseLinkSpeed([]byte(tt.data))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseLinkSpeed(%q) = %d, %v, want %d, %v", tt.data, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLinkLevel(t *testing.T) {
+	const second = time.Second
+	tests := []struct {
+		name     string
+		rx, tx   uint64
+		interval time.Duration
+		speed    int
+		want     float64
+	}{
+		{"saturated 1GbE", 125_000_000, 0, second, 1000, 1},
+		{"half 1GbE", 62_500_000, 0, second, 1000, 0.5},
+		{"tx direction counts", 1_000_000, 62_500_000, second, 1000, 0.5},
+		{"1GbE over 100ms", 1_250_000, 0, 100 * time.Millisecond, 1000, 0.1},
+		{"10GbE", 125_000_000, 0, second, 10000, 0.1},
+		{"saturated 10GbE", 1_250_000_000, 0, second, 10000, 1},
+		{"above link speed", 500_000_000, 0, second, 1000, 1},
+		{"unknown speed", 125_000_000, 0, second, 0, 0},
+	}
+	for _, tt := range tests {
+		got := linkLevel(tt.rx, tt.tx, tt.interval, tt.speed)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: linkLevel() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if b := brightnessForLevel(linkLevel(125_000_000, 0, second, 1000)); b != maxActiveBrightness {
+		t.Errorf("expected a saturated link at full brightness, got %d", b)
+	}
+}
+
+func writeOperstate(t *testing.T, root, iface, state, carrier string) {
+	t.Helper()
+	dir := filepath.Join(root, "sys/class/net", iface)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "operstate"), []byte(state+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if carrier != "" {
+		if err := os.WriteFile(filepath.Join(dir, "carrier"), []byte(carrier+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadLinkUp(t *testing.T) {
+	root := t.TempDir()
+	writeOperstate(t, root, "lo", "unknown", "1")
+	writeOperstate(t, root, "eno1", "down", "")
+	writeOperstate(t, root, "eno2", "down", "")
+	if readLinkUp(root, nil, defaultNetworkExcludePrefixes) {
+		t.Error("expected link down with only loopback up")
+	}
+
+	writeOperstate(t, root, "eno2", "up", "")
+	if !readLinkUp(root, nil, defaultNetworkExcludePrefixes) {
+		t.Error("expected link up with eno2 up")
+	}
+	if readLinkUp(root, []string{"eno1"}, defaultNetworkExcludePrefixes) {
+		t.Error("expected link down when limited to eno1")
+	}
+
+	writeOperstate(t, root, "eno1", "unknown", "1")
+	if !readLinkUp(root, []string{"eno1"}, defaultNetworkExcludePrefixes) {
+		t.Error("expected unknown operstate with carrier to count as up")
+	}
+	if !readLinkUp(filepath.Join(root, "missing"), nil, nil) {
+		t.Error("expected link assumed up without /sys/class/net")
+	}
+}
+
+func TestLinkStateRefresh(t *testing.T) {
+	root := t.TempDir()
+	writeOperstate(t, root, "eno1", "up", "")
+	link := &linkState{root: root, interval: linkStateInterval}
+	start := time.Now()
+	if !link.Up(start, nil, nil) {
+		t.Fatal("expected link up")
+	}
+
+	writeOperstate(t, root, "eno1", "down", "")
+	if !link.Up(start.Add(linkStateInterval/2), nil, nil) {
+		t.Error("expected cached link state before the refresh interval")
+	}
+	if link.Up(start.Add(linkStateInterval), nil, nil) {
+		t.Error("expected link down after the refresh interval")
+	}
+}
+
+func TestLinkSpeedRefresh(t *testing.T) {
+	root := t.TempDir()
+	writeSpeed := func(speed string) {
+		dir := filepath.Join(root, "sys/class/net", "eno1")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "speed"), []byte(speed+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSpeed("1000")
+	link := &linkState{root: root, interval: linkStateInterval}
+	start := time.Now()
+	if got := link.Speed(start, nil, nil, NetworkBondCountBond); got != 1000 {
+		t.Fatalf("expected 1000 Mbit/s, got %d", got)
+	}
+
+	writeSpeed("10000")
+	if got := link.Speed(start.Add(linkStateInterval/2), nil, nil, NetworkBondCountBond); got != 1000 {
+		t.Errorf("expected the cached speed before the refresh interval, got %d", got)
+	}
+	if got := link.Speed(start.Add(linkStateInterval), nil, nil, NetworkBondCountBond); got != 10000 {
+		t.Errorf("expected 10000 Mbit/s after the refresh interval, got %d", got)
+	}
+}
+
+func TestLanDisplayFor(t *testing.T) {
+	tests := []struct {
+		name                 string
+		linkUp, active, idle bool
+		want                 lanDisplay
+	}{
+		{"down", false, false, true, lanDisplayDown},
+		{"down with stale traffic", false, true, false, lanDisplayDown},
+		{"up idle", true, false, true, lanDisplayIdle},
+		{"up active", true, true, false, lanDisplayActive},
+		{"up short gap", true, false, false, lanDisplayHold},
+	}
+	for _, tt := range tests {
+		if got := lanDisplayFor(tt.linkUp, tt.active, tt.idle); got != tt.want {
+			t.Errorf("%s: lanDisplayFor(%v, %v, %v) = %v, want %v", tt.name, tt.linkUp, tt.active, tt.idle, got, tt.want)
+		}
+	}
+}
+
+func TestShowLanIdle(t *testing.T) {
+	brightness := byte(32)
+	tests := []struct {
+		mode     string
+		wantMode string
+		wantOn   bool
+	}{
+		{LanIdleModeOff, "off", false},
+		{LanIdleModeOn, "on", true},
+		{LanIdleModeBreath, "breath", true},
+	}
+	for _, tt := range tests {
+		am := newTestMonitor(t)
+		// Start from an active LAN LED, as after traffic stops
+		if err := am.leds.SetLedMode(lanLedIndex, leds.LedModeBlink, leds.BreathParams(200)); err != nil {
+			t.Fatal(err)
+		}
+		conf := &Config{LanIdleMode: tt.mode, LanIdleBrightness: &brightness, IdleBreathPeriod: defaultIdleBreathPeriod}
+		am.showLanIdle(conf, 3)
+		states := am.leds.LedStates()
+		if len(states) != 1 || states[0].Index != lanLedIndex {
+			t.Fatalf("%s: expected only the LAN LED written, got %+v", tt.mode, states)
+		}
+		state := states[0]
+		if state.Mode != tt.wantMode {
+			t.Errorf("%s: LAN LED mode = %q, want %q", tt.mode, state.Mode, tt.wantMode)
+		}
+		if tt.wantOn && (state.Brightness != brightness || [3]byte{state.R, state.G, state.B} != [3]byte{255, 255, 255}) {
+			t.Errorf("%s: LAN LED = %+v, want white at %d", tt.mode, state, brightness)
+		}
+	}
+}
+
+func BenchmarkParseNetDev(b *testing.B) {
+	data := []byte(sampleBondNetDev)
+	b.ReportAllocs()
+	for b.Loop() {
+		parseNetDev(data, nil, defaultNetworkExcludePrefixes, nil)
+	}
+}
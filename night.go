@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// defaultNightMaxBrightness is the night brightness cap when night_start and
+// night_end are set without night_max_brightness
+const defaultNightMaxBrightness = 32
+
+// defaultAmbientBrightness is the brightness cap after ambient_timeout
+// without activity when ambient_brightness is unset
+const defaultAmbientBrightness = 16
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inNightWindow reports whether minute (after midnight) falls in the window
+// from start up to end, which wraps past midnight when end is before start.
+// An empty window (start == end) contains nothing.
+func inNightWindow(minute, start, end int) bool {
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// brightnessCap returns the highest LED brightness allowed at now:
+// night_max_brightness during the night window, otherwise max_brightness
+func (c *Config) brightnessCap(now time.Time) byte {
+	if c.nightWindow != nil {
+		minute := now.Hour()*60 + now.Minute()
+		if inNightWindow(minute, c.nightWindow[0], c.nightWindow[1]) {
+			return min(c.NightMaxBrightness, c.MaxBrightness)
+		}
+	}
+	return c.MaxBrightness
+}
+
+// noteActivity records whether the disks or network were active at now, for
+// the ambient dimming in updateBrightnessCap
+func (am *ActivityMonitor) noteActivity(now time.Time, active bool) {
+	if active || am.lastActivity.IsZero() {
+		am.lastActivity = now
+	}
+}
+
+// updateBrightnessCap sets the cap applied to every LED brightness write for
+// the time now. After ambient_timeout without activity every LED is also
+// dimmed to ambient_brightness, until noteActivity records activity again.
+func (am *ActivityMonitor) updateBrightnessCap(conf *Config, now time.Time) {
+	limit := conf.brightnessCap(now)
+	ambient := conf.AmbientTimeout > 0 && !am.lastActivity.IsZero() && now.Sub(am.lastActivity) >= conf.AmbientTimeout
+	if ambient {
+		limit = min(limit, conf.AmbientBrightness)
+	}
+	if ambient != am.ambient {
+		slog.Info("ambient dimming", "enabled", ambient, "brightness", conf.AmbientBrightness)
+		am.ambient = ambient
+	}
+	am.brightnessCap.Store(uint32(limit))
+}
+
+// capBrightness limits brightness to the cap set by updateBrightnessCap, if any
+func (am *ActivityMonitor) capBrightness(brightness byte) byte {
+	if c := am.brightnessCap.Load(); c > 0 {
+		return min(brightness, byte(c))
+	}
+	return brightness
+}
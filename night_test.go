@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestInNightWindow(t *testing.T) {
+	clock := func(s string) int {
+		t.Helper()
+		m, err := parseClock(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	tests := []struct {
+		now, start, end string
+		want            bool
+	}{
+		// Same-day window
+		{"00:59", "01:00", "06:00", false},
+		{"01:00", "01:00", "06:00", true},
+		{"05:59", "01:00", "06:00", true},
+		{"06:00", "01:00", "06:00", false},
+		// Wrapping past midnight
+		{"21:59", "22:00", "07:00", false},
+		{"22:00", "22:00", "07:00", true},
+		{"23:59", "22:00", "07:00", true},
+		{"00:00", "22:00", "07:00", true},
+		{"06:59", "22:00", "07:00", true},
+		{"07:00", "22:00", "07:00", false},
+		{"12:00", "22:00", "07:00", false},
+		// Empty window
+		{"22:00", "22:00", "22:00", false},
+	}
+	for _, tt := range tests {
+		if got := inNightWindow(clock(tt.now), clock(tt.start), clock(tt.end)); got != tt.want {
+			t.Errorf("%s in %s-%s = %v, want %v", tt.now, tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	if m, err := parseClock("23:45"); err != nil || m != 23*60+45 {
+		t.Errorf("parseClock(23:45) = %d, %v; want %d", m, err, 23*60+45)
+	}
+	for _, s := range []string{"24:00", "7pm", "12:60", ""} {
+		if _, err := parseClock(s); err == nil {
+			t.Errorf("parseClock(%q): expected an error", s)
+		}
+	}
+}
+
+func TestBrightnessCapConfig(t *testing.T) {
+	log.SetFlags(log.Lshortfile | log.LstdFlags)
+
+	f, err := os.CreateTemp("", "testconfig-*.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("max_brightness: 200\nnight_start: \"22:30\"\nnight_end: \"06:15\"\nnight_max_brightness: 20\n")
+	f.Close()
+
+	loader, err := NewConfigLoader(f.Name())
+	if err != nil {
+		t.Fatalf("failed to create config loader: %v", err)
+	}
+	cfg := loader.Config()
+	if cfg == nil {
+		t.Fatal("expected valid config")
+	}
+	day := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		at   time.Time
+		want byte
+	}{
+		{day(22, 29), 200},
+		{day(22, 30), 20},
+		{day(0, 0), 20},
+		{day(6, 14), 20},
+		{day(6, 15), 200},
+	}
+	for _, tt := range tests {
+		if got := cfg.brightnessCap(tt.at); got != tt.want {
+			t.Errorf("brightnessCap(%s) = %d, want %d", tt.at.Format("15:04"), got, tt.want)
+		}
+	}
+
+	for _, yaml := range []string{"night_start: \"22:00\"\n", "night_start: \"10pm\"\nnight_end: \"06:00\"\n"} {
+		f, err := os.CreateTemp("", "testconfig-*.yaml")
+		if err != nil {
+			t.Fatalf("failed to create temp file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(yaml)
+		f.Close()
+		loader, err := NewConfigLoader(f.Name())
+		if err != nil {
+			t.Fatalf("failed to create config loader: %v", err)
+		}
+		if loader.Config() != nil {
+			t.Errorf("%q: expected config to be rejected", yaml)
+		}
+	}
+}
+
+func TestCapBrightness(t *testing.T) {
+	am := newTestMonitor(t)
+	am.setLedBrightness(firstDiskLedIndex, 255)
+	if got := am.leds.LedStates()[0].Brightness; got != 255 {
+		t.Errorf("uncapped brightness = %d, want 255", got)
+	}
+
+	conf := &Config{MaxBrightness: 100}
+	am.updateBrightnessCap(conf, time.Now())
+	am.setLedBrightness(firstDiskLedIndex, 255)
+	am.setLedBrightness(lanLedIndex, 50)
+	for _, state := range am.leds.LedStates() {
+		want := byte(100)
+		if state.Index == lanLedIndex {
+			want = 50
+		}
+		if state.Brightness != want {
+			t.Errorf("%s brightness = %d, want %d", state.Name, state.Brightness, want)
+		}
+	}
+}
+
+func TestAmbientDimming(t *testing.T) {
+	idleBrightness := byte(100)
+	conf := &Config{
+		PollInterval:      100 * time.Millisecond,
+		IdleMode:          IdleModeSolid,
+		IdleTicks:         1,
+		IdleBrightness:    &idleBrightness,
+		idleColor:         [3]byte{255, 255, 255},
+		MaxBrightness:     255,
+		AmbientTimeout:    time.Minute,
+		AmbientBrightness: 10,
+	}
+	disk := DiskInfo{Name: "sda"}
+	am := newTestMonitor(t, disk)
+	am.maxActivity = 1000
+	brightness := func() byte {
+		for _, state := range am.leds.LedStates() {
+			if state.Index == firstDiskLedIndex {
+				return state.Brightness
+			}
+		}
+		return 0
+	}
+	tick := func(now time.Time, delta DiskActivity) {
+		am.noteActivity(now, delta.Activity > 0)
+		am.updateBrightnessCap(conf, now)
+		am.updateDiskLed(conf, now, conf.PollInterval, firstDiskLedIndex, disk, delta, true, DiskMetrics{}, 0)
+	}
+
+	start := time.Now()
+	am.noteActivity(start, false)
+	tick(start, DiskActivity{})
+	if got := brightness(); got != idleBrightness {
+		t.Fatalf("idle brightness = %d, want %d", got, idleBrightness)
+	}
+
+	tick(start.Add(59*time.Second), DiskActivity{})
+	if got := brightness(); got != idleBrightness {
+		t.Errorf("brightness before ambient_timeout = %d, want %d", got, idleBrightness)
+	}
+	tick(start.Add(time.Minute), DiskActivity{})
+	if got := brightness(); got != conf.AmbientBrightness {
+		t.Errorf("brightness after ambient_timeout = %d, want %d", got, conf.AmbientBrightness)
+	}
+
+	// A burst restores full brightness on the same tick
+	burst := start.Add(2 * time.Minute)
+	tick(burst, DiskActivity{Writes: 1000, Activity: 1000})
+	if got := brightness(); got != brightnessForLevel(1) {
+		t.Errorf("brightness during burst = %d, want %d", got, brightnessForLevel(1))
+	}
+	tick(burst.Add(time.Second), DiskActivity{})
+	if got := brightness(); got != idleBrightness {
+		t.Errorf("idle brightness after burst = %d, want %d", got, idleBrightness)
+	}
+	tick(burst.Add(time.Minute), DiskActivity{})
+	if got := brightness(); got != conf.AmbientBrightness {
+		t.Errorf("brightness after another ambient_timeout = %d, want %d", got, conf.AmbientBrightness)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+const (
+	// maxOverrideTTL caps how long an override can hold an LED
+	maxOverrideTTL = 24 * time.Hour
+
+	// maxOverrideBody caps the size of a POST /override request
+	maxOverrideBody = 64 << 10
+)
+
+// LedOverride is one entry of a POST /override request, e.g.
+// {"led": "disk1", "mode": "blink", "color": "#FF0000", "ttl_seconds": 30}
+type LedOverride struct {
+	Led        string  `json:"led"`
+	Mode       string  `json:"mode"`
+	Color      string  `json:"color,omitempty"` // "#RRGGBB" or "r,g,b"; unset keeps the LED's color
+	Brightness *byte   `json:"brightness,omitempty"`
+	TTLSeconds float64 `json:"ttl_seconds"`
+}
+
+// parse validates the override and returns its LED setting and lifetime
+func (o LedOverride) parse() (LedSetting, time.Duration, error) {
+	id, err := ledIndexByName(o.Led)
+	if err != nil {
+		return LedSetting{}, 0, err
+	}
+	mode, ok := ledModeNames[strings.ToLower(o.Mode)]
+	if !ok {
+		return LedSetting{}, 0, fmt.Errorf("unknown mode %q (valid: off, on, blink, breath)", o.Mode)
+	}
+	setting := LedSetting{ID: id, Mode: mode, Brightness: o.Brightness, PeriodMs: defaultSetPeriodMs}
+	if o.Color != "" {
+		color, err := parseColor(o.Color)
+		if err != nil {
+			return LedSetting{}, 0, err
+		}
+		setting.Color = &color
+	}
+	ttl := time.Duration(o.TTLSeconds * float64(time.Second))
+	if ttl <= 0 || ttl > maxOverrideTTL {
+		return LedSetting{}, 0, fmt.Errorf("invalid ttl_seconds %v for %s: must be above 0 and at most %.0f", o.TTLSeconds, o.Led, maxOverrideTTL.Seconds())
+	}
+	return setting, ttl, nil
+}
+
+type ledOverride struct {
+	setting LedSetting
+	until   time.Time
+	saved   leds.SavedLed // the LED before the override, restored when it expires
+}
+
+// overrideMap holds the active LED overrides by LED index. The zero value
+// is empty and ready to use.
+type overrideMap struct {
+	mu        sync.Mutex
+	overrides map[int]ledOverride
+}
+
+// set overrides an LED until the given time, replacing any earlier override.
+// saved is the LED's state to restore when the override expires; a
+// replaced override keeps the state from before it.
+func (m *overrideMap) set(setting LedSetting, until time.Time, saved leds.SavedLed) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.overrides == nil {
+		m.overrides = make(map[int]ledOverride)
+	}
+	if o, ok := m.overrides[setting.ID]; ok {
+		saved = o.saved
+	}
+	m.overrides[setting.ID] = ledOverride{setting: setting, until: until, saved: saved}
+}
+
+// has reports whether an LED is overridden
+func (m *overrideMap) has(id int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.overrides[id]
+	return ok
+}
+
+// active drops the overrides expired by now and returns the rest, and the
+// expired ones, by LED index
+func (m *overrideMap) active(now time.Time) (settings []LedSetting, expired []ledOverride) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, o := range m.overrides {
+		if !now.Before(o.until) {
+			delete(m.overrides, id)
+			expired = append(expired, o)
+			continue
+		}
+		settings = append(settings, o.setting)
+	}
+	slices.SortFunc(settings, func(a, b LedSetting) int { return a.ID - b.ID })
+	slices.SortFunc(expired, func(a, b ledOverride) int { return a.setting.ID - b.setting.ID })
+	return settings, expired
+}
+
+func (m *overrideMap) clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.overrides)
+}
+
+// applyOverrides writes the active overrides at the start of a tick, and
+// restores the LEDs of expired ones to their state from before. The
+// monitor's own writes skip overridden LEDs, so an LED it drives resumes
+// showing activity on the first tick after its override expires; one it
+// doesn't drive, such as the power LED with power_led_mode off, keeps the
+// restored state.
+func (am *ActivityMonitor) applyOverrides(now time.Time) {
+	settings, expired := am.overrides.active(now)
+	for _, o := range expired {
+		am.logLedError(o.setting.ID, am.leds.RestoreLed(o.setting.ID, o.saved))
+	}
+	for _, setting := range settings {
+		am.logLedError(setting.ID, applyLedSetting(am.leds, setting))
+	}
+}
+
+// overrideOrigin rejects cross-site override requests. A browser sends the
+// JSON content type only after a CORS preflight, which the endpoint never
+// answers, so a page on another site can't drive the LEDs with a simple form
+// or text/plain POST.
+var overrideOrigin = http.NewCrossOriginProtection()
+
+// handleOverride accepts a JSON list of LED overrides. The list is applied
+// only if every entry is valid, from the next tick.
+func (am *ActivityMonitor) handleOverride(w http.ResponseWriter, r *http.Request) {
+	if err := overrideOrigin.Check(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "override request must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var overrides []LedOverride
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOverrideBody)).Decode(&overrides); err != nil {
+		http.Error(w, fmt.Sprintf("invalid override request: %v", err), http.StatusBadRequest)
+		return
+	}
+	settings := make([]LedSetting, len(overrides))
+	ttls := make([]time.Duration, len(overrides))
+	for i, o := range overrides {
+		var err error
+		if settings[i], ttls[i], err = o.parse(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	controller := am.controller()
+	if controller == nil {
+		http.Error(w, "LED controller not open", http.StatusServiceUnavailable)
+		return
+	}
+	now := time.Now()
+	for i, setting := range settings {
+		am.overrides.set(setting, now.Add(ttls[i]), controller.SaveLed(setting.ID))
+		log.Printf("Overriding %s for %s", overrides[i].Led, ttls[i])
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestLedOverrideParse(t *testing.T) {
+	brightness := byte(200)
+	setting, ttl, err := LedOverride{Led: "disk2", Mode: "blink", Color: "#FF0000", Brightness: &brightness, TTLSeconds: 1.5}.parse()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if setting.ID != firstDiskLedIndex+1 || setting.Mode != leds.LedModeBlink || *setting.Color != [3]byte{255, 0, 0} || *setting.Brightness != 200 || ttl != 1500*time.Millisecond {
+		t.Errorf("unexpected setting %+v, ttl %s", setting, ttl)
+	}
+
+	tests := []struct {
+		override LedOverride
+		want     string
+	}{
+		{LedOverride{Led: "fan", Mode: "on", TTLSeconds: 1}, "unknown LED"},
+		{LedOverride{Led: "disk1", Mode: "dim", TTLSeconds: 1}, "unknown mode"},
+		{LedOverride{Led: "disk1", Mode: "on", Color: "red", TTLSeconds: 1}, "invalid color"},
+		{LedOverride{Led: "disk1", Mode: "on"}, "invalid ttl_seconds"},
+		{LedOverride{Led: "disk1", Mode: "on", TTLSeconds: 90000}, "invalid ttl_seconds"},
+	}
+	for _, tt := range tests {
+		if _, _, err := tt.override.parse(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parse(%+v) error = %v, want containing %q", tt.override, err, tt.want)
+		}
+	}
+}
+
+func TestOverrideMapExpiry(t *testing.T) {
+	var m overrideMap
+	now := time.Now()
+	m.set(LedSetting{ID: 3}, now.Add(time.Second), leds.SavedLed{})
+	m.set(LedSetting{ID: 2}, now.Add(2*time.Second), leds.SavedLed{})
+
+	if got, expired := m.active(now); len(got) != 2 || got[0].ID != 2 || got[1].ID != 3 || len(expired) != 0 {
+		t.Errorf("active = %+v, want LEDs 2 and 3", got)
+	}
+	if got, expired := m.active(now.Add(time.Second)); len(got) != 1 || got[0].ID != 2 || len(expired) != 1 || expired[0].setting.ID != 3 {
+		t.Errorf("active after 1s = %+v, expired %+v, want LED 2 and LED 3 expired", got, expired)
+	}
+	if m.has(3) {
+		t.Error("expired override still held")
+	}
+	if got, _ := m.active(now.Add(2 * time.Second)); len(got) != 0 {
+		t.Errorf("active after 2s = %+v, want none", got)
+	}
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	conf := &Config{PollInterval: 50 * time.Millisecond, IdleTicks: 1}
+	disk := DiskInfo{Name: "sda"}
+	am := newTestMonitor(t, disk)
+	am.maxActivity = 1000
+	ledState := func() leds.LedState {
+		for _, state := range am.leds.LedStates() {
+			if state.Index == firstDiskLedIndex {
+				return state
+			}
+		}
+		return leds.LedState{}
+	}
+	tick := func(now time.Time) {
+		am.applyOverrides(now)
+		am.updateDiskLed(conf, now, conf.PollInterval, firstDiskLedIndex, disk, DiskActivity{Writes: 1000, Activity: 1000}, true, DiskMetrics{}, 0)
+	}
+
+	red, brightness := [3]byte{255, 0, 0}, byte(99)
+	now := time.Now()
+	am.overrides.set(LedSetting{ID: firstDiskLedIndex, Mode: leds.LedModeBlink, Color: &red, Brightness: &brightness, PeriodMs: defaultSetPeriodMs}, now.Add(time.Second), am.leds.SaveLed(firstDiskLedIndex))
+	tick(now)
+	if state := ledState(); state.Mode != "blink" || [3]byte{state.R, state.G, state.B} != red || state.Brightness != brightness {
+		t.Errorf("overridden LED = %+v, want blinking red at %d", state, brightness)
+	}
+
+	tick(now.Add(time.Second))
+	r, g, b := colorForActivity(0, 1000, 1, 0, conf.colorOptions())
+	if state := ledState(); state.Mode != "on" || [3]byte{state.R, state.G, state.B} != [3]byte{r, g, b} {
+		t.Errorf("LED after expiry = %+v, want activity color %v", state, [3]byte{r, g, b})
+	}
+}
+
+func TestOverrideRestore(t *testing.T) {
+	am := newTestMonitor(t)
+	powerLed := func() leds.LedState {
+		for _, state := range am.leds.LedStates() {
+			if state.Index == powerLedIndex {
+				return state
+			}
+		}
+		return leds.LedState{}
+	}
+	// The power LED as the firmware left it, never written by the monitor,
+	// so its state is read back
+	green, brightness := [3]byte{0, 255, 0}, byte(40)
+	transport := leds.NewFakeTransport()
+	transport.SetStatus(powerLedIndex, leds.LedStatus{Available: true, OpMode: "on", Brightness: brightness, ColorG: 255})
+	am.leds = leds.NewUGreenLedsWithTransport(transport)
+	saved := am.leds.SaveLed(powerLedIndex)
+
+	red, blue := [3]byte{255, 0, 0}, [3]byte{0, 0, 255}
+	now := time.Now()
+	am.overrides.set(LedSetting{ID: powerLedIndex, Mode: leds.LedModeBlink, Color: &red, PeriodMs: defaultSetPeriodMs}, now.Add(time.Second), saved)
+	am.applyOverrides(now)
+	// A replacing override restores the state from before the first
+	am.overrides.set(LedSetting{ID: powerLedIndex, Mode: leds.LedModeBreath, Color: &blue, PeriodMs: defaultSetPeriodMs}, now.Add(time.Second), am.leds.SaveLed(powerLedIndex))
+	am.applyOverrides(now)
+	if state := powerLed(); state.Mode != "breath" || [3]byte{state.R, state.G, state.B} != blue {
+		t.Errorf("overridden power LED = %+v, want breathing blue", state)
+	}
+
+	am.applyOverrides(now.Add(time.Second))
+	if state := powerLed(); state.Mode != "on" || [3]byte{state.R, state.G, state.B} != green || state.Brightness != brightness {
+		t.Errorf("power LED after expiry = %+v, want restored green at %d", state, brightness)
+	}
+}
+
+func TestHandleOverride(t *testing.T) {
+	am := newTestMonitor(t)
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/override", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		am.statusHandler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(`[{"led": "disk1", "mode": "on", "ttl_seconds": 10}, {"led": "fan", "mode": "on", "ttl_seconds": 10}]`); code != http.StatusBadRequest {
+		t.Errorf("invalid entry: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if am.overrides.has(firstDiskLedIndex) {
+		t.Error("valid entry applied from a rejected request")
+	}
+	if code := post(`{"led": "disk1"}`); code != http.StatusBadRequest {
+		t.Errorf("non-list body: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := post(`[{"led": "disk1", "mode": "blink", "color": "255,0,0", "ttl_seconds": 10}]`); code != http.StatusNoContent {
+		t.Errorf("valid request: status %d, want %d", code, http.StatusNoContent)
+	}
+	if !am.overrides.has(firstDiskLedIndex) {
+		t.Error("override not recorded")
+	}
+}
+
+func TestHandleOverrideCrossSite(t *testing.T) {
+	body := `[{"led": "disk1", "mode": "blink", "color": "255,0,0", "ttl_seconds": 10}]`
+	tests := []struct {
+		name        string
+		contentType string
+		origin      string
+		want        int
+	}{
+		{"text/plain", "text/plain", "", http.StatusUnsupportedMediaType},
+		{"form", "application/x-www-form-urlencoded", "", http.StatusUnsupportedMediaType},
+		{"no content type", "", "", http.StatusUnsupportedMediaType},
+		{"foreign origin", "application/json", "http://evil.example", http.StatusForbidden},
+		{"same origin", "application/json; charset=utf-8", "http://example.com", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			am := newTestMonitor(t)
+			req := httptest.NewRequest("POST", "/override", strings.NewReader(body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			am.statusHandler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+			if applied := am.overrides.has(firstDiskLedIndex); applied != (tt.want == http.StatusNoContent) {
+				t.Errorf("override applied = %v", applied)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// Power LED modes
+const (
+	PowerLedModeOff   = "off"   // leave the power LED alone
+	PowerLedModeSolid = "solid" // steady green
+	PowerLedModeLoad  = "load"  // breathe green, shifting to red as load rises
+
+	PowerLedModePoolActivity = "pool_activity" // summed disk activity, updated each poll
+)
+
+const powerBreathPeriodMs = 4000
+
+// loadColor maps the 1-minute load average per CPU to a color, from green at
+// idle to red at one runnable task per core or more
+func loadColor(load1 float64, cpus int) (r, g, b byte) {
+	if cpus < 1 {
+		cpus = 1
+	}
+	perCore := load1 / float64(cpus)
+	if perCore < 0 {
+		perCore = 0
+	}
+	if perCore > 1 {
+		perCore = 1
+	}
+	return hsvToRgb((1-perCore)*120.0/360.0, 1.0, 1.0)
+}
+
+// parseLoadAvg returns the 1-minute load average from /proc/loadavg data
+func parseLoadAvg(data []byte) (float64, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) < 1 {
+		return 0, fmt.Errorf("empty loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+func readLoadAvg() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	return parseLoadAvg(data)
+}
+
+// updatePowerLed applies one power LED update for the configured mode
+func (am *ActivityMonitor) updatePowerLed(conf *Config) {
+	switch conf.PowerLedMode {
+	case PowerLedModeSolid:
+		r, g, b := loadColor(0, 1)
+		am.setLedColor(powerLedIndex, r, g, b)
+		am.setLedBrightness(powerLedIndex, *conf.PowerLedBrightness)
+		am.setLedMode(powerLedIndex, leds.LedModeOn, nil)
+	case PowerLedModeLoad:
+		load1, err := readLoadAvg()
+		if err != nil {
+			log.Printf("Error reading load average: %v", err)
+			return
+		}
+		r, g, b := loadColor(load1, runtime.NumCPU())
+		am.setLedColor(powerLedIndex, r, g, b)
+		am.setLedBrightness(powerLedIndex, *conf.PowerLedBrightness)
+		am.setLedMode(powerLedIndex, leds.LedModeBreath, leds.BreathParams(powerBreathPeriodMs))
+	}
+}
+
+// powerLoop updates the power LED on its own interval, following config
+// changes, until ctx is cancelled
+func (am *ActivityMonitor) powerLoop(ctx context.Context) {
+	conf := am.configLoader.Config()
+	subscriber := am.configLoader.Subscribe()
+
+	ticker := time.NewTicker(conf.PowerLedInterval)
+	defer ticker.Stop()
+	am.updatePowerLed(conf)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case newconf := <-subscriber:
+			conf = &newconf
+			ticker.Reset(conf.PowerLedInterval)
+			am.updatePowerLed(conf)
+		case <-ticker.C:
+			am.updatePowerLed(conf)
+		}
+	}
+}
+
+// poolActivity sums the activity of disks during a tick
+func poolActivity(disks []DiskInfo, deltas map[string]DiskActivity) DiskActivity {
+	var pool DiskActivity
+	for _, disk := range disks {
+		delta := deltas[disk.Name]
+		pool.Reads += delta.Reads
+		pool.Writes += delta.Writes
+		pool.Activity += delta.Activity
+	}
+	return pool
+}
+
+// updatePoolLed drives the power LED from the tick's summed disk activity,
+// colored and scaled like a disk LED against the pool's own running peak.
+// Without activity it shows steady green, as in solid mode.
+func (am *ActivityMonitor) updatePoolLed(conf *Config, now time.Time, deltas map[string]DiskActivity) {
+	pool := poolActivity(am.diskList(), deltas)
+	am.maxPoolActivity = decayPeak(am.maxPoolActivity, pool.Activity, conf.ActivityDecay)
+
+	idle := am.leds.DebounceIdle(powerLedIndex, pool.Activity > 0, conf.IdleTicks, now, conf.MinOn())
+	if pool.Activity == 0 && !idle {
+		return
+	}
+	if idle {
+		am.cancelFade(powerLedIndex)
+		r, g, b := loadColor(0, 1)
+		am.setLedColor(powerLedIndex, r, g, b)
+		am.setLedBrightness(powerLedIndex, *conf.PowerLedBrightness)
+		am.setLedMode(powerLedIndex, leds.LedModeOn, nil)
+		return
+	}
+
+	am.showPoolActivity(conf, powerLedIndex, pool, am.maxPoolActivity)
+}
+
+// updateLanDiskLed drives the LAN LED from the tick's summed disk activity,
+// for lan_led_source disk_total, scaled against the LAN LED's running peak.
+// Without activity it shows lan_idle_mode.
+func (am *ActivityMonitor) updateLanDiskLed(conf *Config, now time.Time, deltas map[string]DiskActivity, rainbowTime float64) {
+	pool := poolActivity(am.diskList(), deltas)
+	am.maxLanActivity = decayPeak(am.maxLanActivity, pool.Activity, conf.ActivityDecay)
+
+	idle := am.leds.DebounceIdle(lanLedIndex, pool.Activity > 0, conf.IdleTicks, now, conf.MinOn())
+	if pool.Activity == 0 && !idle {
+		return
+	}
+	if idle {
+		am.cancelFade(lanLedIndex)
+		am.showLanIdle(conf, rainbowTime)
+		return
+	}
+	am.showPoolActivity(conf, lanLedIndex, pool, am.maxLanActivity)
+}
+
+// showPoolActivity shows summed disk activity on an LED, colored and scaled
+// like a disk LED against maxActivity, with the same smoothing, transition,
+// and scrub cap
+func (am *ActivityMonitor) showPoolActivity(conf *Config, id int, pool DiskActivity, maxActivity uint64) {
+	level := curveLevel(conf.BrightnessCurve, pool.Activity, maxActivity, conf.BrightnessGamma)
+	r, g, b := colorForActivity(pool.Reads, pool.Writes, level, 0, conf.colorOptions())
+	brightness := am.leds.SmoothBrightness(id, brightnessForLevel(level), conf.BrightnessSmoothing)
+	am.fadeLed(id, [3]byte{r, g, b}, am.scrubCap(conf, brightness), conf.Transition(), conf.PollInterval)
+	am.setLedMode(id, leds.LedModeOn, nil)
+}
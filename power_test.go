@@ -0,0 +1,172 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestLoadColor(t *testing.T) {
+	tests := []struct {
+		load1 float64
+		cpus  int
+		want  [3]byte
+	}{
+		{0, 4, [3]byte{0, 255, 0}},     // idle: green
+		{2, 4, [3]byte{255, 255, 0}},   // half loaded: yellow
+		{4, 4, [3]byte{255, 0, 0}},     // one task per core: red
+		{12, 4, [3]byte{255, 0, 0}},    // overloaded: still red
+		{1, 0, [3]byte{255, 0, 0}},     // unknown CPU count treated as 1
+		{-1, 4, [3]byte{0, 255, 0}},    // nonsense load clamps to idle
+		{0.5, 1, [3]byte{255, 255, 0}}, // per-core, not absolute
+	}
+	for _, tt := range tests {
+		r, g, b := loadColor(tt.load1, tt.cpus)
+		if got := [3]byte{r, g, b}; got != tt.want {
+			t.Errorf("loadColor(%v, %d) = %v, want %v", tt.load1, tt.cpus, got, tt.want)
+		}
+	}
+}
+
+func TestParseLoadAvg(t *testing.T) {
+	load1, err := parseLoadAvg([]byte("1.25 0.80 0.50 2/345 6789\n"))
+	if err != nil || load1 != 1.25 {
+		t.Errorf("expected 1.25, got %v (%v)", load1, err)
+	}
+	if _, err := parseLoadAvg([]byte("")); err == nil {
+		t.Error("expected error for empty loadavg")
+	}
+}
+
+func TestPoolActivity(t *testing.T) {
+	disks := []DiskInfo{{Name: "sda"}, {Name: "sdb"}, {Name: "sdc"}}
+	deltas := map[string]DiskActivity{
+		"sda": {Reads: 10, Writes: 2, Activity: 12},
+		"sdb": {Reads: 0, Writes: 30, Activity: 30},
+		"sdx": {Reads: 99, Activity: 99}, // not a pool member
+	}
+	got := poolActivity(disks, deltas)
+	want := DiskActivity{Reads: 10, Writes: 32, Activity: 42}
+	if got != want {
+		t.Errorf("poolActivity = %+v, want %+v", got, want)
+	}
+}
+
+func TestUpdatePoolLed(t *testing.T) {
+	brightness := byte(30)
+	conf := &Config{
+		PollInterval:       50 * time.Millisecond,
+		PowerLedMode:       PowerLedModePoolActivity,
+		PowerLedBrightness: &brightness,
+		IdleTicks:          1,
+	}
+	am := newTestMonitor(t, DiskInfo{Name: "sda"}, DiskInfo{Name: "sdb"})
+	powerLed := func() leds.LedState {
+		for _, state := range am.leds.LedStates() {
+			if state.Index == powerLedIndex {
+				return state
+			}
+		}
+		t.Fatal("no power LED state")
+		return leds.LedState{}
+	}
+
+	now := time.Now()
+	am.updatePoolLed(conf, now, map[string]DiskActivity{
+		"sda": {Reads: 40, Activity: 40},
+		"sdb": {Writes: 20, Activity: 20},
+	})
+	r, g, b := colorForActivity(40, 20, 1, 0, conf.colorOptions())
+	if state := powerLed(); state.Mode != "on" || [3]byte{state.R, state.G, state.B} != [3]byte{r, g, b} || state.Brightness != brightnessForLevel(1) {
+		t.Errorf("active power LED = %+v, want %v at %d", state, [3]byte{r, g, b}, brightnessForLevel(1))
+	}
+
+	am.updatePoolLed(conf, now.Add(conf.PollInterval), map[string]DiskActivity{})
+	if state := powerLed(); [3]byte{state.R, state.G, state.B} != [3]byte{0, 255, 0} || state.Brightness != brightness {
+		t.Errorf("idle power LED = %+v, want green at %d", state, brightness)
+	}
+}
+
+func TestUpdateLanDiskLed(t *testing.T) {
+	conf := &Config{PollInterval: 50 * time.Millisecond, IdleTicks: 1, BrightnessCurve: BrightnessCurveLinear, ActivityDecay: 1, LanIdleMode: LanIdleModeOff, LanLedSource: LanLedSourceDiskTotal}
+	am := newTestMonitor(t, DiskInfo{Name: "sda"}, DiskInfo{Name: "sdb"})
+	lanLed := func() leds.LedState {
+		for _, state := range am.leds.LedStates() {
+			if state.Index == lanLedIndex {
+				return state
+			}
+		}
+		t.Fatal("no LAN LED state")
+		return leds.LedState{}
+	}
+
+	now := time.Now()
+	am.updateLanDiskLed(conf, now, map[string]DiskActivity{
+		"sda": {Reads: 400, Activity: 400},
+		"sdb": {Writes: 200, Activity: 200},
+	}, 0)
+	r, g, b := colorForActivity(400, 200, 1, 0, conf.colorOptions())
+	if state := lanLed(); state.Mode != "on" || [3]byte{state.R, state.G, state.B} != [3]byte{r, g, b} || state.Brightness != brightnessForLevel(1) {
+		t.Errorf("active LAN LED = %+v, want %v at %d", state, [3]byte{r, g, b}, brightnessForLevel(1))
+	}
+	if am.maxLanActivity != 600 {
+		t.Errorf("LAN peak = %d, want the disk total 600", am.maxLanActivity)
+	}
+
+	// Half the peak from one disk shows at half level
+	am.updateLanDiskLed(conf, now.Add(conf.PollInterval), map[string]DiskActivity{"sdb": {Writes: 300, Activity: 300}}, 0)
+	if state := lanLed(); state.Brightness != brightnessForLevel(0.5) {
+		t.Errorf("LAN LED brightness = %d, want %d", state.Brightness, brightnessForLevel(0.5))
+	}
+
+	am.updateLanDiskLed(conf, now.Add(2*conf.PollInterval), map[string]DiskActivity{}, 0)
+	if state := lanLed(); state.Mode != "off" {
+		t.Errorf("idle LAN LED = %+v, want off for lan_idle_mode off", state)
+	}
+}
+
+func TestUpdatePoolLedCapAndSmoothing(t *testing.T) {
+	brightness := byte(30)
+	conf := &Config{
+		PollInterval:        50 * time.Millisecond,
+		PowerLedMode:        PowerLedModePoolActivity,
+		PowerLedBrightness:  &brightness,
+		IdleTicks:           1,
+		BrightnessCurve:     BrightnessCurveLinear,
+		ActivityDecay:       1,
+		BrightnessSmoothing: 0.5,
+		ScrubBrightnessCap:  64,
+	}
+	am := newTestMonitor(t, DiskInfo{Name: "sda"})
+	powerBrightness := func() byte {
+		for _, state := range am.leds.LedStates() {
+			if state.Index == powerLedIndex {
+				return state.Brightness
+			}
+		}
+		t.Fatal("no power LED state")
+		return 0
+	}
+
+	// The pool LED is smoothed like a disk LED: a burst starts at its
+	// target, and a drop to half the peak moves halfway there
+	now := time.Now()
+	am.updatePoolLed(conf, now, map[string]DiskActivity{"sda": {Reads: 100, Activity: 100}})
+	if got := powerBrightness(); got != brightnessForLevel(1) {
+		t.Errorf("first tick brightness = %d, want %d", got, brightnessForLevel(1))
+	}
+	am.updatePoolLed(conf, now.Add(conf.PollInterval), map[string]DiskActivity{"sda": {Reads: 50, Activity: 50}})
+	want := byte(math.Round((float64(brightnessForLevel(1)) + float64(brightnessForLevel(0.5))) / 2))
+	if got := powerBrightness(); got != want {
+		t.Errorf("smoothed brightness = %d, want %d", got, want)
+	}
+
+	// and capped during a scrub
+	am.scrubbing.Store(true)
+	am.updatePoolLed(conf, now.Add(2*conf.PollInterval), map[string]DiskActivity{"sda": {Reads: 100, Activity: 100}})
+	if got := powerBrightness(); got != conf.ScrubBrightnessCap {
+		t.Errorf("brightness during a scrub = %d, want the cap %d", got, conf.ScrubBrightnessCap)
+	}
+}
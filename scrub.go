@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// scrubCheckTimeout bounds a scrub check, so a hung zpool or scrub_command
+// can't stall the checks that follow
+const scrubCheckTimeout = 30 * time.Second
+
+// ScrubDetector reports whether a ZFS scrub is running
+type ScrubDetector interface {
+	Scrubbing() (bool, error)
+}
+
+// zpoolScrubDetector checks `zpool status` for a running scrub
+type zpoolScrubDetector struct {
+	path string
+}
+
+func newZpoolScrubDetector() (*zpoolScrubDetector, error) {
+	path, err := exec.LookPath("zpool")
+	if err != nil {
+		return nil, err
+	}
+	return &zpoolScrubDetector{path: path}, nil
+}
+
+func (z *zpoolScrubDetector) Scrubbing() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrubCheckTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, z.path, "status").Output()
+	if err != nil {
+		return false, err
+	}
+	return parseZpoolStatus(out), nil
+}
+
+// commandScrubDetector runs a shell command that exits 0 while a scrub is
+// running and 1 otherwise
+type commandScrubDetector struct {
+	command string
+	timeout time.Duration // scrubCheckTimeout when 0
+}
+
+func (c *commandScrubDetector) Scrubbing() (bool, error) {
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = scrubCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := exec.CommandContext(ctx, "sh", "-c", c.command).Run()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return false, fmt.Errorf("scrub_command timed out after %s", timeout)
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return false, nil
+	}
+	return false, err
+}
+
+// parseZpoolStatus reports whether any pool in `zpool status` output has a
+// scrub in progress that isn't paused
+func parseZpoolStatus(out []byte) bool {
+	inProgress, paused := false, false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "pool:"):
+			if inProgress && !paused {
+				return true
+			}
+			inProgress, paused = false, false
+		case strings.HasPrefix(line, "scan: scrub in progress"):
+			inProgress = true
+		case strings.Contains(line, "scrub paused since"):
+			paused = true
+		}
+	}
+	return inProgress && !paused
+}
+
+// scrubDetector returns the configured detector: scrub_command when set,
+// otherwise zpool status
+func scrubDetector(conf *Config) (ScrubDetector, error) {
+	if conf.ScrubCommand != "" {
+		return &commandScrubDetector{command: conf.ScrubCommand}, nil
+	}
+	return newZpoolScrubDetector()
+}
+
+// checkScrub updates the scrubbing flag read by the monitor, logging changes.
+// A failed check keeps the previous state.
+func (am *ActivityMonitor) checkScrub(detector ScrubDetector) {
+	scrubbing, err := detector.Scrubbing()
+	if err != nil {
+		log.Printf("Error checking for a ZFS scrub: %v", err)
+		return
+	}
+	if am.scrubbing.Swap(scrubbing) != scrubbing {
+		if scrubbing {
+			log.Printf("ZFS scrub running, capping disk LED brightness")
+		} else {
+			log.Printf("ZFS scrub finished, restoring disk LED brightness")
+		}
+	}
+}
+
+// scrubLoop checks for a running scrub on its own interval, following config
+// changes, until ctx is cancelled
+func (am *ActivityMonitor) scrubLoop(ctx context.Context, detector ScrubDetector) {
+	conf := am.configLoader.Config()
+	subscriber := am.configLoader.Subscribe()
+
+	ticker := time.NewTicker(conf.ScrubInterval)
+	defer ticker.Stop()
+	am.checkScrub(detector)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case newconf := <-subscriber:
+			conf = &newconf
+			ticker.Reset(conf.ScrubInterval)
+		case <-ticker.C:
+			am.checkScrub(detector)
+		}
+	}
+}
+
+// scrubCap limits an active disk LED's brightness while a scrub runs
+func (am *ActivityMonitor) scrubCap(conf *Config, brightness byte) byte {
+	if conf.ScrubBrightnessCap > 0 && am.scrubbing.Load() {
+		return min(brightness, conf.ScrubBrightnessCap)
+	}
+	return brightness
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const zpoolScrubbing = `  pool: boot-pool
+ state: ONLINE
+  scan: scrub repaired 0B in 00:00:12 with 0 errors on Sun Jan  5 03:45:13 2025
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	boot-pool   ONLINE       0     0     0
+	  nvme0n1p3 ONLINE       0     0     0
+
+errors: No known data errors
+
+  pool: tank
+ state: ONLINE
+  scan: scrub in progress since Sun Jan  5 00:24:01 2025
+	1.23T / 3.45T scanned at 1.05G/s, 456G / 3.45T issued at 389M/s
+	0B repaired, 12.91% done, 02:14:39 to go
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        ONLINE       0     0     0
+	  raidz1-0  ONLINE       0     0     0
+	    sda     ONLINE       0     0     0
+	    sdb     ONLINE       0     0     0
+
+errors: No known data errors
+`
+
+const zpoolIdle = `  pool: tank
+ state: ONLINE
+  scan: scrub repaired 0B in 03:12:45 with 0 errors on Sun Jan  5 03:36:46 2025
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        ONLINE       0     0     0
+
+errors: No known data errors
+`
+
+const zpoolPaused = `  pool: tank
+ state: ONLINE
+  scan: scrub in progress since Sun Jan  5 00:24:01 2025
+	1.23T / 3.45T scanned, 456G / 3.45T issued
+	0B repaired, 12.91% done, scrub paused since Sun Jan  5 01:00:00 2025
+config:
+
+	NAME        STATE     READ WRITE CKSUM
+	tank        ONLINE       0     0     0
+`
+
+const zpoolResilvering = `  pool: tank
+ state: DEGRADED
+  scan: resilver in progress since Sun Jan  5 00:24:01 2025
+	0B repaired, 12.91% done, 02:14:39 to go
+`
+
+func TestParseZpoolStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want bool
+	}{
+		{"scrubbing", zpoolScrubbing, true},
+		{"idle", zpoolIdle, false},
+		{"paused", zpoolPaused, false},
+		{"paused then scrubbing", zpoolPaused + "\n" + zpoolScrubbing, true},
+		{"resilvering", zpoolResilvering, false},
+		{"no pools", "no pools available\n", false},
+	}
+	for _, tt := range tests {
+		if got := parseZpoolStatus([]byte(tt.out)); got != tt.want {
+			t.Errorf("%s: parseZpoolStatus() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fakeScrubDetector returns canned results in order
+type fakeScrubDetector struct {
+	results []bool
+	err     error
+}
+
+func (f *fakeScrubDetector) Scrubbing() (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	scrubbing := f.results[0]
+	f.results = f.results[1:]
+	return scrubbing, nil
+}
+
+func TestScrubCap(t *testing.T) {
+	am := &ActivityMonitor{}
+	conf := &Config{ScrubBrightnessCap: 64}
+	detector := &fakeScrubDetector{results: []bool{true, false}}
+
+	if got := am.scrubCap(conf, 255); got != 255 {
+		t.Errorf("expected no cap before a scrub, got %d", got)
+	}
+	am.checkScrub(detector)
+	if got := am.scrubCap(conf, 255); got != 64 {
+		t.Errorf("expected brightness capped at 64 during a scrub, got %d", got)
+	}
+	if got := am.scrubCap(conf, 40); got != 40 {
+		t.Errorf("expected brightness under the cap kept, got %d", got)
+	}
+	if got := am.scrubCap(&Config{}, 255); got != 255 {
+		t.Errorf("expected a zero cap to disable capping, got %d", got)
+	}
+
+	// A failed check keeps the scrub state
+	am.checkScrub(&fakeScrubDetector{err: errors.New("zpool failed")})
+	if got := am.scrubCap(conf, 255); got != 64 {
+		t.Errorf("expected the cap kept after a failed check, got %d", got)
+	}
+	am.checkScrub(detector)
+	if got := am.scrubCap(conf, 255); got != 255 {
+		t.Errorf("expected no cap after the scrub, got %d", got)
+	}
+}
+
+func TestCommandScrubDetector(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+		wantErr bool
+	}{
+		{"exit 0", true, false},
+		{"exit 1", false, false},
+		{"exit 2", false, true},
+	}
+	for _, tt := range tests {
+		got, err := (&commandScrubDetector{command: tt.command}).Scrubbing()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%q: Scrubbing() = %v, %v, want %v, error=%v", tt.command, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCommandScrubDetectorTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := (&commandScrubDetector{command: "sleep 5", timeout: 50 * time.Millisecond}).Scrubbing()
+	if err == nil {
+		t.Error("expected a hung command to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the command killed at the timeout, took %s", elapsed)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// seenCheckTicks is how many polls a disk has to appear in /proc/diskstats
+// before it's reported missing
+const seenCheckTicks = 5
+
+// diskSeenTracker records which disks have appeared in /proc/diskstats, so a
+// disk whose LED would otherwise stay dark forever gets reported
+type diskSeenTracker struct {
+	mu      sync.Mutex
+	seen    map[string]bool
+	ticks   int
+	checked bool
+}
+
+func newDiskSeenTracker() *diskSeenTracker {
+	return &diskSeenTracker{seen: make(map[string]bool)}
+}
+
+// record marks the disks present in stats as seen. Once, after
+// seenCheckTicks polls, it returns the disks never seen.
+func (t *diskSeenTracker) record(disks []DiskInfo, stats map[string]DiskActivity) []DiskInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for dev := range stats {
+		t.seen[dev] = true
+	}
+	t.ticks++
+	if t.checked || t.ticks < seenCheckTicks {
+		return nil
+	}
+	t.checked = true
+	var missing []DiskInfo
+	for _, disk := range disks {
+		if !t.seen[disk.Name] {
+			missing = append(missing, disk)
+		}
+	}
+	return missing
+}
+
+func (t *diskSeenTracker) isSeen(dev string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.seen[dev]
+}
+
+// recordSeen tracks which disks appear in stats, warning about any still
+// missing after the first few polls
+func (am *ActivityMonitor) recordSeen(stats map[string]DiskActivity) {
+	for _, disk := range am.seen.record(am.disks, stats) {
+		slog.Warn("disk never appeared in /proc/diskstats, its LED will stay dark", "disk", disk.Name, "serial", disk.Serial, "path", disk.Path)
+	}
+}
+
+// DiskStatus is a discovered disk in the status document
+type DiskStatus struct {
+	Name   string `json:"name"`
+	Serial string `json:"serial,omitempty"`
+	Seen   bool   `json:"seen"` // has appeared in /proc/diskstats
+}
+
+func (am *ActivityMonitor) diskStatuses() []DiskStatus {
+	disks := am.diskList()
+	statuses := make([]DiskStatus, len(disks))
+	for i, disk := range disks {
+		statuses[i] = DiskStatus{Name: disk.Name, Serial: disk.Serial, Seen: am.seen != nil && am.seen.isSeen(disk.Name)}
+	}
+	return statuses
+}
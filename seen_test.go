@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiskSeenTracker(t *testing.T) {
+	diskstats := []byte(
+		"   8       0 sda 100 0 800 10 200 0 1600 20 0 30 30 0 0 0 0\n" +
+			"   8      16 sdb 100 0 800 10 200 0 1600 20 0 30 30 0 0 0 0\n")
+	disks := []DiskInfo{{Name: "sda"}, {Name: "sdb"}, {Name: "sdz", Serial: "PHANTOM"}}
+	devices := []string{"sda", "sdb", "sdz"}
+
+	am := newTestMonitor(t, disks...)
+	var missing []DiskInfo
+	for tick := 1; tick <= seenCheckTicks; tick++ {
+		missing = am.seen.record(disks, parseDiskStats(diskstats, deviceSet(devices), make(map[string]DiskActivity)))
+		if tick < seenCheckTicks && missing != nil {
+			t.Fatalf("tick %d: reported missing disks early: %v", tick, missing)
+		}
+	}
+	if len(missing) != 1 || missing[0].Name != "sdz" {
+		t.Fatalf("expected only sdz missing, got %v", missing)
+	}
+	if again := am.seen.record(disks, nil); again != nil {
+		t.Errorf("expected missing disks reported once, got %v", again)
+	}
+
+	rec := httptest.NewRecorder()
+	am.handleStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	var status Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	want := []DiskStatus{{Name: "sda", Seen: true}, {Name: "sdb", Seen: true}, {Name: "sdz", Serial: "PHANTOM"}}
+	if len(status.DiskStatus) != len(want) {
+		t.Fatalf("expected %v, got %v", want, status.DiskStatus)
+	}
+	for i := range want {
+		if status.DiskStatus[i] != want[i] {
+			t.Errorf("disk_status[%d] = %+v, want %+v", i, status.DiskStatus[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// LedSegmentConfig declares one LED controller of a led_segments list
+type LedSegmentConfig struct {
+	Device  string `yaml:"device"`  // I2C device, e.g. /dev/i2c-1
+	Address int    `yaml:"address"` // I2C address, 0x3a if unset
+	First   string `yaml:"first"`   // first LED on the controller, e.g. power
+	Last    string `yaml:"last"`    // last LED on the controller, e.g. disk4
+	Base    int    `yaml:"base"`    // the controller's own index for first
+}
+
+// parseLedSegments resolves the LED names of led_segments and validates the
+// segments
+func parseLedSegments(configs []LedSegmentConfig) ([]leds.LedSegment, error) {
+	var segments []leds.LedSegment
+	for i, c := range configs {
+		first, err := ledIndexByName(c.First)
+		if err != nil {
+			return nil, fmt.Errorf("led_segments %d: first: %w", i, err)
+		}
+		last, err := ledIndexByName(c.Last)
+		if err != nil {
+			return nil, fmt.Errorf("led_segments %d: last: %w", i, err)
+		}
+		segments = append(segments, leds.LedSegment{Device: c.Device, Address: c.Address, First: first, Last: last, Base: c.Base})
+	}
+	if err := leds.ValidateSegments(segments); err != nil {
+		return nil, fmt.Errorf("led_segments: %w", err)
+	}
+	return segments, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestParseLedSegments(t *testing.T) {
+	segments, err := parseLedSegments([]LedSegmentConfig{
+		{Device: "/dev/i2c-1", First: "power", Last: "disk4"},
+		{Device: "/dev/i2c-2", Address: 0x3b, First: "disk5", Last: "Disk8"},
+	})
+	if err != nil {
+		t.Fatalf("parseLedSegments: %v", err)
+	}
+	want := []leds.LedSegment{
+		{Device: "/dev/i2c-1", First: 0, Last: 5},
+		{Device: "/dev/i2c-2", Address: 0x3b, First: 6, Last: 9},
+	}
+	if len(segments) != len(want) || segments[0] != want[0] || segments[1] != want[1] {
+		t.Errorf("segments = %+v, want %+v", segments, want)
+	}
+
+	if _, err := parseLedSegments([]LedSegmentConfig{{Device: "/dev/i2c-1", First: "power", Last: "disk9"}}); err == nil || !strings.Contains(err.Error(), "unknown LED") {
+		t.Errorf("unknown LED error = %v", err)
+	}
+	if _, err := parseLedSegments([]LedSegmentConfig{
+		{Device: "/dev/i2c-1", First: "power", Last: "disk4"},
+		{Device: "/dev/i2c-2", First: "disk4", Last: "disk8"},
+	}); err == nil || !strings.Contains(err.Error(), "already assigned") {
+		t.Errorf("overlap error = %v", err)
+	}
+}
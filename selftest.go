@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+const selfTestBrightness = 128
+
+var selfTestColors = []struct {
+	name    string
+	r, g, b byte
+}{
+	{"red", 255, 0, 0},
+	{"green", 0, 255, 0},
+	{"blue", 0, 0, 255},
+}
+
+// runSelfTest lights each LED in layout red, green, then blue for step each,
+// then turns it off. It stops early, leaving the current LED off, when ctx is
+// cancelled.
+func runSelfTest(ctx context.Context, controller *leds.UGreenLeds, layout LedLayout, step time.Duration) error {
+	log.Printf("Running LED self-test (%s)", layout.Model)
+	for id := range layout.Names() {
+		err := selfTestLed(ctx, controller, id, step)
+		if offErr := controller.SetLedMode(id, leds.LedModeOff, nil); err == nil && offErr != nil {
+			err = fmt.Errorf("%s: error turning off: %w", leds.LedNames[id], offErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("LED self-test complete")
+	return nil
+}
+
+func selfTestLed(ctx context.Context, controller *leds.UGreenLeds, id int, step time.Duration) error {
+	if err := controller.SetLedBrightness(id, selfTestBrightness); err != nil {
+		return fmt.Errorf("%s: error setting brightness: %w", leds.LedNames[id], err)
+	}
+	for _, c := range selfTestColors {
+		if err := controller.SetLedColor(id, c.r, c.g, c.b); err != nil {
+			return fmt.Errorf("%s: error setting %s: %w", leds.LedNames[id], c.name, err)
+		}
+		if err := controller.SetLedMode(id, leds.LedModeOn, nil); err != nil {
+			return fmt.Errorf("%s: error turning on: %w", leds.LedNames[id], err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(step):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	transport := leds.NewFakeTransport()
+	controller := leds.NewUGreenLedsWithTransport(transport)
+	controller.SetTiming(leds.LedTiming{MaxRetry: 1})
+
+	layout, _ := layoutForModel("DXP4800")
+	if err := runSelfTest(context.Background(), controller, layout, time.Millisecond); err != nil {
+		t.Fatalf("runSelfTest: %v", err)
+	}
+
+	for _, c := range transport.Commands() {
+		if !layout.Valid(c.LedID) {
+			t.Errorf("self-test wrote to %s, which a DXP4800 doesn't have", leds.LedNames[c.LedID])
+		}
+	}
+	for id := range layout.Names() {
+		var colors [][]byte
+		for _, c := range transport.Commands() {
+			if c.LedID == id && c.Command == leds.LedCmdColor {
+				colors = append(colors, c.Params)
+			}
+		}
+		if len(colors) != 3 || colors[0][0] != 255 || colors[1][1] != 255 || colors[2][2] != 255 {
+			t.Errorf("%s: expected red, green, blue, got %v", leds.LedNames[id], colors)
+		}
+		if status, _ := transport.ReadStatus(id); status.OpMode != "off" {
+			t.Errorf("%s: expected off after self-test, got %q", leds.LedNames[id], status.OpMode)
+		}
+	}
+}
+
+func TestRunSelfTestCancel(t *testing.T) {
+	transport := leds.NewFakeTransport()
+	controller := leds.NewUGreenLedsWithTransport(transport)
+	controller.SetTiming(leds.LedTiming{MaxRetry: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- runSelfTest(ctx, controller, layoutForDiskCount(8), time.Hour)
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("self-test did not stop after cancel")
+	}
+	if status, _ := transport.ReadStatus(0); status.OpMode != "off" {
+		t.Errorf("expected interrupted LED off, got %q", status.OpMode)
+	}
+}
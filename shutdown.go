@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// parseShutdownState parses shutdown_state entries, each in the --set
+// format, e.g. "power:on:255,255,255:brightness=16"
+func parseShutdownState(specs []string) ([]LedSetting, error) {
+	var settings []LedSetting
+	seen := make(map[int]bool)
+	for _, spec := range specs {
+		setting, err := parseLedSetting(spec)
+		if err != nil {
+			return nil, fmt.Errorf("shutdown_state: %w", err)
+		}
+		if seen[setting.ID] {
+			return nil, fmt.Errorf("shutdown_state: %s listed more than once", leds.LedNames[setting.ID])
+		}
+		seen[setting.ID] = true
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// applyShutdownState leaves the LEDs in their final state on exit: the LEDs
+// the monitor drives are turned off, then the configured shutdown_state
+// entries are written over them. Overrides are dropped and writes are not
+// rate limited, so nothing is left held when the controller closes.
+func (am *ActivityMonitor) applyShutdownState(conf *Config) {
+	am.leds.SetMinWriteInterval(0)
+	am.overrides.clear()
+	am.turnOffLeds()
+	if conf.PowerLedMode != PowerLedModeOff {
+		am.setLedMode(powerLedIndex, leds.LedModeOff, nil)
+	}
+	for _, setting := range conf.shutdownState {
+		if err := applyLedSetting(am.leds, setting); err != nil {
+			log.Printf("Error applying shutdown state to %s: %v", leds.LedNames[setting.ID], err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestParseShutdownState(t *testing.T) {
+	settings, err := parseShutdownState([]string{"power:on:255,255,255:brightness=16", "disk2:breath:0,0,255"})
+	if err != nil {
+		t.Fatalf("parseShutdownState: %v", err)
+	}
+	if len(settings) != 2 || settings[0].ID != powerLedIndex || settings[1].ID != firstDiskLedIndex+1 {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+
+	for _, tt := range []struct {
+		specs []string
+		want  string
+	}{
+		{[]string{"fan:on"}, "unknown LED"},
+		{[]string{"power:dim"}, "unknown mode"},
+		{[]string{"power:on", "POWER:off"}, "listed more than once"},
+	} {
+		if _, err := parseShutdownState(tt.specs); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseShutdownState(%q) error = %v, want containing %q", tt.specs, err, tt.want)
+		}
+	}
+}
+
+func TestCloseShutdownState(t *testing.T) {
+	loader := newTestConfigLoader(t, "min_write_interval_ms: 10000\nshutdown_state:\n  - power:on:255,255,255:brightness=16\n  - disk1:on:0,0,255:brightness=32\n")
+	transport := leds.NewFakeTransport()
+	am := newTestMonitor(t)
+	am.configLoader = loader
+	am.layout = layoutForDiskCount(2)
+	am.leds = leds.NewUGreenLedsWithTransport(transport)
+	am.leds.SetMinWriteInterval(loader.Config().MinWriteInterval())
+	for id := range firstDiskLedIndex + 2 {
+		if err := am.leds.SetLedColor(id, 255, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := am.leds.SetLedMode(id, leds.LedModeOn, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	am.Close()
+	want := map[int]leds.LedStatus{
+		powerLedIndex:         {Available: true, OpMode: "on", ColorR: 255, ColorG: 255, ColorB: 255, Brightness: 16},
+		lanLedIndex:           {Available: true, OpMode: "off", ColorR: 255},
+		firstDiskLedIndex:     {Available: true, OpMode: "on", ColorB: 255, Brightness: 32},
+		firstDiskLedIndex + 1: {Available: true, OpMode: "off", ColorR: 255},
+	}
+	for id, status := range want {
+		if got := transport.StatusOf(id); got != status {
+			t.Errorf("%s = %+v, want %+v", leds.LedNames[id], got, status)
+		}
+	}
+}
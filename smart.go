@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// DiskHealth is a disk's SMART health
+type DiskHealth string
+
+const (
+	HealthUnknown DiskHealth = ""
+	HealthOK      DiskHealth = "ok"
+	HealthWarning DiskHealth = "warning" // pre-fail attribute at or below threshold, or NVMe critical warning
+	HealthFailed  DiskHealth = "failed"  // overall health check failed
+)
+
+// smartBlinkPeriodMs is the slow red blink of a disk with SMART problems
+const smartBlinkPeriodMs = 2000
+
+// smartctl exit status bits, see smartctl(8)
+const (
+	smartctlCommandLineError = 1 << 0
+	smartctlOpenFailed       = 1 << 1
+	smartctlDiskFailing      = 1 << 3
+	smartctlPrefailAttribute = 1 << 4
+)
+
+// SmartReader reads a disk's SMART health
+type SmartReader interface {
+	Health(disk DiskInfo) (DiskHealth, error)
+}
+
+// smartctlReader reads health with `smartctl -H -j`, which covers SATA and NVMe
+type smartctlReader struct {
+	path string
+}
+
+func newSmartctlReader() (*smartctlReader, error) {
+	path, err := exec.LookPath("smartctl")
+	if err != nil {
+		return nil, err
+	}
+	return &smartctlReader{path: path}, nil
+}
+
+func (s *smartctlReader) Health(disk DiskInfo) (DiskHealth, error) {
+	out, err := exec.Command(s.path, "-H", "-j", filepath.Join("/dev", disk.Name)).Output()
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return HealthUnknown, err
+		}
+		// smartctl reports health through its exit status bits
+		exitCode = exitErr.ExitCode()
+	}
+	return parseSmartctl(out, exitCode)
+}
+
+// parseSmartctl derives health from `smartctl -H -j` output and exit status
+func parseSmartctl(out []byte, exitCode int) (DiskHealth, error) {
+	if exitCode&(smartctlCommandLineError|smartctlOpenFailed) != 0 {
+		return HealthUnknown, fmt.Errorf("smartctl failed with exit status %d", exitCode)
+	}
+
+	var result struct {
+		SmartStatus *struct {
+			Passed bool `json:"passed"`
+			NVMe   *struct {
+				Value int `json:"value"` // critical warning bits
+			} `json:"nvme"`
+		} `json:"smart_status"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return HealthUnknown, fmt.Errorf("error parsing smartctl output: %w", err)
+	}
+
+	switch {
+	case exitCode&smartctlDiskFailing != 0:
+		return HealthFailed, nil
+	case result.SmartStatus == nil:
+		return HealthUnknown, fmt.Errorf("smartctl reported no health status")
+	case !result.SmartStatus.Passed:
+		return HealthFailed, nil
+	case exitCode&smartctlPrefailAttribute != 0:
+		return HealthWarning, nil
+	case result.SmartStatus.NVMe != nil && result.SmartStatus.NVMe.Value != 0:
+		return HealthWarning, nil
+	}
+	return HealthOK, nil
+}
+
+// diskHealthMap holds the latest health of each disk by device name
+type diskHealthMap struct {
+	mu     sync.Mutex
+	health map[string]DiskHealth
+}
+
+func newDiskHealthMap() *diskHealthMap {
+	return &diskHealthMap{health: make(map[string]DiskHealth)}
+}
+
+func (m *diskHealthMap) get(dev string) DiskHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.health[dev]
+}
+
+// set records health and returns the previous value
+func (m *diskHealthMap) set(dev string, health DiskHealth) DiskHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev := m.health[dev]
+	m.health[dev] = health
+	return prev
+}
+
+// unhealthy reports whether health should override the disk's LED
+func (h DiskHealth) unhealthy() bool {
+	return h == HealthWarning || h == HealthFailed
+}
+
+// checkHealth reads every disk's health, logging changes. Disks that can't
+// be read keep their previous health.
+func (am *ActivityMonitor) checkHealth(reader SmartReader) {
+	for _, disk := range am.diskList() {
+		health, err := reader.Health(disk)
+		if err != nil {
+			log.Printf("Error reading SMART health of %s: %v", disk.Name, err)
+			continue
+		}
+		if prev := am.health.set(disk.Name, health); prev != health && (prev != HealthUnknown || health != HealthOK) {
+			log.Printf("SMART health of %s (%s): %s", disk.Name, disk.Serial, health)
+		}
+	}
+}
+
+// smartLoop checks disk health on its own interval, following config
+// changes, until ctx is cancelled
+func (am *ActivityMonitor) smartLoop(ctx context.Context, reader SmartReader) {
+	conf := am.configLoader.Config()
+	subscriber := am.configLoader.Subscribe()
+
+	ticker := time.NewTicker(conf.SmartInterval)
+	defer ticker.Stop()
+	am.checkHealth(reader)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case newconf := <-subscriber:
+			conf = &newconf
+			ticker.Reset(conf.SmartInterval)
+		case <-ticker.C:
+			am.checkHealth(reader)
+		}
+	}
+}
+
+// showHealth overrides a disk LED with a slow red blink when its SMART
+// health is bad, and reports whether it did
+func (am *ActivityMonitor) showHealth(ledIndex int, disk DiskInfo) bool {
+	if !am.health.get(disk.Name).unhealthy() {
+		return false
+	}
+	am.setLedColor(ledIndex, 255, 0, 0)
+	am.setLedBrightness(ledIndex, maxActiveBrightness)
+	am.setLedMode(ledIndex, leds.LedModeBlink, leds.BlinkParams(smartBlinkPeriodMs/2, smartBlinkPeriodMs/2))
+	return true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestParseSmartctl(t *testing.T) {
+	tests := []struct {
+		name     string
+		out      string
+		exitCode int
+		want     DiskHealth
+		wantErr  bool
+	}{
+		{"healthy", `{"smart_status":{"passed":true}}`, 0, HealthOK, false},
+		{"past threshold only", `{"smart_status":{"passed":true}}`, 1 << 5, HealthOK, false},
+		{"prefail attribute", `{"smart_status":{"passed":true}}`, smartctlPrefailAttribute, HealthWarning, false},
+		{"nvme critical warning", `{"smart_status":{"passed":true,"nvme":{"value":4}}}`, 0, HealthWarning, false},
+		{"nvme healthy", `{"smart_status":{"passed":true,"nvme":{"value":0}}}`, 0, HealthOK, false},
+		{"failed status", `{"smart_status":{"passed":false}}`, smartctlDiskFailing, HealthFailed, false},
+		{"failed without exit bit", `{"smart_status":{"passed":false}}`, 0, HealthFailed, false},
+		{"open failed", `{}`, smartctlOpenFailed, HealthUnknown, true},
+		{"no status", `{"device":{"name":"/dev/sda"}}`, 4, HealthUnknown, true},
+		{"bad json", `not json`, 0, HealthUnknown, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSmartctl([]byte(tt.out), tt.exitCode)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("parseSmartctl() = %q, %v, want %q, error=%v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeSmartReader returns canned health by device name
+type fakeSmartReader map[string]DiskHealth
+
+func (f fakeSmartReader) Health(disk DiskInfo) (DiskHealth, error) {
+	health, ok := f[disk.Name]
+	if !ok {
+		return HealthUnknown, errors.New("no such disk")
+	}
+	return health, nil
+}
+
+func TestHealthOverride(t *testing.T) {
+	transport := leds.NewFakeTransport()
+	controller := leds.NewUGreenLedsWithTransport(transport)
+	controller.SetTiming(leds.LedTiming{MaxRetry: 1})
+	am := newTestMonitor(t, DiskInfo{Name: "sda"}, DiskInfo{Name: "sdb"}, DiskInfo{Name: "sdc"}, DiskInfo{Name: "sdd"})
+	am.leds = controller
+	am.checkHealth(fakeSmartReader{"sda": HealthOK, "sdb": HealthWarning, "sdc": HealthFailed})
+
+	for i, tt := range []struct {
+		health   DiskHealth
+		override bool
+	}{
+		{HealthOK, false},
+		{HealthWarning, true},
+		{HealthFailed, true},
+		{HealthUnknown, false}, // read error
+	} {
+		disk := am.disks[i]
+		if got := am.health.get(disk.Name); got != tt.health {
+			t.Errorf("%s: health = %q, want %q", disk.Name, got, tt.health)
+		}
+		ledIndex := firstDiskLedIndex + i
+		if got := am.showHealth(ledIndex, disk); got != tt.override {
+			t.Errorf("%s: showHealth() = %v, want %v", disk.Name, got, tt.override)
+		}
+		status, _ := transport.ReadStatus(ledIndex)
+		if tt.override && (status.OpMode != "blink" || status.ColorR != 255 || status.ColorG != 0 || status.ColorB != 0) {
+			t.Errorf("%s: expected red blink, got %+v", disk.Name, status)
+		}
+	}
+
+	// A disk that recovers stops overriding
+	am.checkHealth(fakeSmartReader{"sdb": HealthOK})
+	if am.showHealth(firstDiskLedIndex+1, am.disks[1]) {
+		t.Error("expected recovered disk to show activity again")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// Standby LED modes for spun-down disks
+const (
+	StandbyLedModeIgnore = "ignore" // show the disk as idle
+	StandbyLedModeOff    = "off"    // turn the LED off
+	StandbyLedModeDim    = "dim"    // show a dim blue
+)
+
+// standbyBrightness is the brightness of a sleeping disk's LED in dim mode
+const standbyBrightness = 16
+
+// PowerStateReader reports whether a disk is spun down
+type PowerStateReader interface {
+	Standby(disk DiskInfo) (bool, error)
+}
+
+// hdparmReader checks the power state with `hdparm -C`, which doesn't wake
+// the disk
+type hdparmReader struct {
+	path string
+}
+
+func newHdparmReader() (*hdparmReader, error) {
+	path, err := exec.LookPath("hdparm")
+	if err != nil {
+		return nil, err
+	}
+	return &hdparmReader{path: path}, nil
+}
+
+func (h *hdparmReader) Standby(disk DiskInfo) (bool, error) {
+	if disk.Type == DiskTypeNVMe {
+		return false, nil // NVMe disks don't spin down
+	}
+	out, err := exec.Command(h.path, "-C", filepath.Join("/dev", disk.Name)).Output()
+	if err != nil {
+		return false, err
+	}
+	return parseHdparmState(out)
+}
+
+// parseHdparmState parses the "drive state is:" line of `hdparm -C` output
+func parseHdparmState(out []byte) (bool, error) {
+	for _, line := range strings.Split(string(out), "\n") {
+		_, state, found := strings.Cut(line, "drive state is:")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(state) {
+		case "standby", "sleeping":
+			return true, nil
+		case "unknown":
+			return false, fmt.Errorf("hdparm reported an unknown drive state")
+		}
+		return false, nil // active/idle, or an idle_a/idle_b/idle_c power condition
+	}
+	return false, fmt.Errorf("no drive state in hdparm output")
+}
+
+// sysfsPowerReader treats a disk as in standby while the kernel has it
+// runtime suspended, for systems without hdparm
+type sysfsPowerReader struct {
+	root string
+}
+
+func (s *sysfsPowerReader) Standby(disk DiskInfo) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.root, "sys/block", disk.Name, "device/power/runtime_status"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == "suspended", nil
+}
+
+// powerStateReader returns hdparm when installed, otherwise sysfs
+func powerStateReader() PowerStateReader {
+	if reader, err := newHdparmReader(); err == nil {
+		return reader
+	}
+	log.Printf("hdparm not found, reading disk standby from sysfs runtime power state")
+	return &sysfsPowerReader{root: "/"}
+}
+
+// diskStandbyMap caches which disks are spun down by device name
+type diskStandbyMap struct {
+	mu      sync.Mutex
+	standby map[string]bool
+}
+
+func newDiskStandbyMap() *diskStandbyMap {
+	return &diskStandbyMap{standby: make(map[string]bool)}
+}
+
+func (m *diskStandbyMap) get(dev string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.standby[dev]
+}
+
+// set records standby and returns the previous value
+func (m *diskStandbyMap) set(dev string, standby bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev := m.standby[dev]
+	m.standby[dev] = standby
+	return prev
+}
+
+// checkStandby reads every disk's power state, logging changes. Disks that
+// can't be read keep their previous state.
+func (am *ActivityMonitor) checkStandby(reader PowerStateReader) {
+	for _, disk := range am.diskList() {
+		standby, err := reader.Standby(disk)
+		if err != nil {
+			log.Printf("Error reading power state of %s: %v", disk.Name, err)
+			continue
+		}
+		if prev := am.standby.set(disk.Name, standby); prev != standby {
+			if standby {
+				log.Printf("Disk %s (%s) spun down", disk.Name, disk.Serial)
+			} else {
+				log.Printf("Disk %s (%s) spun up", disk.Name, disk.Serial)
+			}
+		}
+	}
+}
+
+// standbyLoop checks disk power states on its own interval, following
+// config changes, until ctx is cancelled
+func (am *ActivityMonitor) standbyLoop(ctx context.Context, reader PowerStateReader) {
+	conf := am.configLoader.Config()
+	subscriber := am.configLoader.Subscribe()
+
+	ticker := time.NewTicker(conf.StandbyInterval)
+	defer ticker.Stop()
+	am.checkStandby(reader)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case newconf := <-subscriber:
+			conf = &newconf
+			ticker.Reset(conf.StandbyInterval)
+		case <-ticker.C:
+			am.checkStandby(reader)
+		}
+	}
+}
+
+// showStandby drives the LED of a disk with no activity this tick that was
+// last seen in standby, and reports whether it did. Activity means the disk
+// has spun up, so it clears the cached standby state until the next check.
+func (am *ActivityMonitor) showStandby(conf *Config, ledIndex int, disk DiskInfo, active bool) bool {
+	if conf.StandbyLedMode == StandbyLedModeIgnore || am.standby == nil {
+		return false
+	}
+	if active {
+		am.standby.set(disk.Name, false)
+		return false
+	}
+	if !am.standby.get(disk.Name) {
+		return false
+	}
+	switch conf.StandbyLedMode {
+	case StandbyLedModeDim:
+		am.setLedColor(ledIndex, 0, 0, 255)
+		am.setLedBrightness(ledIndex, standbyBrightness)
+		am.setLedMode(ledIndex, leds.LedModeOn, nil)
+	default:
+		am.setLedMode(ledIndex, leds.LedModeOff, nil)
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestParseHdparmState(t *testing.T) {
+	tests := []struct {
+		out     string
+		standby bool
+		wantErr bool
+	}{
+		{"\n/dev/sda:\n drive state is:  standby\n", true, false},
+		{"\n/dev/sda:\n drive state is:  sleeping\n", true, false},
+		{"\n/dev/sda:\n drive state is:  active/idle\n", false, false},
+		{"\n/dev/sda:\n drive state is:  idle_b (standby_y)\n", false, false},
+		{"\n/dev/sda:\n drive state is:  unknown\n", false, true},
+		{"", false, true},
+	}
+	for _, tt := range tests {
+		standby, err := parseHdparmState([]byte(tt.out))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, wantErr %v", tt.out, err, tt.wantErr)
+		}
+		if standby != tt.standby {
+			t.Errorf("%q: standby = %v, want %v", tt.out, standby, tt.standby)
+		}
+	}
+}
+
+func TestSysfsPowerReader(t *testing.T) {
+	root := t.TempDir()
+	for dev, status := range map[string]string{"sda": "suspended\n", "sdb": "active\n"} {
+		dir := filepath.Join(root, "sys/block", dev, "device/power")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "runtime_status"), []byte(status), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := &sysfsPowerReader{root: root}
+	if standby, err := reader.Standby(DiskInfo{Name: "sda"}); err != nil || !standby {
+		t.Errorf("sda: standby = %v, %v; want true", standby, err)
+	}
+	if standby, err := reader.Standby(DiskInfo{Name: "sdb"}); err != nil || standby {
+		t.Errorf("sdb: standby = %v, %v; want false", standby, err)
+	}
+	if _, err := reader.Standby(DiskInfo{Name: "sdc"}); err == nil {
+		t.Errorf("sdc: expected an error for a missing power state")
+	}
+}
+
+func TestShowStandby(t *testing.T) {
+	disk := DiskInfo{Name: "sda"}
+	const ledIndex = firstDiskLedIndex
+	tests := []struct {
+		name     string
+		mode     string
+		standby  bool
+		active   bool
+		want     bool
+		wantMode string
+	}{
+		{"ignored", StandbyLedModeIgnore, true, false, false, ""},
+		{"awake", StandbyLedModeOff, false, false, false, ""},
+		{"active wakes", StandbyLedModeOff, true, true, false, ""},
+		{"off", StandbyLedModeOff, true, false, true, "off"},
+		{"dim", StandbyLedModeDim, true, false, true, "on"},
+	}
+	for _, tt := range tests {
+		am := newTestMonitor(t)
+		// Start from a lit LED, as after activity stops
+		if err := am.leds.SetLedMode(ledIndex, leds.LedModeOn, nil); err != nil {
+			t.Fatal(err)
+		}
+		am.standby.set(disk.Name, tt.standby)
+
+		conf := &Config{StandbyLedMode: tt.mode}
+		if got := am.showStandby(conf, ledIndex, disk, tt.active); got != tt.want {
+			t.Errorf("%s: showStandby() = %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.active && am.standby.get(disk.Name) {
+			t.Errorf("%s: expected activity to clear the standby state", tt.name)
+		}
+		if !tt.want {
+			continue
+		}
+		state := am.leds.LedStates()[0]
+		if state.Mode != tt.wantMode {
+			t.Errorf("%s: LED mode = %q, want %q", tt.name, state.Mode, tt.wantMode)
+		}
+		if tt.mode == StandbyLedModeDim && (state.Brightness != standbyBrightness || [3]byte{state.R, state.G, state.B} != [3]byte{0, 0, 255}) {
+			t.Errorf("%s: LED = %+v, want dim blue", tt.name, state)
+		}
+	}
+}
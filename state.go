@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const defaultStateFile = "/var/lib/ugreen-leds/state.json"
+
+// monitorState is the learned brightness scaling persisted across restarts
+type monitorState struct {
+	MaxActivity    uint64             `json:"max_activity"`
+	MaxLanActivity uint64             `json:"max_lan_activity"`
+	MetricPeaks    map[string]float64 `json:"metric_peaks,omitempty"`
+}
+
+// loadState reads the state file. A missing file returns an empty state and
+// an error satisfying os.IsNotExist.
+func loadState(path string) (monitorState, error) {
+	var state monitorState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return monitorState{}, fmt.Errorf("corrupt state file %q: %w", path, err)
+	}
+	return state, nil
+}
+
+// saveState writes the state file atomically, creating its directory if needed
+func saveState(path string, state monitorState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreState loads the persisted scaling into the monitor, if any
+func (am *ActivityMonitor) restoreState(path string) error {
+	state, err := loadState(path)
+	if err != nil {
+		return err
+	}
+	am.maxActivity = state.MaxActivity
+	am.maxLanActivity = state.MaxLanActivity
+	for name, peak := range state.MetricPeaks {
+		am.metricPeaks[name] = peak
+	}
+	return nil
+}
+
+// persistState saves the monitor's current scaling
+func (am *ActivityMonitor) persistState(path string) error {
+	return saveState(path, monitorState{
+		MaxActivity:    am.maxActivity,
+		MaxLanActivity: am.maxLanActivity,
+		MetricPeaks:    am.metricPeaks,
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+
+	am := &ActivityMonitor{
+		maxActivity:    12345,
+		maxLanActivity: 678,
+		metricPeaks:    map[string]float64{MetricQueue: 4.5},
+	}
+	if err := am.persistState(path); err != nil {
+		t.Fatalf("persistState: %v", err)
+	}
+
+	restored := &ActivityMonitor{metricPeaks: make(map[string]float64)}
+	if err := restored.restoreState(path); err != nil {
+		t.Fatalf("restoreState: %v", err)
+	}
+	if restored.maxActivity != 12345 || restored.maxLanActivity != 678 || restored.metricPeaks[MetricQueue] != 4.5 {
+		t.Errorf("unexpected restored state: max=%d lan=%d peaks=%v", restored.maxActivity, restored.maxLanActivity, restored.metricPeaks)
+	}
+}
+
+func TestLoadStateMissing(t *testing.T) {
+	state, err := loadState(filepath.Join(t.TempDir(), "missing.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not-exist error, got %v", err)
+	}
+	if state.MaxActivity != 0 {
+		t.Errorf("expected empty state, got %+v", state)
+	}
+}
+
+func TestLoadStateCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	os.WriteFile(path, []byte("{not json"), 0644)
+
+	am := &ActivityMonitor{maxActivity: 7, metricPeaks: make(map[string]float64)}
+	if err := am.restoreState(path); err == nil {
+		t.Fatal("expected error for corrupt state file")
+	}
+	if am.maxActivity != 7 {
+		t.Errorf("expected corrupt state to leave scaling untouched, got %d", am.maxActivity)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+// ledErrorLogInterval limits how often LED write errors are logged per LED
+const ledErrorLogInterval = time.Minute
+
+// Status is the JSON document served by the status endpoint
+type Status struct {
+	LedWrites         leds.LedWriteStats     `json:"led_writes"`
+	Disks             int                    `json:"disks"`
+	DiskStatus        []DiskStatus           `json:"disk_status"`
+	DiscoveryWarnings []DiscoveryWarning     `json:"discovery_warnings,omitempty"`
+	Leds              []leds.LedState        `json:"leds"`
+	LedStatus         map[int]leds.LedStatus `json:"led_status"` // as last read back from the controller
+	Warnings          []string               `json:"warnings,omitempty"`
+	DisabledLeds      []string               `json:"disabled_leds,omitempty"` // see ledFailures
+}
+
+// status returns a snapshot of the monitor's state, without the LED fields
+// once the controller is closed
+func (am *ActivityMonitor) status() Status {
+	status := Status{
+		Disks:             len(am.diskList()),
+		DiskStatus:        am.diskStatuses(),
+		DiscoveryWarnings: am.diskWarnings,
+		Warnings:          am.warnings.list(),
+		DisabledLeds:      am.ledFailures.list(),
+	}
+	if controller := am.controller(); controller != nil {
+		status.LedWrites = controller.WriteStats()
+		status.Leds = controller.LedStates()
+		status.LedStatus = controller.AllStatus()
+	}
+	return status
+}
+
+func (am *ActivityMonitor) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(am.status()); err != nil {
+		log.Printf("Error writing status: %v", err)
+	}
+}
+
+// statusHandler routes the status endpoint and its live feed
+func (am *ActivityMonitor) statusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", am.handleStatus)
+	mux.HandleFunc("GET /history", am.handleHistory)
+	mux.HandleFunc("GET /metrics", am.handleMetrics)
+	mux.HandleFunc("GET /healthz", am.handleHealthz)
+	mux.HandleFunc("GET /ws", am.handleWebSocket)
+	mux.HandleFunc("POST /override", am.handleOverride)
+	return mux
+}
+
+// ServeStatus serves the status endpoint on addr until the server fails
+func (am *ActivityMonitor) ServeStatus(addr string) error {
+	log.Printf("Serving status on http://%s/status and ws://%s/ws", addr, addr)
+	return http.ListenAndServe(addr, am.statusHandler())
+}
+
+// warningList records non-fatal problems found while starting up, so a
+// degraded service can be diagnosed from the status endpoint
+type warningList struct {
+	mu    sync.Mutex
+	items []string
+}
+
+func (w *warningList) add(warning string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.items = append(w.items, warning)
+}
+
+func (w *warningList) list() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.items)
+}
+
+// warn logs a non-fatal startup problem and records it for the status endpoint
+func (am *ActivityMonitor) warn(format string, args ...any) {
+	warning := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", warning)
+	am.warnings.add(warning)
+}
+
+// recordInitWarnings records the problems found with the discovered disks:
+// discovery entries skipped (already logged in the discovery summary), disks
+// without a serial, disk_order serials that matched no disk, and bays left
+// without a disk
+func (am *ActivityMonitor) recordInitWarnings(discovery []DiscoveryWarning, missingOrder []string) {
+	for _, w := range discovery {
+		am.warnings.add("disk discovery: " + w.String())
+	}
+	for _, disk := range am.disks {
+		if disk.Serial == "" {
+			am.warn("no serial found for %s", disk.Name)
+		}
+	}
+	for _, serial := range missingOrder {
+		am.warn("disk_order serial %q matches no discovered disk (discovered: %s)", serial, diskSerials(am.disks))
+	}
+	switch {
+	case len(am.disks) == 0:
+		am.warn("no disks found: disk LEDs stay off, LAN and power LEDs are still driven")
+	case len(am.disks) < am.layout.DiskBays:
+		am.warn("%d disks found for %d bays (%s)", len(am.disks), am.layout.DiskBays, am.layout.Model)
+	}
+}
+
+// errorLimiter logs at most one error per key per interval, counting the rest
+type errorLimiter struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	last       map[int]time.Time
+	suppressed map[int]int
+}
+
+func newErrorLimiter(interval time.Duration) *errorLimiter {
+	return &errorLimiter{
+		interval:   interval,
+		last:       make(map[int]time.Time),
+		suppressed: make(map[int]int),
+	}
+}
+
+// allow reports whether an error for key should be logged now, and how many
+// errors for key were suppressed since the last one logged
+func (l *errorLimiter) allow(key int, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		l.suppressed[key]++
+		return false, 0
+	}
+	suppressed := l.suppressed[key]
+	l.last[key] = now
+	l.suppressed[key] = 0
+	return true, suppressed
+}
+
+// maxLedFailures is how many failed writes in a row disable an LED
+const maxLedFailures = 10
+
+// ledRetryInterval is how long a disabled LED goes unwritten before a write
+// is tried again
+const ledRetryInterval = time.Minute
+
+// ledFailures disables LEDs whose monitor writes keep failing, so their
+// retries stop slowing every tick. Any write that succeeds or has nothing to
+// do resets the count and re-enables the LED. A disabled LED is retried
+// every ledRetryInterval, and a single failed retry disables it again. The
+// zero value is ready to use.
+type ledFailures struct {
+	mu       sync.Mutex
+	failures map[int]int
+	disabled map[int]time.Time // until when, kept while retrying
+}
+
+// record counts the result of a write at now, reporting whether it disabled
+// the LED, not counting disabling it again after a failed retry
+func (f *ledFailures) record(id int, err error, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.failures, id)
+		delete(f.disabled, id)
+		return false
+	}
+	if f.failures == nil {
+		f.failures = make(map[int]int)
+		f.disabled = make(map[int]time.Time)
+	}
+	f.failures[id]++
+	if f.failures[id] < maxLedFailures {
+		return false
+	}
+	_, retried := f.disabled[id]
+	f.disabled[id] = now.Add(ledRetryInterval)
+	f.failures[id] = maxLedFailures - 1
+	return !retried
+}
+
+// isDisabled reports whether the LED is not to be written at now
+func (f *ledFailures) isDisabled(id int, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	until, ok := f.disabled[id]
+	return ok && now.Before(until)
+}
+
+// list returns the names of the disabled LEDs, in LED order, including
+// those being retried
+func (f *ledFailures) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for id, name := range leds.LedNames {
+		if _, ok := f.disabled[id]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// reset re-enables every LED
+func (f *ledFailures) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clear(f.failures)
+	clear(f.disabled)
+}
+
+// ledWritten logs a monitor write's error and counts it towards disabling
+// the LED
+func (am *ActivityMonitor) ledWritten(id int, err error) {
+	am.logLedError(id, err)
+	if am.ledFailures.record(id, err, time.Now()) {
+		slog.Warn("LED disabled after repeated write failures", "led", leds.LedNames[id], "failures", maxLedFailures, "retry_interval", ledRetryInterval)
+	}
+}
+
+func (am *ActivityMonitor) logLedError(id int, err error) {
+	if err == nil {
+		return
+	}
+	if ok, suppressed := am.ledErrors.allow(id, time.Now()); ok {
+		slog.Error("error updating LED", "led_id", id, "led", leds.LedNames[id], "error", err, "suppressed", suppressed)
+	}
+}
+
+// setLedColor, setLedBrightness, and setLedMode are the monitor's LED
+// writes. They log errors, and skip LEDs held by an override, see
+// applyOverrides, and LEDs disabled by repeated failures, see ledFailures.
+func (am *ActivityMonitor) setLedColor(id int, r, g, b byte) {
+	if am.overrides.has(id) || am.ledFailures.isDisabled(id, time.Now()) {
+		return
+	}
+	am.ledWritten(id, am.leds.SetLedColor(id, r, g, b))
+}
+
+func (am *ActivityMonitor) setLedBrightness(id int, brightness byte) {
+	if am.overrides.has(id) || am.ledFailures.isDisabled(id, time.Now()) {
+		return
+	}
+	am.ledWritten(id, am.leds.SetLedBrightness(id, am.capBrightness(brightness)))
+}
+
+func (am *ActivityMonitor) setLedMode(id int, mode byte, params []byte) {
+	if am.overrides.has(id) || am.ledFailures.isDisabled(id, time.Now()) {
+		return
+	}
+	am.ledWritten(id, am.leds.SetLedMode(id, mode, params))
+}
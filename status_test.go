@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestErrorLimiter(t *testing.T) {
+	l := newErrorLimiter(time.Minute)
+	start := time.Unix(1000, 0)
+
+	if ok, _ := l.allow(2, start); !ok {
+		t.Fatal("expected first error to be logged")
+	}
+	for i := 1; i <= 3; i++ {
+		if ok, _ := l.allow(2, start.Add(time.Duration(i)*time.Second)); ok {
+			t.Errorf("expected error %d within the interval to be suppressed", i)
+		}
+	}
+	if ok, _ := l.allow(3, start.Add(time.Second)); !ok {
+		t.Error("expected errors for another LED to be logged independently")
+	}
+	ok, suppressed := l.allow(2, start.Add(time.Minute))
+	if !ok || suppressed != 3 {
+		t.Errorf("expected error after the interval to be logged with 3 suppressed, got %v, %d", ok, suppressed)
+	}
+}
+
+// failingTransport fails every write
+type failingTransport struct {
+	*leds.FakeTransport
+}
+
+func (t *failingTransport) WriteCommand(ledID int, command byte, params []byte) error {
+	return errors.New("bus error")
+}
+
+func TestWriteStats(t *testing.T) {
+	controller := leds.NewUGreenLedsWithTransport(&failingTransport{leds.NewFakeTransport()})
+	controller.SetTiming(leds.LedTiming{MaxRetry: 3})
+
+	if err := controller.SetLedBrightness(2, 10); err == nil {
+		t.Fatal("expected error from failing transport")
+	}
+	got := controller.WriteStats()
+	want := leds.LedWriteStats{Writes: 0, Retries: 2, Failures: 1}
+	if got != want {
+		t.Errorf("expected stats %+v, got %+v", want, got)
+	}
+}
+
+func TestLedDisabledAfterFailures(t *testing.T) {
+	transport := &failingTransport{leds.NewFakeTransport()}
+	controller := leds.NewUGreenLedsWithTransport(transport)
+	controller.SetTiming(leds.LedTiming{MaxRetry: 1})
+	am := &ActivityMonitor{leds: controller, ledErrors: newErrorLimiter(ledErrorLogInterval)}
+
+	for i := range maxLedFailures {
+		if am.ledFailures.isDisabled(2, time.Now()) {
+			t.Fatalf("LED disabled after %d failures, want %d", i, maxLedFailures)
+		}
+		am.setLedBrightness(2, byte(i+1))
+	}
+	if !am.ledFailures.isDisabled(2, time.Now()) {
+		t.Fatalf("LED not disabled after %d failures", maxLedFailures)
+	}
+	writes := controller.WriteStats().Failures
+	am.setLedBrightness(2, 100)
+	if got := controller.WriteStats().Failures; got != writes {
+		t.Errorf("disabled LED still written: %d failures, want %d", got, writes)
+	}
+	if got := am.status().DisabledLeds; !slices.Equal(got, []string{"disk1"}) {
+		t.Errorf("status disabled LEDs = %q, want [disk1]", got)
+	}
+
+	// A success resets the count, so only consecutive failures disable
+	now := time.Now()
+	for range maxLedFailures - 1 {
+		am.ledFailures.record(3, errors.New("bus error"), now)
+	}
+	am.ledFailures.record(3, nil, now)
+	if am.ledFailures.record(3, errors.New("bus error"), now) {
+		t.Error("LED disabled by failures separated by a success")
+	}
+
+	// A disabled LED is retried after the interval; one failure disables it
+	// again, and a success re-enables it
+	for range maxLedFailures {
+		am.ledFailures.record(4, errors.New("bus error"), now)
+	}
+	retry := now.Add(ledRetryInterval)
+	if !am.ledFailures.isDisabled(4, retry.Add(-time.Second)) || am.ledFailures.isDisabled(4, retry) {
+		t.Error("disabled LED not retried after the retry interval")
+	}
+	if am.ledFailures.record(4, errors.New("bus error"), retry) {
+		t.Error("failed retry reported as newly disabling the LED")
+	}
+	if !am.ledFailures.isDisabled(4, retry.Add(time.Second)) {
+		t.Error("LED not disabled again by a failed retry")
+	}
+	am.ledFailures.record(4, nil, retry.Add(ledRetryInterval))
+	if am.ledFailures.isDisabled(4, retry.Add(ledRetryInterval)) || slices.Contains(am.ledFailures.list(), "disk3") {
+		t.Error("LED still disabled after a successful retry")
+	}
+
+	am.setDisks(&Config{}, nil)
+	if am.ledFailures.isDisabled(2, time.Now()) {
+		t.Error("LED still disabled after rediscovery")
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	controller := leds.NewUGreenLedsWithTransport(leds.NewFakeTransport())
+	controller.SetLedBrightness(2, 10)
+	am := &ActivityMonitor{leds: controller}
+
+	rec := httptest.NewRecorder()
+	am.handleStatus(rec, httptest.NewRequest("GET", "/status", nil))
+
+	var status Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if status.LedWrites.Writes != 1 {
+		t.Errorf("expected 1 write in status, got %+v", status.LedWrites)
+	}
+}
+
+func TestStatusWarningsWithoutSerials(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dev/disk/by-path"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dev/sda"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../sda", filepath.Join(root, "dev/disk/by-path/pci-0000:59:00.0-ata-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// no /sys/block, so no serials can be read
+	disks, discovery, err := discoverDisksIn(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := &ActivityMonitor{
+		leds:   leds.NewUGreenLedsWithTransport(leds.NewFakeTransport()),
+		disks:  disks,
+		layout: resolveLedLayout("", len(disks)),
+	}
+	am.recordInitWarnings(discovery, nil)
+
+	warnings := am.status().Warnings
+	hasPrefix := func(prefix string) bool {
+		return slices.ContainsFunc(warnings, func(w string) bool { return strings.HasPrefix(w, prefix) })
+	}
+	if !hasPrefix("disk discovery: serials: ") {
+		t.Errorf("expected a warning that serials couldn't be read, got %q", warnings)
+	}
+	if !hasPrefix("no serial found for sda") {
+		t.Errorf("expected a warning that sda has no serial, got %q", warnings)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// activitySummary totals the activity of the ticks between summary log
+// lines. The monitor adds each tick's event; summaryLoop takes the totals.
+type activitySummary struct {
+	mu          sync.Mutex
+	since       time.Time
+	ticks       int
+	disks       []DiskEvent // totals in the order disks were first seen
+	rx, tx      uint64
+	network     bool   // any tick read the network
+	maxActivity uint64 // disk brightness scale at the latest tick
+}
+
+// add totals a tick's activity
+func (s *activitySummary) add(event ActivityEvent, maxActivity uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		s.since = event.Time
+	}
+	s.ticks++
+	for _, disk := range event.Disks {
+		i := 0
+		for i < len(s.disks) && s.disks[i].Name != disk.Name {
+			i++
+		}
+		if i == len(s.disks) {
+			s.disks = append(s.disks, DiskEvent{Name: disk.Name, Serial: disk.Serial})
+		}
+		s.disks[i].ReadBytes += disk.ReadBytes
+		s.disks[i].WriteBytes += disk.WriteBytes
+	}
+	if event.Network != nil {
+		s.network = true
+		s.rx += event.Network.RxBytes
+		s.tx += event.Network.TxBytes
+	}
+	s.maxActivity = maxActivity
+}
+
+// take returns a summary line for the activity since the last one, at now,
+// and starts the next. It returns false when no tick ran.
+func (s *activitySummary) take(now time.Time) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ticks == 0 {
+		return "", false
+	}
+	line := s.format(now.Sub(s.since))
+	s.since, s.ticks, s.disks = now, 0, nil
+	s.rx, s.tx, s.network = 0, 0, false
+	return line, true
+}
+
+// format writes the totals compactly, e.g. "activity 1m0s: sda r=1.5M
+// w=0B, sdb r=0B w=4.0K; lan rx=12M tx=800K; scale 8.0M"
+func (s *activitySummary) format(elapsed time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "activity %s:", elapsed.Round(time.Second))
+	for i, disk := range s.disks {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, " %s r=%s w=%s", disk.Name, formatBytes(disk.ReadBytes), formatBytes(disk.WriteBytes))
+	}
+	if len(s.disks) == 0 {
+		b.WriteString(" no disks")
+	}
+	if s.network {
+		fmt.Fprintf(&b, "; lan rx=%s tx=%s", formatBytes(s.rx), formatBytes(s.tx))
+	}
+	fmt.Fprintf(&b, "; scale %s", formatBytes(s.maxActivity))
+	return b.String()
+}
+
+// formatBytes abbreviates a byte count with a binary unit, e.g. 1.5M
+func formatBytes(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value, unit := float64(n)/1024, 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value >= 10 {
+		return fmt.Sprintf("%.0f%c", value, units[unit])
+	}
+	return fmt.Sprintf("%.1f%c", value, units[unit])
+}
+
+// summaryLoop logs the activity summary every SummaryInterval, following
+// config changes, until ctx is cancelled. An interval of 0 logs nothing.
+func (am *ActivityMonitor) summaryLoop(ctx context.Context) {
+	conf := am.configLoader.Config()
+	subscriber := am.configLoader.Subscribe()
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	reset := func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+		ticker, tick = nil, nil
+		if conf.SummaryInterval > 0 {
+			ticker = time.NewTicker(conf.SummaryInterval)
+			tick = ticker.C
+		}
+	}
+	reset()
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case newconf := <-subscriber:
+			conf = &newconf
+			reset()
+		case now := <-tick:
+			if line, ok := am.summary.take(now); ok {
+				log.Print(line)
+			}
+		}
+	}
+}
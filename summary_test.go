@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivitySummary(t *testing.T) {
+	var s activitySummary
+	start := time.Unix(1000, 0)
+	if _, ok := s.take(start); ok {
+		t.Error("summary without ticks")
+	}
+
+	for i := range 3 {
+		s.add(ActivityEvent{
+			Time: start.Add(time.Duration(i) * 20 * time.Second),
+			Disks: []DiskEvent{
+				{Name: "sda", ReadBytes: 1024, WriteBytes: 512},
+				{Name: "sdb", WriteBytes: 3 << 20},
+			},
+			Network: &NetworkEvent{RxBytes: 100, TxBytes: 2048},
+		}, uint64(i+1)<<20)
+	}
+	line, ok := s.take(start.Add(time.Minute))
+	want := "activity 1m0s: sda r=3.0K w=1.5K, sdb r=0B w=9.0M; lan rx=300B tx=6.0K; scale 3.0M"
+	if !ok || line != want {
+		t.Errorf("summary = %q, %v, want %q", line, ok, want)
+	}
+
+	// The next summary starts from zero
+	s.add(ActivityEvent{Time: start.Add(80 * time.Second), Disks: []DiskEvent{{Name: "sdb", ReadBytes: 10}}}, 1)
+	line, _ = s.take(start.Add(2 * time.Minute))
+	if want := "activity 1m0s: sdb r=10B w=0B; scale 1B"; line != want {
+		t.Errorf("second summary = %q, want %q", line, want)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1536, "1.5K"},
+		{20 << 20, "20M"},
+		{5 << 40, "5.0T"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// minTransitionDelta is the largest channel change that snaps instead of fading
+const minTransitionDelta = 16
+
+// Transition returns the configured color fade time
+func (c *Config) Transition() time.Duration {
+	return time.Duration(c.TransitionMs) * time.Millisecond
+}
+
+// fadeStep is the color and brightness one tick of a fade writes
+type fadeStep struct {
+	color      [3]byte
+	brightness byte
+}
+
+// colorFade is an LED's fade in progress, writing one step per tick
+type colorFade struct {
+	id    int
+	steps []fadeStep
+}
+
+// fadeTicks returns the number of ticks a fade over transition takes at
+// pollInterval, at least 2 so any fade has a step between its ends
+func fadeTicks(transition, pollInterval time.Duration) int {
+	if pollInterval <= 0 {
+		return 2
+	}
+	return max(2, int((transition+pollInterval-1)/pollInterval))
+}
+
+// fadeSteps returns the colors a fade from -> to writes, ending exactly at
+// to. Changes of less than minTransitionDelta on every channel take one step.
+func fadeSteps(from, to [3]byte, steps int) [][3]byte {
+	if steps < 1 || maxChannelDelta(from, to) < minTransitionDelta {
+		return [][3]byte{to}
+	}
+	colors := make([][3]byte, steps)
+	for i := range steps {
+		t := float64(i+1) / float64(steps)
+		for c := range 3 {
+			colors[i][c] = byte(float64(from[c]) + (float64(to[c])-float64(from[c]))*t + 0.5)
+		}
+	}
+	colors[steps-1] = to
+	return colors
+}
+
+func maxChannelDelta(a, b [3]byte) int {
+	delta := 0
+	for c := range 3 {
+		d := int(a[c]) - int(b[c])
+		if d < 0 {
+			d = -d
+		}
+		delta = max(delta, d)
+	}
+	return delta
+}
+
+// fadeLed sets a lit LED's color and brightness, fading both from the
+// current ones over transition when enabled, so the new brightness never
+// shows in the old color. An LED that isn't lit has nothing to fade from
+// and is set directly. A new color replaces any fade in progress for the
+// LED, and runFades writes the steps, the first in this tick.
+func (am *ActivityMonitor) fadeLed(id int, color [3]byte, brightness byte, transition, pollInterval time.Duration) {
+	for i := range am.fades {
+		if f := &am.fades[i]; f.id == id && f.steps[len(f.steps)-1].color == color {
+			// Already fading to this color; only the brightness target moves
+			f.steps[len(f.steps)-1].brightness = brightness
+			return
+		}
+	}
+	am.cancelFade(id)
+	from, fromBrightness, ok := am.leds.LastLit(id)
+	if transition <= 0 || !ok {
+		am.setLedColor(id, color[0], color[1], color[2])
+		am.setLedBrightness(id, brightness)
+		return
+	}
+	colors := fadeSteps(from, color, fadeTicks(transition, pollInterval))
+	steps := make([]fadeStep, len(colors))
+	for i, c := range colors {
+		t := float64(i+1) / float64(len(colors))
+		steps[i] = fadeStep{color: c, brightness: byte(float64(fromBrightness) + (float64(brightness)-float64(fromBrightness))*t + 0.5)}
+	}
+	steps[len(steps)-1].brightness = brightness
+	am.fades = append(am.fades, colorFade{id: id, steps: steps})
+}
+
+// cancelFade drops the fade in progress for an LED, if any, so another
+// display can take it over
+func (am *ActivityMonitor) cancelFade(id int) {
+	am.fades = slices.DeleteFunc(am.fades, func(f colorFade) bool { return f.id == id })
+}
+
+// runFades writes the next step of every fade in progress, color first, and
+// drops the fades that are done
+func (am *ActivityMonitor) runFades() {
+	fades := am.fades[:0]
+	for _, f := range am.fades {
+		step := f.steps[0]
+		am.setLedColor(f.id, step.color[0], step.color[1], step.color[2])
+		am.setLedBrightness(f.id, step.brightness)
+		if f.steps = f.steps[1:]; len(f.steps) > 0 {
+			fades = append(fades, f)
+		}
+	}
+	am.fades = fades
+}
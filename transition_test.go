@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestFadeSteps(t *testing.T) {
+	steps := fadeSteps([3]byte{0, 0, 255}, [3]byte{255, 0, 0}, 4)
+	want := [][3]byte{{64, 0, 191}, {128, 0, 128}, {191, 0, 64}, {255, 0, 0}}
+	if len(steps) != len(want) {
+		t.Fatalf("expected %d steps, got %v", len(want), steps)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("step %d = %v, want %v", i, steps[i], want[i])
+		}
+	}
+
+	// Small changes snap
+	if steps := fadeSteps([3]byte{100, 100, 100}, [3]byte{110, 95, 100}, 4); len(steps) != 1 || steps[0] != [3]byte{110, 95, 100} {
+		t.Errorf("expected a small change to take one step, got %v", steps)
+	}
+
+	// Every fade ends exactly on the target
+	for _, to := range [][3]byte{{1, 2, 3}, {255, 255, 255}, {17, 200, 99}} {
+		steps := fadeSteps([3]byte{128, 0, 64}, to, 3)
+		if steps[len(steps)-1] != to {
+			t.Errorf("fade to %v ended at %v", to, steps[len(steps)-1])
+		}
+	}
+}
+
+func TestFadeTicks(t *testing.T) {
+	for _, tc := range []struct {
+		transition, poll time.Duration
+		want             int
+	}{
+		{50 * time.Millisecond, 100 * time.Millisecond, 2},
+		{300 * time.Millisecond, 100 * time.Millisecond, 3},
+		{350 * time.Millisecond, 100 * time.Millisecond, 4},
+		{time.Second, 0, 2},
+	} {
+		if got := fadeTicks(tc.transition, tc.poll); got != tc.want {
+			t.Errorf("fadeTicks(%s, %s) = %d, want %d", tc.transition, tc.poll, got, tc.want)
+		}
+	}
+}
+
+func TestRunFades(t *testing.T) {
+	transport := leds.NewFakeTransport()
+	controller := leds.NewUGreenLedsWithTransport(transport)
+	controller.SetTiming(leds.LedTiming{MaxRetry: 1})
+	am := &ActivityMonitor{leds: controller, ledErrors: newErrorLimiter(ledErrorLogInterval)}
+	transition, poll := 300*time.Millisecond, 100*time.Millisecond
+
+	// An LED that isn't lit has nothing to fade from
+	am.fadeLed(2, [3]byte{0, 0, 255}, 100, transition, poll)
+	am.fadeLed(3, [3]byte{0, 0, 255}, 100, transition, poll)
+	if len(am.fades) != 0 {
+		t.Fatalf("expected unlit LEDs set directly, got %d fades", len(am.fades))
+	}
+	am.setLedMode(2, leds.LedModeOn, nil)
+	am.setLedMode(3, leds.LedModeOn, nil)
+
+	am.fadeLed(2, [3]byte{255, 0, 0}, 200, transition, poll)
+	am.fadeLed(3, [3]byte{0, 8, 255}, 100, transition, poll) // small change
+	if len(am.fades) != 2 {
+		t.Fatalf("expected 2 fades, got %d", len(am.fades))
+	}
+
+	// One step per tick, color and brightness together
+	am.runFades()
+	if c, b, _ := controller.LastLit(2); c != [3]byte{85, 0, 170} || b != 133 {
+		t.Errorf("expected LED 2 a third of the way after one tick, got %v at %d", c, b)
+	}
+	if c, _, _ := controller.LastLit(3); c != [3]byte{0, 8, 255} {
+		t.Errorf("expected LED 3 at its target after one tick, got %v", c)
+	}
+	if len(am.fades) != 1 {
+		t.Errorf("expected LED 3's fade done, got %d fades", len(am.fades))
+	}
+	am.runFades()
+	am.runFades()
+	if c, b, _ := controller.LastLit(2); c != [3]byte{255, 0, 0} || b != 200 {
+		t.Errorf("expected LED 2 to end at red and 200, got %v at %d", c, b)
+	}
+	if len(am.fades) != 0 {
+		t.Errorf("expected fades cleared, got %v", am.fades)
+	}
+
+	// The same target keeps the fade in progress; a new one replaces it
+	am.fadeLed(2, [3]byte{0, 255, 0}, 200, transition, poll)
+	am.runFades()
+	am.fadeLed(2, [3]byte{0, 255, 0}, 150, transition, poll)
+	if len(am.fades) != 1 || len(am.fades[0].steps) != 2 || am.fades[0].steps[1].brightness != 150 {
+		t.Errorf("expected the fade kept with a new brightness, got %v", am.fades)
+	}
+	am.fadeLed(2, [3]byte{0, 0, 255}, 200, transition, poll)
+	if len(am.fades) != 1 || len(am.fades[0].steps) != 3 {
+		t.Errorf("expected the fade replaced, got %v", am.fades)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build info, set at build time with
+// -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.buildDate=2025-01-01T00:00:00Z"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// versionString describes the build. A commit or build date not set with
+// -ldflags falls back to the VCS info Go embeds in the binary.
+func versionString() string {
+	c, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = setting.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = setting.Value
+				}
+			}
+		}
+	}
+	return formatVersion(version, c, date)
+}
+
+func formatVersion(version, commit, date string) string {
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("truenas-leds %s (commit %s, built %s)", version, commit, date)
+}
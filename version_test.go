@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestFormatVersion(t *testing.T) {
+	tests := []struct {
+		version, commit, date string
+		want                  string
+	}{
+		{"v1.2.3", "abc1234", "2025-01-01T00:00:00Z", "truenas-leds v1.2.3 (commit abc1234, built 2025-01-01T00:00:00Z)"},
+		{"dev", "", "", "truenas-leds dev (commit unknown, built unknown)"},
+	}
+	for _, tt := range tests {
+		if got := formatVersion(tt.version, tt.commit, tt.date); got != tt.want {
+			t.Errorf("formatVersion(%q, %q, %q) = %q, want %q", tt.version, tt.commit, tt.date, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// watchdog records when the monitor loop last ticked
+type watchdog struct {
+	last atomic.Int64 // Unix nanoseconds, 0 before the first tick
+}
+
+// pet records a tick at now
+func (w *watchdog) pet(now time.Time) {
+	w.last.Store(now.UnixNano())
+}
+
+// since returns how long before now the loop last ticked, 0 before the
+// first tick
+func (w *watchdog) since(now time.Time) time.Duration {
+	last := w.last.Load()
+	if last == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, last))
+}
+
+// checkWatchdog logs when the monitor loop stops ticking for the watchdog
+// timeout, and when it starts again. stalled is the result of the previous
+// check, so each stall is reported once.
+func (am *ActivityMonitor) checkWatchdog(conf *Config, now time.Time, stalled bool) bool {
+	since := am.watchdog.since(now)
+	switch {
+	case since >= conf.WatchdogTimeout && !stalled:
+		log.Printf("Warning: monitor loop stalled, no tick for %s", since.Round(time.Millisecond))
+		if controller := am.controller(); conf.WatchdogReopen && controller != nil {
+			if err := controller.TryReopen("a monitor loop stall"); err != nil {
+				log.Printf("Warning: can't reopen LED controller: %v", err)
+			} else {
+				// Writes that failed on the old device may work on the new one
+				am.ledFailures.reset()
+			}
+		}
+		return true
+	case since < conf.WatchdogTimeout && stalled:
+		log.Printf("Monitor loop recovered")
+		return false
+	}
+	return stalled
+}
+
+// watchdogLoop checks the monitor loop a few times per watchdog timeout,
+// following config changes, until ctx is cancelled
+func (am *ActivityMonitor) watchdogLoop(ctx context.Context) {
+	conf := am.configLoader.Config()
+	subscriber := am.configLoader.Subscribe()
+
+	ticker := time.NewTicker(conf.WatchdogTimeout / 4)
+	defer ticker.Stop()
+	stalled := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case newconf := <-subscriber:
+			conf = &newconf
+			ticker.Reset(conf.WatchdogTimeout / 4)
+		case now := <-ticker.C:
+			stalled = am.checkWatchdog(conf, now, stalled)
+		}
+	}
+}
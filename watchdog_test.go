@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+)
+
+func TestCheckWatchdog(t *testing.T) {
+	conf := &Config{WatchdogTimeout: time.Second}
+	am := &ActivityMonitor{leds: leds.NewUGreenLedsWithTransport(leds.NewFakeTransport())}
+	start := time.Now()
+
+	if am.checkWatchdog(conf, start.Add(time.Hour), false) {
+		t.Error("stall reported before the monitor loop started")
+	}
+
+	am.watchdog.pet(start)
+	stalled := am.checkWatchdog(conf, start.Add(500*time.Millisecond), false)
+	if stalled {
+		t.Error("stall reported within the timeout")
+	}
+
+	// The loop stops petting
+	if stalled = am.checkWatchdog(conf, start.Add(time.Second), stalled); !stalled {
+		t.Fatal("stall not detected after the timeout")
+	}
+	if stalled = am.checkWatchdog(conf, start.Add(3*time.Second), stalled); !stalled {
+		t.Error("stall cleared without a tick")
+	}
+
+	am.watchdog.pet(start.Add(4 * time.Second))
+	if am.checkWatchdog(conf, start.Add(4*time.Second), stalled) {
+		t.Error("stall still reported after the loop ticked again")
+	}
+}
+
+func TestCheckWatchdogAfterClose(t *testing.T) {
+	conf := &Config{WatchdogTimeout: time.Second, WatchdogReopen: true}
+	am := newTestMonitor(t)
+	am.Close() // a stall check landing during shutdown
+	start := time.Now()
+	am.watchdog.pet(start)
+	if !am.checkWatchdog(conf, start.Add(time.Second), false) {
+		t.Error("stall not detected after Close")
+	}
+}
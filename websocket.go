@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const wsWriteTimeout = 5 * time.Second
+
+var wsUpgrader = websocket.Upgrader{
+	// The feed is read-only, so any dashboard origin may connect
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// statusHub fans status updates out to WebSocket clients. Each client holds
+// at most one pending update; a slow client skips to the latest one instead
+// of blocking the broadcaster.
+type statusHub struct {
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+func newStatusHub() *statusHub {
+	return &statusHub{clients: make(map[chan []byte]struct{})}
+}
+
+func (h *statusHub) subscribe() chan []byte {
+	ch := make(chan []byte, 1)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *statusHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+// empty reports whether no clients are connected
+func (h *statusHub) empty() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients) == 0
+}
+
+// broadcast queues msg for every client without blocking
+func (h *statusHub) broadcast(msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		// Replace any update the client hasn't picked up yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- msg
+	}
+}
+
+// broadcastStatus sends the current status to WebSocket clients
+func (am *ActivityMonitor) broadcastStatus() {
+	if am.hub == nil || am.hub.empty() {
+		return
+	}
+	msg, err := json.Marshal(am.status())
+	if err != nil {
+		log.Printf("Error encoding status: %v", err)
+		return
+	}
+	am.hub.broadcast(msg)
+}
+
+// handleWebSocket streams the status document, sending it on connect and
+// again whenever the monitor changes an LED
+func (am *ActivityMonitor) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error
+		return
+	}
+	defer conn.Close()
+
+	updates := am.hub.subscribe()
+	defer am.hub.unsubscribe(updates)
+
+	// Read until the client goes away; clients aren't expected to send anything
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(msg []byte) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteMessage(websocket.TextMessage, msg) == nil
+	}
+	initial, err := json.Marshal(am.status())
+	if err != nil || !send(initial) {
+		return
+	}
+	for {
+		select {
+		case msg := <-updates:
+			if !send(msg) {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devilmonastery/ugreen-truenas-leds/leds"
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketFeed(t *testing.T) {
+	controller := leds.NewUGreenLedsWithTransport(leds.NewFakeTransport())
+	controller.SetTiming(leds.LedTiming{MaxRetry: 1})
+	am := &ActivityMonitor{leds: controller, hub: newStatusHub(), ledErrors: newErrorLimiter(ledErrorLogInterval)}
+
+	server := httptest.NewServer(am.statusHandler())
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	read := func() Status {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var status Status
+		if err := json.Unmarshal(msg, &status); err != nil {
+			t.Fatalf("invalid status %s: %v", msg, err)
+		}
+		return status
+	}
+
+	if status := read(); len(status.Leds) != 0 {
+		t.Errorf("expected no LED state before the first tick, got %+v", status.Leds)
+	}
+
+	// Simulate a tick that lights disk1
+	am.setLedColor(2, 255, 0, 0)
+	am.setLedMode(2, leds.LedModeOn, nil)
+	am.tickDone(&Config{}, ActivityEvent{})
+
+	status := read()
+	want := []leds.LedState{{Index: 2, Name: "disk1", Mode: "on", R: 255}}
+	if len(status.Leds) != 1 || status.Leds[0] != want[0] {
+		t.Errorf("expected %+v after tick, got %+v", want, status.Leds)
+	}
+
+	// A tick that changes nothing sends nothing
+	am.tickDone(&Config{}, ActivityEvent{})
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, msg, err := conn.ReadMessage(); err == nil {
+		t.Errorf("expected no update for an unchanged tick, got %s", msg)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for !am.hub.empty() {
+		if time.Now().After(deadline) {
+			t.Fatal("expected client to be removed after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatusHubBroadcastDoesNotBlock(t *testing.T) {
+	hub := newStatusHub()
+	ch := hub.subscribe()
+	hub.broadcast([]byte("1"))
+	hub.broadcast([]byte("2")) // client hasn't read; replaces the pending update
+	if got := string(<-ch); got != "2" {
+		t.Errorf("expected latest update, got %q", got)
+	}
+	hub.unsubscribe(ch)
+	if !hub.empty() {
+		t.Error("expected hub to be empty after unsubscribe")
+	}
+}